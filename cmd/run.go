@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
 
 	intoto "github.com/in-toto/in-toto-golang/in_toto"
@@ -9,11 +11,15 @@ import (
 )
 
 var (
-	stepName       string
-	runDir         string
-	materialsPaths []string
-	productsPaths  []string
-	noCommand      bool
+	stepName         string
+	runDir           string
+	materialsPaths   []string
+	productsPaths    []string
+	noCommand        bool
+	envAllowlist     []string
+	streamOutput     bool
+	maxByproductSize int
+	dryRun           bool
 )
 
 var runCmd = &cobra.Command{
@@ -23,10 +29,18 @@ var runCmd = &cobra.Command{
 files before command execution) and 'products' (i.e. files after command
 execution) and stores them together with other information (executed command,
 return value, stdout, stderr, ...) to a link metadata file, which is signed
-with the passed key.  Returns nonzero value on failure and zero otherwise.`,
-	Args:    cobra.MinimumNArgs(0),
-	PreRunE: getKeyCert,
-	RunE:    run,
+with the passed key.  With --dry-run, the unsigned link metadata is printed
+instead, without requiring a key.  Returns nonzero value on failure and zero
+otherwise.`,
+	Args: cobra.MinimumNArgs(0),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Dry runs record the link without signing it
+		if dryRun {
+			return nil
+		}
+		return getKeyCert(cmd, args)
+	},
+	RunE: run,
 }
 
 func init() {
@@ -77,7 +91,8 @@ the provided key.`,
 		[]string{},
 		`Paths to files or directories, whose paths and hashes
 are stored in the resulting link metadata before the
-command is executed. Symlinks are followed.`,
+command is executed. Symlinks are followed. Glob patterns,
+e.g. 'dist/*.tar.gz' or 'dist/**', are expanded.`,
 	)
 
 	runCmd.Flags().StringArrayVarP(
@@ -87,7 +102,8 @@ command is executed. Symlinks are followed.`,
 		[]string{},
 		`Paths to files or directories, whose paths and hashes
 are stored in the resulting link metadata after the
-command is executed. Symlinks are followed.`,
+command is executed. Symlinks are followed. Glob patterns,
+e.g. 'dist/*.tar.gz' or 'dist/**', are expanded.`,
 	)
 
 	runCmd.Flags().StringVarP(
@@ -156,6 +172,34 @@ recorded independently of this parameter.`,
 		"Create metadata using DSSE instead of the legacy signature wrapper.",
 	)
 
+	runCmd.Flags().StringArrayVar(
+		&envAllowlist,
+		"record-env",
+		[]string{},
+		`Names of environment variables whose values are recorded
+in the ‘environment’ field of the resulting link metadata,
+e.g. ‘--record-env CC --record-env SOURCE_DATE_EPOCH’. Only
+the passed variables are recorded, unset variables are omitted.`,
+	)
+
+	runCmd.Flags().BoolVar(
+		&streamOutput,
+		"stream-output",
+		false,
+		`Show stdout and stderr of the command while it runs,
+in addition to recording them in the resulting link metadata.`,
+	)
+
+	runCmd.Flags().IntVar(
+		&maxByproductSize,
+		"max-byproduct-size",
+		intoto.DefaultMaxByproductSize,
+		`Maximum number of bytes of each of stdout and stderr of the command
+that are recorded in the resulting link metadata. Output beyond the limit
+is truncated and marked with "[truncated]". Zero or a negative value
+disables the limit.`,
+	)
+
 	runCmd.Flags().StringVar(
 		&spiffeUDS,
 		"spiffe-workload-api-path",
@@ -163,6 +207,20 @@ recorded independently of this parameter.`,
 		"UDS path for SPIFFE workload API",
 	)
 
+	runCmd.Flags().BoolVar(
+		&dryRun,
+		"dry-run",
+		false,
+		`Print the unsigned link metadata that would be recorded, i.e.
+the materials, products and byproducts, to stdout instead of signing
+it and writing it to the metadata directory, e.g. to try out
+‘--materials’ and ‘--products’ patterns before setting up a key.`,
+	)
+
+	runCmd.MarkFlagsMutuallyExclusive("dry-run", "key")
+	runCmd.MarkFlagsMutuallyExclusive("dry-run", "cert")
+	runCmd.MarkFlagsMutuallyExclusive("dry-run", "spiffe-workload-api-path")
+
 }
 
 func run(cmd *cobra.Command, args []string) error {
@@ -174,7 +232,26 @@ func run(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("no command arguments passed, please specify or use --no-command option")
 	}
 
-	metadata, err := intoto.InTotoRun(stepName, runDir, materialsPaths, productsPaths, args, key, []string{"sha256"}, exclude, lStripPaths, lineNormalization, followSymlinkDirs, useDSSE)
+	opts := intoto.InTotoRunOptions{
+		RecordArtifactsOptions: intoto.RecordArtifactsOptions{
+			HashAlgorithms:    []string{"sha256"},
+			GitignorePatterns: exclude,
+			LStripPaths:       lStripPaths,
+			LineNormalization: lineNormalization,
+			FollowSymlinkDirs: followSymlinkDirs,
+		},
+		RunCommandOptions: intoto.RunCommandOptions{
+			MaxByproductSize: maxByproductSize,
+		},
+		UseDSSE:      useDSSE,
+		EnvAllowlist: envAllowlist,
+	}
+	if streamOutput {
+		opts.Stdout = os.Stdout
+		opts.Stderr = os.Stderr
+	}
+
+	metadata, err := intoto.InTotoRunWithOptions(stepName, runDir, materialsPaths, productsPaths, args, key, opts)
 	if err != nil {
 		return fmt.Errorf("failed to create link metadata: %w", err)
 	}
@@ -183,6 +260,16 @@ func run(cmd *cobra.Command, args []string) error {
 	if !ok {
 		return fmt.Errorf("metadata must be link")
 	}
+
+	if dryRun {
+		linkJSON, err := json.MarshalIndent(link, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode link metadata: %w", err)
+		}
+		fmt.Println(string(linkJSON))
+		return nil
+	}
+
 	linkName := fmt.Sprintf(intoto.LinkNameFormat, link.Name, key.KeyID)
 
 	linkPath := filepath.Join(outDir, linkName)
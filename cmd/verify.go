@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
 
 	intoto "github.com/in-toto/in-toto-golang/in_toto"
 	"github.com/spf13/cobra"
@@ -12,6 +13,9 @@ var (
 	pubKeyPaths       []string
 	linkDir           string
 	intermediatePaths []string
+	layoutThreshold   int
+	verificationTime  string
+	skipKeyIDCheck    bool
 )
 
 var verifyCmd = &cobra.Command{
@@ -44,7 +48,17 @@ func init() {
 		`Path(s) to PEM formatted public key(s), used to verify the passed 
 root layout's signature(s). Passing at least one key using
 '--layout-keys' is required. For each passed key the layout
-must carry a valid signature.`,
+must carry a valid signature, unless '--layout-threshold' is
+passed.`,
+	)
+
+	verifyCmd.Flags().IntVar(
+		&layoutThreshold,
+		"layout-threshold",
+		0,
+		`Number of passed layout keys that must have a valid
+signature on the root layout, e.g. 2 out of 3 release
+managers. If not passed, all keys must have signed.`,
 	)
 
 	verifyCmd.Flags().StringVarP(
@@ -67,6 +81,25 @@ the chain of trust to the layout's trusted root. These will be used in
 addition to any intermediates in the layout.`,
 	)
 
+	verifyCmd.Flags().StringVar(
+		&verificationTime,
+		"verification-time",
+		"",
+		`Time in RFC 3339 format, e.g. '2023-05-01T10:00:00Z', at
+which the expiration of the layout and of any sublayouts is
+checked. Allows to verify a release against the layout that
+was valid at the time. If not passed, the current time is used.`,
+	)
+
+	verifyCmd.Flags().BoolVar(
+		&skipKeyIDCheck,
+		"skip-keyid-validation",
+		false,
+		`Do not check that the keyids of the keys in the layout
+match the keys. Allows to verify legacy layouts whose keyids
+were computed differently.`,
+	)
+
 	verifyCmd.MarkFlagRequired("layout")
 	verifyCmd.MarkFlagRequired("layout-keys")
 
@@ -81,6 +114,10 @@ with a new line character.`,
 }
 
 func verify(cmd *cobra.Command, args []string) error {
+	if layoutThreshold < 0 {
+		return fmt.Errorf("invalid layout threshold %d: must not be negative", layoutThreshold)
+	}
+
 	layoutMb, err := intoto.LoadMetadata(layoutPath)
 	if err != nil {
 		return fmt.Errorf("failed to load layout at %s: %w", layoutPath, err)
@@ -108,7 +145,20 @@ func verify(cmd *cobra.Command, args []string) error {
 		intermediatePems = append(intermediatePems, pemBytes)
 	}
 
-	_, err = intoto.InTotoVerify(layoutMb, layoutKeys, linkDir, "", make(map[string]string), intermediatePems, lineNormalization)
+	var referenceTime time.Time
+	if verificationTime != "" {
+		referenceTime, err = time.Parse(time.RFC3339, verificationTime)
+		if err != nil {
+			return fmt.Errorf("invalid verification time %s: %w", verificationTime, err)
+		}
+	}
+
+	_, _, err = intoto.InTotoVerifyWithOptions(layoutMb, layoutKeys, linkDir, "", make(map[string]string), intermediatePems, intoto.InTotoVerifyOptions{
+		LineNormalization:   lineNormalization,
+		LayoutThreshold:     layoutThreshold,
+		ReferenceTime:       referenceTime,
+		SkipKeyIDValidation: skipKeyIDCheck,
+	})
 	if err != nil {
 		return fmt.Errorf("inspection failed: %w", err)
 	}
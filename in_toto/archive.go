@@ -0,0 +1,253 @@
+package in_toto
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+archiveMember is an entry of a tar or zip archive.  Regular files hold their
+hashes, symlinks the slash separated link target.
+*/
+type archiveMember struct {
+	isDir     bool
+	isSymlink bool
+	hashes    HashObj
+	target    string
+}
+
+/*
+archiveMembers maps the cleaned in-archive paths of the entries of an archive
+to the entries.  Later entries replace earlier ones of the same path, like when
+the archive is extracted.
+*/
+type archiveMembers map[string]archiveMember
+
+/*
+RecordArtifactsFromTar records the regular files of the uncompressed tar archive
+read from the passed reader, without extracting it, and returns a map in the
+same format as RecordArtifacts, keyed by the in-archive paths of the files,
+with leading "./" and "/" removed, e.g.:
+
+	{
+		"usr/bin/foo": {
+			"sha256": <hex representation of hash>
+		},
+		...
+	}
+
+Directories are not recorded.  Symlinks and hard links are resolved within the
+archive and recorded with the hashes of the files they point to, treating the
+archive as root directory for absolute link targets.  Symlinks to directories
+are skipped, like RecordArtifacts does by default.  If a symlink does not point
+to a file of the archive, an error is returned, and if more than
+DefaultMaxSymlinkDepth symlinks have to be followed to reach a file, e.g.
+because of a symlink cycle, an error wrapping ErrSymDepthExceeded is returned.
+Other entries, e.g. device files, are skipped.  Compressed archives must be
+decompressed by the caller, e.g. with gzip.NewReader.
+*/
+func RecordArtifactsFromTar(r io.Reader, hashAlgorithms []string) (map[string]HashObj, error) {
+	if err := ValidateHashAlgorithms(hashAlgorithms); err != nil {
+		return nil, err
+	}
+
+	members := archiveMembers{}
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		name := cleanArchivePath(header.Name)
+		if name == "" {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			hashes, err := hashReader(tarReader, hashAlgorithms, rawLineEndings)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read tar archive: %w", err)
+			}
+			members.add(name, archiveMember{hashes: hashes})
+		case tar.TypeDir:
+			members.add(name, archiveMember{isDir: true})
+		case tar.TypeSymlink:
+			members.add(name, archiveMember{isSymlink: true, target: header.Linkname})
+		case tar.TypeLink:
+			// Hard links refer to a member that precedes them in the archive
+			linked, ok := members[cleanArchivePath(header.Linkname)]
+			if !ok || linked.isDir {
+				return nil, fmt.Errorf("hard link %s points to %s, which is not a preceding file of the tar archive", name, header.Linkname)
+			}
+			members.add(name, linked)
+		}
+	}
+
+	return members.artifacts()
+}
+
+/*
+RecordArtifactsFromZip is like RecordArtifactsFromTar for the zip archive of
+the passed size, which is read from the passed reader, as with zip.NewReader.
+Entries whose mode has os.ModeSymlink set are symlinks with their content as
+link target.
+*/
+func RecordArtifactsFromZip(r io.ReaderAt, size int64, hashAlgorithms []string) (map[string]HashObj, error) {
+	if err := ValidateHashAlgorithms(hashAlgorithms); err != nil {
+		return nil, err
+	}
+
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip archive: %w", err)
+	}
+
+	members := archiveMembers{}
+	for _, file := range zipReader.File {
+		name := cleanArchivePath(file.Name)
+		if name == "" {
+			continue
+		}
+		mode := file.Mode()
+		switch {
+		case mode.IsDir():
+			members.add(name, archiveMember{isDir: true})
+		case mode&os.ModeSymlink != 0:
+			target, err := readZipFile(file)
+			if err != nil {
+				return nil, err
+			}
+			members.add(name, archiveMember{isSymlink: true, target: string(target)})
+		case mode.IsRegular():
+			rc, err := file.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read zip archive member %s: %w", file.Name, err)
+			}
+			hashes, err := hashReader(rc, hashAlgorithms, rawLineEndings)
+			rc.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read zip archive member %s: %w", file.Name, err)
+			}
+			members.add(name, archiveMember{hashes: hashes})
+		}
+	}
+
+	return members.artifacts()
+}
+
+/*
+readZipFile returns the decompressed content of the passed zip archive member.
+*/
+func readZipFile(file *zip.File) ([]byte, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip archive member %s: %w", file.Name, err)
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip archive member %s: %w", file.Name, err)
+	}
+	return content, nil
+}
+
+/*
+cleanArchivePath returns the passed in-archive path as clean, slash separated
+path relative to the archive root, which is returned as empty string.
+*/
+func cleanArchivePath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+}
+
+/*
+add adds the passed member and all of its parent directories, which archives
+do not necessarily contain as entries of their own.
+*/
+func (m archiveMembers) add(name string, member archiveMember) {
+	m[name] = member
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := m[dir]; ok {
+			break
+		}
+		m[dir] = archiveMember{isDir: true}
+	}
+}
+
+/*
+artifacts returns the hashes of the regular files of the archive and of the
+symlinks that point to regular files of the archive, keyed by their paths.
+*/
+func (m archiveMembers) artifacts() (map[string]HashObj, error) {
+	evalArtifacts := make(map[string]HashObj, len(m))
+	for name, member := range m {
+		if member.isDir {
+			continue
+		}
+		if member.isSymlink {
+			resolved, err := m.resolve(name)
+			if err != nil {
+				return nil, err
+			}
+			// We don't follow symlinked directories
+			if resolved.isDir {
+				continue
+			}
+			member = resolved
+		}
+		evalArtifacts[name] = member.hashes
+	}
+	return evalArtifacts, nil
+}
+
+/*
+resolve follows the symlinks in the passed path, including symlinks to
+directories among its parents, and returns the member that it points to.
+*/
+func (m archiveMembers) resolve(name string) (archiveMember, error) {
+	hops := 0
+	remaining := name
+	resolved := ""
+	for remaining != "" {
+		component, rest, _ := strings.Cut(remaining, "/")
+		current := path.Join(resolved, component)
+		member, ok := m[current]
+		if !ok {
+			return archiveMember{}, fmt.Errorf("symlink %s points to %s, which is not contained in the archive", name, path.Join(current, rest))
+		}
+		if !member.isSymlink {
+			if rest != "" && !member.isDir {
+				return archiveMember{}, fmt.Errorf("symlink %s points to %s, which is not contained in the archive", name, path.Join(current, rest))
+			}
+			resolved = current
+			remaining = rest
+			continue
+		}
+
+		hops++
+		if hops > DefaultMaxSymlinkDepth {
+			return archiveMember{}, fmt.Errorf("%w: %s is reached via more than %d symlinks", ErrSymDepthExceeded, name, DefaultMaxSymlinkDepth)
+		}
+		// Absolute targets are relative to the archive root and relative
+		// targets to the directory of the symlink
+		target := member.target
+		if !strings.HasPrefix(target, "/") {
+			target = path.Join(resolved, target)
+		}
+		remaining = cleanArchivePath(path.Join(target, rest))
+		resolved = ""
+	}
+	if resolved == "" {
+		// The archive root itself is a directory
+		return archiveMember{isDir: true}, nil
+	}
+	return m[resolved], nil
+}
@@ -0,0 +1,151 @@
+package in_toto
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+testArchiveEntry is an entry of an archive created by the archive tests.  If
+link is set, the entry is a symlink, if name ends with "/", a directory.
+*/
+type testArchiveEntry struct {
+	name    string
+	content string
+	link    string
+}
+
+func createTestTar(t *testing.T, entries []testArchiveEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.content))}
+		switch {
+		case entry.link != "":
+			header = &tar.Header{Name: entry.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: entry.link}
+		case strings.HasSuffix(entry.name, "/"):
+			header = &tar.Header{Name: entry.name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(entry.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func createTestZip(t *testing.T, entries []testArchiveEntry) *bytes.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		content := entry.content
+		switch {
+		case entry.link != "":
+			header.SetMode(os.ModeSymlink | 0777)
+			content = entry.link
+		case strings.HasSuffix(entry.name, "/"):
+			header.SetMode(os.ModeDir | 0755)
+		default:
+			header.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestRecordArtifactsFromArchive(t *testing.T) {
+	sha256Hex := func(content string) HashObj {
+		return HashObj{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte(content)))}
+	}
+	recordTar := func(entries []testArchiveEntry) (map[string]HashObj, error) {
+		return RecordArtifactsFromTar(createTestTar(t, entries), []string{"sha256"})
+	}
+	recordZip := func(entries []testArchiveEntry) (map[string]HashObj, error) {
+		r := createTestZip(t, entries)
+		return RecordArtifactsFromZip(r, r.Size(), []string{"sha256"})
+	}
+
+	entries := []testArchiveEntry{
+		{name: "./usr/"},
+		{name: "./usr/bin/foo", content: "foo"},
+		{name: "./usr/lib/libfoo.so.1", content: "libfoo"},
+		{name: "./usr/lib/libfoo.so", link: "libfoo.so.1"},
+		{name: "./usr/lib/libfoo-abs.so", link: "/usr/lib/libfoo.so"},
+		{name: "./lib", link: "usr/lib"},
+		{name: "./bin/foo", link: "../lib/../usr/bin/foo"},
+		{name: "./share/"},
+	}
+	expected := map[string]HashObj{
+		"usr/bin/foo":           sha256Hex("foo"),
+		"usr/lib/libfoo.so.1":   sha256Hex("libfoo"),
+		"usr/lib/libfoo.so":     sha256Hex("libfoo"),
+		"usr/lib/libfoo-abs.so": sha256Hex("libfoo"),
+		"bin/foo":               sha256Hex("foo"),
+	}
+	for name, record := range map[string]func([]testArchiveEntry) (map[string]HashObj, error){"tar": recordTar, "zip": recordZip} {
+		artifacts, err := record(entries)
+		assert.Nil(t, err, name)
+		assert.Equal(t, expected, artifacts, name)
+
+		// Later entries replace earlier ones
+		artifacts, err = record([]testArchiveEntry{{name: "foo", content: "old"}, {name: "foo", content: "new"}})
+		assert.Nil(t, err, name)
+		assert.Equal(t, map[string]HashObj{"foo": sha256Hex("new")}, artifacts, name)
+
+		_, err = record([]testArchiveEntry{{name: "foo", link: "bar"}})
+		assert.ErrorContains(t, err, "symlink foo points to bar, which is not contained in the archive", name)
+		_, err = record([]testArchiveEntry{{name: "foo", content: "foo"}, {name: "bar", link: "foo/baz"}})
+		assert.ErrorContains(t, err, "symlink bar points to foo/baz, which is not contained in the archive", name)
+		_, err = record([]testArchiveEntry{{name: "a", link: "b"}, {name: "b", link: "a"}})
+		assert.ErrorIs(t, err, ErrSymDepthExceeded, name)
+	}
+
+	// Hard links have the hashes of the file they refer to
+	hardLinkTar := func(linkname string) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "foo", Mode: 0644, Typeflag: tar.TypeReg, Size: 3}))
+		_, err := tw.Write([]byte("foo"))
+		assert.Nil(t, err)
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "bar", Typeflag: tar.TypeLink, Linkname: linkname}))
+		assert.Nil(t, tw.Close())
+		return &buf
+	}
+	artifacts, err := RecordArtifactsFromTar(hardLinkTar("./foo"), []string{"sha256"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{"foo": sha256Hex("foo"), "bar": sha256Hex("foo")}, artifacts)
+	_, err = RecordArtifactsFromTar(hardLinkTar("missing"), []string{"sha256"})
+	assert.ErrorContains(t, err, "hard link bar points to missing")
+
+	_, err = RecordArtifactsFromTar(strings.NewReader("not a tar archive"), []string{"sha256"})
+	assert.ErrorContains(t, err, "failed to read tar archive")
+	_, err = RecordArtifactsFromZip(strings.NewReader("not a zip archive"), 17, []string{"sha256"})
+	assert.ErrorContains(t, err, "failed to read zip archive")
+	_, err = RecordArtifactsFromTar(createTestTar(t, entries), []string{"md5"})
+	assert.ErrorIs(t, err, ErrUnsupportedHashAlgorithm)
+}
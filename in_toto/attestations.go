@@ -1,11 +1,16 @@
 package in_toto
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
 	ita1 "github.com/in-toto/attestation/go/v1"
 	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
 	slsa01 "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.1"
 	slsa02 "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
 	slsa1 "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v1"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 )
 
 const (
@@ -73,6 +78,179 @@ type Statement struct {
 	Predicate interface{} `json:"predicate"`
 }
 
+/*
+ArtifactsToSubjects converts artifacts as returned by RecordArtifacts, e.g. the
+materials or products of a link created by InTotoRun, into statement
+subjects.  The subjects are sorted by name, so that the resulting statement is
+deterministic.  Recorded file modes, see ArtifactModeKey, are not digests and
+thus omitted, as are empty directories, see ArtifactDirKey, which have no
+digests at all.
+*/
+func ArtifactsToSubjects(artifacts map[string]HashObj) []Subject {
+	names := make([]string, 0, len(artifacts))
+	for name := range artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	subjects := make([]Subject, 0, len(names))
+	for _, name := range names {
+		if _, ok := artifacts[name][ArtifactDirKey]; ok {
+			continue
+		}
+		digest := make(common.DigestSet, len(artifacts[name]))
+		for algorithm, value := range artifacts[name] {
+			if algorithm != ArtifactModeKey {
+				digest[algorithm] = value
+			}
+		}
+		subjects = append(subjects, Subject{
+			Name:   name,
+			Digest: digest,
+		})
+	}
+	return subjects
+}
+
+/*
+NewStatement returns a Statement of type StatementInTotoV01 about the passed
+artifacts, as returned by RecordArtifacts, which wraps the passed predicate of
+the passed predicateType, e.g. a SLSA provenance predicate.
+*/
+func NewStatement(artifacts map[string]HashObj, predicateType string, predicate interface{}) Statement {
+	return Statement{
+		StatementHeader: StatementHeader{
+			Type:          StatementInTotoV01,
+			PredicateType: predicateType,
+			Subject:       ArtifactsToSubjects(artifacts),
+		},
+		Predicate: predicate,
+	}
+}
+
+/*
+StatementToEnvelope returns an unsigned DSSE envelope with the passed
+statement, e.g. a Statement or a ProvenanceStatementSLSA02, as canonical JSON
+payload of type PayloadType.  The envelope can be signed with its Sign method
+and written with Dump.
+*/
+func StatementToEnvelope(statement interface{}) (*Envelope, error) {
+	env := &Envelope{}
+	if err := env.SetPayload(statement); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+/*
+EnvelopeToStatement decodes the payload of the passed DSSE envelope, e.g. one
+created with StatementToEnvelope and parsed from JSON, into a Statement.  The
+predicate is decoded generically and can be converted into a typed predicate by
+re-marshalling it.  It returns ErrInvalidPayloadType if the envelope does not
+hold an in-toto payload, and an error if the payload is not a v0.1 or v1
+statement.  Signatures are not verified.
+*/
+func EnvelopeToStatement(env *dsse.Envelope) (Statement, error) {
+	if env.PayloadType != PayloadType {
+		return Statement{}, ErrInvalidPayloadType
+	}
+	payload, err := env.DecodeB64Payload()
+	if err != nil {
+		return Statement{}, err
+	}
+	var statement Statement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return Statement{}, err
+	}
+	if statement.Type != StatementInTotoV01 && statement.Type != StatementInTotoV1 {
+		return Statement{}, fmt.Errorf("unknown statement type '%s'", statement.Type)
+	}
+	return statement, nil
+}
+
+/*
+LinkToProvenanceSLSA02 creates a SLSA v0.2 provenance predicate from a link as
+returned by InTotoRun.  The passed builderID identifies the builder and the
+passed buildType URI the type of build.  The command of the link is recorded as
+invocation parameter and its environment as invocation environment.  Its
+byproducts, e.g. the return value of the command, are recorded as build config.
+The materials of the link become the provenance materials, sorted by URI.
+*/
+func LinkToProvenanceSLSA02(link Link, builderID string, buildType string) slsa02.ProvenancePredicate {
+	predicate := slsa02.ProvenancePredicate{
+		Builder:   common.ProvenanceBuilder{ID: builderID},
+		BuildType: buildType,
+		Invocation: slsa02.ProvenanceInvocation{
+			Parameters: map[string]interface{}{"command": link.Command},
+		},
+	}
+	if len(link.Environment) > 0 {
+		predicate.Invocation.Environment = link.Environment
+	}
+	if len(link.ByProducts) > 0 {
+		predicate.BuildConfig = map[string]interface{}{"byproducts": link.ByProducts}
+	}
+	for _, subject := range ArtifactsToSubjects(link.Materials) {
+		predicate.Materials = append(predicate.Materials, common.ProvenanceMaterial{
+			URI:    subject.Name,
+			Digest: subject.Digest,
+		})
+	}
+	return predicate
+}
+
+/*
+LinkToProvenanceStatementSLSA02 wraps the predicate created by
+LinkToProvenanceSLSA02 in a statement, whose subjects are the products of the
+passed link.
+*/
+func LinkToProvenanceStatementSLSA02(link Link, builderID string, buildType string) ProvenanceStatementSLSA02 {
+	return ProvenanceStatementSLSA02{
+		StatementHeader: StatementHeader{
+			Type:          StatementInTotoV01,
+			PredicateType: slsa02.PredicateSLSAProvenance,
+			Subject:       ArtifactsToSubjects(link.Products),
+		},
+		Predicate: LinkToProvenanceSLSA02(link, builderID, buildType),
+	}
+}
+
+/*
+GenerateProvenanceOptions configures GenerateProvenance.  BuilderID identifies
+the builder and BuildType the type of build in the provenance.  The command is
+executed in RunDir, if set, and artifacts are recorded and the command is run
+as configured by the embedded InTotoRunOptions.
+*/
+type GenerateProvenanceOptions struct {
+	InTotoRunOptions
+	BuilderID string
+	BuildType string
+	RunDir    string
+}
+
+/*
+GenerateProvenance records the passed materials, executes the passed command
+and records the passed products like InTotoRun, but returns a Statement with a
+SLSA v0.2 provenance predicate instead of a link, see
+LinkToProvenanceStatementSLSA02.  The products are the subjects of the
+statement, and the passed step name is recorded as entry point of the
+invocation.  The statement is not signed, it can be wrapped in a DSSE envelope
+with StatementToEnvelope.
+*/
+func GenerateProvenance(name string, materialPaths []string, productPaths []string, cmdArgs []string, opts GenerateProvenanceOptions) (Statement, error) {
+	runOpts := opts.InTotoRunOptions
+	runOpts.UseDSSE = false
+	metadata, err := InTotoRunWithOptions(name, opts.RunDir, materialPaths, productPaths, cmdArgs, Key{}, runOpts)
+	if err != nil {
+		return Statement{}, err
+	}
+	link := metadata.GetPayload().(Link)
+
+	predicate := LinkToProvenanceSLSA02(link, opts.BuilderID, opts.BuildType)
+	predicate.Invocation.ConfigSource.EntryPoint = name
+	return NewStatement(link.Products, slsa02.PredicateSLSAProvenance, predicate), nil
+}
+
 // ProvenanceStatementSLSA01 is the definition for an entire provenance statement with SLSA 0.1 predicate.
 type ProvenanceStatementSLSA01 struct {
 	StatementHeader
@@ -2,12 +2,15 @@ package in_toto
 
 import (
 	"encoding/json"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
 	slsa01 "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.1"
 	slsa02 "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -483,3 +486,188 @@ func TestLinkStatement(t *testing.T) {
 
 	assert.Equal(t, want, got, "Unexpexted object after decoding")
 }
+
+func TestNewStatement(t *testing.T) {
+	products, err := RecordArtifacts([]string{"foo.tar.gz", "helloworld"}, []string{"sha256"}, nil, nil, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statement := NewStatement(products, PredicateLinkV1, map[string]string{"foo": "bar"})
+	assert.Equal(t, StatementInTotoV01, statement.Type)
+	assert.Equal(t, PredicateLinkV1, statement.PredicateType)
+	assert.Equal(t, []Subject{
+		{Name: "foo.tar.gz", Digest: common.DigestSet(products["foo.tar.gz"])},
+		{Name: "helloworld", Digest: common.DigestSet(products["helloworld"])},
+	}, statement.Subject)
+
+	b, err := json.Marshal(statement)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"_type":"https://in-toto.io/Statement/v0.1"`)
+	assert.Contains(t, string(b), `"predicate":{"foo":"bar"}`)
+
+	assert.Equal(t, []Subject{}, ArtifactsToSubjects(nil))
+
+	// File modes are not digests
+	assert.Equal(t, []Subject{{Name: "foo", Digest: common.DigestSet{"sha256": "abcd"}}},
+		ArtifactsToSubjects(map[string]HashObj{"foo": {"sha256": "abcd", ArtifactModeKey: "0755"}}))
+
+	// Empty directories have no digests
+	assert.Equal(t, []Subject{},
+		ArtifactsToSubjects(map[string]HashObj{"empty": {ArtifactDirKey: ArtifactDirValue}}))
+}
+
+func TestLinkToProvenanceSLSA02(t *testing.T) {
+	link := Link{
+		Type:    "link",
+		Name:    "build",
+		Command: []string{"make", "release"},
+		Materials: map[string]HashObj{
+			"src/main.c": {"sha256": "aaa"},
+			"Makefile":   {"sha256": "bbb"},
+		},
+		Products: map[string]HashObj{
+			"release.tar.gz": {"sha256": "ccc"},
+		},
+		ByProducts:  map[string]interface{}{"return-value": 0},
+		Environment: map[string]interface{}{"CC": "gcc"},
+	}
+
+	statement := LinkToProvenanceStatementSLSA02(link, "https://example.com/builder", "https://example.com/make@v1")
+	assert.Equal(t, slsa02.PredicateSLSAProvenance, statement.PredicateType)
+	assert.Equal(t, []Subject{{Name: "release.tar.gz", Digest: common.DigestSet{"sha256": "ccc"}}}, statement.Subject)
+	assert.Equal(t, slsa02.ProvenancePredicate{
+		Builder:   common.ProvenanceBuilder{ID: "https://example.com/builder"},
+		BuildType: "https://example.com/make@v1",
+		Invocation: slsa02.ProvenanceInvocation{
+			Parameters:  map[string]interface{}{"command": []string{"make", "release"}},
+			Environment: map[string]interface{}{"CC": "gcc"},
+		},
+		BuildConfig: map[string]interface{}{"byproducts": map[string]interface{}{"return-value": 0}},
+		Materials: []common.ProvenanceMaterial{
+			{URI: "Makefile", Digest: common.DigestSet{"sha256": "bbb"}},
+			{URI: "src/main.c", Digest: common.DigestSet{"sha256": "aaa"}},
+		},
+	}, statement.Predicate)
+
+	// Empty environment and byproducts are omitted
+	b, err := json.Marshal(LinkToProvenanceSLSA02(Link{Command: []string{"true"}}, "builder", "type"))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"builder":{"id":"builder"},"buildType":"type","invocation":{"configSource":{},"parameters":{"command":["true"]}}}`, string(b))
+}
+
+func TestStatementEnvelopeRoundTrip(t *testing.T) {
+	var carol Key
+	if err := carol.LoadKey("carol", "ed25519", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	predicate := slsa02.ProvenancePredicate{
+		Builder:   common.ProvenanceBuilder{ID: "https://example.com/builder"},
+		BuildType: "https://example.com/buildtype",
+		Invocation: slsa02.ProvenanceInvocation{
+			Parameters: map[string]interface{}{"command": []interface{}{"make"}},
+		},
+		Metadata: &slsa02.ProvenanceMetadata{BuildStartedOn: &started},
+		Materials: []common.ProvenanceMaterial{{
+			URI:    "foo.py",
+			Digest: common.DigestSet{"sha256": "74dc3727c6e89308b39e4dfedf787e37841198b1fa165a27c013544a60502549"},
+		}},
+	}
+	statement := NewStatement(map[string]HashObj{
+		"foo.tar.gz": {"sha256": "52947cb78b91ad01fe81cd6aef42d1f6817e92b9e6936c1e5aabb7c98514f355"},
+	}, slsa02.PredicateSLSAProvenance, predicate)
+
+	env, err := StatementToEnvelope(statement)
+	if err != nil {
+		t.Fatalf("StatementToEnvelope returned '%s'", err)
+	}
+	assert.Nil(t, env.Sign(carol))
+	assert.Nil(t, env.VerifySignature(carol))
+
+	fn := filepath.Join(t.TempDir(), "statement.json")
+	if err := env.Dump(fn); err != nil {
+		t.Fatal(err)
+	}
+	jsonBytes, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dsseEnv dsse.Envelope
+	if err := json.Unmarshal(jsonBytes, &dsseEnv); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := EnvelopeToStatement(&dsseEnv)
+	if err != nil {
+		t.Fatalf("EnvelopeToStatement returned '%s'", err)
+	}
+	assert.Equal(t, statement.StatementHeader, loaded.StatementHeader)
+
+	// The generic predicate converts back into the provenance predicate
+	predicateBytes, err := json.Marshal(loaded.Predicate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loadedPredicate slsa02.ProvenancePredicate
+	if err := json.Unmarshal(predicateBytes, &loadedPredicate); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, predicate, loadedPredicate)
+
+	// Only in-toto statements are decoded
+	dsseEnv.PayloadType = "application/json"
+	_, err = EnvelopeToStatement(&dsseEnv)
+	assert.ErrorIs(t, err, ErrInvalidPayloadType)
+
+	env, err = StatementToEnvelope(Link{Type: "link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkEnv := dsse.Envelope{PayloadType: PayloadType, Payload: env.envelope.Payload}
+	_, err = EnvelopeToStatement(&linkEnv)
+	assert.ErrorContains(t, err, "unknown statement type")
+}
+
+func TestGenerateProvenance(t *testing.T) {
+	dir := t.TempDir()
+	material := filepath.Join(dir, "foo.py")
+	product := filepath.Join(dir, "foo.tar.gz")
+	if err := os.WriteFile(material, []byte("print('foo')\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmdArgs := []string{"tar", "zcf", "foo.tar.gz", "foo.py"}
+
+	statement, err := GenerateProvenance("package", []string{material}, []string{product}, cmdArgs, GenerateProvenanceOptions{
+		InTotoRunOptions: InTotoRunOptions{
+			RecordArtifactsOptions: RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}},
+		},
+		BuilderID: "https://example.com/builder",
+		BuildType: "https://example.com/tar@v1",
+		RunDir:    dir,
+	})
+	if err != nil {
+		t.Fatalf("GenerateProvenance returned '%s'", err)
+	}
+
+	assert.Equal(t, StatementInTotoV01, statement.Type)
+	assert.Equal(t, slsa02.PredicateSLSAProvenance, statement.PredicateType)
+	if assert.Len(t, statement.Subject, 1) {
+		assert.Equal(t, filepath.ToSlash(product), statement.Subject[0].Name)
+		assert.Contains(t, statement.Subject[0].Digest, "sha256")
+	}
+
+	predicate, ok := statement.Predicate.(slsa02.ProvenancePredicate)
+	if !ok {
+		t.Fatalf("expected provenance predicate, got %T", statement.Predicate)
+	}
+	assert.Equal(t, "https://example.com/builder", predicate.Builder.ID)
+	assert.Equal(t, "package", predicate.Invocation.ConfigSource.EntryPoint)
+	assert.Equal(t, map[string]interface{}{"command": cmdArgs}, predicate.Invocation.Parameters)
+	if assert.Len(t, predicate.Materials, 1) {
+		assert.Equal(t, filepath.ToSlash(material), predicate.Materials[0].URI)
+	}
+
+	// Literal product paths must exist
+	_, err = GenerateProvenance("package", nil, []string{filepath.Join(dir, "missing")}, nil, GenerateProvenanceOptions{})
+	assert.NotNil(t, err)
+}
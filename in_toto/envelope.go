@@ -3,12 +3,12 @@ package in_toto
 import (
 	"context"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 
-	"github.com/secure-systems-lab/go-securesystemslib/cjson"
 	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 	"github.com/secure-systems-lab/go-securesystemslib/signerverifier"
 )
@@ -42,7 +42,7 @@ func loadEnvelope(env *dsse.Envelope) (*Envelope, error) {
 }
 
 func (e *Envelope) SetPayload(payload any) error {
-	encodedBytes, err := cjson.EncodeCanonical(payload)
+	encodedBytes, err := EncodeCanonical(payload)
 	if err != nil {
 		return err
 	}
@@ -61,6 +61,10 @@ func (e *Envelope) GetPayload() any {
 }
 
 func (e *Envelope) VerifySignature(key Key) error {
+	// The signature may reference the key by another of its keyids
+	if sig, err := getSignatureForKey(e.Sigs(), key); err == nil {
+		key.KeyID = sig.KeyID
+	}
 	verifier, err := getSignerVerifierFromKey(key)
 	if err != nil {
 		return err
@@ -80,6 +84,9 @@ func (e *Envelope) Sign(key Key) error {
 	if err != nil {
 		return err
 	}
+	if err := key.ValidateKeyID(); err != nil {
+		return err
+	}
 
 	es, err := dsse.NewEnvelopeSigner(signer)
 	if err != nil {
@@ -100,6 +107,54 @@ func (e *Envelope) Sign(key Key) error {
 	return nil
 }
 
+/*
+SignWith is like Sign, but creates the signature with the passed Signer, e.g.
+one that is backed by an SSH agent.  Like Sign, it replaces any existing
+signatures.  Signers that do not create a raw signature in the Sig field of
+the Signature, such as GPG signers, are not supported.
+*/
+func (e *Envelope) SignWith(signer Signer) error {
+	es, err := dsse.NewEnvelopeSigner(&dsseSigner{signer: signer})
+	if err != nil {
+		return err
+	}
+
+	payload, err := e.envelope.DecodeB64Payload()
+	if err != nil {
+		return err
+	}
+
+	env, err := es.SignPayload(context.Background(), e.envelope.PayloadType, payload)
+	if err != nil {
+		return err
+	}
+
+	e.envelope = env
+	return nil
+}
+
+/*
+dsseSigner adapts a Signer to the signer interface of the dsse package.
+*/
+type dsseSigner struct {
+	signer Signer
+}
+
+func (s *dsseSigner) KeyID() (string, error) {
+	return s.signer.KeyID(), nil
+}
+
+func (s *dsseSigner) Sign(_ context.Context, data []byte) ([]byte, error) {
+	sig, err := s.signer.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	if sig.Sig == "" {
+		return nil, fmt.Errorf("signer '%s' does not support DSSE envelopes", s.signer.KeyID())
+	}
+	return hex.DecodeString(sig.Sig)
+}
+
 func (e *Envelope) Sigs() []Signature {
 	sigs := []Signature{}
 	for _, s := range e.envelope.Signatures {
@@ -137,6 +192,9 @@ func (e *Envelope) Dump(path string) error {
 }
 
 func getSignerVerifierFromKey(key Key) (dsse.SignerVerifier, error) {
+	if isGPGKey(key) {
+		return nil, fmt.Errorf("%w: gpg keys can only verify metablocks", ErrUnsupportedKeyType)
+	}
 	sslibKey := getSSLibKeyFromKey(key)
 
 	switch sslibKey.KeyType {
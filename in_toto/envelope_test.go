@@ -1,6 +1,9 @@
 package in_toto
 
 import (
+	"context"
+	"encoding/base64"
+	"path/filepath"
 	"testing"
 
 	"github.com/secure-systems-lab/go-securesystemslib/dsse"
@@ -241,6 +244,65 @@ func TestEnvelopeSign(t *testing.T) {
 	})
 }
 
+func TestEnvelopeLinkRoundTrip(t *testing.T) {
+	var key Key
+	if err := key.LoadKey("carol", "ed25519", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+
+	link := Link{
+		Type:      "link",
+		Name:      "write-code",
+		Materials: map[string]HashObj{},
+		Products: map[string]HashObj{
+			"foo.py": {"sha256": "74dc3727c6e89308b39e4dfedf787e37841198b1fa165a27c013544a60502549"},
+		},
+		ByProducts:  map[string]interface{}{},
+		Command:     []string{"vi", "foo.py"},
+		Environment: map[string]interface{}{},
+	}
+
+	env := &Envelope{}
+	if err := env.SetPayload(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "write-code.be6371bc.link")
+	if err := env.Dump(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedEnv, ok := loaded.(*Envelope)
+	assert.True(t, ok, "loaded metadata must be envelope")
+	assert.Equal(t, PayloadType, loadedEnv.envelope.PayloadType)
+	assert.Equal(t, link, loadedEnv.GetPayload())
+	assert.Nil(t, loadedEnv.VerifySignature(key))
+
+	// The signature covers the pre-authentication encoding of the payload,
+	// not the raw payload bytes
+	verifier, err := getSignerVerifierFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := loadedEnv.envelope.DecodeB64Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(loadedEnv.envelope.Signatures[0].Sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, verifier.Verify(context.Background(), dsse.PAE(PayloadType, payload), sig))
+	assert.NotNil(t, verifier.Verify(context.Background(), payload, sig))
+}
+
 func TestEnvelopeGetSignatureForKeyID(t *testing.T) {
 	env := &Envelope{
 		envelope: &dsse.Envelope{
@@ -0,0 +1,274 @@
+package in_toto
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/shibumi/go-pathspec"
+)
+
+/*
+gitCommitHashType is the hash type, under which RecordArtifactsFromGitTree
+records the commit id of a submodule.
+*/
+const gitCommitHashType = "gitCommit"
+
+/*
+gitTreeEntry is a blob or a submodule of a git tree, along with its repository
+relative path.
+*/
+type gitTreeEntry struct {
+	object.TreeEntry
+	path string
+}
+
+/*
+RecordArtifactsFromGit records the files and submodules of the tree of the
+passed tree-ish, e.g. a branch, a tag, a commit SHA or a tree SHA, of the
+possibly bare git repository at repoPath with the passed hash algorithms.  It
+is a shorthand for RecordArtifactsFromGitTree with RecordSubmodules set.
+*/
+func RecordArtifactsFromGit(repoPath string, treeish string, hashAlgorithms []string) (map[string]HashObj, error) {
+	return RecordArtifactsFromGitTree(repoPath, treeish, "", RecordArtifactsOptions{
+		HashAlgorithms:   hashAlgorithms,
+		RecordSubmodules: true,
+	})
+}
+
+/*
+RecordArtifactsFromGitTree is a wrapper around RecordArtifactsFromGitTreeCtx,
+which uses a background context.
+*/
+func RecordArtifactsFromGitTree(repoDir string, revision string, subdir string, opts RecordArtifactsOptions) (map[string]HashObj, error) {
+	return RecordArtifactsFromGitTreeCtx(context.Background(), repoDir, revision, subdir, opts)
+}
+
+/*
+RecordArtifactsFromGitTreeCtx records the files of the tree of the passed git
+revision, e.g. a branch, a tag, a commit SHA or a tree SHA, of the possibly
+bare repository at repoDir.  The repository is read in-process, so that no git
+executable is required and nothing is checked out.  The committed file
+contents are hashed, rather than the files of the working tree, so that neither
+untracked or modified files nor the .git directory end up in the recorded
+artifacts.  If subdir is not empty, only the files beneath that repository
+relative directory are recorded.  The returned map has the same format as the
+one returned by RecordArtifacts, keyed by the slash separated repository
+relative paths of the files.
+
+HashAlgorithms, GitignorePatterns, LStripPaths, LineNormalization and
+SkipBinaryNormalization of the passed options have the same meaning as for
+RecordArtifactsWithOptions.  Symlinks in the tree are recorded as entries of
+their own, hashed over their target, as with RecordSymlinks.  Submodules are
+skipped, unless RecordSubmodules is set, in which case they are recorded with
+the id of the commit that the tree refers to as "gitCommit" hash, e.g.:
+
+	{
+		"vendor/lib": {
+			"gitCommit": <hex representation of the commit id>
+		},
+		...
+	}
+
+The other options do not apply.  If subdir does not exist in the tree, an
+error is returned.  Once the passed context is done, recording is aborted and
+an error is returned.
+*/
+func RecordArtifactsFromGitTreeCtx(ctx context.Context, repoDir string, revision string, subdir string, opts RecordArtifactsOptions) (map[string]HashObj, error) {
+	if err := ValidateHashAlgorithms(opts.HashAlgorithms); err != nil {
+		return nil, err
+	}
+
+	// Revisions must not be mistaken for options, which git refuses as well
+	if strings.HasPrefix(revision, "-") {
+		return nil, fmt.Errorf("invalid git revision '%s'", revision)
+	}
+	repo, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open git repository '%s': %w", repoDir, err)
+	}
+	tree, err := resolveGitTree(repo, revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve git revision '%s': %w", revision, err)
+	}
+
+	root := object.TreeEntry{Mode: filemode.Dir, Hash: tree.Hash}
+	if subdir = strings.Trim(path.Clean("/"+subdir), "/"); subdir != "" {
+		entry, err := tree.FindEntry(subdir)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' not found in git revision '%s'", subdir, revision)
+		}
+		root = *entry
+	}
+	entries, err := listGitTree(ctx, repo, subdir, root, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	evalArtifacts := make(map[string]HashObj, len(entries))
+	unstripped := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if entry.Mode == filemode.Submodule && !opts.RecordSubmodules {
+			continue
+		}
+		ignore, err := gitTreePathIgnored(opts.GitignorePatterns, entry.path)
+		if err != nil {
+			return nil, err
+		}
+		if ignore {
+			continue
+		}
+		key := lStripPath(entry.path, opts.LStripPaths)
+		if _, exists := evalArtifacts[key]; exists {
+			return nil, fmt.Errorf("left stripping has resulted in non unique dictionary key: %s (%s and %s)", key, unstripped[key], entry.path)
+		}
+		unstripped[key] = entry.path
+
+		// Submodule commits are not contained in the repository
+		if entry.Mode == filemode.Submodule {
+			evalArtifacts[key] = HashObj{gitCommitHashType: entry.Hash.String()}
+			continue
+		}
+		hashes, err := hashGitBlob(repo, entry, opts)
+		if err != nil {
+			return nil, err
+		}
+		evalArtifacts[key] = hashes
+	}
+
+	return evalArtifacts, nil
+}
+
+/*
+resolveGitTree returns the tree of the passed revision.  Besides revisions,
+which resolve to commits, the full or abbreviated ids of trees are accepted as
+well.
+*/
+func resolveGitTree(repo *git.Repository, revision string) (*object.Tree, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		if tree, treeErr := findGitTree(repo, revision); treeErr == nil {
+			return tree, nil
+		}
+		return nil, err
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+/*
+findGitTree returns the tree with the passed id, which may be abbreviated to
+no less than four hex digits, like git does.  An error is returned if no tree,
+or more than one, matches.
+*/
+func findGitTree(repo *git.Repository, id string) (*object.Tree, error) {
+	if plumbing.IsHash(id) {
+		return repo.TreeObject(plumbing.NewHash(id))
+	}
+	if len(id) < 4 || strings.Trim(id, "0123456789abcdefABCDEF") != "" {
+		return nil, plumbing.ErrObjectNotFound
+	}
+	id = strings.ToLower(id)
+	trees, err := repo.TreeObjects()
+	if err != nil {
+		return nil, err
+	}
+	var found *object.Tree
+	err = trees.ForEach(func(tree *object.Tree) error {
+		if !strings.HasPrefix(tree.Hash.String(), id) {
+			return nil
+		}
+		if found != nil {
+			return fmt.Errorf("ambiguous git tree id '%s'", id)
+		}
+		found = tree
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, plumbing.ErrObjectNotFound
+	}
+	return found, nil
+}
+
+/*
+listGitTree appends the passed entry at entryPath to entries, or, if it is a
+tree, all blobs and submodules beneath it.  Unlike object.TreeWalker, missing
+trees are reported instead of being skipped, so that no files are silently
+left out.
+*/
+func listGitTree(ctx context.Context, repo *git.Repository, entryPath string, entry object.TreeEntry, entries []gitTreeEntry) ([]gitTreeEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	switch entry.Mode {
+	case filemode.Dir:
+		tree, err := repo.TreeObject(entry.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read git tree '%s': %w", entryPath, err)
+		}
+		for _, child := range tree.Entries {
+			entries, err = listGitTree(ctx, repo, path.Join(entryPath, child.Name), child, entries)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return entries, nil
+	case filemode.Regular, filemode.Deprecated, filemode.Executable, filemode.Symlink, filemode.Submodule:
+		return append(entries, gitTreeEntry{TreeEntry: entry, path: entryPath}), nil
+	default:
+		return entries, nil
+	}
+}
+
+/*
+hashGitBlob hashes the contents of the blob of the passed entry.  Symlinks are
+hashed over their target, which is never line normalized.
+*/
+func hashGitBlob(repo *git.Repository, entry gitTreeEntry, opts RecordArtifactsOptions) (HashObj, error) {
+	blob, err := repo.BlobObject(entry.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read git blob '%s': %w", entry.path, err)
+	}
+	content, err := blob.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read git blob '%s': %w", entry.path, err)
+	}
+	defer content.Close()
+	return hashReader(content, opts.HashAlgorithms,
+		lineEndingsFor(opts.LineNormalization && entry.Mode != filemode.Symlink, opts.SkipBinaryNormalization))
+}
+
+/*
+gitTreePathIgnored returns true if the passed repository relative path, or any
+of its parent directories, matches the passed gitignore patterns, like files
+beneath excluded directories are not walked by RecordArtifacts.
+*/
+func gitTreePathIgnored(gitignorePatterns []string, entryPath string) (bool, error) {
+	if len(gitignorePatterns) == 0 {
+		return false, nil
+	}
+	for p := entryPath; p != "." && p != "/"; p = path.Dir(p) {
+		ignore, err := pathspec.GitIgnore(gitignorePatterns, p)
+		if err != nil {
+			return false, err
+		}
+		if ignore {
+			return true, nil
+		}
+	}
+	return false, nil
+}
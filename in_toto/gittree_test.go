@@ -0,0 +1,185 @@
+package in_toto
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordArtifactsFromGitTree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repoDir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", repoDir, "-c", "user.name=test",
+			"-c", "user.email=test@example.com", "-c", "commit.gpgsign=false", "-c", "core.autocrlf=false"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %s: %s", args[0], err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name string, content string) {
+		path := filepath.Join(repoDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sha256Hex := func(content string) HashObj {
+		return HashObj{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte(content)))}
+	}
+
+	git("init", "-q")
+	write("foo.py", "print('foo')\r\n")
+	write("src/bar.c", "int bar;\n")
+	write("src/build/bar.o", "object")
+	if err := os.Symlink("foo.py", filepath.Join(repoDir, "link.py")); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+	commit := git("rev-parse", "HEAD")
+	git("tag", "v1")
+
+	// Changes of the working tree and later commits are not recorded
+	write("foo.py", "print('modified')\n")
+	write("untracked.txt", "junk")
+	write("src/new.c", "int new;\n")
+	git("add", "src/new.c")
+	git("commit", "-q", "-m", "second")
+
+	opts := RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}}
+	expected := map[string]HashObj{
+		"foo.py":          sha256Hex("print('foo')\r\n"),
+		"src/bar.c":       sha256Hex("int bar;\n"),
+		"src/build/bar.o": sha256Hex("object"),
+		"link.py":         sha256Hex("foo.py"),
+	}
+	for _, revision := range []string{commit, commit[:12], "v1", "HEAD~1"} {
+		artifacts, err := RecordArtifactsFromGitTree(repoDir, revision, "", opts)
+		assert.Nil(t, err, revision)
+		assert.Equal(t, expected, artifacts, revision)
+	}
+
+	// Subdirectories, gitignore patterns and left stripping
+	opts.GitignorePatterns = []string{"build"}
+	opts.LStripPaths = []string{"src/"}
+	artifacts, err := RecordArtifactsFromGitTree(repoDir, "HEAD", "src", opts)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{
+		"bar.c": sha256Hex("int bar;\n"),
+		"new.c": sha256Hex("int new;\n"),
+	}, artifacts)
+
+	// Line normalization applies to the committed contents
+	artifacts, err = RecordArtifactsFromGitTree(repoDir, "v1", "foo.py",
+		RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}, LineNormalization: true})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{"foo.py": sha256Hex("print('foo')\n")}, artifacts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = RecordArtifactsFromGitTreeCtx(ctx, repoDir, "HEAD", "", opts)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = RecordArtifactsFromGitTree(t.TempDir(), "HEAD", "", opts)
+	assert.ErrorContains(t, err, "failed to open git repository")
+	_, err = RecordArtifactsFromGitTree(repoDir, "does-not-exist", "", opts)
+	assert.ErrorContains(t, err, "failed to resolve git revision 'does-not-exist'")
+	_, err = RecordArtifactsFromGitTree(repoDir, "--all", "", opts)
+	assert.ErrorContains(t, err, "invalid git revision")
+	_, err = RecordArtifactsFromGitTree(repoDir, "HEAD", "does-not-exist", opts)
+	assert.ErrorContains(t, err, "'does-not-exist' not found")
+	_, err = RecordArtifactsFromGitTree(repoDir, "HEAD", "", RecordArtifactsOptions{HashAlgorithms: []string{"md5"}})
+	assert.ErrorIs(t, err, ErrUnsupportedHashAlgorithm)
+}
+
+func TestRecordArtifactsFromGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	tmpDir := t.TempDir()
+	workDir := filepath.Join(tmpDir, "work")
+	bareDir := filepath.Join(tmpDir, "bare.git")
+	git := func(dir string, args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test",
+			"-c", "user.email=test@example.com", "-c", "commit.gpgsign=false", "-c", "core.autocrlf=false"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %s: %s", args[0], err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := os.MkdirAll(filepath.Join(workDir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"README.md": "# foo\n", "src/foo.c": "int foo;\n"} {
+		if err := os.WriteFile(filepath.Join(workDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(workDir, "init", "-q", "-b", "main")
+	git(workDir, "add", "-A")
+	git(workDir, "commit", "-q", "-m", "files")
+
+	// Compare the recorded blobs with the checked out files
+	hashAlgorithms := []string{"sha256", "sha512"}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(workDir); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := RecordArtifactsWithOptions([]string{"."}, RecordArtifactsOptions{
+		HashAlgorithms: hashAlgorithms, GitignorePatterns: []string{".git"}})
+	if err := os.Chdir(cwd); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, err)
+	assert.Len(t, expected, 2)
+
+	// Add a submodule without fetching it
+	submoduleCommit := strings.Repeat("ab", 20)
+	git(workDir, "update-index", "--add", "--cacheinfo", "160000,"+submoduleCommit+",vendor/lib")
+	git(workDir, "commit", "-q", "-m", "submodule")
+	git(workDir, "tag", "-a", "-m", "release", "v1")
+	git(tmpDir, "clone", "-q", "--bare", workDir, bareDir)
+
+	artifacts, err := RecordArtifactsFromGit(bareDir, "HEAD~1", hashAlgorithms)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, artifacts)
+
+	expected["vendor/lib"] = HashObj{"gitCommit": submoduleCommit}
+	tree := git(bareDir, "rev-parse", "HEAD^{tree}")
+	for _, treeish := range []string{"HEAD", "main", "v1", tree, tree[:12]} {
+		artifacts, err = RecordArtifactsFromGit(bareDir, treeish, hashAlgorithms)
+		assert.Nil(t, err, treeish)
+		assert.Equal(t, expected, artifacts, treeish)
+	}
+
+	// RecordArtifactsFromGitTree skips submodules, unless RecordSubmodules is set
+	artifacts, err = RecordArtifactsFromGitTree(bareDir, "HEAD", "", RecordArtifactsOptions{HashAlgorithms: hashAlgorithms})
+	assert.Nil(t, err)
+	assert.NotContains(t, artifacts, "vendor/lib")
+	artifacts, err = RecordArtifactsFromGitTree(bareDir, "HEAD", "vendor", RecordArtifactsOptions{
+		HashAlgorithms:   hashAlgorithms,
+		LStripPaths:      []string{"vendor/"},
+		RecordSubmodules: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{"lib": {"gitCommit": submoduleCommit}}, artifacts)
+
+	_, err = RecordArtifactsFromGit(bareDir, "does-not-exist", hashAlgorithms)
+	assert.ErrorContains(t, err, "failed to resolve git revision 'does-not-exist'")
+}
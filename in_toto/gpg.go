@@ -0,0 +1,708 @@
+package in_toto
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+/*
+gpgRSAScheme is the scheme of GPG keys, i.e. the signing method of GPG keys in
+the in-toto reference implementation.
+*/
+const gpgRSAScheme = "pgp+rsa-pkcsv1.5"
+
+// gpgCommand is the gpg executable used by the Signer returned by NewGPGSigner
+var gpgCommand = "gpg"
+
+// ErrGPGSignature is returned if a GPG signature cannot be parsed or has an unsupported format
+var ErrGPGSignature = errors.New("unsupported gpg signature")
+
+// ErrGPGKeyExpired is returned when verifying with a GPG key whose validity period has passed
+var ErrGPGKeyExpired = errors.New("gpg key expired")
+
+/*
+gpgHashAlgorithms maps the OpenPGP hash algorithm ids, see RFC 4880, section
+9.4, to the supported hash functions.
+*/
+var gpgHashAlgorithms = map[byte]crypto.Hash{
+	8:  crypto.SHA256,
+	9:  crypto.SHA384,
+	10: crypto.SHA512,
+	11: crypto.SHA224,
+}
+
+/*
+gpgHashesSHA2 is the only hash algorithm identifier that the format of GPG keys
+of securesystemslib knows, standing for the SHA-2 family.
+*/
+const gpgHashesSHA2 = "pgp+SHA2"
+
+/*
+GPGKeyInfo holds the fields of a GPG key that the format of GPG keys of
+securesystemslib, which the in-toto reference implementation uses, has in
+addition to those of other keys.  Keys with GPGKeyInfo are marshaled to and
+unmarshaled from that format, e.g.:
+
+	{
+		"keyid": <fingerprint>,
+		"type": "rsa",
+		"method": "pgp+rsa-pkcsv1.5",
+		"hashes": ["pgp+SHA2"],
+		"keyval": {"private": "", "public": {"e": <hex>, "n": <hex>}},
+		"creation_time": <seconds since the epoch>,
+		"validity_period": <seconds>,
+		"subkeys": {<fingerprint>: <key in the same format>, ...}
+	}
+
+The fingerprint is recomputed from the creation time and the public key, see
+ComputeKeyID.  Subkeys have no subkeys of their own.
+*/
+type GPGKeyInfo struct {
+	// Hashes are the hash algorithms the key signs with, i.e. "pgp+SHA2"
+	Hashes []string
+	// CreationTime is the creation time of the key in seconds since the epoch
+	CreationTime int64
+	// ValidityPeriod is the lifetime of the key in seconds, or 0 if it does not expire
+	ValidityPeriod int64
+	// E and N are the hex encoded exponent and modulus of the RSA public key
+	E string
+	N string
+	// Subkeys are the RSA signing subkeys of a primary key, keyed by their keyid
+	Subkeys map[string]Key
+}
+
+// gpgKeyJSON is the JSON representation of GPG keys, see GPGKeyInfo
+type gpgKeyJSON struct {
+	KeyID          string         `json:"keyid"`
+	Type           string         `json:"type"`
+	Method         string         `json:"method"`
+	Hashes         []string       `json:"hashes"`
+	KeyVal         gpgKeyValJSON  `json:"keyval"`
+	CreationTime   int64          `json:"creation_time,omitempty"`
+	ValidityPeriod int64          `json:"validity_period,omitempty"`
+	Subkeys        map[string]Key `json:"subkeys,omitempty"`
+}
+
+// gpgKeyValJSON is the JSON representation of the key value of GPG keys
+type gpgKeyValJSON struct {
+	Private string `json:"private"`
+	Public  struct {
+		E string `json:"e"`
+		N string `json:"n"`
+	} `json:"public"`
+}
+
+/*
+isGPGKey returns true if the passed key is a GPG key, i.e. it holds an OpenPGP
+public key and verifies GPG signatures.
+*/
+func isGPGKey(key Key) bool {
+	return key.Scheme == gpgRSAScheme
+}
+
+/*
+marshalGPGKey returns the JSON representation of the passed GPG key in the
+format of securesystemslib, see GPGKeyInfo.
+*/
+func marshalGPGKey(k Key) ([]byte, error) {
+	if k.GPG == nil {
+		return nil, fmt.Errorf("%w: gpg key parameters", ErrEmptyKeyField)
+	}
+	keyJSON := gpgKeyJSON{
+		KeyID:          k.KeyID,
+		Type:           k.KeyType,
+		Method:         k.Scheme,
+		Hashes:         k.GPG.Hashes,
+		CreationTime:   k.GPG.CreationTime,
+		ValidityPeriod: k.GPG.ValidityPeriod,
+		Subkeys:        k.GPG.Subkeys,
+	}
+	keyJSON.KeyVal.Private = k.KeyVal.Private
+	keyJSON.KeyVal.Public.E = k.GPG.E
+	keyJSON.KeyVal.Public.N = k.GPG.N
+	return json.Marshal(keyJSON)
+}
+
+// unmarshalGPGKey parses the JSON representation of a GPG key, see GPGKeyInfo.
+func unmarshalGPGKey(data []byte) (Key, error) {
+	var keyJSON gpgKeyJSON
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&keyJSON); err != nil {
+		return Key{}, err
+	}
+	return Key{
+		KeyID:   keyJSON.KeyID,
+		KeyType: keyJSON.Type,
+		Scheme:  keyJSON.Method,
+		KeyVal:  KeyVal{Private: keyJSON.KeyVal.Private},
+		GPG: &GPGKeyInfo{
+			Hashes:         keyJSON.Hashes,
+			CreationTime:   keyJSON.CreationTime,
+			ValidityPeriod: keyJSON.ValidityPeriod,
+			E:              keyJSON.KeyVal.Public.E,
+			N:              keyJSON.KeyVal.Public.N,
+			Subkeys:        keyJSON.Subkeys,
+		},
+	}, nil
+}
+
+/*
+validateGPGKey validates a GPG key, as validateKey does for other keys, and
+its subkeys.
+*/
+func validateGPGKey(key Key) error {
+	if key.KeyType != rsaKeyType {
+		return fmt.Errorf("%w: gpg keys must be RSA keys", ErrUnsupportedKeyType)
+	}
+	if key.GPG == nil {
+		return fmt.Errorf("%w: gpg key parameters", ErrEmptyKeyField)
+	}
+	if _, err := key.GPG.rsaPublicKey(); err != nil {
+		return err
+	}
+	for _, subkey := range key.GPG.Subkeys {
+		if subkey.GPG != nil && len(subkey.GPG.Subkeys) > 0 {
+			return fmt.Errorf("%w: subkey '%s' has subkeys", ErrInvalidKey, subkey.KeyID)
+		}
+		if err := validatePublicKey(subkey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rsaPublicKey returns the RSA public key of the GPG key.
+func (info *GPGKeyInfo) rsaPublicKey() (*rsa.PublicKey, error) {
+	if err := validateHexString(info.E); err != nil {
+		return nil, err
+	}
+	if err := validateHexString(info.N); err != nil {
+		return nil, err
+	}
+	n, _ := new(big.Int).SetString(info.N, 16)
+	e, _ := new(big.Int).SetString(info.E, 16)
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: invalid rsa exponent", ErrInvalidKey)
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+/*
+gpgFingerprint returns the fingerprint of the passed GPG key, i.e. of the
+OpenPGP version 4 RSA public key with its creation time and public key, see RFC
+4880, section 12.2.
+*/
+func gpgFingerprint(info *GPGKeyInfo) (string, error) {
+	if info == nil {
+		return "", fmt.Errorf("%w: gpg key parameters", ErrEmptyKeyField)
+	}
+	publicKey, err := info.rsaPublicKey()
+	if err != nil {
+		return "", err
+	}
+	pgpKey := packet.NewRSAPublicKey(time.Unix(info.CreationTime, 0), publicKey)
+	return hex.EncodeToString(pgpKey.Fingerprint[:]), nil
+}
+
+/*
+LoadGPGKey loads an OpenPGP public key as exported with "gpg --export", armored
+or not, as key that verifies signatures created with GPG, e.g. links of
+functionaries that sign with GPG in the in-toto reference implementation.  The
+primary key must be an RSA key, whose fingerprint becomes the keyid.  Its RSA
+subkeys that may sign are loaded as subkeys, so that signatures created with
+them, as GPG does by default, verify with the key, too.  Revoked subkeys are
+skipped.  The key is stored as in securesystemslib, see GPGKeyInfo.  GPG keys
+cannot sign, see NewGPGSigner, and only sign Metablocks, not DSSE envelopes.
+*/
+func (k *Key) LoadGPGKey(path string) error {
+	keyFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer keyFile.Close()
+
+	err = k.LoadGPGKeyReader(keyFile)
+	if err != nil {
+		return err
+	}
+
+	return keyFile.Close()
+}
+
+// LoadGPGKeyReader loads the GPG key from a supplied reader. The logic matches LoadGPGKey otherwise.
+func (k *Key) LoadGPGKeyReader(r io.Reader) error {
+	if r == nil {
+		return ErrNoPEMBlock
+	}
+	keyBytes, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	entity, err := readGPGEntity(keyBytes)
+	if err != nil {
+		return err
+	}
+
+	var lifetime *uint32
+	for _, identity := range entity.Identities {
+		if identity.SelfSignature != nil && (lifetime == nil || identity.SelfSignature.IsPrimaryId != nil && *identity.SelfSignature.IsPrimaryId) {
+			lifetime = identity.SelfSignature.KeyLifetimeSecs
+		}
+	}
+	gpgKey := newGPGKey(entity.PrimaryKey, lifetime)
+	for _, subkey := range entity.Subkeys {
+		if _, ok := subkey.PublicKey.PublicKey.(*rsa.PublicKey); !ok ||
+			len(subkey.Revocations) > 0 ||
+			subkey.Sig.FlagsValid && !subkey.Sig.FlagSign {
+			continue
+		}
+		if gpgKey.GPG.Subkeys == nil {
+			gpgKey.GPG.Subkeys = map[string]Key{}
+		}
+		gpgSubkey := newGPGKey(subkey.PublicKey, subkey.Sig.KeyLifetimeSecs)
+		gpgKey.GPG.Subkeys[gpgSubkey.KeyID] = gpgSubkey
+	}
+	if err := validatePublicKey(gpgKey); err != nil {
+		return err
+	}
+	*k = gpgKey
+	return nil
+}
+
+/*
+newGPGKey returns the GPG key for the passed OpenPGP RSA public key, which
+expires after the passed lifetime, if any.
+*/
+func newGPGKey(pgpKey *packet.PublicKey, lifetime *uint32) Key {
+	publicKey := pgpKey.PublicKey.(*rsa.PublicKey)
+	info := &GPGKeyInfo{
+		Hashes:       []string{gpgHashesSHA2},
+		CreationTime: pgpKey.CreationTime.Unix(),
+		// Hex encoded like the octets of the MPIs, as in securesystemslib
+		E: hex.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		N: hex.EncodeToString(publicKey.N.Bytes()),
+	}
+	if lifetime != nil {
+		info.ValidityPeriod = int64(*lifetime)
+	}
+	return Key{
+		KeyID:   hex.EncodeToString(pgpKey.Fingerprint[:]),
+		KeyType: rsaKeyType,
+		Scheme:  gpgRSAScheme,
+		GPG:     info,
+	}
+}
+
+/*
+readGPGEntity returns the first OpenPGP entity in the passed armored or binary
+key bytes, if its primary key is an RSA key.
+*/
+func readGPGEntity(keyBytes []byte) (*openpgp.Entity, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyBytes))
+	if err != nil {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(keyBytes))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gpg key: %w", err)
+	}
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("failed to read gpg key: no key found")
+	}
+	if _, ok := entities[0].PrimaryKey.PublicKey.(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("%w: gpg keys must be RSA keys", ErrUnsupportedKeyType)
+	}
+	return entities[0], nil
+}
+
+/*
+gpgVerifier is the Verifier implementation for GPG keys.
+*/
+type gpgVerifier struct {
+	keyID         string
+	publicKey     *rsa.PublicKey
+	expires       time.Time
+	referenceTime time.Time
+}
+
+/*
+newGPGVerifier returns a Verifier that verifies GPG signatures with the passed
+key or, if its keyid is the one of a subkey, with that subkey.  The expiration
+of the key is checked against the passed reference time, or against the
+current time, if it is zero.
+*/
+func newGPGVerifier(key Key, referenceTime time.Time) (Verifier, error) {
+	if err := validateGPGKey(key); err != nil {
+		return nil, err
+	}
+	info := key.GPG
+	if subkey, ok := info.Subkeys[key.KeyID]; ok {
+		info = subkey.GPG
+	}
+	publicKey, err := info.rsaPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	v := &gpgVerifier{keyID: key.KeyID, publicKey: publicKey, referenceTime: referenceTime}
+	if info.ValidityPeriod > 0 {
+		v.expires = time.Unix(info.CreationTime+info.ValidityPeriod, 0)
+	}
+	return v, nil
+}
+
+func (v *gpgVerifier) KeyID() string {
+	return v.keyID
+}
+
+/*
+Verify verifies a GPG signature over the passed data.  As in the in-toto
+reference implementation, the signed hash covers the data, the OtherHeaders of
+the signature, i.e. the signature packet fields up to the hashed subpackets,
+and the OpenPGP version 4 trailer, see RFC 4880, section 5.2.4.  Signatures of
+keys that expired before the reference time of the Verifier are rejected with
+an error wrapping ErrGPGKeyExpired.
+*/
+func (v *gpgVerifier) Verify(data []byte, sig Signature) error {
+	referenceTime := v.referenceTime
+	if referenceTime.IsZero() {
+		referenceTime = time.Now()
+	}
+	if !v.expires.IsZero() && referenceTime.After(v.expires) {
+		return fmt.Errorf("%w: key '%s' expired at %s", ErrGPGKeyExpired, v.keyID, v.expires.UTC().Format(time.RFC3339))
+	}
+	headers, err := hex.DecodeString(sig.OtherHeaders)
+	if err != nil {
+		return err
+	}
+	sigBytes, err := hex.DecodeString(sig.GPGSignature)
+	if err != nil {
+		return err
+	}
+	if len(headers) < 6 || headers[0] != 4 {
+		return fmt.Errorf("%w: only version 4 signatures are supported", ErrGPGSignature)
+	}
+	hash, ok := gpgHashAlgorithms[headers[3]]
+	if !ok {
+		return fmt.Errorf("%w: hash algorithm '%d'", ErrGPGSignature, headers[3])
+	}
+
+	h := hash.New()
+	h.Write(data)
+	h.Write(headers)
+	h.Write([]byte{0x04, 0xff})
+	if err := binary.Write(h, binary.BigEndian, uint32(len(headers))); err != nil {
+		return err
+	}
+
+	// Leading zero octets of the signature are not encoded in the MPI
+	if len(sigBytes) < v.publicKey.Size() {
+		sigBytes = append(make([]byte, v.publicKey.Size()-len(sigBytes)), sigBytes...)
+	}
+	if err := rsa.VerifyPKCS1v15(v.publicKey, hash, h.Sum(nil), sigBytes); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
+/*
+gpgSigner is the Signer implementation that signs with a local gpg.
+*/
+type gpgSigner struct {
+	keyID   string
+	homedir string
+}
+
+/*
+NewGPGSigner returns a Signer that signs with the GPG key identified by the
+passed keyid, or any other user id that gpg understands, using the gpg
+executable and its agent, e.g. with a key on a hardware token.  If homedir is
+not empty, it is passed to gpg as "--homedir".  The created signatures have the
+same format as those created by the in-toto reference implementation, and are
+verified with the public key loaded with LoadGPGKey.
+
+Like gpg, the Signer signs with the most recently created valid signing subkey
+of the key, or with the primary key if there is none, unless the user id is a
+keyid or fingerprint suffixed with "!", which selects that exact (sub)key.  The
+keyid of the Signer is the fingerprint of the (sub)key that signs.
+*/
+func NewGPGSigner(keyID string, homedir string) (Signer, error) {
+	s := &gpgSigner{homedir: homedir}
+	out, err := s.gpg(nil, "--with-colons", "--fingerprint", "--with-subkey-fingerprint",
+		"--list-keys", keyID)
+	if err != nil {
+		return nil, err
+	}
+	s.keyID = gpgSigningKeyID(string(out), keyID)
+	if s.keyID == "" {
+		return nil, fmt.Errorf("no gpg signing key found for '%s'", keyID)
+	}
+	return s, nil
+}
+
+/*
+gpgSigningKeyID returns the fingerprint of the key that signs for the passed
+user id, among the keys of the first primary key listed in the passed output of
+"gpg --with-colons --list-keys", see NewGPGSigner, or an empty string if none
+of them may sign.
+*/
+func gpgSigningKeyID(listing string, userID string) string {
+	type gpgListedKey struct {
+		fingerprint string
+		created     int64
+		canSign     bool
+	}
+	var primary *gpgListedKey
+	var subkeys []*gpgListedKey
+	var current *gpgListedKey
+records:
+	for _, line := range strings.Split(listing, "\n") {
+		// See doc/DETAILS of gpg for the fields of the records
+		fields := strings.Split(line, ":")
+		switch {
+		case (fields[0] == "pub" || fields[0] == "sub") && len(fields) > 11:
+			if fields[0] == "pub" && primary != nil {
+				break records
+			}
+			created, _ := strconv.ParseInt(fields[5], 10, 64)
+			current = &gpgListedKey{
+				created: created,
+				// Expired, revoked, disabled and invalid keys cannot sign
+				canSign: strings.Contains(fields[11], "s") && !strings.ContainsAny(fields[1], "erdi"),
+			}
+			if fields[0] == "pub" {
+				primary = current
+			} else {
+				subkeys = append(subkeys, current)
+			}
+		case fields[0] == "fpr" && len(fields) > 9 && current != nil && current.fingerprint == "":
+			current.fingerprint = strings.ToLower(fields[9])
+		}
+	}
+	if primary == nil {
+		return ""
+	}
+
+	if exact, ok := strings.CutSuffix(strings.ToLower(userID), "!"); ok {
+		exact = strings.TrimPrefix(exact, "0x")
+		for _, key := range append([]*gpgListedKey{primary}, subkeys...) {
+			if key.canSign && key.fingerprint != "" && strings.HasSuffix(key.fingerprint, exact) {
+				return key.fingerprint
+			}
+		}
+		return ""
+	}
+	var signing *gpgListedKey
+	for _, subkey := range subkeys {
+		if subkey.canSign && (signing == nil || subkey.created >= signing.created) {
+			signing = subkey
+		}
+	}
+	if signing == nil && primary.canSign {
+		signing = primary
+	}
+	if signing == nil {
+		return ""
+	}
+	return signing.fingerprint
+}
+
+func (s *gpgSigner) KeyID() string {
+	return s.keyID
+}
+
+/*
+Sign creates a detached GPG signature over the passed data with the (sub)key
+of the Signer.  As in the in-toto reference implementation, the short keyid is
+omitted from the signature once its full keyid is known.
+*/
+func (s *gpgSigner) Sign(data []byte) (Signature, error) {
+	out, err := s.gpg(data, "--detach-sign", "--digest-algo", "SHA256",
+		"--local-user", s.keyID+"!")
+	if err != nil {
+		return Signature{}, err
+	}
+	sig, err := parseGPGSignature(out)
+	if err != nil {
+		return Signature{}, err
+	}
+	// Older versions of gpg only record the short keyid of the signer
+	if sig.KeyID == "" && strings.HasSuffix(s.keyID, sig.ShortKeyID) {
+		sig.KeyID = s.keyID
+	}
+	if sig.KeyID != "" {
+		sig.ShortKeyID = ""
+	}
+	return sig, nil
+}
+
+/*
+gpg runs the gpg executable in batch mode with the passed arguments and input,
+and returns its output.
+*/
+func (s *gpgSigner) gpg(input []byte, args ...string) ([]byte, error) {
+	args = append([]string{"--batch", "--no-tty"}, args...)
+	if s.homedir != "" {
+		args = append([]string{"--homedir", s.homedir}, args...)
+	}
+	cmd := exec.Command(gpgCommand, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gpg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+/*
+parseGPGSignature parses a binary OpenPGP signature packet, see RFC 4880,
+section 5.2.3, of a version 4 RSA signature over binary data into a Signature,
+as in the in-toto reference implementation.  The keyid is the issuer
+fingerprint of the signature, if present.
+*/
+func parseGPGSignature(sigBytes []byte) (Signature, error) {
+	p, err := packet.Read(bytes.NewReader(sigBytes))
+	if err != nil {
+		return Signature{}, fmt.Errorf("%w: %w", ErrGPGSignature, err)
+	}
+	parsed, ok := p.(*packet.Signature)
+	if !ok {
+		return Signature{}, fmt.Errorf("%w: not a version 4 signature packet", ErrGPGSignature)
+	}
+	if parsed.SigType != packet.SigTypeBinary || parsed.PubKeyAlgo != packet.PubKeyAlgoRSA {
+		return Signature{}, fmt.Errorf("%w: only RSA signatures over binary data are supported",
+			ErrGPGSignature)
+	}
+
+	// HashSuffix holds the signature packet fields up to the hashed
+	// subpackets, followed by the 6 octet trailer
+	headers := parsed.HashSuffix[:len(parsed.HashSuffix)-6]
+	keyID, err := gpgIssuerFingerprint(headers[6:])
+	if err != nil {
+		return Signature{}, err
+	}
+
+	// The signature MPI follows the packet fields, see above, the unhashed
+	// subpackets and the left 16 bits of the signed hash
+	body, err := gpgPacketBody(sigBytes)
+	if err != nil {
+		return Signature{}, err
+	}
+	ptr := len(headers)
+	if len(body) < ptr+2 {
+		return Signature{}, fmt.Errorf("%w: truncated packet", ErrGPGSignature)
+	}
+	ptr += 2 + int(binary.BigEndian.Uint16(body[ptr:])) + 2
+	if len(body) < ptr+2 {
+		return Signature{}, fmt.Errorf("%w: truncated packet", ErrGPGSignature)
+	}
+	mpiLength := (int(binary.BigEndian.Uint16(body[ptr:])) + 7) / 8
+	ptr += 2
+	if len(body) < ptr+mpiLength {
+		return Signature{}, fmt.Errorf("%w: truncated packet", ErrGPGSignature)
+	}
+
+	sig := Signature{
+		KeyID:        keyID,
+		OtherHeaders: hex.EncodeToString(headers),
+		GPGSignature: hex.EncodeToString(body[ptr : ptr+mpiLength]),
+	}
+	if parsed.IssuerKeyId != nil {
+		sig.ShortKeyID = fmt.Sprintf("%016x", *parsed.IssuerKeyId)
+	}
+	return sig, nil
+}
+
+/*
+gpgPacketBody returns the body of the OpenPGP packet at the start of the
+passed bytes, see RFC 4880, section 4.2.
+*/
+func gpgPacketBody(packetBytes []byte) ([]byte, error) {
+	if len(packetBytes) < 2 {
+		return nil, fmt.Errorf("%w: truncated packet", ErrGPGSignature)
+	}
+	var headerLength, bodyLength int
+	if packetBytes[0]&0x40 != 0 {
+		// New format packet header
+		switch l := int(packetBytes[1]); {
+		case l < 192:
+			headerLength, bodyLength = 2, l
+		case l < 224 && len(packetBytes) >= 3:
+			headerLength, bodyLength = 3, (l-192)<<8+int(packetBytes[2])+192
+		case l == 255 && len(packetBytes) >= 6:
+			headerLength, bodyLength = 6, int(binary.BigEndian.Uint32(packetBytes[2:]))
+		default:
+			return nil, fmt.Errorf("%w: unsupported packet length", ErrGPGSignature)
+		}
+	} else {
+		// Old format packet header
+		switch packetBytes[0] & 0x03 {
+		case 0:
+			headerLength, bodyLength = 2, int(packetBytes[1])
+		case 1:
+			if len(packetBytes) < 3 {
+				return nil, fmt.Errorf("%w: truncated packet", ErrGPGSignature)
+			}
+			headerLength, bodyLength = 3, int(binary.BigEndian.Uint16(packetBytes[1:]))
+		case 2:
+			if len(packetBytes) < 5 {
+				return nil, fmt.Errorf("%w: truncated packet", ErrGPGSignature)
+			}
+			headerLength, bodyLength = 5, int(binary.BigEndian.Uint32(packetBytes[1:]))
+		default:
+			return nil, fmt.Errorf("%w: unsupported packet length", ErrGPGSignature)
+		}
+	}
+	if len(packetBytes) < headerLength+bodyLength {
+		return nil, fmt.Errorf("%w: truncated packet", ErrGPGSignature)
+	}
+	return packetBytes[headerLength : headerLength+bodyLength], nil
+}
+
+/*
+gpgIssuerFingerprint returns the hex encoded fingerprint of the issuer
+fingerprint subpacket among the passed hashed subpackets, or an empty string if
+there is none, see RFC 4880, section 5.2.3.1.
+*/
+func gpgIssuerFingerprint(subpackets []byte) (string, error) {
+	for len(subpackets) > 0 {
+		var length int
+		switch l := int(subpackets[0]); {
+		case l < 192:
+			length, subpackets = l, subpackets[1:]
+		case l < 255 && len(subpackets) >= 2:
+			length, subpackets = (l-192)<<8+int(subpackets[1])+192, subpackets[2:]
+		case l == 255 && len(subpackets) >= 5:
+			length, subpackets = int(binary.BigEndian.Uint32(subpackets[1:])), subpackets[5:]
+		default:
+			return "", fmt.Errorf("%w: truncated subpacket", ErrGPGSignature)
+		}
+		if length < 1 || len(subpackets) < length {
+			return "", fmt.Errorf("%w: truncated subpacket", ErrGPGSignature)
+		}
+		// Issuer fingerprint subpackets hold a version octet and the fingerprint
+		if subpackets[0]&0x7f == 33 && length > 2 {
+			return hex.EncodeToString(subpackets[2:length]), nil
+		}
+		subpackets = subpackets[length:]
+	}
+	return "", nil
+}
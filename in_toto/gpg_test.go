@@ -0,0 +1,302 @@
+package in_toto
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+const gpgTestKeyID = "bacfdc3a404ef97b984a353861a4b4f1e2e5e01c"
+
+// gpg-subkey.pub.asc has a primary key that only certifies and a signing subkey
+const (
+	gpgTestPrimaryKeyID = "a9a14d0d82d5967e1406dfa9bc668779c9093172"
+	gpgTestSubkeyID     = "6ac51cd76f9fe85acfb2c77acb4bc6c2cb7f7585"
+)
+
+func TestLoadGPGKey(t *testing.T) {
+	var key Key
+	if err := key.LoadGPGKey("gpg.pub.asc"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, gpgTestKeyID, key.KeyID)
+	assert.Equal(t, "rsa", key.KeyType)
+	assert.Equal(t, gpgRSAScheme, key.Scheme)
+	assert.Equal(t, []string{"pgp+SHA2"}, key.GPG.Hashes)
+	assert.Equal(t, "010001", key.GPG.E)
+	assert.Empty(t, key.GPG.Subkeys)
+	assert.Empty(t, key.KeyVal)
+	assert.Nil(t, key.ValidateKeyID())
+	assert.Nil(t, validatePublicKey(key))
+
+	wrongKeyID := key
+	wrongKeyID.KeyID = "61a4b4f1e2e5e01c"
+	assert.ErrorIs(t, wrongKeyID.ValidateKeyID(), ErrInvalidKeyID)
+
+	// The keyid is the fingerprint over the creation time and public key
+	wrongCreationTime := key
+	wrongCreationTime.GPG = &GPGKeyInfo{}
+	*wrongCreationTime.GPG = *key.GPG
+	wrongCreationTime.GPG.CreationTime++
+	assert.ErrorIs(t, wrongCreationTime.ValidateKeyID(), ErrInvalidKeyID)
+
+	// GPG keys can neither sign nor be used with DSSE
+	_, err := NewKeySigner(key)
+	assert.ErrorIs(t, err, ErrUnsupportedKeyType)
+
+	// Only OpenPGP keys load
+	assert.NotNil(t, key.LoadGPGKey("alice.pub"))
+	assert.NotNil(t, key.LoadGPGKey("does-not-exist.asc"))
+}
+
+func TestLoadGPGKeyRevokedSubkey(t *testing.T) {
+	secretKey, err := os.ReadFile("gpg-subkey.sec.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(secretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entity := entities[0]
+	if err := entity.RevokeSubkey(&entity.Subkeys[0], packet.KeyCompromised, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	var publicKey bytes.Buffer
+	if err := entity.Serialize(&publicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revoked subkeys are skipped
+	var key Key
+	assert.Nil(t, key.LoadGPGKeyReader(&publicKey))
+	assert.Equal(t, gpgTestPrimaryKeyID, key.KeyID)
+	assert.Empty(t, key.GPG.Subkeys)
+}
+
+func TestLoadGPGKeyWithSubkey(t *testing.T) {
+	var key Key
+	if err := key.LoadGPGKey("gpg-subkey.pub.asc"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, gpgTestPrimaryKeyID, key.KeyID)
+	assert.Len(t, key.GPG.Subkeys, 1)
+	assert.Contains(t, key.GPG.Subkeys, gpgTestSubkeyID)
+	assert.Nil(t, key.ValidateKeyID())
+	assert.True(t, key.MatchesKeyID(gpgTestSubkeyID))
+	assert.False(t, key.MatchesKeyID(gpgTestKeyID))
+
+	// The key equals the one exported in the format of securesystemslib
+	metadata, err := LoadMetadata("gpg-subkey.layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layoutKey := metadata.GetPayload().(Layout).Keys[gpgTestPrimaryKeyID]
+	assert.Equal(t, key, layoutKey)
+
+	// and is encoded in that format again
+	data, err := json.Marshal(key)
+	assert.Nil(t, err)
+	var encoded map[string]any
+	assert.Nil(t, json.Unmarshal(data, &encoded))
+	assert.ElementsMatch(t, []string{"keyid", "type", "method", "hashes", "keyval", "creation_time", "subkeys"},
+		mapKeys(encoded))
+	assert.Equal(t, map[string]any{"private": "", "public": map[string]any{"e": "010001", "n": key.GPG.N}},
+		encoded["keyval"])
+	var decoded Key
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, key, decoded)
+
+	// Subkeys are validated like keys
+	badSubkey := key
+	badSubkey.GPG = &GPGKeyInfo{}
+	*badSubkey.GPG = *key.GPG
+	subkey := key.GPG.Subkeys[gpgTestSubkeyID]
+	subkey.KeyID = gpgTestKeyID
+	badSubkey.GPG.Subkeys = map[string]Key{gpgTestSubkeyID: subkey}
+	assert.ErrorIs(t, badSubkey.ValidateKeyID(), ErrInvalidKeyID)
+	subkey.GPG = &GPGKeyInfo{E: "010001", N: "xyz"}
+	badSubkey.GPG.Subkeys = map[string]Key{gpgTestSubkeyID: subkey}
+	assert.ErrorIs(t, validatePublicKey(badSubkey), ErrInvalidHexString)
+
+	// Unknown fields are rejected in both formats
+	assert.NotNil(t, json.Unmarshal([]byte(`{"keyid": "ab", "method": "pgp+rsa-pkcsv1.5", "foo": 1}`), &decoded))
+	assert.NotNil(t, json.Unmarshal([]byte(`{"keyid": "ab", "keytype": "rsa", "foo": 1}`), &decoded))
+}
+
+func mapKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestVerifyGPGSignature(t *testing.T) {
+	var key Key
+	if err := key.LoadGPGKey("gpg.pub.asc"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The link was signed with gpg as by the in-toto reference implementation
+	metadata, err := LoadMetadata("write-code-gpg.bacfdc3a.link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb := metadata.(*Metablock)
+	assert.Nil(t, mb.VerifySignature(key))
+	assert.Nil(t, mb.VerifySignatures(map[string]Key{key.KeyID: key}, 1))
+
+	tampered := *mb
+	link := tampered.Signed.(Link)
+	link.Name = "tampered"
+	tampered.Signed = link
+	assert.ErrorIs(t, tampered.VerifySignature(key), ErrInvalidSignature)
+
+	badHeaders := *mb
+	badHeaders.Signatures = []Signature{mb.Signatures[0]}
+	badHeaders.Signatures[0].OtherHeaders = "03" + mb.Signatures[0].OtherHeaders[2:]
+	assert.ErrorIs(t, badHeaders.VerifySignature(key), ErrGPGSignature)
+}
+
+func TestVerifyGPGSubkeySignature(t *testing.T) {
+	// The layout and link were signed with the subkey, see
+	// gpg-subkey-fixtures.py, and the layout holds the key in the format of
+	// securesystemslib
+	layoutMetadata, err := LoadMetadata("gpg-subkey.layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := layoutMetadata.GetPayload().(Layout).Keys[gpgTestPrimaryKeyID]
+	assert.Equal(t, gpgTestSubkeyID, layoutMetadata.Sigs()[0].KeyID)
+	assert.Nil(t, layoutMetadata.VerifySignature(key))
+
+	linkMetadata, err := LoadMetadata("write-code-subkey.6ac51cd7.link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, linkMetadata.VerifySignature(key))
+
+	// The step authorizes the primary key, the link is signed by its subkey
+	_, err = InTotoVerify(layoutMetadata, map[string]Key{key.KeyID: key}, ".", "", nil, nil, false)
+	assert.Nil(t, err)
+
+	// Keys without the subkey do not verify
+	withoutSubkey := key
+	withoutSubkey.GPG = &GPGKeyInfo{}
+	*withoutSubkey.GPG = *key.GPG
+	withoutSubkey.GPG.Subkeys = nil
+	assert.NotNil(t, linkMetadata.VerifySignature(withoutSubkey))
+
+	// Expired subkeys do not verify
+	expired := key
+	expired.GPG = &GPGKeyInfo{}
+	*expired.GPG = *key.GPG
+	subkey := key.GPG.Subkeys[gpgTestSubkeyID]
+	subkey.GPG = &GPGKeyInfo{}
+	*subkey.GPG = *key.GPG.Subkeys[gpgTestSubkeyID].GPG
+	subkey.GPG.ValidityPeriod = 1
+	expired.GPG.Subkeys = map[string]Key{gpgTestSubkeyID: subkey}
+	assert.ErrorIs(t, linkMetadata.VerifySignature(expired), ErrGPGKeyExpired)
+
+	// Expiration is checked against the reference time of the verification
+	created := time.Unix(subkey.GPG.CreationTime, 0)
+	expiredKeys := map[string]Key{expired.KeyID: expired}
+	_, _, err = InTotoVerifyWithOptions(layoutMetadata, expiredKeys, ".", "", nil, nil,
+		InTotoVerifyOptions{})
+	assert.ErrorIs(t, err, ErrGPGKeyExpired)
+	_, _, err = InTotoVerifyWithOptions(layoutMetadata, expiredKeys, ".", "", nil, nil,
+		InTotoVerifyOptions{ReferenceTime: created})
+	assert.Nil(t, err)
+}
+
+func TestGPGSigner(t *testing.T) {
+	if _, err := exec.LookPath(gpgCommand); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	homedir := t.TempDir()
+	for _, secretKey := range []string{"gpg.sec.asc", "gpg-subkey.sec.asc"} {
+		if out, err := exec.Command(gpgCommand, "--homedir", homedir, "--batch",
+			"--import", secretKey).CombinedOutput(); err != nil {
+			t.Fatalf("failed to import gpg key: %s: %s", err, out)
+		}
+	}
+
+	for _, test := range []struct {
+		userID      string
+		keyFile     string
+		signerKeyID string
+	}{
+		// Short keyids resolve to the fingerprint
+		{"61a4b4f1e2e5e01c", "gpg.pub.asc", gpgTestKeyID},
+		// Like gpg, the signing subkey signs for the primary key
+		{gpgTestPrimaryKeyID, "gpg-subkey.pub.asc", gpgTestSubkeyID},
+		{gpgTestSubkeyID + "!", "gpg-subkey.pub.asc", gpgTestSubkeyID},
+	} {
+		signer, err := NewGPGSigner(test.userID, homedir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, test.signerKeyID, signer.KeyID(), test.userID)
+
+		var key Key
+		if err := key.LoadGPGKey(test.keyFile); err != nil {
+			t.Fatal(err)
+		}
+		mb := &Metablock{Signed: Link{Type: "link", Name: "write-code-gpg"}}
+		if err := mb.SignWith(signer); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, test.signerKeyID, mb.Signatures[0].KeyID, test.userID)
+		assert.Empty(t, mb.Signatures[0].ShortKeyID, test.userID)
+		assert.Empty(t, mb.Signatures[0].Sig, test.userID)
+		assert.Nil(t, validateSignature(mb.Signatures[0]), test.userID)
+		assert.Nil(t, mb.VerifySignature(key), test.userID)
+	}
+
+	// The primary key of gpg-subkey.sec.asc cannot sign
+	_, err := NewGPGSigner(gpgTestPrimaryKeyID+"!", homedir)
+	assert.ErrorContains(t, err, "no gpg signing key found")
+	_, err = NewGPGSigner("unknown@example.com", homedir)
+	assert.NotNil(t, err)
+}
+
+func TestGPGSigningKeyID(t *testing.T) {
+	// The newest valid signing subkey signs, see doc/DETAILS of gpg
+	listing := `tru::1:1767225600:0:3:1:5
+pub:u:2048:1:BC668779C9093172:1767225600:::u:::cSC:::::::23::0:
+fpr:::::::::A9A14D0D82D5967E1406DFA9BC668779C9093172:
+uid:u::::1767225600::0::test <test@example.com>::::::::::0:
+sub:u:2048:1:1111111111111111:1767225700::::::s:::::::23:
+fpr:::::::::1111111111111111111111111111111111111111:
+sub:e:2048:1:2222222222222222:1767225900::::::s:::::::23:
+fpr:::::::::2222222222222222222222222222222222222222:
+sub:u:2048:1:3333333333333333:1767225800::::::s:::::::23:
+fpr:::::::::3333333333333333333333333333333333333333:
+sub:u:2048:1:4444444444444444:1767226000::::::e:::::::23:
+fpr:::::::::4444444444444444444444444444444444444444:
+pub:u:2048:1:5555555555555555:1767226100:::u:::scSC:::::::23::0:
+fpr:::::::::5555555555555555555555555555555555555555:
+`
+	assert.Equal(t, "3333333333333333333333333333333333333333", gpgSigningKeyID(listing, "test"))
+	assert.Equal(t, "1111111111111111111111111111111111111111", gpgSigningKeyID(listing, "1111111111111111!"))
+	assert.Equal(t, "", gpgSigningKeyID(listing, "2222222222222222!"))
+	assert.Equal(t, "", gpgSigningKeyID(listing, "BC668779C9093172!"))
+	assert.Equal(t, "", gpgSigningKeyID("", "test"))
+}
+
+func TestParseGPGSignatureErrors(t *testing.T) {
+	_, err := parseGPGSignature([]byte{})
+	assert.ErrorIs(t, err, ErrGPGSignature)
+	_, err = gpgPacketBody([]byte{0x89, 0x01, 0x00})
+	assert.ErrorIs(t, err, ErrGPGSignature)
+	_, err = gpgIssuerFingerprint([]byte{0x05, 0x21})
+	assert.ErrorIs(t, err, ErrGPGSignature)
+}
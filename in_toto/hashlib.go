@@ -1,9 +1,17 @@
 package in_toto
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"crypto/sha512"
+	"fmt"
 	"hash"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
 )
 
 /*
@@ -12,19 +20,132 @@ interface.
 */
 func getHashMapping() map[string]func() hash.Hash {
 	return map[string]func() hash.Hash{
-		"sha256": sha256.New,
-		"sha512": sha512.New,
-		"sha384": sha512.New384,
+		"sha256":      sha256.New,
+		"sha512":      sha512.New,
+		"sha384":      sha512.New384,
+		"sha3_256":    sha3.New256,
+		"sha3_512":    sha3.New512,
+		"blake2b-256": newBlake2b256,
+		"blake2b-512": newBlake2b512,
+	}
+}
+
+// newBlake2b256 returns an unkeyed BLAKE2b-256 hash, which never fails
+func newBlake2b256() hash.Hash {
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
+// newBlake2b512 returns an unkeyed BLAKE2b-512 hash, which never fails
+func newBlake2b512() hash.Hash {
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+/*
+ValidateHashAlgorithms checks that each of the passed hash algorithm names is
+supported for hashing artifacts, see RecordArtifacts, before any artifact is
+hashed.  It returns an error wrapping ErrUnsupportedHashAlgorithm that lists all
+unknown names, in the passed order, or nil if all names are supported.
+*/
+func ValidateHashAlgorithms(hashAlgorithms []string) error {
+	supportedHashMappings := getHashMapping()
+	var unknown []string
+	for _, element := range hashAlgorithms {
+		if _, ok := supportedHashMappings[element]; !ok {
+			unknown = append(unknown, element)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnsupportedHashAlgorithm, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// hashBufferSize is the size of the chunks in which files are hashed.
+const hashBufferSize = 32 * 1024
+
+/*
+binaryDetectionSize is the number of leading bytes that are inspected to decide
+whether a file is binary.  Like git, a file is considered binary if a NUL byte
+occurs within this range.
+*/
+const binaryDetectionSize = 8000
+
+/*
+lineEndings selects how hashReader treats the line separators of the data it
+hashes.
+*/
+type lineEndings int
+
+const (
+	// rawLineEndings hashes the data as is
+	rawLineEndings lineEndings = iota
+	// normalizedLineEndings normalizes line separators like the Python
+	// reference implementation, i.e. of all data
+	normalizedLineEndings
+	// textLineEndings normalizes line separators, unless the data looks
+	// binary, see isBinary
+	textLineEndings
+)
+
+/*
+lineEndingsFor returns the lineEndings for the passed line normalization
+settings.
+*/
+func lineEndingsFor(lineNormalization bool, skipBinary bool) lineEndings {
+	switch {
+	case !lineNormalization:
+		return rawLineEndings
+	case skipBinary:
+		return textLineEndings
+	default:
+		return normalizedLineEndings
 	}
 }
 
 /*
-hashToHex calculates the hash over data based on hash algorithm h.
+isBinary peeks at the start of the passed reader and reports whether the data
+looks binary, i.e. contains a NUL byte.  Peeked data is not consumed.
 */
-func hashToHex(h hash.Hash, data []byte) []byte {
-	h.Write(data)
-	// We need to use h.Sum(nil) here, because otherwise hash.Sum() appends
-	// the hash to the passed data. So instead of having only the hash
-	// we would get: "dataHASH"
-	return h.Sum(nil)
+func isBinary(r *bufio.Reader) (bool, error) {
+	head, err := r.Peek(binaryDetectionSize)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return bytes.IndexByte(head, 0) != -1, nil
+}
+
+/*
+lineNormalizer is an io.Writer that converts Windows-style (CRLF) and old
+Mac-style (CR) line separators to Unix-style line separators (LF) before
+passing the data on to the wrapped writer.  It keeps track of a trailing CR,
+so that a CRLF pair split across two writes is still converted to a single LF.
+*/
+type lineNormalizer struct {
+	w      io.Writer
+	skipLF bool
+	buf    []byte
+}
+
+func (n *lineNormalizer) Write(p []byte) (int, error) {
+	// Reuse the buffer of previous writes to keep memory usage constant
+	normalized := n.buf[:0]
+	for _, b := range p {
+		switch {
+		case b == '\r':
+			normalized = append(normalized, '\n')
+			n.skipLF = true
+		case b == '\n' && n.skipLF:
+			n.skipLF = false
+		default:
+			normalized = append(normalized, b)
+			n.skipLF = false
+		}
+	}
+	n.buf = normalized
+	if _, err := n.w.Write(normalized); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
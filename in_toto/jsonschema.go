@@ -0,0 +1,34 @@
+package in_toto
+
+import (
+	_ "embed"
+	"slices"
+)
+
+//go:embed schemas/layout.schema.json
+var layoutJSONSchema []byte
+
+//go:embed schemas/link.schema.json
+var linkJSONSchema []byte
+
+/*
+LayoutJSONSchema returns the JSON Schema (draft 2020-12) of signed layout
+metadata files in the format read by Metablock.Load, so that external tools,
+e.g. editors, can validate layouts while they are authored.  The schema
+requires the "_type", "steps", "inspect", "keys", "expires" and "readme" fields
+of a layout, but checks neither signatures nor references between fields, e.g.
+to the keyids of steps, which is left to layout validation.  The schema is also
+available as schemas/layout.schema.json in the source tree.
+*/
+func LayoutJSONSchema() []byte {
+	return slices.Clone(layoutJSONSchema)
+}
+
+/*
+LinkJSONSchema returns the JSON Schema (draft 2020-12) of signed link metadata
+files in the format read by Metablock.Load, see LayoutJSONSchema.  The schema is
+also available as schemas/link.schema.json in the source tree.
+*/
+func LinkJSONSchema() []byte {
+	return slices.Clone(linkJSONSchema)
+}
@@ -0,0 +1,189 @@
+package in_toto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+validateJSONSchema validates the passed JSON value against the passed schema,
+supporting the keywords that are used by the schemas of this package.  Unknown
+keywords are an error, so that no part of a schema is silently ignored.
+*/
+func validateJSONSchema(root, schema map[string]any, value any, location string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		def, ok := root["$defs"].(map[string]any)[strings.TrimPrefix(ref, "#/$defs/")].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: unknown reference %s", location, ref)
+		}
+		return validateJSONSchema(root, def, value, location)
+	}
+
+	for keyword, arg := range schema {
+		switch keyword {
+		case "$schema", "$id", "$defs", "title", "description":
+		case "type":
+			types, ok := arg.([]any)
+			if !ok {
+				types = []any{arg}
+			}
+			matches := false
+			for _, typ := range types {
+				switch typ {
+				case "object":
+					_, matches = value.(map[string]any)
+				case "array":
+					_, matches = value.([]any)
+				case "string":
+					_, matches = value.(string)
+				case "integer":
+					number, ok := value.(float64)
+					matches = ok && number == float64(int64(number))
+				case "null":
+					matches = value == nil
+				}
+				if matches {
+					break
+				}
+			}
+			if !matches {
+				return fmt.Errorf("%s: expected type %v, got %v", location, arg, value)
+			}
+		case "anyOf":
+			var errs []error
+			for _, option := range arg.([]any) {
+				err := validateJSONSchema(root, option.(map[string]any), value, location)
+				if err == nil {
+					errs = nil
+					break
+				}
+				errs = append(errs, err)
+			}
+			if errs != nil {
+				return fmt.Errorf("%s: matches none of the schemas: %w", location, errors.Join(errs...))
+			}
+		case "const":
+			if value != arg {
+				return fmt.Errorf("%s: expected %v, got %v", location, arg, value)
+			}
+		case "pattern":
+			if s, ok := value.(string); ok && !regexp.MustCompile(arg.(string)).MatchString(s) {
+				return fmt.Errorf("%s: %s does not match %s", location, s, arg)
+			}
+		case "minLength":
+			if s, ok := value.(string); ok && float64(len(s)) < arg.(float64) {
+				return fmt.Errorf("%s: shorter than %v", location, arg)
+			}
+		case "minimum":
+			if number, ok := value.(float64); ok && number < arg.(float64) {
+				return fmt.Errorf("%s: less than %v", location, arg)
+			}
+		case "minItems":
+			if items, ok := value.([]any); ok && float64(len(items)) < arg.(float64) {
+				return fmt.Errorf("%s: fewer than %v items", location, arg)
+			}
+		case "items":
+			items, _ := value.([]any)
+			for i, item := range items {
+				if err := validateJSONSchema(root, arg.(map[string]any), item, fmt.Sprintf("%s[%d]", location, i)); err != nil {
+					return err
+				}
+			}
+		case "required":
+			object, ok := value.(map[string]any)
+			for _, field := range arg.([]any) {
+				if _, present := object[field.(string)]; ok && !present {
+					return fmt.Errorf("%s: required field %s missing", location, field)
+				}
+			}
+		case "properties":
+			object, _ := value.(map[string]any)
+			for field, fieldSchema := range arg.(map[string]any) {
+				fieldValue, ok := object[field]
+				if !ok {
+					continue
+				}
+				if err := validateJSONSchema(root, fieldSchema.(map[string]any), fieldValue, location+"."+field); err != nil {
+					return err
+				}
+			}
+		case "additionalProperties":
+			object, _ := value.(map[string]any)
+			properties, _ := schema["properties"].(map[string]any)
+			for field, fieldValue := range object {
+				if _, ok := properties[field]; ok {
+					continue
+				}
+				if err := validateJSONSchema(root, arg.(map[string]any), fieldValue, location+"."+field); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("%s: unsupported keyword %s", location, keyword)
+		}
+	}
+	return nil
+}
+
+func TestJSONSchema(t *testing.T) {
+	unmarshal := func(data []byte) map[string]any {
+		var value map[string]any
+		if err := json.Unmarshal(data, &value); err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	layoutSchema := unmarshal(LayoutJSONSchema())
+	linkSchema := unmarshal(LinkJSONSchema())
+
+	for _, test := range []struct {
+		schema map[string]any
+		files  []string
+	}{
+		{layoutSchema, []string{"demo.layout", "super.layout", "gpg-subkey.layout"}},
+		{linkSchema, []string{"write-code.b7d643de.link", "package.d3ffd108.link", "foo.b7d643de.link", "write-code-subkey.6ac51cd7.link"}},
+	} {
+		for _, file := range test.files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Nil(t, validateJSONSchema(test.schema, test.schema, unmarshal(data), file))
+		}
+	}
+
+	// Links created by InTotoRun, whose nil maps are encoded as null
+	link, err := InTotoRun("foo", "", []string{"foo.tar.gz"}, []string{}, []string{}, Key{}, []string{"sha256"}, nil, nil, false, false, false)
+	assert.Nil(t, err)
+	data, err := json.Marshal(link)
+	assert.Nil(t, err)
+	assert.Nil(t, validateJSONSchema(linkSchema, linkSchema, unmarshal(data), "link"))
+
+	// The schemas reject what Load rejects
+	data, err = os.ReadFile("demo.layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		modify   func(layout map[string]any)
+		expected string
+	}{
+		{func(layout map[string]any) { delete(layout, "expires") }, "required field expires missing"},
+		{func(layout map[string]any) { layout["expires"] = "2030-11-18" }, "does not match"},
+		{func(layout map[string]any) { layout["_type"] = "link" }, "expected layout"},
+		{func(layout map[string]any) { layout["steps"] = map[string]any{} }, "expected type array"},
+		{func(layout map[string]any) { layout["keys"] = map[string]any{"a": 1} }, "expected type object"},
+	} {
+		metablock := unmarshal(data)
+		test.modify(metablock["signed"].(map[string]any))
+		assert.ErrorContains(t, validateJSONSchema(layoutSchema, layoutSchema, metablock, "layout"), test.expected)
+	}
+	assert.NotNil(t, validateJSONSchema(linkSchema, linkSchema, unmarshal(data), "layout"))
+}
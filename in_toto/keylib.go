@@ -1,20 +1,31 @@
 package in_toto
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
+	"slices"
 	"strings"
 
-	"github.com/secure-systems-lab/go-securesystemslib/cjson"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 // ErrFailedPEMParsing gets returned when PKCS1, PKCS8 or PKIX key parsing fails
@@ -32,6 +43,21 @@ var ErrInvalidSignature = errors.New("invalid signature")
 // ErrInvalidKey is returned when a given key is none of RSA, ECDSA or ED25519
 var ErrInvalidKey = errors.New("invalid key")
 
+// ErrIncorrectPassphrase is returned when an encrypted private key cannot be decrypted with the passed passphrase
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase for encrypted private key")
+
+// ErrNotCertificate is returned if a PEM block passed to LoadCertificate is not an X.509 certificate
+var ErrNotCertificate = errors.New("PEM block is not a certificate")
+
+// ErrInvalidKeyID is returned if the keyid of a key does not match the key
+var ErrInvalidKeyID = errors.New("keyid does not match key")
+
+// ErrUnsupportedKeyEncryption is returned when an encrypted private key uses an unsupported encryption algorithm
+var ErrUnsupportedKeyEncryption = errors.New("unsupported private key encryption")
+
+// ErrNoPrivateKey is returned when a private key is written, but the key holds no private portion
+var ErrNoPrivateKey = errors.New("the given key has no private portion")
+
 const (
 	rsaKeyType            string = "rsa"
 	ecdsaKeyType          string = "ecdsa"
@@ -44,9 +70,13 @@ const (
 	ed25519Scheme         string = "ed25519"
 	pemPublicKey          string = "PUBLIC KEY"
 	pemPrivateKey         string = "PRIVATE KEY"
+	pemEncryptedKey       string = "ENCRYPTED PRIVATE KEY"
 	pemRSAPrivateKey      string = "RSA PRIVATE KEY"
 )
 
+// generatedRSAKeySize is the size of RSA keys created with GenerateKey, as in the securesystemslib
+const generatedRSAKeySize = 3072
+
 /*
 getSupportedKeyIDHashAlgorithms returns a string slice of supported
 KeyIDHashAlgorithms. We need to use this function instead of a constant,
@@ -83,6 +113,29 @@ func getSupportedEd25519Schemes() []string {
 	return []string{ed25519Scheme}
 }
 
+/*
+validateKeyIDHashAlgorithms checks that each of the passed keyid hash algorithm
+names is supported, see getSupportedKeyIDHashAlgorithms.  It returns an error
+wrapping ErrUnsupportedKeyIDHashAlgorithms that lists all unknown names, in the
+passed order, or nil if all names are supported.
+*/
+func validateKeyIDHashAlgorithms(keyIDHashAlgorithms []string) error {
+	supported := getSupportedKeyIDHashAlgorithms()
+	var unknown []string
+	for _, algorithm := range keyIDHashAlgorithms {
+		if !supported.Has(algorithm) {
+			unknown = append(unknown, algorithm)
+		}
+	}
+	if len(unknown) > 0 {
+		supportedNames := supported.Slice()
+		slices.Sort(supportedNames)
+		return fmt.Errorf("%w: %s, supported are: %s", ErrUnsupportedKeyIDHashAlgorithms,
+			strings.Join(unknown, ", "), strings.Join(supportedNames, ", "))
+	}
+	return nil
+}
+
 /*
 generateKeyID creates a partial key map and generates the key ID
 based on the created partial key map via the SHA256 method.
@@ -91,13 +144,40 @@ On success generateKeyID will return nil, in case of errors while encoding
 there will be an error.
 */
 func (k *Key) generateKeyID() error {
+	keyCanonical, err := k.keyIDRepresentation()
+	if err != nil {
+		return err
+	}
+	// calculate sha256 and return string representation of keyID
+	keyHashed := sha256.Sum256(keyCanonical)
+	k.KeyID = fmt.Sprintf("%x", keyHashed)
+	err = validateKey(*k)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+/*
+keyIDRepresentation returns the canonical representation of the key, whose hash
+is the keyid of the key.
+*/
+func (k *Key) keyIDRepresentation() ([]byte, error) {
+	return EncodeCanonical(k.keyIDFields())
+}
+
+/*
+keyIDFields returns the partial key map, whose canonical representation is
+hashed to create the keyid.
+*/
+func (k *Key) keyIDFields() map[string]interface{} {
 	// Create partial key map used to create the keyid
 	// Unfortunately, we can't use the Key object because this also carries
 	// yet unwanted fields, such as KeyID and KeyVal.Private and therefore
 	// produces a different hash. We generate the keyID exactly as we do in
 	// the securesystemslib  to keep interoperability between other in-toto
 	// implementations.
-	var keyToBeHashed = map[string]interface{}{
+	return map[string]interface{}{
 		"keytype":               k.KeyType,
 		"scheme":                k.Scheme,
 		"keyid_hash_algorithms": k.KeyIDHashAlgorithms,
@@ -105,17 +185,142 @@ func (k *Key) generateKeyID() error {
 			"public": k.KeyVal.Public,
 		},
 	}
-	keyCanonical, err := cjson.EncodeCanonical(keyToBeHashed)
+}
+
+/*
+ComputeKeyID returns the keyid of the key computed from its public portion,
+i.e. the sha256 hash of the canonical representation of the key type, scheme,
+keyid hash algorithms and public key, as generateKeyID does and the in-toto
+reference implementation expects.  The keyid of GPG keys is the fingerprint of
+their OpenPGP key.  The keyid field of the key is neither
+used nor changed.
+*/
+func (k *Key) ComputeKeyID() (string, error) {
+	if isGPGKey(*k) {
+		return gpgFingerprint(k.GPG)
+	}
+	computed := *k
+	if err := computed.generateKeyID(); err != nil {
+		return "", err
+	}
+	return computed.KeyID, nil
+}
+
+/*
+ComputeKeyIDs returns the keyids of the key computed with each of its keyid
+hash algorithms, in their order.  Other in-toto implementations may reference
+the key by any of these keyids, e.g. by the keyid computed with "sha512"
+instead of the one computed with "sha256", which ComputeKeyID returns.  Keys
+without keyid hash algorithms have the keyid computed with "sha256" and,
+because current versions of securesystemslib omit the field from keys without
+it, the keyid computed over the key type, scheme and public key only.  GPG
+keys only have their fingerprint.
+*/
+func (k *Key) ComputeKeyIDs() ([]string, error) {
+	keyID, err := k.ComputeKeyID()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// calculate sha256 and return string representation of keyID
-	keyHashed := sha256.Sum256(keyCanonical)
-	k.KeyID = fmt.Sprintf("%x", keyHashed)
-	err = validateKey(*k)
+	if isGPGKey(*k) {
+		return []string{keyID}, nil
+	}
+	if len(k.KeyIDHashAlgorithms) == 0 {
+		fields := k.keyIDFields()
+		delete(fields, "keyid_hash_algorithms")
+		keyCanonical, err := EncodeCanonical(fields)
+		if err != nil {
+			return nil, err
+		}
+		return []string{keyID, fmt.Sprintf("%x", sha256.Sum256(keyCanonical))}, nil
+	}
+	keyCanonical, err := k.keyIDRepresentation()
+	if err != nil {
+		return nil, err
+	}
+	keyIDs := make([]string, 0, len(k.KeyIDHashAlgorithms))
+	for _, algorithm := range k.KeyIDHashAlgorithms {
+		switch algorithm {
+		case "sha256":
+			keyIDs = append(keyIDs, keyID)
+		case "sha512":
+			keyIDs = append(keyIDs, fmt.Sprintf("%x", sha512.Sum512(keyCanonical)))
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyIDHashAlgorithms, algorithm)
+		}
+	}
+	return keyIDs, nil
+}
+
+/*
+MatchesKeyID returns true if the passed keyid is the keyid of the key or one of
+the keyids returned by ComputeKeyIDs, i.e. if it references the key.  The
+keyids of the subkeys of GPG keys match as well, because GPG signs with a
+signing subkey rather than the primary key, if there is one.  The keyids of
+ecdsa-sha2-nistp384 keys computed with the ecdsa-sha2-nistp256 scheme match
+too, because LoadKeyDefaults used to load P-384 keys with that scheme, so that
+existing layouts and links still reference them.
+*/
+func (k *Key) MatchesKeyID(keyID string) bool {
+	if keyID == k.KeyID {
+		return true
+	}
+	if k.GPG != nil {
+		if _, ok := k.GPG.Subkeys[keyID]; ok {
+			return true
+		}
+	}
+	keyIDs, err := k.ComputeKeyIDs()
+	if err != nil {
+		return false
+	}
+	if k.KeyType == ecdsaKeyType && k.Scheme == ecdsaSha2nistp384 {
+		legacy := *k
+		legacy.Scheme = ecdsaSha2nistp256
+		legacyKeyIDs, err := legacy.ComputeKeyIDs()
+		if err != nil {
+			return false
+		}
+		keyIDs = append(keyIDs, legacyKeyIDs...)
+	}
+	return slices.Contains(keyIDs, keyID)
+}
+
+/*
+ValidateKeyID recomputes the keyids of the key with ComputeKeyIDs and returns
+an error wrapping ErrInvalidKeyID if none of them is the keyid of the key.  Keys
+loaded with LoadKey always pass, because loading computes their keyid and
+overwrites any previous one.  The check is meant for keys whose keyid was read
+from a file, such as the keys in a layout, or set by the caller.  Keys loaded
+with LoadCertificate, whose keyid is the fingerprint of their certificate, pass
+as well.  The subkeys of GPG keys are validated likewise.
+*/
+func (k *Key) ValidateKeyID() error {
+	if k.GPG != nil {
+		for subkeyID, subkey := range k.GPG.Subkeys {
+			if subkey.KeyID != subkeyID {
+				return fmt.Errorf("%w: subkey stored as '%s' has keyid '%s'",
+					ErrInvalidKeyID, subkeyID, subkey.KeyID)
+			}
+			if err := subkey.ValidateKeyID(); err != nil {
+				return err
+			}
+		}
+	}
+	if k.KeyVal.Certificate != "" {
+		if _, key, err := decodeAndParse([]byte(k.KeyVal.Certificate)); err == nil {
+			if cert, ok := key.(*x509.Certificate); ok && certificateKeyID(cert) == k.KeyID {
+				return nil
+			}
+		}
+	}
+	expected, err := k.ComputeKeyIDs()
 	if err != nil {
 		return err
 	}
+	if !slices.Contains(expected, k.KeyID) {
+		return fmt.Errorf("%w: key has keyid '%s', expected '%s'",
+			ErrInvalidKeyID, k.KeyID, expected[0])
+	}
 	return nil
 }
 
@@ -252,6 +457,171 @@ func decodeAndParse(pemBytes []byte) (*pem.Block, interface{}, error) {
 	return data, key, nil
 }
 
+/*
+decryptPEMBlock returns a PEM block with the decrypted DER bytes of the passed
+PKCS8 encrypted or legacy encrypted PEM block.  Blocks that are not encrypted
+are returned unchanged.
+*/
+func decryptPEMBlock(block *pem.Block, passphrase []byte) (*pem.Block, error) {
+	if block.Type == pemEncryptedKey {
+		der, err := decryptPKCS8(block.Bytes, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: pemPrivateKey, Bytes: der}, nil
+	}
+	// Legacy PEM encryption is insecure by design, but still what many
+	// existing keys use
+	if x509.IsEncryptedPEMBlock(block) { //nolint:staticcheck
+		der, err := x509.DecryptPEMBlock(block, passphrase) //nolint:staticcheck
+		if err != nil {
+			if errors.Is(err, x509.IncorrectPasswordError) {
+				return nil, ErrIncorrectPassphrase
+			}
+			return nil, err
+		}
+		return &pem.Block{Type: block.Type, Bytes: der}, nil
+	}
+	return block, nil
+}
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+/*
+maxPBKDF2Iterations is the largest PBKDF2 iteration count of encrypted PKCS8
+keys that decryptPKCS8 accepts.  It is well above what openssl and other key
+generating tools use, and keeps a crafted key from making the key derivation
+run for hours.
+*/
+const maxPBKDF2Iterations = 2000000
+
+// encryptedPrivateKeyInfo is the ASN.1 structure of PKCS8 encrypted keys (RFC 5208)
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params are the parameters of the PBES2 encryption scheme (RFC 8018)
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the parameters of the PBKDF2 key derivation function (RFC 8018)
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+/*
+decryptPKCS8 decrypts the passed DER encoded PKCS8 EncryptedPrivateKeyInfo
+and returns the DER encoded PKCS8 PrivateKeyInfo.  Only PBES2 with PBKDF2 and
+AES-CBC is supported, which is what current versions of openssl create.
+*/
+func decryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyEncryption, info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyEncryption, params.KeyDerivationFunc.Algorithm)
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, err
+	}
+
+	var prf func() hash.Hash
+	switch {
+	// hmacWithSHA1 is the default, if no PRF is given
+	case len(kdfParams.PRF.Algorithm) == 0, kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyEncryption, kdfParams.PRF.Algorithm)
+	}
+
+	var keyLength int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLength = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLength = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLength = 32
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedKeyEncryption, params.EncryptionScheme.Algorithm)
+	}
+	if kdfParams.IterationCount < 1 {
+		return nil, fmt.Errorf("%w: invalid PBKDF2 iteration count %d", ErrFailedPEMParsing, kdfParams.IterationCount)
+	}
+	if kdfParams.IterationCount > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("%w: PBKDF2 iteration count %d exceeds the maximum of %d",
+			ErrUnsupportedKeyEncryption, kdfParams.IterationCount, maxPBKDF2Iterations)
+	}
+	// The key length is optional, but must match the cipher if it is given
+	if kdfParams.KeyLength != 0 && kdfParams.KeyLength != keyLength {
+		return nil, fmt.Errorf("%w: PBKDF2 key length %d does not match the cipher key length %d",
+			ErrFailedPEMParsing, kdfParams.KeyLength, keyLength)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, kdfParams.Salt, kdfParams.IterationCount, keyLength, prf))
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() || len(info.EncryptedData) == 0 ||
+		len(info.EncryptedData)%block.BlockSize() != 0 {
+		return nil, ErrFailedPEMParsing
+	}
+	plaintext := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, info.EncryptedData)
+
+	// A wrong passphrase results in invalid padding or, rarely, in garbage
+	// that is not a valid PKCS8 key
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > block.BlockSize() {
+		return nil, ErrIncorrectPassphrase
+	}
+	for _, b := range plaintext[len(plaintext)-padding:] {
+		if int(b) != padding {
+			return nil, ErrIncorrectPassphrase
+		}
+	}
+	plaintext = plaintext[:len(plaintext)-padding]
+	if _, err := x509.ParsePKCS8PrivateKey(plaintext); err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+	return plaintext, nil
+}
+
 /*
 LoadKey loads the key file at specified file path into the key object.
 It automatically derives the PEM type and the key type.
@@ -272,11 +642,13 @@ The following schemes are supported:
 
   - ed25519 -> ed25519
   - rsa -> rsassa-pss-sha256
-  - ecdsa -> ecdsa-sha256-nistp256
+  - ecdsa -> ecdsa-sha2-nistp384 for P-384 keys, ecdsa-sha2-nistp256 otherwise
 
 Note that, this behavior is consistent with the securesystemslib, except for
 ecdsa. We do not use the scheme string as key type in in-toto-golang.
-Instead we are going with a ecdsa/ecdsa-sha2-nistp256 pair.
+Instead we are going with an ecdsa/ecdsa-sha2-nistp256 pair, or an
+ecdsa/ecdsa-sha2-nistp384 pair for P-384 keys.  Other curves keep the
+ecdsa-sha2-nistp256 scheme, so that their keyids do not change.
 
 On success it will return nil. The following errors can happen:
 
@@ -285,8 +657,12 @@ On success it will return nil. The following errors can happen:
   - no valid PKCS8/PKCS1 private key or PKIX public key
   - errors while marshalling
   - unsupported key types
+  - unsupported keyid hash algorithms, which are reported before the file is read
 */
 func (k *Key) LoadKey(path string, scheme string, KeyIDHashAlgorithms []string) error {
+	if err := validateKeyIDHashAlgorithms(KeyIDHashAlgorithms); err != nil {
+		return err
+	}
 	pemFile, err := os.Open(path)
 	if err != nil {
 		return err
@@ -301,6 +677,30 @@ func (k *Key) LoadKey(path string, scheme string, KeyIDHashAlgorithms []string)
 	return pemFile.Close()
 }
 
+/*
+LoadKeyPassphrase is like LoadKey, but decrypts the private key with the
+passed passphrase before parsing it.  Supported are PKCS8 encrypted keys
+("ENCRYPTED PRIVATE KEY") using PBES2 with PBKDF2 and AES-CBC, as created by
+"openssl pkcs8 -topk8", and legacy encrypted PEM blocks with a
+"Proc-Type: 4,ENCRYPTED" header.  Keys that are not encrypted are loaded as
+with LoadKey.  If the passphrase is incorrect, ErrIncorrectPassphrase is
+returned, so that callers may ask for the passphrase again.
+*/
+func (k *Key) LoadKeyPassphrase(path string, scheme string, KeyIDHashAlgorithms []string, passphrase []byte) error {
+	pemFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer pemFile.Close()
+
+	err = k.LoadKeyReaderPassphrase(pemFile, scheme, KeyIDHashAlgorithms, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return pemFile.Close()
+}
+
 func (k *Key) LoadKeyDefaults(path string) error {
 	pemFile, err := os.Open(path)
 	if err != nil {
@@ -318,6 +718,9 @@ func (k *Key) LoadKeyDefaults(path string) error {
 
 // LoadKeyReader loads the key from a supplied reader. The logic matches LoadKey otherwise.
 func (k *Key) LoadKeyReader(r io.Reader, scheme string, KeyIDHashAlgorithms []string) error {
+	if err := validateKeyIDHashAlgorithms(KeyIDHashAlgorithms); err != nil {
+		return err
+	}
 	if r == nil {
 		return ErrNoPEMBlock
 	}
@@ -336,6 +739,35 @@ func (k *Key) LoadKeyReader(r io.Reader, scheme string, KeyIDHashAlgorithms []st
 	return k.loadKey(key, pemData, scheme, KeyIDHashAlgorithms)
 }
 
+// LoadKeyReaderPassphrase loads an encrypted key from a supplied reader. The logic matches LoadKeyPassphrase otherwise.
+func (k *Key) LoadKeyReaderPassphrase(r io.Reader, scheme string, KeyIDHashAlgorithms []string, passphrase []byte) error {
+	if err := validateKeyIDHashAlgorithms(KeyIDHashAlgorithms); err != nil {
+		return err
+	}
+	if r == nil {
+		return ErrNoPEMBlock
+	}
+	// Read key bytes
+	pemBytes, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	data, _ := pem.Decode(pemBytes)
+	if data == nil {
+		return ErrNoPEMBlock
+	}
+	pemData, err := decryptPEMBlock(data, passphrase)
+	if err != nil {
+		return err
+	}
+	key, err := parseKey(pemData.Bytes)
+	if err != nil {
+		return err
+	}
+
+	return k.loadKey(key, pemData, scheme, KeyIDHashAlgorithms)
+}
+
 func (k *Key) LoadKeyReaderDefaults(r io.Reader) error {
 	if r == nil {
 		return ErrNoPEMBlock
@@ -360,6 +792,246 @@ func (k *Key) LoadKeyReaderDefaults(r io.Reader) error {
 	return k.loadKey(key, pemData, scheme, keyIDHashAlgorithms)
 }
 
+/*
+LoadKeyFromBytes is like LoadKey, but parses the passed PEM bytes, e.g. a key
+read from a secret store or an environment variable, instead of a file.  The
+resulting key, including its keyid, is the same as when loading the bytes from
+a file.
+*/
+func (k *Key) LoadKeyFromBytes(pemBytes []byte, scheme string, keyIDHashAlgorithms []string) error {
+	return k.LoadKeyReader(bytes.NewReader(pemBytes), scheme, keyIDHashAlgorithms)
+}
+
+/*
+LoadPublicKeyFromBytes loads the public portion of the key in the passed PEM
+bytes, using the default scheme for its key type as LoadKeyDefaults does, but
+with the passed keyid hash algorithms.  If the bytes hold a private key, the
+private portion is dropped, so that the key is the same as when loading the
+corresponding public key.
+*/
+func (k *Key) LoadPublicKeyFromBytes(pemBytes []byte, keyIDHashAlgorithms []string) error {
+	pemData, key, err := decodeAndParse(pemBytes)
+	if err != nil {
+		return err
+	}
+	scheme, _, err := getDefaultKeyScheme(key)
+	if err != nil {
+		return err
+	}
+	if err := k.loadKey(key, pemData, scheme, keyIDHashAlgorithms); err != nil {
+		return err
+	}
+	k.KeyVal.Private = ""
+	return nil
+}
+
+/*
+GenerateKey generates a new key pair of the passed key type, i.e. "rsa",
+"ecdsa" or "ed25519", for use with the passed scheme.  If the scheme is empty,
+the default scheme for the key type is used, as with LoadKeyDefaults.  The curve
+of ecdsa keys is the one of their scheme, RSA keys have 3072 bits.  The keyid
+is computed with the sha256 and sha512 keyid hash algorithms, so that the key
+equals the key that LoadKeyDefaults loads from the files written with
+WritePrivateKey and WritePublicKey, unless the scheme is ecdsa-sha2-nistp224 or
+ecdsa-sha2-nistp521, which LoadKeyDefaults does not use.
+*/
+func GenerateKey(keyType string, scheme string) (Key, error) {
+	var privateKey interface{}
+	var err error
+	switch keyType {
+	case rsaKeyType:
+		privateKey, err = rsa.GenerateKey(rand.Reader, generatedRSAKeySize)
+	case ecdsaKeyType:
+		var curve elliptic.Curve
+		switch scheme {
+		case ecdsaSha2nistp224:
+			curve = elliptic.P224()
+		case ecdsaSha2nistp384:
+			curve = elliptic.P384()
+		case ecdsaSha2nistp521:
+			curve = elliptic.P521()
+		default:
+			curve = elliptic.P256()
+		}
+		privateKey, err = ecdsa.GenerateKey(curve, rand.Reader)
+	case ed25519KeyType:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return Key{}, fmt.Errorf("%w: %s", ErrUnsupportedKeyType, keyType)
+	}
+	if err != nil {
+		return Key{}, err
+	}
+
+	defaultScheme, keyIDHashAlgorithms, err := getDefaultKeyScheme(privateKey)
+	if err != nil {
+		return Key{}, err
+	}
+	if scheme == "" {
+		scheme = defaultScheme
+	}
+
+	var key Key
+	if err := key.loadKey(privateKey, nil, scheme, keyIDHashAlgorithms); err != nil {
+		return Key{}, err
+	}
+	if err := validateKey(key); err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+/*
+WritePrivateKey writes the private portion of the key as unencrypted PEM file
+to the passed path, which only the owner can read, in a format that LoadKey
+loads again.  It returns ErrNoPrivateKey if the key has no private portion.
+*/
+func (k *Key) WritePrivateKey(path string) error {
+	if k.KeyVal.Private == "" {
+		return ErrNoPrivateKey
+	}
+	pemBytes := []byte(k.KeyVal.Private + "\n")
+	if k.KeyType == ed25519KeyType {
+		privateKey, err := hex.DecodeString(k.KeyVal.Private)
+		if err != nil {
+			return err
+		}
+		if len(privateKey) != ed25519.PrivateKeySize {
+			return ErrInvalidKey
+		}
+		privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(ed25519.PrivateKey(privateKey))
+		if err != nil {
+			return err
+		}
+		pemBytes = generatePEMBlock(privateKeyBytes, pemPrivateKey)
+	}
+	return os.WriteFile(path, pemBytes, 0600)
+}
+
+/*
+WritePublicKey writes the public portion of the key as PEM file to the passed
+path, in a format that LoadKey loads again.
+*/
+func (k *Key) WritePublicKey(path string) error {
+	if k.KeyVal.Public == "" {
+		return fmt.Errorf("%w: keyval.public", ErrEmptyKeyField)
+	}
+	pemBytes := []byte(k.KeyVal.Public + "\n")
+	if k.KeyType == ed25519KeyType {
+		publicKey, err := hex.DecodeString(k.KeyVal.Public)
+		if err != nil {
+			return err
+		}
+		if len(publicKey) != ed25519.PublicKeySize {
+			return ErrInvalidKey
+		}
+		publicKeyBytes, err := x509.MarshalPKIXPublicKey(ed25519.PublicKey(publicKey))
+		if err != nil {
+			return err
+		}
+		pemBytes = generatePEMBlock(publicKeyBytes, pemPublicKey)
+	}
+	return os.WriteFile(path, pemBytes, 0644)
+}
+
+/*
+LoadCertificate loads an X.509 certificate from a PEM file as key, whose
+identity is the certificate rather than its public key, e.g. a short-lived
+certificate issued for keyless signing.  The certificate is stored in
+KeyVal.Certificate and its public key, with the default scheme for its key
+type, in KeyVal.Public, for verification.  The keyid is the hex encoded
+SHA256 fingerprint of the DER encoded certificate.
+
+If the key already holds the private key that belongs to the certificate, e.g.
+loaded with LoadKey, the private key and its scheme are kept, so that the key
+signs with the certificate as identity.  Signatures created with such a key
+carry the certificate, see Signature.GetCertificate.  Whether the certificate
+chains to trusted roots is not checked, see VerifyCertificate.
+*/
+func (k *Key) LoadCertificate(path string) error {
+	pemFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer pemFile.Close()
+
+	err = k.LoadCertificateReader(pemFile)
+	if err != nil {
+		return err
+	}
+
+	return pemFile.Close()
+}
+
+// LoadCertificateReader loads the certificate from a supplied reader. The logic matches LoadCertificate otherwise.
+func (k *Key) LoadCertificateReader(r io.Reader) error {
+	if r == nil {
+		return ErrNoPEMBlock
+	}
+	pemBytes, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	pemData, key, err := decodeAndParse(pemBytes)
+	if err != nil {
+		return err
+	}
+	cert, ok := key.(*x509.Certificate)
+	if !ok {
+		return ErrNotCertificate
+	}
+	scheme, keyIDHashAlgorithms, err := getDefaultKeyScheme(cert)
+	if err != nil {
+		return err
+	}
+
+	var certKey Key
+	if err := certKey.loadKey(cert, pemData, scheme, keyIDHashAlgorithms); err != nil {
+		return err
+	}
+	if k.KeyVal.Private != "" && k.KeyVal.Public == certKey.KeyVal.Public {
+		certKey.KeyVal.Private = k.KeyVal.Private
+		certKey.Scheme = k.Scheme
+		certKey.KeyIDHashAlgorithms = k.KeyIDHashAlgorithms
+	}
+	certKey.KeyID = certificateKeyID(cert)
+	if err := validateKey(certKey); err != nil {
+		return err
+	}
+	*k = certKey
+	return nil
+}
+
+/*
+certificateKeyID returns the keyid of a key loaded with LoadCertificate, that
+is the hex encoded SHA256 fingerprint of the passed certificate.
+*/
+func certificateKeyID(cert *x509.Certificate) string {
+	return fmt.Sprintf("%x", sha256.Sum256(cert.Raw))
+}
+
+/*
+VerifyCertificate verifies that the certificate of the key has a chain of
+trust to a root in rootCertPool, possibly using any intermediates in
+intermediateCertPool, see VerifyCertificateTrust.  It returns an error if the
+key does not hold a certificate.
+*/
+func (k *Key) VerifyCertificate(rootCertPool, intermediateCertPool *x509.CertPool) error {
+	if k.KeyVal.Certificate == "" {
+		return fmt.Errorf("key '%s' does not hold a certificate", k.KeyID)
+	}
+	_, key, err := decodeAndParse([]byte(k.KeyVal.Certificate))
+	if err != nil {
+		return err
+	}
+	cert, ok := key.(*x509.Certificate)
+	if !ok {
+		return ErrNotCertificate
+	}
+	_, err = VerifyCertificateTrust(cert, rootCertPool, intermediateCertPool)
+	return err
+}
+
 func getDefaultKeyScheme(key interface{}) (scheme string, keyIDHashAlgorithms []string, err error) {
 	keyIDHashAlgorithms = []string{"sha256", "sha512"}
 
@@ -368,8 +1040,10 @@ func getDefaultKeyScheme(key interface{}) (scheme string, keyIDHashAlgorithms []
 		scheme = rsassapsssha256Scheme
 	case ed25519.PrivateKey, ed25519.PublicKey:
 		scheme = ed25519Scheme
-	case *ecdsa.PrivateKey, *ecdsa.PublicKey:
-		scheme = ecdsaSha2nistp256
+	case *ecdsa.PrivateKey:
+		scheme = getDefaultEcdsaScheme(k.Curve)
+	case *ecdsa.PublicKey:
+		scheme = getDefaultEcdsaScheme(k.Curve)
 	case *x509.Certificate:
 		return getDefaultKeyScheme(k.PublicKey)
 	default:
@@ -379,6 +1053,17 @@ func getDefaultKeyScheme(key interface{}) (scheme string, keyIDHashAlgorithms []
 	return scheme, keyIDHashAlgorithms, err
 }
 
+/*
+getDefaultEcdsaScheme returns ecdsa-sha2-nistp384 for P-384 curves and
+ecdsa-sha2-nistp256 for all other curves, which all keys had before.
+*/
+func getDefaultEcdsaScheme(curve elliptic.Curve) string {
+	if curve.Params().BitSize == 384 {
+		return ecdsaSha2nistp384
+	}
+	return ecdsaSha2nistp256
+}
+
 func (k *Key) loadKey(keyObj interface{}, pemData *pem.Block, scheme string, keyIDHashAlgorithms []string) error {
 	switch key := keyObj.(type) {
 	case *rsa.PublicKey:
@@ -396,7 +1081,10 @@ func (k *Key) loadKey(keyObj interface{}, pemData *pem.Block, scheme string, key
 		if err != nil {
 			return err
 		}
-		if err := k.setKeyComponents(pubKeyBytes, pemData.Bytes, rsaKeyType, scheme, keyIDHashAlgorithms); err != nil {
+		// Private keys may also be passed in PKCS8 format, but are always
+		// stored as PKCS1, which is what the "RSA PRIVATE KEY" PEM type denotes
+		privKeyBytes := x509.MarshalPKCS1PrivateKey(key)
+		if err := k.setKeyComponents(pubKeyBytes, privKeyBytes, rsaKeyType, scheme, keyIDHashAlgorithms); err != nil {
 			return err
 		}
 	case ed25519.PublicKey:
@@ -417,7 +1105,13 @@ func (k *Key) loadKey(keyObj interface{}, pemData *pem.Block, scheme string, key
 		if err != nil {
 			return err
 		}
-		if err := k.setKeyComponents(pubKeyBytes, pemData.Bytes, ecdsaKeyType, scheme, keyIDHashAlgorithms); err != nil {
+		// Private keys may also be passed in SEC1 format, but are always
+		// stored as PKCS8, which is what the "PRIVATE KEY" PEM type denotes
+		privKeyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return err
+		}
+		if err := k.setKeyComponents(pubKeyBytes, privKeyBytes, ecdsaKeyType, scheme, keyIDHashAlgorithms); err != nil {
 			return err
 		}
 	case *ecdsa.PublicKey:
@@ -444,6 +1138,40 @@ func (k *Key) loadKey(keyObj interface{}, pemData *pem.Block, scheme string, key
 	return nil
 }
 
+/*
+GenerateSignature signs the passed bytes with the private portion of the key,
+as Metablock.Sign does for the canonical representation of the signed object,
+and returns the signature.  The signing method is chosen by the key type and
+scheme of the key, see NewKeySigner.
+*/
+func (k *Key) GenerateSignature(data []byte) (Signature, error) {
+	signer, err := NewKeySigner(*k)
+	if err != nil {
+		return Signature{}, err
+	}
+	return signer.Sign(data)
+}
+
+/*
+VerifySignature verifies the passed signature over the passed bytes with the
+public portion of the key, as Metablock.VerifySignature does for the canonical
+representation of the signed object.  The verification method is chosen by the
+key type and scheme of the key, see NewKeyVerifier.  It returns an error
+wrapping ErrInvalidKeyID if the keyid of the signature does not reference the
+key, see MatchesKeyID, and an error if the signature is invalid.
+*/
+func (k *Key) VerifySignature(sig Signature, data []byte) error {
+	if !k.MatchesKeyID(sig.KeyID) {
+		return fmt.Errorf("%w: signature by key '%s' cannot be verified with key '%s'",
+			ErrInvalidKeyID, sig.KeyID, k.KeyID)
+	}
+	verifier, err := NewKeyVerifier(*k)
+	if err != nil {
+		return err
+	}
+	return verifier.Verify(data, sig)
+}
+
 /*
 VerifyCertificateTrust verifies that the certificate has a chain of trust
 to a root in rootCertPool, possibly using any intermediates in
@@ -1,10 +1,25 @@
 package in_toto
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"math/big"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -60,8 +75,8 @@ func TestLoadKeyDefaults(t *testing.T) {
 		{"ed25519 public key", "carol.pub", "be6371bc627318218191ce0780fd3183cce6c36da02938a477d2e4dfae1804a6", ed25519Scheme},
 		{"ecdsa private key (P521)", "frank", "0ab02fd8a1195d902d4e71df38123be0d3fa9ea45ebc6e1246d8e82179acb6dd", ecdsaSha2nistp256},
 		{"ecdsa public key (P521)", "frank.pub", "0ab02fd8a1195d902d4e71df38123be0d3fa9ea45ebc6e1246d8e82179acb6dd", ecdsaSha2nistp256},
-		{"ecdsa private key (P384)", "grace", "a5fe82bffd11c43cd25b41b427496dea8eb61505bfa11907a6a565ebb00fa323", ecdsaSha2nistp256},
-		{"ecdsa public key (P384)", "grace.pub", "a5fe82bffd11c43cd25b41b427496dea8eb61505bfa11907a6a565ebb00fa323", ecdsaSha2nistp256},
+		{"ecdsa private key (P384)", "grace", "a5522ebccd492f64e6ec0bbcb5eb782708f6e26709a3712e64fff108b98e5142", ecdsaSha2nistp384},
+		{"ecdsa public key (P384)", "grace.pub", "a5522ebccd492f64e6ec0bbcb5eb782708f6e26709a3712e64fff108b98e5142", ecdsaSha2nistp384},
 		{"ecdsa private key (P224)", "heidi", "337f2a2bed46e863a68f17ae0e3e96756eca87c38080d872c5824493cec1ce1a", ecdsaSha2nistp256},
 		{"ecdsa public key (P224)", "heidi.pub", "337f2a2bed46e863a68f17ae0e3e96756eca87c38080d872c5824493cec1ce1a", ecdsaSha2nistp256},
 		{"rsa public key from certificate", "example.com.write-code.cert.pem", "4979dea7a8467cbe0299693703b81d490854143b859a469ec0f6349e7bdf582a", rsassapsssha256Scheme},
@@ -79,6 +94,34 @@ func TestLoadKeyDefaults(t *testing.T) {
 			t.Errorf("scheme for %s %s does not match expected scheme: %s. Got scheme %s", table.name, table.path, table.expectedScheme, key.Scheme)
 		}
 	}
+
+	// P-384 keys were loaded with the ecdsa-sha2-nistp256 scheme before, so
+	// signatures by their former keyid still verify
+	const legacyKeyID = "a5fe82bffd11c43cd25b41b427496dea8eb61505bfa11907a6a565ebb00fa323"
+	var legacy, grace Key
+	if err := legacy.LoadKey("grace", ecdsaSha2nistp256, []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, legacyKeyID, legacy.KeyID)
+	if err := grace.LoadKeyDefaults("grace.pub"); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, grace.MatchesKeyID(legacyKeyID))
+	mb := &Metablock{Signed: Link{Type: "link", Name: "foo"}}
+	if err := mb.Sign(legacy); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, mb.VerifySignature(grace))
+
+	// Other keys do not match their keyid with another scheme
+	var frank, frankP521 Key
+	if err := frank.LoadKeyDefaults("frank.pub"); err != nil {
+		t.Fatal(err)
+	}
+	if err := frankP521.LoadKey("frank.pub", ecdsaSha2nistp521, []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, frank.MatchesKeyID(frankP521.KeyID))
 }
 
 // TestLoadKeyReader makes sure, that our LoadKeyReader function loads keys correctly
@@ -88,6 +131,265 @@ func TestLoadKeyReader(t *testing.T) {
 	if err := key.LoadKeyReader(nil, "ed25519", []string{"sha256", "sha512"}); err != ErrNoPEMBlock {
 		t.Errorf("unexpected error loading key: %s", err)
 	}
+
+	// Keys loaded from memory equal keys loaded from disk, e.g. for keys
+	// that are provided by a secret manager
+	for _, path := range []string{"carol", "carol.pub"} {
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fileKey, readerKey Key
+		if err := fileKey.LoadKey(path, "ed25519", []string{"sha256", "sha512"}); err != nil {
+			t.Fatalf("failed key.LoadKey() for %s: %s", path, err)
+		}
+		if err := readerKey.LoadKeyReader(bytes.NewBuffer(pemBytes), "ed25519", []string{"sha256", "sha512"}); err != nil {
+			t.Fatalf("failed key.LoadKeyReader() for %s: %s", path, err)
+		}
+		if readerKey.KeyID != "be6371bc627318218191ce0780fd3183cce6c36da02938a477d2e4dfae1804a6" {
+			t.Errorf("keyID for %s does not match expected keyID. Got keyID: %s", path, readerKey.KeyID)
+		}
+		if !reflect.DeepEqual(fileKey, readerKey) {
+			t.Errorf("key.LoadKeyReader() for %s returned %v, expected %v", path, readerKey, fileKey)
+		}
+	}
+}
+
+func TestLoadKeyFromBytes(t *testing.T) {
+	var tables = []struct {
+		path   string
+		scheme string
+	}{
+		{"alice", "rsassa-pss-sha256"},
+		{"carol", "ed25519"},
+		{"grace", "ecdsa-sha2-nistp384"},
+	}
+	for _, table := range tables {
+		pemBytes, err := os.ReadFile(table.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fileKey, key Key
+		if err := fileKey.LoadKey(table.path, table.scheme, []string{"sha256", "sha512"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := key.LoadKeyFromBytes(pemBytes, table.scheme, []string{"sha256", "sha512"}); err != nil {
+			t.Fatalf("failed key.LoadKeyFromBytes() for %s: %s", table.path, err)
+		}
+		assert.Equal(t, fileKey, key)
+
+		// The public key is the same, whether loaded from the public or the
+		// private key
+		var filePubKey, pubKey, pubKeyFromPrivate Key
+		if err := filePubKey.LoadKey(table.path+".pub", table.scheme, []string{"sha256", "sha512"}); err != nil {
+			t.Fatal(err)
+		}
+		pubPemBytes, err := os.ReadFile(table.path + ".pub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pubKey.LoadPublicKeyFromBytes(pubPemBytes, []string{"sha256", "sha512"}); err != nil {
+			t.Fatalf("failed key.LoadPublicKeyFromBytes() for %s: %s", table.path, err)
+		}
+		assert.Equal(t, filePubKey, pubKey)
+		if err := pubKeyFromPrivate.LoadPublicKeyFromBytes(pemBytes, []string{"sha256", "sha512"}); err != nil {
+			t.Fatalf("failed key.LoadPublicKeyFromBytes() for %s: %s", table.path, err)
+		}
+		assert.Equal(t, filePubKey, pubKeyFromPrivate)
+	}
+
+	var key Key
+	assert.ErrorIs(t, key.LoadKeyFromBytes([]byte("not a key"), "ed25519", []string{"sha256"}), ErrNoPEMBlock)
+	assert.ErrorIs(t, key.LoadPublicKeyFromBytes(nil, []string{"sha256"}), ErrNoPEMBlock)
+}
+
+// TestLoadKeyEcdsaSEC1 makes sure, that ecdsa keys in SEC1 format are loaded
+// with the scheme of their curve, are stored as PKCS8 and have the keyid that
+// the securesystemslib computes over the canonical public key.
+func TestLoadKeyEcdsaSEC1(t *testing.T) {
+	curves := map[string]elliptic.Curve{
+		"ecdsa-sha2-nistp256": elliptic.P256(),
+		"ecdsa-sha2-nistp384": elliptic.P384(),
+	}
+	for scheme, curve := range curves {
+		ecKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sec1Bytes, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sec1PEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1Bytes})
+
+		var key Key
+		if err := key.LoadKeyReaderDefaults(bytes.NewReader(sec1PEM)); err != nil {
+			t.Fatalf("failed key.LoadKeyReaderDefaults() for %s: %s", scheme, err)
+		}
+		assert.Equal(t, "ecdsa", key.KeyType)
+		assert.Equal(t, scheme, key.Scheme)
+
+		privBlock, _ := pem.Decode([]byte(key.KeyVal.Private))
+		if privBlock == nil || privBlock.Type != "PRIVATE KEY" {
+			t.Fatalf("private key for %s is not a PKCS8 PEM block: %s", scheme, key.KeyVal.Private)
+		}
+		if _, err := x509.ParsePKCS8PrivateKey(privBlock.Bytes); err != nil {
+			t.Errorf("private key for %s is not in PKCS8 format: %s", scheme, err)
+		}
+
+		// This is the canonical representation the securesystemslib hashes,
+		// which only escapes quotes and backslashes, i.e. none in a PEM block
+		canonical := `{"keyid_hash_algorithms":["sha256","sha512"],"keytype":"ecdsa","keyval":{"public":"` +
+			key.KeyVal.Public + `"},"scheme":"` + scheme + `"}`
+		assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte(canonical))), key.KeyID)
+	}
+}
+
+// TestLoadKeyPKCS8 makes sure, that private keys wrapped in PKCS8 PEM blocks
+// load the same keys as their legacy counterparts, and sign accordingly.
+func TestLoadKeyPKCS8(t *testing.T) {
+	var tables = []struct {
+		path   string
+		scheme string
+	}{
+		{"alice", "rsassa-pss-sha256"},
+		{"carol", "ed25519"},
+	}
+	for _, table := range tables {
+		var legacy Key
+		if err := legacy.LoadKey(table.path, table.scheme, []string{"sha256", "sha512"}); err != nil {
+			t.Fatal(err)
+		}
+		var parsed interface{}
+		pemBytes, err := os.ReadFile(table.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, parsed, err = decodeAndParse(pemBytes); err != nil {
+			t.Fatal(err)
+		}
+		pkcs8Bytes, err := x509.MarshalPKCS8PrivateKey(parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkcs8PEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Bytes})
+
+		var key Key
+		if err := key.LoadKeyReader(bytes.NewReader(pkcs8PEM), table.scheme, []string{"sha256", "sha512"}); err != nil {
+			t.Fatalf("failed key.LoadKeyReader() for PKCS8 %s: %s", table.path, err)
+		}
+		assert.Equal(t, legacy, key)
+
+		var pubKey Key
+		if err := pubKey.LoadKey(table.path+".pub", table.scheme, []string{"sha256", "sha512"}); err != nil {
+			t.Fatal(err)
+		}
+		mb := &Metablock{Signed: Link{Type: "link", Name: "pkcs8"}}
+		if err := mb.Sign(key); err != nil {
+			t.Fatalf("failed signing with PKCS8 %s: %s", table.path, err)
+		}
+		assert.Nil(t, mb.VerifySignature(pubKey))
+	}
+}
+
+// TestLoadKeyPassphrase makes sure, that encrypted keys load the same keys as
+// their unencrypted counterparts, and that wrong passphrases are detected.
+func TestLoadKeyPassphrase(t *testing.T) {
+	var tables = []struct {
+		path      string
+		plainPath string
+		scheme    string
+	}{
+		{"carol.enc", "carol", "ed25519"},
+		{"dan.enc", "dan", "rsassa-pss-sha256"},
+	}
+	for _, table := range tables {
+		var plainKey, key Key
+		if err := plainKey.LoadKey(table.plainPath, table.scheme, []string{"sha256", "sha512"}); err != nil {
+			t.Fatalf("failed key.LoadKey() for %s: %s", table.plainPath, err)
+		}
+		if err := key.LoadKeyPassphrase(table.path, table.scheme, []string{"sha256", "sha512"}, []byte("in-toto")); err != nil {
+			t.Fatalf("failed key.LoadKeyPassphrase() for %s: %s", table.path, err)
+		}
+		assert.Equal(t, plainKey, key, table.path)
+
+		err := key.LoadKeyPassphrase(table.path, table.scheme, []string{"sha256", "sha512"}, []byte("wrong"))
+		if !errors.Is(err, ErrIncorrectPassphrase) {
+			t.Errorf("key.LoadKeyPassphrase() for %s with wrong passphrase returned '%v', expected '%s'", table.path, err, ErrIncorrectPassphrase)
+		}
+		if err := key.LoadKey(table.path, table.scheme, []string{"sha256", "sha512"}); err == nil {
+			t.Errorf("key.LoadKey() for encrypted %s did not fail", table.path)
+		}
+	}
+
+	// Unencrypted keys are loaded regardless of the passphrase
+	var key Key
+	if err := key.LoadKeyPassphrase("carol", "ed25519", []string{"sha256", "sha512"}, []byte("unused")); err != nil {
+		t.Errorf("failed key.LoadKeyPassphrase() for unencrypted key: %s", err)
+	}
+}
+
+// TestDecryptPKCS8Params makes sure, that decryptPKCS8 rejects PBKDF2
+// parameters with an invalid or excessive iteration count, or with a key length
+// that does not match the cipher, before deriving the key.
+func TestDecryptPKCS8Params(t *testing.T) {
+	pemBytes, err := os.ReadFile("carol.enc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemBytes)
+
+	// withKDFParams re-encodes carol.enc with modified PBKDF2 parameters
+	withKDFParams := func(modify func(*pbkdf2Params)) []byte {
+		var info encryptedPrivateKeyInfo
+		var params pbes2Params
+		var kdfParams pbkdf2Params
+		if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+			t.Fatal(err)
+		}
+		modify(&kdfParams)
+		der, err := asn1.Marshal(kdfParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params.KeyDerivationFunc.Parameters = asn1.RawValue{FullBytes: der}
+		if der, err = asn1.Marshal(params); err != nil {
+			t.Fatal(err)
+		}
+		info.Algorithm.Parameters = asn1.RawValue{FullBytes: der}
+		if der, err = asn1.Marshal(info); err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+
+	// An explicit key length that matches AES-256 is fine
+	if _, err := decryptPKCS8(withKDFParams(func(p *pbkdf2Params) { p.KeyLength = 32 }), []byte("in-toto")); err != nil {
+		t.Errorf("decryptPKCS8() with matching key length failed: %s", err)
+	}
+
+	invalidTables := []struct {
+		name   string
+		modify func(*pbkdf2Params)
+		err    error
+	}{
+		{"zero iterations", func(p *pbkdf2Params) { p.IterationCount = 0 }, ErrFailedPEMParsing},
+		{"negative iterations", func(p *pbkdf2Params) { p.IterationCount = -1 }, ErrFailedPEMParsing},
+		{"too many iterations", func(p *pbkdf2Params) { p.IterationCount = 1 << 30 }, ErrUnsupportedKeyEncryption},
+		{"mismatching key length", func(p *pbkdf2Params) { p.KeyLength = 16 }, ErrFailedPEMParsing},
+	}
+	for _, table := range invalidTables {
+		_, err := decryptPKCS8(withKDFParams(table.modify), []byte("in-toto"))
+		if !errors.Is(err, table.err) {
+			t.Errorf("decryptPKCS8() with %s returned '%v', expected '%s'", table.name, err, table.err)
+		}
+	}
 }
 
 // TestLoadKeyErrors tests the LoadKey functions for the most popular errors:
@@ -171,6 +473,195 @@ func TestSetKeyComponentsErrors(t *testing.T) {
 	}
 }
 
+func TestValidateKeyID(t *testing.T) {
+	var key Key
+	if err := key.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, key.ValidateKeyID())
+
+	// The keyid covers the keyid hash algorithms, too
+	tampered := key
+	tampered.KeyIDHashAlgorithms = []string{"sha256"}
+	assert.ErrorIs(t, tampered.ValidateKeyID(), ErrInvalidKeyID)
+	tampered = key
+	tampered.KeyID = strings.Repeat("0", 64)
+	assert.ErrorIs(t, tampered.ValidateKeyID(), ErrInvalidKeyID)
+}
+
+func TestComputeKeyID(t *testing.T) {
+	var key Key
+	if err := key.LoadKey("carol", "ed25519", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	keyID, err := key.ComputeKeyID()
+	assert.Nil(t, err)
+	assert.Equal(t, key.KeyID, keyID)
+
+	// The keyid field is ignored and left unchanged
+	bogus := key
+	bogus.KeyID = "this-is-invalid"
+	keyID, err = bogus.ComputeKeyID()
+	assert.Nil(t, err)
+	assert.Equal(t, key.KeyID, keyID)
+	assert.Equal(t, "this-is-invalid", bogus.KeyID)
+
+	// Loading a key overwrites a previous keyid
+	if err := bogus.LoadKey("carol", "ed25519", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, key.KeyID, bogus.KeyID)
+
+	// Keys with a keyid that does not match cannot sign
+	mismatched := key
+	mismatched.KeyID = strings.Repeat("0", 64)
+	_, err = NewKeySigner(mismatched)
+	assert.ErrorIs(t, err, ErrInvalidKeyID)
+	env := &Envelope{}
+	if err := env.SetPayload(Link{Type: "link", Name: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorIs(t, env.Sign(mismatched), ErrInvalidKeyID)
+
+	empty := Key{KeyType: "ed25519", Scheme: "ed25519"}
+	_, err = empty.ComputeKeyID()
+	assert.ErrorIs(t, err, ErrEmptyKeyField)
+}
+
+func TestLoadKeyUnsupportedKeyIDHashAlgorithms(t *testing.T) {
+	var key Key
+	// The keyid hash algorithms are checked before the key is read
+	err := key.LoadKey("does-not-exist", "ed25519", []string{"sha256", "md5", "foo"})
+	assert.ErrorIs(t, err, ErrUnsupportedKeyIDHashAlgorithms)
+	assert.ErrorContains(t, err, "md5, foo, supported are: sha256, sha512")
+	err = key.LoadKeyReaderPassphrase(strings.NewReader(""), "ed25519", []string{"sha384"}, nil)
+	assert.ErrorIs(t, err, ErrUnsupportedKeyIDHashAlgorithms)
+}
+
+func TestComputeKeyIDs(t *testing.T) {
+	var key Key
+	if err := key.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	keyCanonical, err := key.keyIDRepresentation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyIDs, err := key.ComputeKeyIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{key.KeyID, fmt.Sprintf("%x", sha512.Sum512(keyCanonical))}, keyIDs)
+	for _, keyID := range keyIDs {
+		assert.True(t, key.MatchesKeyID(keyID))
+		alternative := key
+		alternative.KeyID = keyID
+		assert.Nil(t, alternative.ValidateKeyID())
+	}
+	assert.False(t, key.MatchesKeyID(strings.Repeat("0", 64)))
+
+	// Without keyid hash algorithms, the sha256 keyid references the key, with
+	// and without the field, as securesystemslib omits it
+	key.KeyIDHashAlgorithms = nil
+	if err := key.generateKeyID(); err != nil {
+		t.Fatal(err)
+	}
+	keyCanonical, err = EncodeCanonical(map[string]interface{}{
+		"keytype": key.KeyType,
+		"scheme":  key.Scheme,
+		"keyval":  map[string]string{"public": key.KeyVal.Public},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutField := fmt.Sprintf("%x", sha256.Sum256(keyCanonical))
+	keyIDs, err = key.ComputeKeyIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{key.KeyID, withoutField}, keyIDs)
+	assert.True(t, key.MatchesKeyID(withoutField))
+	key.KeyID = withoutField
+	assert.Nil(t, key.ValidateKeyID())
+}
+
+func TestLoadCertificate(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "functionary@example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, ecKey.Public(), ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privBytes, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "functionary.cert.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "functionary")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var certKey Key
+	if err := certKey.LoadCertificate(certPath); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(certDER)), certKey.KeyID)
+	assert.Equal(t, "ecdsa", certKey.KeyType)
+	assert.Equal(t, "ecdsa-sha2-nistp256", certKey.Scheme)
+	assert.Empty(t, certKey.KeyVal.Private)
+	assert.Nil(t, certKey.ValidateKeyID())
+
+	// The private key signs with the certificate as identity
+	var signingKey Key
+	if err := signingKey.LoadKeyDefaults(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := signingKey.LoadCertificate(certPath); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, certKey.KeyID, signingKey.KeyID)
+	assert.NotEmpty(t, signingKey.KeyVal.Private)
+
+	mb := &Metablock{Signed: Link{Type: "link", Name: "cert"}}
+	if err := mb.Sign(signingKey); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, mb.VerifySignature(certKey))
+	sigKey, err := mb.Signatures[0].GetCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, certKey, sigKey)
+
+	// Chains of trust are checked on request
+	rootPool := x509.NewCertPool()
+	rootPool.AppendCertsFromPEM([]byte(certKey.KeyVal.Certificate))
+	assert.Nil(t, certKey.VerifyCertificate(rootPool, x509.NewCertPool()))
+	assert.NotNil(t, certKey.VerifyCertificate(x509.NewCertPool(), x509.NewCertPool()))
+
+	// Keys and certificates are not mixed up
+	var key Key
+	err = key.LoadCertificate(keyPath)
+	assert.ErrorIs(t, err, ErrNotCertificate)
+	var aliceKey Key
+	if err := aliceKey.LoadKeyDefaults("alice.pub"); err != nil {
+		t.Fatal(err)
+	}
+	assert.ErrorContains(t, aliceKey.VerifyCertificate(rootPool, x509.NewCertPool()), "does not hold a certificate")
+}
+
 func TestVerifyCertificateTrust(t *testing.T) {
 	var rootKey, intermediateKey, leafKey Key
 	err := rootKey.LoadKeyDefaults("root.cert.pem")
@@ -204,3 +695,140 @@ func TestVerifyCertificateTrust(t *testing.T) {
 	_, err = VerifyCertificateTrust(leafCert, x509.NewCertPool(), intermediatePool)
 	assert.NotNil(t, err, "expected error with missing root")
 }
+
+func TestGenerateKey(t *testing.T) {
+	tables := []struct {
+		keyType        string
+		scheme         string
+		expectedScheme string
+	}{
+		{"rsa", "", "rsassa-pss-sha256"},
+		{"ecdsa", "", "ecdsa-sha2-nistp256"},
+		{"ecdsa", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp384"},
+		{"ed25519", "ed25519", "ed25519"},
+	}
+	for _, table := range tables {
+		t.Run(table.expectedScheme, func(t *testing.T) {
+			key, err := GenerateKey(table.keyType, table.scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, table.keyType, key.KeyType)
+			assert.Equal(t, table.expectedScheme, key.Scheme)
+			assert.Equal(t, []string{"sha256", "sha512"}, key.KeyIDHashAlgorithms)
+			assert.NotEmpty(t, key.KeyVal.Private)
+			assert.Nil(t, key.ValidateKeyID())
+
+			dir := t.TempDir()
+			privPath := filepath.Join(dir, "key")
+			pubPath := filepath.Join(dir, "key.pub")
+			if err := key.WritePrivateKey(privPath); err != nil {
+				t.Fatal(err)
+			}
+			if err := key.WritePublicKey(pubPath); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(privPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+			var priv, pub Key
+			if err := priv.LoadKeyDefaults(privPath); err != nil {
+				t.Fatal(err)
+			}
+			if err := pub.LoadKeyDefaults(pubPath); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, key, priv)
+			assert.Equal(t, key.KeyID, pub.KeyID)
+			assert.Empty(t, pub.KeyVal.Private)
+
+			mb := &Metablock{Signed: Link{Type: "link", Name: "generated"}}
+			if err := mb.Sign(priv); err != nil {
+				t.Fatal(err)
+			}
+			assert.Nil(t, mb.VerifySignature(pub))
+
+			assert.ErrorIs(t, pub.WritePrivateKey(filepath.Join(dir, "pub")), ErrNoPrivateKey)
+		})
+	}
+
+	_, err := GenerateKey("dsa", "")
+	assert.ErrorIs(t, err, ErrUnsupportedKeyType)
+	_, err = GenerateKey("ed25519", "rsassa-pss-sha256")
+	assert.ErrorIs(t, err, ErrSchemeKeyTypeMismatch)
+}
+
+func TestKeyGenerateVerifySignature(t *testing.T) {
+	tables := []struct {
+		private string
+		public  string
+	}{
+		{"alice", "alice.pub"},
+		{"carol", "carol.pub"},
+		{"grace", "grace.pub"},
+	}
+	for _, table := range tables {
+		t.Run(table.private, func(t *testing.T) {
+			var priv, pub Key
+			if err := priv.LoadKeyDefaults(table.private); err != nil {
+				t.Fatal(err)
+			}
+			if err := pub.LoadKeyDefaults(table.public); err != nil {
+				t.Fatal(err)
+			}
+
+			data := []byte("arbitrary payload")
+			sig, err := priv.GenerateSignature(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, priv.KeyID, sig.KeyID)
+			assert.Nil(t, pub.VerifySignature(sig, data))
+			assert.NotNil(t, pub.VerifySignature(sig, []byte("other payload")))
+
+			// Signatures over the signable representation of a Metablock are
+			// the signatures of the Metablock
+			mb := &Metablock{Signed: Link{Type: "link", Name: "foo"}}
+			payload, err := mb.GetSignableRepresentation()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err = priv.GenerateSignature(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mb.Signatures = []Signature{sig}
+			assert.Nil(t, mb.VerifySignature(pub))
+
+			foreign := sig
+			foreign.KeyID = strings.Repeat("0", 64)
+			assert.ErrorIs(t, pub.VerifySignature(foreign, payload), ErrInvalidKeyID)
+		})
+	}
+
+	// Public keys cannot sign
+	var pub Key
+	if err := pub.LoadKeyDefaults("carol.pub"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := pub.GenerateSignature([]byte("data"))
+	assert.NotNil(t, err)
+
+	// GPG signatures of other implementations verify, too
+	var gpgKey Key
+	if err := gpgKey.LoadGPGKey("gpg.pub.asc"); err != nil {
+		t.Fatal(err)
+	}
+	link, err := LoadMetadata("write-code-gpg.bacfdc3a.link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := link.(*Metablock).GetSignableRepresentation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, gpgKey.VerifySignature(link.Sigs()[0], payload))
+}
@@ -0,0 +1,204 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+)
+
+// Signing algorithms of AWS KMS that produce in-toto compatible signatures
+const (
+	AWSSigningAlgorithmRSAPSSSHA256 = "RSASSA_PSS_SHA_256"
+	AWSSigningAlgorithmECDSASHA256  = "ECDSA_SHA_256"
+	AWSSigningAlgorithmECDSASHA384  = "ECDSA_SHA_384"
+	AWSSigningAlgorithmECDSASHA512  = "ECDSA_SHA_512"
+)
+
+// ErrUnsupportedKeySpec is returned for KMS keys that are neither RSA nor ECDSA on a NIST curve
+var ErrUnsupportedKeySpec = errors.New("unsupported KMS key spec")
+
+/*
+AWSClient captures the AWS KMS operations needed to sign with a KMS key.
+NewAWSKMSSigner uses an implementation backed by the KMS client of the AWS
+SDK; other implementations may be passed to NewAWSKMSSignerWithClient, e.g. to
+reuse a configured client:
+
+  - GetPublicKey returns the DER encoded SubjectPublicKeyInfo of the key, i.e.
+    the PublicKey field of the GetPublicKey output.
+  - Sign signs the passed digest (MessageType DIGEST) with the passed signing
+    algorithm and returns the Signature field of the Sign output.
+*/
+type AWSClient interface {
+	GetPublicKey(ctx context.Context, keyID string) ([]byte, error)
+	Sign(ctx context.Context, keyID string, digest []byte, signingAlgorithm string) ([]byte, error)
+}
+
+/*
+AWSKMSSigner is an intoto.Signer that signs with an asymmetric AWS KMS key, so
+that the private key never leaves the HSM.
+*/
+type AWSKMSSigner struct {
+	client    AWSClient
+	keyARN    string
+	key       intoto.Key
+	algorithm string
+	hash      crypto.Hash
+}
+
+/*
+NewAWSKMSSigner is a wrapper around NewAWSKMSSignerCtx, which uses a background
+context.
+*/
+func NewAWSKMSSigner(keyARN string) (*AWSKMSSigner, error) {
+	return NewAWSKMSSignerCtx(context.Background(), keyARN)
+}
+
+/*
+NewAWSKMSSignerCtx returns a Signer for the KMS key with the passed ARN, which
+calls KMS through the AWS SDK.  Credentials and settings are loaded from the
+default sources of the SDK, i.e. environment variables, shared configuration
+files and instance roles.  If keyARN is an ARN, the key is addressed in its
+region, otherwise, e.g. for key ids or aliases, in the configured one.
+*/
+func NewAWSKMSSignerCtx(ctx context.Context, keyARN string) (*AWSKMSSigner, error) {
+	var optFns []func(*config.LoadOptions) error
+	if parts := strings.Split(keyARN, ":"); len(parts) >= 6 && parts[0] == "arn" && parts[2] == "kms" {
+		optFns = append(optFns, config.WithRegion(parts[3]))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	return NewAWSKMSSignerWithClient(ctx, &sdkAWSClient{client: kms.NewFromConfig(cfg)}, keyARN)
+}
+
+/*
+NewAWSKMSSignerWithClient fetches the public key of the KMS key with the
+passed ARN via the passed client and returns a Signer for it.  RSA keys sign
+with RSASSA_PSS_SHA_256, matching the rsassa-pss-sha256 scheme, and ECDSA keys
+with the ECDSA algorithm matching their curve.  The in-toto keyid is derived
+from the public key, exactly like for a Key loaded from the public key in PEM
+format, so that signatures verify against such a Key.
+*/
+func NewAWSKMSSignerWithClient(ctx context.Context, client AWSClient, keyARN string) (*AWSKMSSigner, error) {
+	der, err := client.GetPublicKey(ctx, keyARN)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &AWSKMSSigner{client: client, keyARN: keyARN}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		s.algorithm, s.hash = AWSSigningAlgorithmRSAPSSSHA256, crypto.SHA256
+	case *ecdsa.PublicKey:
+		switch pub.Curve.Params().BitSize {
+		case 256:
+			s.algorithm, s.hash = AWSSigningAlgorithmECDSASHA256, crypto.SHA256
+		case 384:
+			s.algorithm, s.hash = AWSSigningAlgorithmECDSASHA384, crypto.SHA384
+		case 521:
+			s.algorithm, s.hash = AWSSigningAlgorithmECDSASHA512, crypto.SHA512
+		default:
+			return nil, fmt.Errorf("%w: curve %s", ErrUnsupportedKeySpec, pub.Curve.Params().Name)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKeySpec, pub)
+	}
+
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := s.key.LoadKeyReaderDefaults(bytes.NewReader(pemBytes)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// KeyID returns the in-toto keyid of the KMS key.
+func (s *AWSKMSSigner) KeyID() string {
+	return s.key.KeyID
+}
+
+/*
+PublicKey returns the public portion of the KMS key as Key, e.g. to add it to
+the keys of a layout.
+*/
+func (s *AWSKMSSigner) PublicKey() intoto.Key {
+	return s.key
+}
+
+// Sign is a wrapper around SignCtx, which uses a background context.
+func (s *AWSKMSSigner) Sign(data []byte) (intoto.Signature, error) {
+	return s.SignCtx(context.Background(), data)
+}
+
+/*
+SignCtx hashes the passed data and has it signed by KMS.  The passed context
+applies to the KMS request, e.g. to bound it with a deadline.
+*/
+func (s *AWSKMSSigner) SignCtx(ctx context.Context, data []byte) (intoto.Signature, error) {
+	var digest []byte
+	switch s.hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256(data)
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(data)
+		digest = sum[:]
+	default:
+		sum := sha512.Sum512(data)
+		digest = sum[:]
+	}
+	sig, err := s.client.Sign(ctx, s.keyARN, digest, s.algorithm)
+	if err != nil {
+		return intoto.Signature{}, err
+	}
+	return intoto.Signature{
+		KeyID: s.key.KeyID,
+		Sig:   hex.EncodeToString(sig),
+	}, nil
+}
+
+// sdkAWSClient implements AWSClient with the KMS client of the AWS SDK
+type sdkAWSClient struct {
+	client *kms.Client
+}
+
+func (c *sdkAWSClient) GetPublicKey(ctx context.Context, keyID string) ([]byte, error) {
+	out, err := c.client.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return nil, err
+	}
+	return out.PublicKey, nil
+}
+
+func (c *sdkAWSClient) Sign(ctx context.Context, keyID string, digest []byte, signingAlgorithm string) ([]byte, error) {
+	out, err := c.client.Sign(ctx, &kms.SignInput{
+		KeyId:            aws.String(keyID),
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: types.SigningAlgorithmSpec(signingAlgorithm),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Signature, nil
+}
@@ -0,0 +1,202 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAWSClient signs digests with a local key, like KMS does with MessageType DIGEST
+type fakeAWSClient struct {
+	keyARN string
+	key    crypto.Signer
+}
+
+func (c *fakeAWSClient) GetPublicKey(ctx context.Context, keyID string) ([]byte, error) {
+	if keyID != c.keyARN {
+		return nil, fmt.Errorf("NotFoundException: %s", keyID)
+	}
+	return x509.MarshalPKIXPublicKey(c.key.Public())
+}
+
+func (c *fakeAWSClient) Sign(ctx context.Context, keyID string, digest []byte, signingAlgorithm string) ([]byte, error) {
+	if keyID != c.keyARN {
+		return nil, fmt.Errorf("NotFoundException: %s", keyID)
+	}
+	switch key := c.key.(type) {
+	case *rsa.PrivateKey:
+		if signingAlgorithm != AWSSigningAlgorithmRSAPSSSHA256 {
+			return nil, fmt.Errorf("InvalidKeyUsageException: %s", signingAlgorithm)
+		}
+		return rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case *ecdsa.PrivateKey:
+		if !strings.HasPrefix(signingAlgorithm, "ECDSA_SHA_") {
+			return nil, fmt.Errorf("InvalidKeyUsageException: %s", signingAlgorithm)
+		}
+		return ecdsa.SignASN1(rand.Reader, key, digest)
+	}
+	return nil, errors.New("unexpected key")
+}
+
+func TestAWSKMSSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]crypto.Signer{"rsa": rsaKey}
+	for name, curve := range map[string]elliptic.Curve{"p256": elliptic.P256(), "p384": elliptic.P384(), "p521": elliptic.P521()} {
+		ecKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = ecKey
+	}
+
+	for name, key := range keys {
+		t.Run(name, func(t *testing.T) {
+			keyARN := "arn:aws:kms:us-east-1:123456789012:key/" + name
+			signer, err := NewAWSKMSSignerWithClient(context.Background(), &fakeAWSClient{keyARN: keyARN, key: key}, keyARN)
+			if err != nil {
+				t.Fatalf("NewAWSKMSSigner returned '%s'", err)
+			}
+
+			// The keyid is the one of the public key loaded from PEM
+			der, err := x509.MarshalPKIXPublicKey(key.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pubKey intoto.Key
+			if err := pubKey.LoadKeyReaderDefaults(strings.NewReader(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, pubKey.KeyID, signer.KeyID())
+			assert.Equal(t, pubKey, signer.PublicKey())
+
+			mb := intoto.Metablock{Signed: intoto.Link{
+				Type:        "link",
+				Name:        "kms",
+				Materials:   map[string]intoto.HashObj{},
+				Products:    map[string]intoto.HashObj{},
+				ByProducts:  map[string]interface{}{},
+				Command:     []string{},
+				Environment: map[string]interface{}{},
+			}}
+			if err := mb.SignWith(signer); err != nil {
+				t.Fatalf("SignWith returned '%s'", err)
+			}
+			assert.Nil(t, mb.VerifySignature(pubKey))
+		})
+	}
+
+	// Only RSA and ECDSA keys can sign in-toto metadata
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewAWSKMSSignerWithClient(context.Background(), &fakeAWSClient{keyARN: "ed25519", key: edKey}, "ed25519")
+	assert.ErrorIs(t, err, ErrUnsupportedKeySpec)
+
+	// KMS errors are passed through
+	_, err = NewAWSKMSSignerWithClient(context.Background(), &fakeAWSClient{keyARN: "rsa", key: rsaKey}, "missing")
+	assert.ErrorContains(t, err, "NotFoundException")
+}
+
+func TestNewAWSKMSSigner(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyARN := "arn:aws:kms:eu-west-1:123456789012:key/test"
+	fake := &fakeAWSClient{keyARN: keyARN, key: ecKey}
+
+	// Serve the JSON protocol of KMS, backed by the fake client
+	var regions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The region is part of the credential scope of the signed request
+		if _, scope, ok := strings.Cut(r.Header.Get("Authorization"), "Credential="); ok {
+			if parts := strings.Split(scope, "/"); len(parts) > 2 {
+				regions = append(regions, parts[2])
+			}
+		}
+		var in struct {
+			KeyId            string
+			Message          []byte
+			SigningAlgorithm string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var out map[string]interface{}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			der, err := fake.GetPublicKey(r.Context(), in.KeyId)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			out = map[string]interface{}{"KeyId": in.KeyId, "PublicKey": der}
+		case "TrentService.Sign":
+			sig, err := fake.Sign(r.Context(), in.KeyId, in.Message, in.SigningAlgorithm)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			out = map[string]interface{}{"KeyId": in.KeyId, "Signature": sig, "SigningAlgorithm": in.SigningAlgorithm}
+		default:
+			http.Error(w, "unexpected target", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		_ = json.NewEncoder(w).Encode(out)
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_CONFIG_FILE", "does-not-exist")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "does-not-exist")
+
+	signer, err := NewAWSKMSSigner(keyARN)
+	if err != nil {
+		t.Fatalf("NewAWSKMSSigner returned '%s'", err)
+	}
+	mb := intoto.Metablock{Signed: intoto.Link{
+		Type:        "link",
+		Name:        "kms",
+		Materials:   map[string]intoto.HashObj{},
+		Products:    map[string]intoto.HashObj{},
+		ByProducts:  map[string]interface{}{},
+		Command:     []string{},
+		Environment: map[string]interface{}{},
+	}}
+	assert.Nil(t, mb.SignWith(signer))
+	assert.Nil(t, mb.VerifySignature(signer.PublicKey()))
+
+	// Requests go to the region of the ARN rather than the configured one
+	assert.Equal(t, []string{"eu-west-1", "eu-west-1"}, regions)
+
+	// SignCtx passes the context on to the request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = signer.SignCtx(ctx, []byte("data"))
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,184 @@
+package in_toto
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+/*
+LayoutBuilder assembles a Layout step by step, as alternative to a Layout
+literal.  Its methods return the builder itself, so that calls can be chained:
+
+	layout, err := NewLayoutBuilder().
+		AddKey(bobKey).
+		AddStep(Step{
+			SupplyChainItem: SupplyChainItem{Name: "write-code"},
+			PubKeys:         []string{bobKey.KeyID},
+			Threshold:       1,
+		}).
+		SetExpires(time.Now().AddDate(0, 1, 0)).
+		Build()
+
+Mistakes are collected and reported together by Build, so that the builder
+never has to be checked in between.
+*/
+type LayoutBuilder struct {
+	layout Layout
+	errs   []error
+}
+
+/*
+NewLayoutBuilder returns a LayoutBuilder for a layout without any steps,
+inspections and keys.
+*/
+func NewLayoutBuilder() *LayoutBuilder {
+	return &LayoutBuilder{
+		layout: Layout{
+			Type:    "layout",
+			Steps:   []Step{},
+			Inspect: []Inspection{},
+			Keys:    map[string]Key{},
+		},
+	}
+}
+
+/*
+AddKey adds the public part of the passed key to the keys of the layout, which
+authorize functionaries for the steps that list its keyid.  Adding a keyid
+twice is an error.
+*/
+func (b *LayoutBuilder) AddKey(key Key) *LayoutBuilder {
+	if _, ok := b.layout.Keys[key.KeyID]; ok {
+		b.errs = append(b.errs, fmt.Errorf("duplicate key '%s'", key.KeyID))
+		return b
+	}
+	b.layout.Keys[key.KeyID] = publicLayoutKey(key)
+	return b
+}
+
+/*
+AddRootCA adds the public part of the passed key, which must hold a
+certificate, to the root certificate authorities of the layout, see Step.
+*/
+func (b *LayoutBuilder) AddRootCA(key Key) *LayoutBuilder {
+	b.layout.RootCas = b.addCAKey(b.layout.RootCas, "root CA", key)
+	return b
+}
+
+/*
+AddIntermediateCA adds the public part of the passed key, which must hold a
+certificate, to the intermediate certificate authorities of the layout.
+*/
+func (b *LayoutBuilder) AddIntermediateCA(key Key) *LayoutBuilder {
+	b.layout.IntermediateCas = b.addCAKey(b.layout.IntermediateCas, "intermediate CA", key)
+	return b
+}
+
+/*
+addCAKey adds the public part of the passed key to the passed map of
+certificate authorities, which is created if it is nil, and returns the map.
+*/
+func (b *LayoutBuilder) addCAKey(cas map[string]Key, kind string, key Key) map[string]Key {
+	if cas == nil {
+		cas = map[string]Key{}
+	}
+	if _, ok := cas[key.KeyID]; ok {
+		b.errs = append(b.errs, fmt.Errorf("duplicate %s '%s'", kind, key.KeyID))
+		return cas
+	}
+	cas[key.KeyID] = publicLayoutKey(key)
+	return cas
+}
+
+/*
+AddStep appends the passed step to the steps of the layout.  If the Type of the
+step is empty, it is set to "step".
+*/
+func (b *LayoutBuilder) AddStep(step Step) *LayoutBuilder {
+	if step.Type == "" {
+		step.Type = "step"
+	}
+	b.layout.Steps = append(b.layout.Steps, step)
+	return b
+}
+
+/*
+AddInspection appends the passed inspection to the inspections of the layout.
+If the Type of the inspection is empty, it is set to "inspection".
+*/
+func (b *LayoutBuilder) AddInspection(inspection Inspection) *LayoutBuilder {
+	if inspection.Type == "" {
+		inspection.Type = "inspection"
+	}
+	b.layout.Inspect = append(b.layout.Inspect, inspection)
+	return b
+}
+
+/*
+SetExpires sets the expiration date of the layout, which is stored in UTC with
+a precision of seconds, see ISO8601DateSchema.
+*/
+func (b *LayoutBuilder) SetExpires(expires time.Time) *LayoutBuilder {
+	b.layout.Expires = expires.UTC().Format(ISO8601DateSchema)
+	return b
+}
+
+/*
+SetReadme sets the human readable description of the layout.
+*/
+func (b *LayoutBuilder) SetReadme(readme string) *LayoutBuilder {
+	b.layout.Readme = readme
+	return b
+}
+
+/*
+Build returns the assembled layout, which passes Layout.Validate.  Besides
+these checks, Build ensures that an expiration date was set and that no key is
+listed twice for a step.  All problems found are returned as a single error.
+*/
+func (b *LayoutBuilder) Build() (Layout, error) {
+	errs := append([]error{}, b.errs...)
+	if b.layout.Expires == "" {
+		errs = append(errs, errors.New("layout expiration date not set"))
+	}
+	for _, step := range b.layout.Steps {
+		seen := make(map[string]bool, len(step.PubKeys))
+		for _, keyID := range step.PubKeys {
+			if seen[keyID] {
+				errs = append(errs, fmt.Errorf("duplicate key '%s' for step '%s'", keyID, step.Name))
+			}
+			seen[keyID] = true
+		}
+		if err := validateStepThreshold(step); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		if err := validateLayout(b.layout); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) != 0 {
+		return Layout{}, fmt.Errorf("invalid layout: %w", errors.Join(errs...))
+	}
+	// Later calls of the builder must not modify the returned layout
+	layout := b.layout
+	layout.Steps = slices.Clone(layout.Steps)
+	layout.Inspect = slices.Clone(layout.Inspect)
+	layout.Keys = maps.Clone(layout.Keys)
+	layout.RootCas = maps.Clone(layout.RootCas)
+	layout.IntermediateCas = maps.Clone(layout.IntermediateCas)
+	return layout, nil
+}
+
+/*
+publicLayoutKey returns the passed key without its private part, which must
+not end up in a layout.
+*/
+func publicLayoutKey(key Key) Key {
+	key.KeyVal.Private = ""
+	return key
+}
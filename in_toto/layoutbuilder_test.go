@@ -0,0 +1,110 @@
+package in_toto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayoutBuilder(t *testing.T) {
+	demoMb, err := LoadMetadata("demo.layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	demo := demoMb.GetPayload().(Layout)
+
+	// Rebuild the demo layout from its parts
+	builder := NewLayoutBuilder()
+	for _, key := range demo.Keys {
+		builder.AddKey(key)
+	}
+	for _, key := range demo.RootCas {
+		builder.AddRootCA(key)
+	}
+	for _, key := range demo.IntermediateCas {
+		builder.AddIntermediateCA(key)
+	}
+	for _, step := range demo.Steps {
+		step.Type = ""
+		builder.AddStep(step)
+	}
+	for _, inspection := range demo.Inspect {
+		builder.AddInspection(inspection)
+	}
+	expires := time.Now().AddDate(0, 1, 0)
+	layout, err := builder.SetExpires(expires).SetReadme(demo.Readme).Build()
+	assert.Nil(t, err)
+	assert.Equal(t, expires.UTC().Format(ISO8601DateSchema), layout.Expires)
+	demo.Expires = layout.Expires
+	assert.Equal(t, demo, layout)
+
+	// Later calls of the builder do not modify the built layout
+	builder.AddStep(Step{SupplyChainItem: SupplyChainItem{Name: "later"}, Threshold: 1})
+	assert.Len(t, layout.Steps, 2)
+
+	// The built layout verifies against the demo links
+	var alice Key
+	if err := alice.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layoutMb := &Metablock{Signed: layout}
+	if err := layoutMb.Sign(alice); err != nil {
+		t.Fatal(err)
+	}
+	var alicePub Key
+	if err := alicePub.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = InTotoVerify(layoutMb, map[string]Key{alicePub.KeyID: alicePub}, ".", "",
+		map[string]string{}, [][]byte{}, testOSisWindows())
+	assert.Nil(t, err)
+
+	// The private part of keys is dropped
+	var alicePriv Key
+	if err := alicePriv.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layout, err = NewLayoutBuilder().AddKey(alicePriv).SetExpires(expires).Build()
+	assert.Nil(t, err)
+	assert.Empty(t, layout.Keys[alicePriv.KeyID].KeyVal.Private)
+
+	keyID := alicePub.KeyID
+	invalidBuilds := []struct {
+		name    string
+		builder *LayoutBuilder
+		errs    []string
+	}{
+		{"no expiration date", NewLayoutBuilder(), []string{"layout expiration date not set"}},
+		{"duplicate key", NewLayoutBuilder().AddKey(alicePub).AddKey(alicePub).SetExpires(expires),
+			[]string{"duplicate key '" + keyID + "'"}},
+		{"duplicate step key and threshold too high", NewLayoutBuilder().AddKey(alicePub).
+			AddStep(Step{SupplyChainItem: SupplyChainItem{Name: "foo"}, PubKeys: []string{keyID, keyID}, Threshold: 2}),
+			[]string{"layout expiration date not set", "duplicate key '" + keyID + "' for step 'foo'",
+				"threshold 2 of step 'foo' exceeds its 1 keys"}},
+		{"zero threshold", NewLayoutBuilder().AddKey(alicePub).SetExpires(expires).
+			AddStep(Step{SupplyChainItem: SupplyChainItem{Name: "foo"}, PubKeys: []string{keyID}}),
+			[]string{"invalid threshold for step 'foo'"}},
+		{"duplicate names", NewLayoutBuilder().AddKey(alicePub).SetExpires(expires).
+			AddStep(Step{SupplyChainItem: SupplyChainItem{Name: "foo"}, PubKeys: []string{keyID}, Threshold: 1}).
+			AddInspection(Inspection{SupplyChainItem: SupplyChainItem{Name: "foo"}}),
+			[]string{"non unique step or inspection name found"}},
+		{"unknown step key", NewLayoutBuilder().SetExpires(expires).
+			AddStep(Step{SupplyChainItem: SupplyChainItem{Name: "foo"}, PubKeys: []string{keyID}, Threshold: 1}),
+			[]string{"key '" + keyID + "' of step 'foo' not found in layout keys"}},
+	}
+	for _, tt := range invalidBuilds {
+		_, err := tt.builder.Build()
+		for _, msg := range tt.errs {
+			assert.ErrorContains(t, err, msg, tt.name)
+		}
+	}
+
+	// Certificate constraints allow thresholds above the number of keys
+	_, err = NewLayoutBuilder().SetExpires(expires).AddStep(Step{
+		SupplyChainItem:        SupplyChainItem{Name: "foo"},
+		CertificateConstraints: []CertificateConstraint{{CommonName: "*"}},
+		Threshold:              2,
+	}).Build()
+	assert.Nil(t, err)
+}
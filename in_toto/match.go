@@ -8,6 +8,7 @@ package in_toto
 
 import (
 	"errors"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -20,8 +21,10 @@ var errBadPattern = errors.New("syntax error in pattern")
 //	pattern:
 //		{ term }
 //	term:
-//		'*'         matches any sequence of non-/ characters
-//		'?'         matches any single non-/ character
+//		'*'         matches any sequence of characters, including /
+//		'?'         matches any single character, including /
+//		'**/'       at the start of pattern or after a /, matches zero
+//		            or more directories
 //		'[' [ '^' ] { character-range } ']'
 //		            character class (must be non-empty)
 //		c           matches character c (c != '*', '?', '\\', '[')
@@ -35,7 +38,40 @@ var errBadPattern = errors.New("syntax error in pattern")
 // Match requires pattern to match all of name, not just a substring.
 // The only possible returned error is ErrBadPattern, when pattern
 // is malformed.
+//
+// As '*' also matches /, patterns are unanchored in the sense that e.g.
+// "*.whl" matches wheels at any depth.  '**/' additionally matches no
+// directory at all, so that "dist/**/*.whl" matches both "dist/foo.whl"
+// and "dist/py3/foo.whl", and "**/foo" matches "foo" as well as "a/b/foo".
 func match(pattern, name string) (matched bool, err error) {
+	return matchRecursive(pattern, name, 0)
+}
+
+// matchRecursive matches name against pattern, trying each '**/' at or
+// after the passed offset of pattern both as zero directories, by removing
+// it, and as one or more directories, by keeping it as '*' followed by /.
+func matchRecursive(pattern, name string, offset int) (matched bool, err error) {
+	for i := offset; i < len(pattern); {
+		j := strings.Index(pattern[i:], "**/")
+		if j < 0 {
+			break
+		}
+		i += j
+		if i == 0 || pattern[i-1] == '/' {
+			matched, err := matchRecursive(pattern[:i]+pattern[i+3:], name, i)
+			if matched || err != nil {
+				return matched, err
+			}
+			return matchRecursive(pattern, name, i+3)
+		}
+		i++
+	}
+	return matchFlat(pattern, name)
+}
+
+// matchFlat matches name against pattern, where each '*' matches any
+// sequence of characters, see match.
+func matchFlat(pattern, name string) (matched bool, err error) {
 Pattern:
 	for len(pattern) > 0 {
 		var star bool
@@ -54,6 +54,27 @@ var matchTests = []MatchTest{
 	{"[\\-x]", "x", true, nil},
 	{"[\\-x]", "-", true, nil},
 	{"[\\-x]", "a", false, nil},
+	{"**/foo.py", "foo.py", true, nil},
+	{"**/foo.py", "src/foo.py", true, nil},
+	{"**/foo.py", "src/lib/foo.py", true, nil},
+	{"**/foo.py", "src/barfoo.py", false, nil},
+	{"dist/**/*.whl", "dist/foo.whl", true, nil},
+	{"dist/**/*.whl", "dist/py3/foo.whl", true, nil},
+	{"dist/**/*.whl", "dist/py3/linux/foo.whl", true, nil},
+	{"dist/**/*.whl", "build/dist/foo.whl", false, nil},
+	{"dist/**/*.whl", "dist/foo.tar.gz", false, nil},
+	{"*/**/*.whl", "dist/foo.whl", true, nil},
+	{"a/**/b/**/c", "a/b/c", true, nil},
+	{"a/**/b/**/c", "a/x/b/c", true, nil},
+	{"a/**/b/**/c", "a/b/x/y/c", true, nil},
+	{"a/**/b/**/c", "a/x/b/y/z/c", true, nil},
+	{"a/**/b/**/c", "a/x/c", false, nil},
+	{"a/**/b", "a/xb", false, nil},
+	{"a**/b", "b", false, nil},
+	{"a**/b", "ax/b", true, nil},
+	{"dist/**", "dist/py3/foo.whl", true, nil},
+	{"\\**/foo", "foo", false, nil},
+	{"**/[]a]", "a", false, errBadPattern},
 	{"[]a]", "]", false, errBadPattern},
 	{"[-]", "-", false, errBadPattern},
 	{"[x-]", "x", false, errBadPattern},
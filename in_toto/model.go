@@ -1,6 +1,7 @@
 package in_toto
 
 import (
+	"bytes"
 	"context"
 	"crypto/ecdsa"
 	"crypto/rsa"
@@ -12,6 +13,7 @@ import (
 	"os"
 	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -45,6 +47,52 @@ type Key struct {
 	KeyType             string   `json:"keytype"`
 	KeyVal              KeyVal   `json:"keyval"`
 	Scheme              string   `json:"scheme"`
+	// GPG is only set for GPG keys, see LoadGPGKey and GPGKeyInfo
+	GPG *GPGKeyInfo `json:"-"`
+}
+
+/*
+MarshalJSON encodes the key in the format of in-toto keys, or, for GPG keys, in
+the format of GPG keys of securesystemslib, see GPGKeyInfo.
+*/
+func (k Key) MarshalJSON() ([]byte, error) {
+	if isGPGKey(k) {
+		return marshalGPGKey(k)
+	}
+	type plainKey Key
+	return json.Marshal(plainKey(k))
+}
+
+/*
+UnmarshalJSON decodes a key in the format of in-toto keys, or, if it has a
+"method", in the format of GPG keys of securesystemslib, see GPGKeyInfo.
+Unknown fields are rejected.
+*/
+func (k *Key) UnmarshalJSON(data []byte) error {
+	var format struct {
+		Method *string `json:"method"`
+	}
+	if err := json.Unmarshal(data, &format); err != nil {
+		return err
+	}
+	if format.Method != nil {
+		key, err := unmarshalGPGKey(data)
+		if err != nil {
+			return err
+		}
+		*k = key
+		return nil
+	}
+
+	type plainKey Key
+	var key plainKey
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&key); err != nil {
+		return err
+	}
+	*k = Key(key)
+	return nil
 }
 
 // ErrEmptyKeyField will be thrown if a field in our Key struct is empty.
@@ -207,6 +255,9 @@ compatible matchKeyTypeScheme will return nil.
 func matchKeyTypeScheme(key Key) error {
 	switch key.KeyType {
 	case rsaKeyType:
+		if isGPGKey(key) {
+			return nil
+		}
 		for _, scheme := range getSupportedRSASchemes() {
 			if key.Scheme == scheme {
 				return nil
@@ -246,6 +297,9 @@ func validateKey(key Key) error {
 	if key.KeyType == "" {
 		return fmt.Errorf("%w: keytype", ErrEmptyKeyField)
 	}
+	if isGPGKey(key) {
+		return validateGPGKey(key)
+	}
 	if key.KeyVal.Public == "" && key.KeyVal.Certificate == "" {
 		return fmt.Errorf("%w: keyval.public and keyval.certificate cannot both be blank", ErrEmptyKeyField)
 	}
@@ -285,12 +339,103 @@ func validatePublicKey(key Key) error {
 /*
 Signature represents a generic in-toto signature that contains the identifier
 of the Key, which was used to create the signature and the signature data.  The
-used signature scheme is found in the corresponding Key.
+used signature scheme is found in the corresponding Key.  Signatures created
+with GPG, see NewGPGSigner, hold the signature data in GPGSignature instead of
+Sig, along with the OpenPGP signature packet fields in OtherHeaders, as in the
+in-toto reference implementation.
 */
 type Signature struct {
-	KeyID       string `json:"keyid"`
-	Sig         string `json:"sig"`
-	Certificate string `json:"cert,omitempty"`
+	KeyID        string `json:"keyid"`
+	Sig          string `json:"sig,omitempty"`
+	Certificate  string `json:"cert,omitempty"`
+	ShortKeyID   string `json:"short_keyid,omitempty"`
+	OtherHeaders string `json:"other_headers,omitempty"`
+	GPGSignature string `json:"signature,omitempty"`
+}
+
+/*
+Signer creates signatures over metadata, e.g. with a Key held in memory or with
+a key that lives in an HSM or a cloud KMS.  KeyID returns the identifier of
+the signing key, which is recorded in the created Signature and used to find
+the Signature again during verification.
+*/
+type Signer interface {
+	Sign(data []byte) (Signature, error)
+	KeyID() string
+}
+
+/*
+Verifier verifies signatures over metadata.  KeyID returns the identifier of
+the verifying key, which is used to select the Signature to verify.
+*/
+type Verifier interface {
+	Verify(data []byte, sig Signature) error
+	KeyID() string
+}
+
+/*
+keySignerVerifier is the Signer and Verifier implementation for Key objects,
+which routes to the RSA, ECDSA or ED25519 implementation based on the key type.
+*/
+type keySignerVerifier struct {
+	key Key
+	sv  dsse.SignerVerifier
+}
+
+/*
+NewKeySigner returns a Signer that signs with the private portion of the passed
+Key.  It returns an error if the key type is not supported, or an error
+wrapping ErrInvalidKeyID if the keyid of the key does not match the key, so
+that signatures never reference a key by an id that belongs to another key.
+*/
+func NewKeySigner(key Key) (Signer, error) {
+	sv, err := getSignerVerifierFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.ValidateKeyID(); err != nil {
+		return nil, err
+	}
+	return &keySignerVerifier{key: key, sv: sv}, nil
+}
+
+/*
+NewKeyVerifier returns a Verifier that verifies with the public portion of the
+passed Key.  It returns an error if the key type is not supported.
+*/
+func NewKeyVerifier(key Key) (Verifier, error) {
+	if isGPGKey(key) {
+		return newGPGVerifier(key, time.Time{})
+	}
+	sv, err := getSignerVerifierFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &keySignerVerifier{key: key, sv: sv}, nil
+}
+
+func (k *keySignerVerifier) KeyID() string {
+	return k.key.KeyID
+}
+
+func (k *keySignerVerifier) Sign(data []byte) (Signature, error) {
+	sig, err := k.sv.Sign(context.Background(), data)
+	if err != nil {
+		return Signature{}, err
+	}
+	return Signature{
+		KeyID:       k.key.KeyID,
+		Sig:         hex.EncodeToString(sig),
+		Certificate: k.key.KeyVal.Certificate,
+	}, nil
+}
+
+func (k *keySignerVerifier) Verify(data []byte, sig Signature) error {
+	sigBytes, err := hex.DecodeString(sig.Sig)
+	if err != nil {
+		return err
+	}
+	return k.sv.Verify(context.Background(), data, sigBytes)
 }
 
 // GetCertificate returns the parsed x509 certificate attached to the signature,
@@ -301,6 +446,13 @@ func (sig Signature) GetCertificate() (Key, error) {
 		return key, errors.New("Signature has empty Certificate")
 	}
 
+	// Signatures of keys loaded with LoadCertificate are identified by the
+	// fingerprint of the certificate
+	if err := key.LoadCertificateReader(strings.NewReader(sig.Certificate)); err == nil && key.KeyID == sig.KeyID {
+		return key, nil
+	}
+
+	key = Key{}
 	err := key.LoadKeyReaderDefaults(strings.NewReader(sig.Certificate))
 	return key, err
 }
@@ -313,6 +465,12 @@ func validateSignature(signature Signature) error {
 	if err := validateHexString(signature.KeyID); err != nil {
 		return err
 	}
+	if signature.OtherHeaders != "" || signature.GPGSignature != "" {
+		if err := validateHexString(signature.OtherHeaders); err != nil {
+			return err
+		}
+		return validateHexString(signature.GPGSignature)
+	}
 	if err := validateHexString(signature.Sig); err != nil {
 		return err
 	}
@@ -509,6 +667,14 @@ metadata, which is used as signed evidence that the step was performed
 according to the supply chain definition.  Materials and products used/produced
 by the step are constrained by the artifact rules in the step's
 ExpectedMaterials and ExpectedProducts fields.
+
+Functionaries are authorized either by the keyids of their keys in PubKeys, or
+by certificates that chain to the RootCas of the layout and meet any of the
+CertificateConstraints.  The latter allows functionaries to rotate their keys,
+e.g. short-lived keys with certificates issued for keyless signing, without
+changing the layout.  Their links must be Metablocks signed with a key loaded
+with LoadCertificate, or another key that holds its certificate, so that the
+signatures carry the certificate.  DSSE envelopes cannot carry certificates.
 */
 type Step struct {
 	Type                   string                  `json:"_type"`
@@ -562,6 +728,10 @@ func validateStep(step Step) error {
 		return fmt.Errorf("invalid Type value for step '%s': should be 'step'",
 			step.SupplyChainItem.Name)
 	}
+	if step.Threshold < 1 {
+		return fmt.Errorf("invalid threshold for step '%s': should be at"+
+			" least 1", step.SupplyChainItem.Name)
+	}
 	for _, keyID := range step.PubKeys {
 		if err := validateHexString(keyID); err != nil {
 			return err
@@ -570,6 +740,26 @@ func validateStep(step Step) error {
 	return nil
 }
 
+/*
+validateStepThreshold ensures that the threshold of a passed step can be met by
+the distinct keys of the step.  Steps with certificate constraints are exempt,
+as any number of certificates may meet them.
+*/
+func validateStepThreshold(step Step) error {
+	if len(step.CertificateConstraints) != 0 {
+		return nil
+	}
+	keyIDs := make(map[string]bool, len(step.PubKeys))
+	for _, keyID := range step.PubKeys {
+		keyIDs[keyID] = true
+	}
+	if step.Threshold > len(keyIDs) {
+		return fmt.Errorf("threshold %d of step '%s' exceeds its %d keys",
+			step.Threshold, step.Name, len(keyIDs))
+	}
+	return nil
+}
+
 /*
 ISO8601DateSchema defines the format string of a timestamp following the
 ISO 8601 standard.
@@ -676,6 +866,13 @@ func validateLayout(layout Layout) error {
 		if err := validateStep(step); err != nil {
 			return err
 		}
+
+		for _, keyID := range step.PubKeys {
+			if _, ok := layout.Keys[keyID]; !ok {
+				return fmt.Errorf("key '%s' of step '%s' not found in layout"+
+					" keys", keyID, step.Name)
+			}
+		}
 	}
 	for _, inspection := range layout.Inspect {
 		if namesSeen[inspection.Name] {
@@ -684,9 +881,28 @@ func validateLayout(layout Layout) error {
 
 		namesSeen[inspection.Name] = true
 	}
+
+	for _, step := range layout.Steps {
+		if err := validateStepThreshold(step); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+/*
+Validate checks the structure of the layout, e.g. right after authoring it, and
+returns a descriptive error for the first problem found.  Among others, it
+checks that every step and inspection has a unique, non-empty name, that step
+thresholds are at least 1 and can be met by the keys of a step, unless the
+step has certificate constraints, that every keyid of a step refers to a key of
+the layout and that the expiration date is a valid ISO8601DateSchema
+timestamp.  Metablock.Load runs the same checks for loaded layouts.
+*/
+func (l Layout) Validate() error {
+	return validateLayout(l)
+}
+
 type Metadata interface {
 	Sign(Key) error
 	VerifySignature(Key) error
@@ -702,6 +918,14 @@ func LoadMetadata(path string) (Metadata, error) {
 		return nil, err
 	}
 
+	return LoadMetadataFromBytes(jsonBytes)
+}
+
+/*
+LoadMetadataFromBytes is like LoadMetadata, but parses the passed JSON bytes,
+e.g. a HTTP response body, instead of reading a file.
+*/
+func LoadMetadataFromBytes(jsonBytes []byte) (Metadata, error) {
 	var rawData map[string]*json.RawMessage
 	if err := json.Unmarshal(jsonBytes, &rawData); err != nil {
 		return nil, err
@@ -725,26 +949,10 @@ func LoadMetadata(path string) (Metadata, error) {
 	}
 
 	mb := &Metablock{}
-
-	// Error out on missing `signed` or `signatures` field or if
-	// one of them has a `null` value, which would lead to a nil pointer
-	// dereference in Unmarshal below.
-	if rawData["signed"] == nil || rawData["signatures"] == nil {
-		return nil, fmt.Errorf("in-toto metadata requires 'signed' and 'signatures' parts")
-	}
-
-	// Fully unmarshal signatures part
-	if err := json.Unmarshal(*rawData["signatures"], &mb.Signatures); err != nil {
+	if err := mb.LoadFromBytes(jsonBytes); err != nil {
 		return nil, err
 	}
 
-	payload, err := loadPayload(*rawData["signed"])
-	if err != nil {
-		return nil, err
-	}
-
-	mb.Signed = payload
-
 	return mb, nil
 }
 
@@ -753,6 +961,11 @@ Metablock is a generic container for signable in-toto objects such as Layout
 or Link.  It has two fields, one that contains the signable object and one that
 contains corresponding signatures.  Metablock also provides functionality for
 signing and signature verification, and reading from and writing to disk.
+
+A loaded Metablock retains the canonical encoding of the signed JSON, see
+GetSignableRepresentation.  Hence it is not reflect.DeepEqual to a Metablock
+with the same Signed and Signatures fields that was not loaded, so compare
+these fields instead.
 */
 type Metablock struct {
 	// NOTE: Whenever we want to access an attribute of `Signed` we have to
@@ -767,6 +980,14 @@ type Metablock struct {
 	// turn out to be a layout (sublayout)
 	Signed     interface{} `json:"signed"`
 	Signatures []Signature `json:"signatures"`
+
+	// signedCanonical is the canonical encoding of the signed JSON as it was
+	// loaded, i.e. the bytes that were actually signed, and parsedCanonical
+	// the canonical encoding of Signed right after loading.  The former is
+	// only used as long as Signed still encodes to the latter, see
+	// GetSignableRepresentation.
+	signedCanonical []byte
+	parsedCanonical []byte
 }
 
 type jsonField struct {
@@ -824,6 +1045,14 @@ func (mb *Metablock) Load(path string) error {
 		return err
 	}
 
+	return mb.LoadFromBytes(jsonBytes)
+}
+
+/*
+LoadFromBytes is like Load, but parses the passed JSON bytes, e.g. a HTTP
+response body, instead of reading a file.
+*/
+func (mb *Metablock) LoadFromBytes(jsonBytes []byte) error {
 	// Unmarshal JSON into a map of raw messages (signed and signatures)
 	// We can't fully unmarshal immediately, because we need to inspect the
 	// type (link or layout) to decide which data structure to use
@@ -851,18 +1080,78 @@ func (mb *Metablock) Load(path string) error {
 	}
 
 	mb.Signed = payload
+	if err := mb.Validate(); err != nil {
+		return err
+	}
+
+	// Retain what was actually signed, which may differ from what Signed
+	// encodes to, e.g. because empty fields tagged omitempty, such as
+	// "rootcas", are dropped, or fields that were omitted, such as the
+	// "keyid_hash_algorithms" of keys, are added as null.  If either cannot
+	// be canonicalized, fall back to re-encoding Signed.
+	mb.signedCanonical, mb.parsedCanonical = nil, nil
+	signedCanonical, err := EncodeCanonical(*rawMb["signed"])
+	if err != nil {
+		return nil
+	}
+	parsedCanonical, err := EncodeCanonical(payload)
+	if err != nil {
+		return nil
+	}
+	mb.signedCanonical, mb.parsedCanonical = signedCanonical, parsedCanonical
 
 	return nil
 }
 
+/*
+LoadLinkWithDetachedSig loads link metadata, whose payload and signature are
+kept in separate files.  The file at linkPath holds the JSON encoded link, i.e.
+the "signed" part of a link Metablock, and the file at sigPath the JSON encoded
+Signature over the link, i.e. an entry of its "signatures" part.  The returned
+Metablock is validated like one loaded with Load, and its signature is verified
+with VerifySignature, e.g. during verification, as if it were loaded from a
+single file.
+*/
+func LoadLinkWithDetachedSig(linkPath, sigPath string) (Metablock, error) {
+	linkBytes, err := os.ReadFile(linkPath)
+	if err != nil {
+		return Metablock{}, err
+	}
+	sigBytes, err := os.ReadFile(sigPath)
+	if err != nil {
+		return Metablock{}, err
+	}
+
+	var sig Signature
+	if err := json.Unmarshal(sigBytes, &sig); err != nil {
+		return Metablock{}, fmt.Errorf("failed to parse detached signature '%s': %w", sigPath, err)
+	}
+
+	// Load the combined metadata, so that the link is validated and its
+	// signed bytes are retained, as with Load
+	mbBytes, err := json.Marshal(map[string]any{
+		"signed":     json.RawMessage(linkBytes),
+		"signatures": []Signature{sig},
+	})
+	if err != nil {
+		return Metablock{}, fmt.Errorf("failed to parse link '%s': %w", linkPath, err)
+	}
+	var mb Metablock
+	if err := mb.LoadFromBytes(mbBytes); err != nil {
+		return Metablock{}, err
+	}
+	if _, ok := mb.Signed.(Link); !ok {
+		return Metablock{}, fmt.Errorf("'%s' does not contain a link", linkPath)
+	}
+	return mb, nil
+}
+
 /*
 Dump JSON serializes and writes the Metablock on which it was called to the
 passed path.  It returns an error if JSON serialization or writing fails.
 */
 func (mb *Metablock) Dump(path string) error {
-	// JSON encode Metablock formatted with newlines and indentation
-	// TODO: parametrize format
-	jsonBytes, err := json.MarshalIndent(mb, "", "  ")
+	jsonBytes, err := mb.DumpToBytes()
 	if err != nil {
 		return err
 	}
@@ -876,13 +1165,49 @@ func (mb *Metablock) Dump(path string) error {
 	return nil
 }
 
+/*
+DumpToBytes JSON serializes the Metablock on which it was called in the same
+format as Dump, e.g. to upload it to object storage.  It returns an error if
+JSON serialization fails.
+*/
+func (mb *Metablock) DumpToBytes() ([]byte, error) {
+	// JSON encode Metablock formatted with newlines and indentation
+	// TODO: parametrize format
+	return json.MarshalIndent(mb, "", "  ")
+}
+
+/*
+EncodeCanonical returns the canonical JSON representation of the passed object,
+i.e. with lexicographically sorted object keys, without insignificant
+whitespace and with only backslash and double quote escaped in strings.  It is
+the encoding that is used to create the signable representation of metadata
+and to compute key IDs, so it can be used to produce the exact bytes that are
+signed, e.g. with an external signing service.  If canonicalization fails the
+first return value is nil and the second return value is the error.
+*/
+func EncodeCanonical(obj interface{}) ([]byte, error) {
+	return cjson.EncodeCanonical(obj)
+}
+
 /*
 GetSignableRepresentation returns the canonical JSON representation of the
-Signed field of the Metablock on which it was called.  If canonicalization
-fails the first return value is nil and the second return value is the error.
+Signed field of the Metablock on which it was called.  For a Metablock that
+was loaded and whose Signed field was not modified since, this is the
+canonical representation of the signed JSON as loaded, so that signatures
+created by other implementations verify even if re-encoding the JSON differs,
+e.g. because it contains empty fields that this implementation omits, or omits
+fields that this implementation encodes as null.  If canonicalization fails
+the first return value is nil and the second return value is the error.
 */
 func (mb *Metablock) GetSignableRepresentation() ([]byte, error) {
-	return cjson.EncodeCanonical(mb.Signed)
+	canonical, err := EncodeCanonical(mb.Signed)
+	if err != nil {
+		return nil, err
+	}
+	if mb.signedCanonical != nil && bytes.Equal(canonical, mb.parsedCanonical) {
+		return mb.signedCanonical, nil
+	}
+	return canonical, nil
 }
 
 func (mb *Metablock) GetPayload() any {
@@ -896,36 +1221,112 @@ func (mb *Metablock) Sigs() []Signature {
 /*
 VerifySignature verifies the first signature, corresponding to the passed Key,
 that it finds in the Signatures field of the Metablock on which it was called.
-It returns an error if Signatures does not contain a Signature corresponding to
-the passed Key, the object in Signed cannot be canonicalized, or the Signature
-is invalid.
+A signature corresponds to the Key if its keyid references the Key, see
+Key.MatchesKeyID, e.g. with the keyid computed with another of the keyid hash
+algorithms of the Key.  It returns an error if Signatures does not contain a
+Signature corresponding to the passed Key, the object in Signed cannot be
+canonicalized, or the Signature is invalid.
 */
 func (mb *Metablock) VerifySignature(key Key) error {
-	sig, err := mb.GetSignatureForKeyID(key.KeyID)
+	return mb.verifySignatureAtTime(key, time.Time{})
+}
+
+/*
+verifySignatureAtTime is like VerifySignature, but checks the expiration of GPG
+keys against the passed reference time, or against the current time, if it is
+zero.
+*/
+func (mb *Metablock) verifySignatureAtTime(key Key, referenceTime time.Time) error {
+	// Report a missing signature before an unusable key
+	sig, err := getSignatureForKey(mb.Signatures, key)
 	if err != nil {
 		return err
 	}
 
-	verifier, err := getSignerVerifierFromKey(key)
+	// The signature may reference the key by another of its keyids
+	key.KeyID = sig.KeyID
+	var verifier Verifier
+	if isGPGKey(key) {
+		verifier, err = newGPGVerifier(key, referenceTime)
+	} else {
+		verifier, err = NewKeyVerifier(key)
+	}
 	if err != nil {
 		return err
 	}
 
-	payload, err := mb.GetSignableRepresentation()
-	if err != nil {
-		return err
+	return mb.VerifySignatureWith(verifier)
+}
+
+/*
+verifySignatureAtTime verifies the signature of the passed Metadata that
+corresponds to the passed Key, see Metablock.verifySignatureAtTime.  Other
+Metadata than Metablocks, e.g. DSSE envelopes, cannot be signed with GPG keys
+and are verified with their VerifySignature.
+*/
+func verifySignatureAtTime(md Metadata, key Key, referenceTime time.Time) error {
+	if mb, ok := md.(*Metablock); ok {
+		return mb.verifySignatureAtTime(key, referenceTime)
 	}
+	return md.VerifySignature(key)
+}
 
-	sigBytes, err := hex.DecodeString(sig.Sig)
+/*
+VerifySignatureWith is like VerifySignature, but verifies the Signature
+corresponding to the KeyID of the passed Verifier using that Verifier.
+*/
+func (mb *Metablock) VerifySignatureWith(verifier Verifier) error {
+	sig, err := mb.GetSignatureForKeyID(verifier.KeyID())
 	if err != nil {
 		return err
 	}
 
-	err = verifier.Verify(context.Background(), payload, sigBytes)
+	payload, err := mb.GetSignableRepresentation()
 	if err != nil {
 		return err
 	}
 
+	return verifier.Verify(payload, sig)
+}
+
+/*
+VerifySignatures verifies the signatures of the Metablock on which it was called
+with the passed keys, and returns an error that wraps ErrThresholdNotMet if
+fewer than threshold distinct keys produced a valid signature.  Multiple
+signatures with the same keyid only count once.  The error also wraps the
+reason why the last key was rejected, if any.
+*/
+func (mb *Metablock) VerifySignatures(keys map[string]Key, threshold int) error {
+	if threshold < 1 {
+		return fmt.Errorf("threshold must be at least one, got: '%d'", threshold)
+	}
+
+	// Verify in a stable order, so that the returned error is deterministic
+	keyIDs := make([]string, 0, len(keys))
+	for keyID := range keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	slices.Sort(keyIDs)
+
+	verified := NewSet()
+	var lastErr error
+	for _, keyID := range keyIDs {
+		key := keys[keyID]
+		if err := mb.VerifySignature(key); err != nil {
+			lastErr = err
+			continue
+		}
+		verified.Add(key.KeyID)
+	}
+
+	if len(verified) < threshold {
+		if lastErr == nil {
+			return fmt.Errorf("%w: '%d' out of '%d' required signatures are valid",
+				ErrThresholdNotMet, len(verified), threshold)
+		}
+		return fmt.Errorf("%w: '%d' out of '%d' required signatures are valid: %w",
+			ErrThresholdNotMet, len(verified), threshold, lastErr)
+	}
 	return nil
 }
 
@@ -940,6 +1341,37 @@ func (mb *Metablock) GetSignatureForKeyID(keyID string) (Signature, error) {
 	return Signature{}, fmt.Errorf("no signature found for key '%s'", keyID)
 }
 
+/*
+getSignatureForKey returns the first of the passed signatures, whose keyid
+references the passed key, see Key.MatchesKeyID.  A signature with the keyid of
+the key takes precedence.
+*/
+func getSignatureForKey(sigs []Signature, key Key) (Signature, error) {
+	for _, s := range sigs {
+		if s.KeyID == key.KeyID {
+			return s, nil
+		}
+	}
+	for _, s := range sigs {
+		if key.MatchesKeyID(s.KeyID) {
+			return s, nil
+		}
+	}
+
+	return Signature{}, fmt.Errorf("no signature found for key '%s'", key.KeyID)
+}
+
+/*
+Validate checks the structural invariants of the Metablock on which it was
+called, see ValidateMetablock, e.g. that the type of the Link or Layout in
+Signed is correct, that step and inspection names are unique, that step
+thresholds are at least 1 and that the keys of the steps are in the keys of the
+layout.  Load and LoadFromBytes validate the loaded Metablock.
+*/
+func (mb *Metablock) Validate() error {
+	return ValidateMetablock(*mb)
+}
+
 /*
 ValidateMetablock ensures that a passed Metablock object is valid. It indirectly
 validates the Link or Layout that the Metablock object contains.
@@ -969,30 +1401,65 @@ func ValidateMetablock(mb Metablock) error {
 /*
 Sign creates a signature over the signed portion of the metablock using the Key
 object provided. It then appends the resulting signature to the signatures
-field as provided. It returns an error if the Signed object cannot be
-canonicalized, or if the key is invalid or not supported.
+field as provided, or replaces an existing signature by the same key, so that
+Sign can be called repeatedly to sign with multiple keys, e.g. when authoring
+a layout.  It returns an error if the Signed object cannot be canonicalized,
+or if the key is invalid or not supported.
 */
 func (mb *Metablock) Sign(key Key) error {
-	signer, err := getSignerVerifierFromKey(key)
+	signer, err := NewKeySigner(key)
 	if err != nil {
 		return err
 	}
 
+	return mb.SignWith(signer)
+}
+
+/*
+SignWith is like Sign, but creates the signature with the passed Signer, e.g.
+one that is backed by an HSM or a cloud KMS, and appends it to the signatures
+field or replaces the existing signature for the keyid of the signer.
+*/
+func (mb *Metablock) SignWith(signer Signer) error {
 	payload, err := mb.GetSignableRepresentation()
 	if err != nil {
 		return err
 	}
 
-	signature, err := signer.Sign(context.Background(), payload)
+	signature, err := signer.Sign(payload)
 	if err != nil {
 		return err
 	}
 
-	mb.Signatures = append(mb.Signatures, Signature{
-		KeyID:       key.KeyID,
-		Sig:         hex.EncodeToString(signature),
-		Certificate: key.KeyVal.Certificate,
-	})
+	for i, sig := range mb.Signatures {
+		if sig.KeyID == signature.KeyID {
+			if err := validateSignature(signature); err != nil {
+				return err
+			}
+			mb.Signatures[i] = signature
+			return nil
+		}
+	}
+
+	return mb.AddSignature(signature)
+}
+
+/*
+AddSignature appends a signature that was created outside of this library, e.g.
+by an HSM, over the bytes returned by GetSignableRepresentation.  It returns
+an error if the keyid or the signature data are not hex strings, or if the
+Metablock already holds a signature for the same keyid.  The signature is not
+verified.
+*/
+func (mb *Metablock) AddSignature(sig Signature) error {
+	if err := validateSignature(sig); err != nil {
+		return err
+	}
+	if _, err := mb.GetSignatureForKeyID(sig.KeyID); err == nil {
+		return fmt.Errorf("signature for key '%s' already exists", sig.KeyID)
+	}
+
+	mb.Signatures = append(mb.Signatures, sig)
 
 	return nil
 }
@@ -2,13 +2,23 @@ package in_toto
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -138,6 +148,67 @@ func TestMetablockLoad(t *testing.T) {
 	}
 }
 
+func TestLoadLinkWithDetachedSig(t *testing.T) {
+	var linkMb, layoutMb Metablock
+	if err := linkMb.Load("write-code.b7d643de.link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := layoutMb.Load("demo.layout"); err != nil {
+		t.Fatal(err)
+	}
+	key := layoutMb.Signed.(Layout).Keys["b7d643dec0a051096ee5d87221b5d91a33daa658699d30903e1cefb90c418401"]
+
+	// Split the link file into its payload and its signature
+	jsonBytes, err := os.ReadFile("write-code.b7d643de.link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rawMb struct {
+		Signed     json.RawMessage   `json:"signed"`
+		Signatures []json.RawMessage `json:"signatures"`
+	}
+	if err := json.Unmarshal(jsonBytes, &rawMb); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeFile := func(name string, content []byte) string {
+		filePath := filepath.Join(dir, name)
+		if err := os.WriteFile(filePath, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filePath
+	}
+	linkPath := writeFile("write-code.link", rawMb.Signed)
+	sigPath := writeFile("write-code.sig", rawMb.Signatures[0])
+
+	mb, err := LoadLinkWithDetachedSig(linkPath, sigPath)
+	assert.Nil(t, err)
+	assert.Equal(t, linkMb.Signed, mb.Signed)
+	assert.Equal(t, linkMb.Signatures, mb.Signatures)
+	assert.Nil(t, mb.VerifySignature(key))
+
+	// A signature over a different payload does not verify
+	otherMb, err := LoadLinkWithDetachedSig(writeFile("package.link", []byte(`{"_type": "link", "name": "package",
+		"materials": {}, "products": {}, "byproducts": {}, "command": [], "environment": {}}`)), sigPath)
+	assert.Nil(t, err)
+	assert.NotNil(t, otherMb.VerifySignature(key))
+
+	_, err = LoadLinkWithDetachedSig(linkPath, writeFile("invalid.sig", []byte("not a signature")))
+	assert.ErrorContains(t, err, "failed to parse detached signature")
+	_, err = LoadLinkWithDetachedSig(linkPath, writeFile("invalid-keyid.sig", []byte(`{"keyid": "not hex", "sig": "00"}`)))
+	assert.NotNil(t, err)
+	_, err = LoadLinkWithDetachedSig(writeFile("invalid.link", []byte("not a link")), sigPath)
+	assert.NotNil(t, err)
+	layoutBytes, err := json.Marshal(layoutMb.Signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadLinkWithDetachedSig(writeFile("demo.layout", layoutBytes), sigPath)
+	assert.ErrorContains(t, err, "does not contain a link")
+	_, err = LoadLinkWithDetachedSig(linkPath, filepath.Join(dir, "missing.sig"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
 func TestMetablockDump(t *testing.T) {
 	// Test dump metablock errors:
 	// - invalid content
@@ -213,7 +284,8 @@ func TestMetablockLoadDumpLoad(t *testing.T) {
 		if err := mbFile.Load(fn); err != nil {
 			t.Errorf("could not parse Metablock: %s", err)
 		}
-		if !reflect.DeepEqual(mbMemory, mbFile) {
+		// mbFile additionally retains the signed bytes it was loaded from
+		if !reflect.DeepEqual(mbMemory.Signed, mbFile.Signed) || !reflect.DeepEqual(mbMemory.Signatures, mbFile.Signatures) {
 			t.Errorf("dumped and Loaded Metablocks are not equal: \n%s\n\n\n%s\n",
 				mbMemory, mbFile)
 		}
@@ -224,6 +296,58 @@ func TestMetablockLoadDumpLoad(t *testing.T) {
 	}
 }
 
+func TestMetablockLoadFromBytesDumpToBytes(t *testing.T) {
+	// Bytes round-trip yields the same Metablock and the same bytes as the
+	// file based Dump
+	fn := "demo.layout"
+	jsonBytes, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mbFile, mbBytes, mbRoundTrip Metablock
+	if err := mbFile.Load(fn); err != nil {
+		t.Fatalf("could not parse Metablock: %s", err)
+	}
+	if err := mbBytes.LoadFromBytes(jsonBytes); err != nil {
+		t.Fatalf("LoadFromBytes returned '%s'", err)
+	}
+	assert.Equal(t, mbFile, mbBytes)
+	if _, ok := mbBytes.Signed.(Layout); !ok {
+		t.Errorf("expected Layout, got %T", mbBytes.Signed)
+	}
+
+	dumped, err := mbBytes.DumpToBytes()
+	if err != nil {
+		t.Fatalf("DumpToBytes returned '%s'", err)
+	}
+	if err := mbRoundTrip.LoadFromBytes(dumped); err != nil {
+		t.Fatalf("LoadFromBytes returned '%s'", err)
+	}
+	assert.Equal(t, mbBytes, mbRoundTrip)
+
+	fnTmp := fn + ".tmp"
+	if err := mbBytes.Dump(fnTmp); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fnTmp)
+	dumpedFile, err := os.ReadFile(fnTmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dumped, dumpedFile)
+
+	// Parsing errors are the same as for Load
+	assert.NotNil(t, mbRoundTrip.LoadFromBytes([]byte("{")))
+	_, err = LoadMetadataFromBytes([]byte(`{"signatures": [], "signed": {"_type": "something else"}}`))
+	assert.NotNil(t, err)
+
+	md, err := LoadMetadataFromBytes(jsonBytes)
+	if err != nil {
+		t.Fatalf("LoadMetadataFromBytes returned '%s'", err)
+	}
+	assert.Equal(t, &mbFile, md)
+}
+
 func TestMetablockGetSignableRepresentation(t *testing.T) {
 	// Test successful metadata canonicalization with encoding corner cases
 	// (unicode, escapes, non-string types, ...) and compare with reference
@@ -251,6 +375,116 @@ func TestMetablockGetSignableRepresentation(t *testing.T) {
 	}
 }
 
+func TestEncodeCanonical(t *testing.T) {
+	link := Link{
+		Type:      "link",
+		Name:      "write-code",
+		Materials: map[string]HashObj{},
+		Products: map[string]HashObj{
+			"foo.py": {"sha256": "74dc3727c6e89308b39e4dfedf787e37841198b1fa165a27c013544a60502549"},
+		},
+		ByProducts:  map[string]interface{}{"return-value": 0, "stdout": "\"quoted\"\n"},
+		Command:     []string{"vi", "foo.py"},
+		Environment: map[string]interface{}{},
+	}
+	layout := Layout{
+		Type:    "layout",
+		Expires: "2030-11-18T16:06:36Z",
+		Keys:    map[string]Key{},
+		Steps: []Step{{
+			Type:            "step",
+			PubKeys:         []string{"b7d643dec0a051096ee5d87221b5d91a33daa658699d30903e1cefb90c418401"},
+			ExpectedCommand: []string{},
+			Threshold:       1,
+			SupplyChainItem: SupplyChainItem{
+				Name:              "write-code",
+				ExpectedMaterials: [][]string{},
+				ExpectedProducts:  [][]string{{"CREATE", "foo.py"}},
+			},
+		}},
+		Inspect: []Inspection{},
+	}
+
+	tables := []struct {
+		name     string
+		obj      interface{}
+		expected string
+	}{
+		{
+			// Only backslash and double quote are escaped, other control
+			// characters are kept as is
+			"link",
+			link,
+			`{"_type":"link","byproducts":{"return-value":0,"stdout":"\"quoted\"` + "\n" + `"},` +
+				`"command":["vi","foo.py"],"environment":{},"materials":{},"name":"write-code",` +
+				`"products":{"foo.py":{"sha256":"74dc3727c6e89308b39e4dfedf787e37841198b1fa165a27c013544a60502549"}}}`,
+		},
+		{
+			"layout",
+			layout,
+			`{"_type":"layout","expires":"2030-11-18T16:06:36Z","inspect":[],"keys":{},"readme":"",` +
+				`"steps":[{"_type":"step","expected_command":[],"expected_materials":[],` +
+				`"expected_products":[["CREATE","foo.py"]],"name":"write-code",` +
+				`"pubkeys":["b7d643dec0a051096ee5d87221b5d91a33daa658699d30903e1cefb90c418401"],"threshold":1}]}`,
+		},
+	}
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			canonical, err := EncodeCanonical(table.obj)
+			assert.Nil(t, err)
+			assert.Equal(t, table.expected, string(canonical))
+
+			// The output matches the bytes that are signed
+			mb := Metablock{Signed: table.obj}
+			signable, err := mb.GetSignableRepresentation()
+			assert.Nil(t, err)
+			assert.Equal(t, signable, canonical)
+		})
+	}
+
+	_, err := EncodeCanonical(map[string]interface{}{"float": 1.5})
+	assert.NotNil(t, err)
+}
+
+func TestEncodeCanonicalPythonVectors(t *testing.T) {
+	// Vectors of the encode_canonical tests of the Python securesystemslib,
+	// which in-toto uses to create the bytes that are signed
+	tables := []struct {
+		obj      interface{}
+		expected string
+	}{
+		{"", `""`},
+		{[]int{1, 2, 3}, `[1,2,3]`},
+		{[]interface{}{}, `[]`},
+		{map[string]interface{}{}, `{}`},
+		{map[string]interface{}{"A": []int{99}}, `{"A":[99]}`},
+		{map[string]interface{}{"A": true}, `{"A":true}`},
+		{map[string]interface{}{"B": false}, `{"B":false}`},
+		{map[string]interface{}{"x": 3, "y": 2}, `{"x":3,"y":2}`},
+		{map[string]interface{}{"x": 3, "y": nil}, `{"x":3,"y":null}`},
+		// Keys are sorted by their bytes, only backslash and double quote
+		// are escaped and non-ASCII characters are kept as UTF-8
+		{map[string]interface{}{"b": 1, "a": 2, "B": 3}, `{"B":3,"a":2,"b":1}`},
+		{"back\\slash \"quote\"", `"back\\slash \"quote\""`},
+		{"tab\tnewline\n", "\"tab\tnewline\n\""},
+		{"\u00fc\u20ac", "\"\u00fc\u20ac\""},
+	}
+	for _, table := range tables {
+		canonical, err := EncodeCanonical(table.obj)
+		assert.Nil(t, err)
+		assert.Equal(t, table.expected, string(canonical))
+	}
+
+	// Like in Python, non-integer floats cannot be encoded.  Unlike Python,
+	// integral floats such as 8.0 are encoded as integers, because numbers
+	// of metadata parsed from JSON are float64 in Go.
+	canonical, err := EncodeCanonical(map[string]interface{}{"x": 8.0})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"x":8}`, string(canonical))
+	_, err = EncodeCanonical(map[string]interface{}{"x": 8.5})
+	assert.NotNil(t, err)
+}
+
 func TestMetablockVerifySignature(t *testing.T) {
 	// Test metablock signature verification errors:
 	// - no signature found
@@ -512,12 +746,14 @@ func TestValidateLayout(t *testing.T) {
 					SupplyChainItem: SupplyChainItem{
 						Name: "foo",
 					},
+					Threshold: 1,
 				},
 				{
 					Type: "step",
 					SupplyChainItem: SupplyChainItem{
 						Name: "foo",
 					},
+					Threshold: 1,
 				},
 			},
 			Inspect: []Inspection{},
@@ -546,6 +782,7 @@ func TestValidateLayout(t *testing.T) {
 					SupplyChainItem: SupplyChainItem{
 						Name: "foo",
 					},
+					Threshold: 1,
 				},
 			},
 			Inspect: []Inspection{
@@ -666,6 +903,52 @@ func TestValidateLayout(t *testing.T) {
 	}
 }
 
+func TestLayoutValidate(t *testing.T) {
+	var mb Metablock
+	if err := mb.Load("demo.layout"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, mb.Signed.(Layout).Validate())
+
+	// Steps are modified on a copy of the demo layout, whose first step has
+	// certificate constraints and whose second step has a single key
+	tests := []struct {
+		name     string
+		modify   func(layout *Layout)
+		expected string
+	}{
+		{"empty step name", func(layout *Layout) { layout.Steps[1].Name = "" }, "step name cannot be empty"},
+		{"zero threshold", func(layout *Layout) { layout.Steps[1].Threshold = 0 }, "invalid threshold for step 'package'"},
+		{"threshold above keys", func(layout *Layout) { layout.Steps[1].Threshold = 2 }, "threshold 2 of step 'package' exceeds its 1 keys"},
+		{"threshold above duplicate keys", func(layout *Layout) {
+			layout.Steps[1].PubKeys = append(layout.Steps[1].PubKeys, layout.Steps[1].PubKeys[0])
+			layout.Steps[1].Threshold = 2
+		}, "threshold 2 of step 'package' exceeds its 1 keys"},
+		{"unknown step key", func(layout *Layout) { layout.Steps[1].PubKeys = []string{"deadbeef"} }, "key 'deadbeef' of step 'package' not found in layout keys"},
+		{"invalid expiration date", func(layout *Layout) { layout.Expires = "2030-11-18" }, "expiry time parsed incorrectly"},
+	}
+	for _, test := range tests {
+		layout := mb.Signed.(Layout)
+		layout.Steps = slices.Clone(layout.Steps)
+		test.modify(&layout)
+		assert.ErrorContains(t, layout.Validate(), test.expected, test.name)
+
+		// Loading the layout runs the same checks
+		jsonBytes, err := json.Marshal(Metablock{Signed: layout, Signatures: []Signature{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var loaded Metablock
+		assert.ErrorContains(t, loaded.LoadFromBytes(jsonBytes), test.expected, test.name)
+	}
+
+	// Certificate constraints allow thresholds above the number of keys
+	layout := mb.Signed.(Layout)
+	layout.Steps = slices.Clone(layout.Steps)
+	layout.Steps[0].Threshold = 2
+	assert.Nil(t, layout.Validate())
+}
+
 func TestValidateStep(t *testing.T) {
 	testStep := Step{
 		Type: "invalid",
@@ -685,6 +968,7 @@ func TestValidateStep(t *testing.T) {
 		SupplyChainItem: SupplyChainItem{
 			Name: "foo",
 		},
+		Threshold: 1,
 	}
 	err = validateStep(testStep)
 	if !errors.Is(err, ErrInvalidHexString) {
@@ -845,6 +1129,72 @@ func TestValidatePubKey(t *testing.T) {
 	}
 }
 
+func TestMetablockValidate(t *testing.T) {
+	keyID := "70ca5750c2eda80b18f41f4ec5f92146789b5d68dd09577be422a0159bd13680"
+	var alice Key
+	if err := alice.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	newLayout := func() Layout {
+		return Layout{
+			Type:    "layout",
+			Expires: "2030-11-18T16:06:36Z",
+			Keys:    map[string]Key{keyID: alice},
+			Steps: []Step{{
+				Type:            "step",
+				SupplyChainItem: SupplyChainItem{Name: "foo"},
+				PubKeys:         []string{keyID},
+				Threshold:       1,
+			}},
+			Inspect: []Inspection{{
+				Type:            "inspection",
+				SupplyChainItem: SupplyChainItem{Name: "bar"},
+			}},
+		}
+	}
+	mb := &Metablock{Signed: newLayout()}
+	assert.Nil(t, mb.Validate())
+
+	layout := newLayout()
+	layout.Type = "link"
+	mb.Signed = layout
+	assert.ErrorContains(t, mb.Validate(), "should be 'layout'")
+
+	mb.Signed = Link{Type: "layout", Name: "foo"}
+	assert.ErrorContains(t, mb.Validate(), "should be 'link'")
+
+	layout = newLayout()
+	layout.Steps = append(layout.Steps, layout.Steps[0])
+	mb.Signed = layout
+	assert.ErrorContains(t, mb.Validate(), "non unique step or inspection name")
+
+	layout = newLayout()
+	layout.Inspect[0].Name = "foo"
+	mb.Signed = layout
+	assert.ErrorContains(t, mb.Validate(), "non unique step or inspection name")
+
+	layout = newLayout()
+	layout.Steps[0].Threshold = 0
+	mb.Signed = layout
+	assert.ErrorContains(t, mb.Validate(), "invalid threshold for step 'foo'")
+
+	layout = newLayout()
+	layout.Keys = map[string]Key{}
+	mb.Signed = layout
+	assert.ErrorContains(t, mb.Validate(), "of step 'foo' not found in layout keys")
+
+	// Loading validates, too
+	mb.Signatures = []Signature{}
+	jsonBytes, err := mb.DumpToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded Metablock
+	assert.ErrorContains(t, loaded.LoadFromBytes(jsonBytes), "not found in layout keys")
+	_, err = LoadMetadataFromBytes(jsonBytes)
+	assert.ErrorContains(t, err, "not found in layout keys")
+}
+
 func TestValidateMetablock(t *testing.T) {
 	testMetablock := Metablock{
 		Signatures: []Signature{
@@ -1161,6 +1511,395 @@ func TestMetablockSignWithRSA(t *testing.T) {
 	}
 }
 
+// cryptoSigner is a Signer and Verifier backed by a crypto.Signer, as exposed
+// by e.g. PKCS#11 or KMS libraries
+type cryptoSigner struct {
+	keyID  string
+	signer crypto.Signer
+}
+
+func (s cryptoSigner) KeyID() string {
+	return s.keyID
+}
+
+func (s cryptoSigner) Sign(data []byte) (Signature, error) {
+	sig, err := s.signer.Sign(rand.Reader, data, crypto.Hash(0))
+	if err != nil {
+		return Signature{}, err
+	}
+	return Signature{KeyID: s.keyID, Sig: hex.EncodeToString(sig)}, nil
+}
+
+func (s cryptoSigner) Verify(data []byte, sig Signature) error {
+	sigBytes, err := hex.DecodeString(sig.Sig)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(s.signer.Public().(ed25519.PublicKey), data, sigBytes) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func TestMetablockSignWithVerifySignatureWith(t *testing.T) {
+	_, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8Bytes, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key Key
+	if err := key.LoadKeyReaderDefaults(bytes.NewReader(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Bytes}))); err != nil {
+		t.Fatal(err)
+	}
+	external := cryptoSigner{keyID: key.KeyID, signer: privKey}
+
+	newLink := func() *Metablock {
+		return &Metablock{Signed: Link{
+			Type:        "link",
+			Name:        "external",
+			Materials:   map[string]HashObj{},
+			Products:    map[string]HashObj{},
+			ByProducts:  map[string]interface{}{},
+			Command:     []string{},
+			Environment: map[string]interface{}{},
+		}}
+	}
+
+	// Signatures of an external Signer verify with the corresponding Key
+	mb := newLink()
+	assert.Nil(t, mb.SignWith(external))
+	assert.Equal(t, key.KeyID, mb.Signatures[0].KeyID)
+	assert.Nil(t, mb.VerifySignature(key))
+
+	// Signatures of a Key verify with an external Verifier
+	mb = newLink()
+	assert.Nil(t, mb.Sign(key))
+	assert.Nil(t, mb.VerifySignatureWith(external))
+	verifier, err := NewKeyVerifier(key)
+	assert.Nil(t, err)
+	assert.Nil(t, mb.VerifySignatureWith(verifier))
+
+	// Verification fails for a modified payload or an unknown keyid
+	mb.Signed = Link{Type: "link", Name: "tampered"}
+	assert.ErrorIs(t, mb.VerifySignatureWith(external), ErrInvalidSignature)
+	assert.NotNil(t, mb.VerifySignatureWith(cryptoSigner{keyID: "unknown", signer: privKey}))
+
+	_, err = NewKeySigner(Key{KeyType: "dsa"})
+	assert.ErrorIs(t, err, ErrUnsupportedKeyType)
+}
+
+func TestMetablockAddSignature(t *testing.T) {
+	var key Key
+	if err := key.LoadKey("carol", "ed25519", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	privBytes, err := hex.DecodeString(key.KeyVal.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mb := Metablock{Signed: Link{
+		Type:        "link",
+		Name:        "external",
+		Materials:   map[string]HashObj{},
+		Products:    map[string]HashObj{},
+		ByProducts:  map[string]interface{}{},
+		Command:     []string{},
+		Environment: map[string]interface{}{},
+	}}
+	payload, err := mb.GetSignableRepresentation()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sign outside of the library, as an HSM would
+	sig := Signature{
+		KeyID: key.KeyID,
+		Sig:   hex.EncodeToString(ed25519.Sign(ed25519.NewKeyFromSeed(privBytes[:ed25519.SeedSize]), payload)),
+	}
+	assert.Nil(t, mb.AddSignature(sig))
+	assert.Nil(t, mb.VerifySignature(key))
+
+	assert.ErrorContains(t, mb.AddSignature(sig), "already exists")
+	assert.ErrorIs(t, mb.AddSignature(Signature{KeyID: "abc", Sig: "not hex"}), ErrInvalidHexString)
+	assert.Len(t, mb.Signatures, 1)
+}
+
+func TestMetablockVerifySignatures(t *testing.T) {
+	var carol, dan Key
+	if err := carol.LoadKey("carol", "ed25519", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dan.LoadKey("dan", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	newLink := func() *Metablock {
+		return &Metablock{Signed: Link{
+			Type:        "link",
+			Name:        "multi",
+			Materials:   map[string]HashObj{},
+			Products:    map[string]HashObj{},
+			ByProducts:  map[string]interface{}{},
+			Command:     []string{},
+			Environment: map[string]interface{}{},
+		}}
+	}
+	keys := map[string]Key{carol.KeyID: carol, dan.KeyID: dan}
+
+	// Two valid signers meet a threshold of two
+	mb := newLink()
+	assert.Nil(t, mb.Sign(carol))
+	assert.Nil(t, mb.Sign(dan))
+	assert.Nil(t, mb.VerifySignatures(keys, 2))
+	assert.ErrorIs(t, mb.VerifySignatures(keys, 3), ErrThresholdNotMet)
+	assert.NotNil(t, mb.VerifySignatures(keys, 0))
+
+	// A duplicate signature from the same keyid only counts once, even if
+	// the key is passed under several names
+	mb = newLink()
+	assert.Nil(t, mb.Sign(carol))
+	mb.Signatures = append(mb.Signatures, mb.Signatures[0])
+	err := mb.VerifySignatures(map[string]Key{carol.KeyID: carol, "alias": carol}, 2)
+	assert.ErrorIs(t, err, ErrThresholdNotMet)
+	assert.ErrorContains(t, err, "'1' out of '2'")
+
+	// The reason why a key was rejected is passed on
+	err = mb.VerifySignatures(keys, 2)
+	assert.ErrorIs(t, err, ErrThresholdNotMet)
+	assert.ErrorContains(t, err, "no signature found for key")
+}
+
+func TestMetablockVerifyExternallySignedLayout(t *testing.T) {
+	// external.layout was written and signed in the format of the Python
+	// reference implementation, see external-layout-fixture.py.  Its
+	// functionary key has no "keyid_hash_algorithms", which re-encoding
+	// Signed adds as null
+	var alice Key
+	if err := alice.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	md, err := LoadMetadata("external.layout")
+	if err != nil {
+		t.Fatalf("LoadMetadata returned '%s'", err)
+	}
+	mb := md.(*Metablock)
+	reencoded, err := EncodeCanonical(mb.Signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signable, err := mb.GetSignableRepresentation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, reencoded, signable)
+	assert.Contains(t, string(reencoded), `"keyid_hash_algorithms":null`)
+	assert.NotContains(t, string(signable), "keyid_hash_algorithms")
+	assert.Nil(t, mb.VerifySignature(alice))
+	assert.Nil(t, VerifyLayoutKeyIDs(mb.Signed.(Layout)))
+
+	var fileMb Metablock
+	if err := fileMb.Load("external.layout"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, fileMb.VerifySignature(alice))
+
+	// Once Signed is modified, the loaded bytes are no longer what is signed
+	layout := mb.Signed.(Layout)
+	layout.Expires = "2031-11-18T16:06:36Z"
+	mb.Signed = layout
+	signable, err = mb.GetSignableRepresentation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Contains(t, string(signable), "2031-11-18T16:06:36Z")
+	assert.NotNil(t, mb.VerifySignature(alice))
+}
+
+func TestMetablockSignLayoutMultipleKeys(t *testing.T) {
+	var alice, carol Key
+	if err := alice.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := carol.LoadKey("carol", "ed25519", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	mb := Metablock{Signed: Layout{
+		Type:    "layout",
+		Expires: "2030-11-18T16:06:36Z",
+		Keys:    map[string]Key{},
+		Steps:   []Step{},
+		Inspect: []Inspection{},
+	}}
+
+	assert.Nil(t, mb.Sign(alice))
+	assert.Nil(t, mb.Sign(carol))
+	assert.Len(t, mb.Signatures, 2)
+	assert.Nil(t, mb.VerifySignature(alice))
+	assert.Nil(t, mb.VerifySignature(carol))
+
+	// Re-signing with alice replaces her signature in place, PSS signatures
+	// differ on every call
+	aliceSig := mb.Signatures[0]
+	assert.Nil(t, mb.Sign(alice))
+	assert.Len(t, mb.Signatures, 2)
+	assert.Equal(t, alice.KeyID, mb.Signatures[0].KeyID)
+	assert.NotEqual(t, aliceSig.Sig, mb.Signatures[0].Sig)
+	assert.Nil(t, mb.VerifySignatures(map[string]Key{alice.KeyID: alice, carol.KeyID: carol}, 2))
+}
+
+func TestMetablockSignVerifyRSA(t *testing.T) {
+	var key Key
+	// dan is a 3072-bit RSA key
+	if err := key.LoadKey("dan", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatalf("unable to load RSA key: %s", err)
+	}
+	assert.Equal(t, "rsa", key.KeyType)
+	assert.Equal(t, "rsassa-pss-sha256", key.Scheme)
+
+	var pubKey Key
+	if err := pubKey.LoadKey("dan.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatalf("unable to load RSA public key: %s", err)
+	}
+	// The keyid only depends on the public portion of the key
+	assert.Equal(t, key.KeyID, pubKey.KeyID)
+
+	mb := Metablock{Signed: Link{
+		Type:        "link",
+		Name:        "rsa",
+		Materials:   map[string]HashObj{},
+		Products:    map[string]HashObj{},
+		ByProducts:  map[string]interface{}{},
+		Command:     []string{},
+		Environment: map[string]interface{}{},
+	}}
+	if err := mb.Sign(key); err != nil {
+		t.Fatalf("unable to sign link with RSA key: %s", err)
+	}
+	assert.Nil(t, mb.VerifySignature(pubKey))
+
+	// Verification fails for a modified payload
+	link := mb.Signed.(Link)
+	link.Name = "tampered"
+	mb.Signed = link
+	assert.NotNil(t, mb.VerifySignature(pubKey))
+}
+
+func TestMetablockSignVerifyRSAKeySizes(t *testing.T) {
+	for _, bits := range []int{2048, 4096} {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkcs8Bytes, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pubBytes, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privPEMs := map[string][]byte{
+			"PKCS1": pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+			"PKCS8": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Bytes}),
+		}
+		pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
+
+		var pubKey Key
+		if err := pubKey.LoadKeyReaderDefaults(bytes.NewReader(pubPEM)); err != nil {
+			t.Fatalf("unable to load RSA-%d public key: %s", bits, err)
+		}
+		assert.Equal(t, "rsa", pubKey.KeyType)
+		assert.Equal(t, "rsassa-pss-sha256", pubKey.Scheme)
+
+		for format, privPEM := range privPEMs {
+			var key Key
+			if err := key.LoadKeyReaderDefaults(bytes.NewReader(privPEM)); err != nil {
+				t.Fatalf("unable to load RSA-%d %s private key: %s", bits, format, err)
+			}
+			assert.Equal(t, pubKey.KeyID, key.KeyID)
+
+			mb := Metablock{Signed: Link{
+				Type:        "link",
+				Name:        "rsa",
+				Materials:   map[string]HashObj{},
+				Products:    map[string]HashObj{},
+				ByProducts:  map[string]interface{}{},
+				Command:     []string{},
+				Environment: map[string]interface{}{},
+			}}
+			if err := mb.Sign(key); err != nil {
+				t.Fatalf("unable to sign link with RSA-%d %s key: %s", bits, format, err)
+			}
+			assert.Nil(t, mb.VerifySignature(pubKey))
+		}
+	}
+}
+
+func TestMetablockSignVerifyEcdsa(t *testing.T) {
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Bytes, err := x509.MarshalPKCS8PrivateKey(p256Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256PEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: p256Bytes})
+	p384PEM, err := os.ReadFile("grace")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		name           string
+		pem            []byte
+		expectedScheme string
+	}{
+		{"P-256", p256PEM, ecdsaSha2nistp256},
+		{"P-384", p384PEM, ecdsaSha2nistp384},
+	}
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			var key Key
+			if err := key.LoadKeyReaderDefaults(bytes.NewReader(table.pem)); err != nil {
+				t.Fatalf("unable to load ecdsa key: %s", err)
+			}
+			assert.Equal(t, ecdsaKeyType, key.KeyType)
+			assert.Equal(t, table.expectedScheme, key.Scheme)
+
+			mb := Metablock{Signed: Link{
+				Type:        "link",
+				Name:        "ecdsa",
+				Materials:   map[string]HashObj{},
+				Products:    map[string]HashObj{},
+				ByProducts:  map[string]interface{}{},
+				Command:     []string{},
+				Environment: map[string]interface{}{},
+			}}
+			if err := mb.Sign(key); err != nil {
+				t.Fatalf("unable to sign link with ecdsa key: %s", err)
+			}
+			// Signatures are hex encoded DER sequences of r and s
+			sig, err := hex.DecodeString(mb.Signatures[0].Sig)
+			assert.Nil(t, err)
+			assert.Equal(t, byte(0x30), sig[0])
+
+			pubKey := key
+			pubKey.KeyVal.Private = ""
+			assert.Nil(t, mb.VerifySignature(pubKey))
+
+			// Verification fails for a modified payload
+			link := mb.Signed.(Link)
+			link.Name = "tampered"
+			mb.Signed = link
+			assert.NotNil(t, mb.VerifySignature(pubKey))
+		})
+	}
+}
+
 func TestMetablockSignWithEd25519(t *testing.T) {
 	var mb Metablock
 	if err := mb.Load("demo.layout"); err != nil {
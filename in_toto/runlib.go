@@ -1,16 +1,24 @@
 package in_toto
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/shibumi/go-pathspec"
 )
@@ -18,17 +26,125 @@ import (
 // ErrSymCycle signals a detected symlink cycle in our RecordArtifacts() function.
 var ErrSymCycle = errors.New("symlink cycle detected")
 
+/*
+SymCycleError is returned if RecordArtifacts detects a symlink cycle.  Paths
+holds the symlinks that were followed in order, ending with the symlink that
+was reached a second time, e.g. "a/linkToB", "b/linkToA", "a/linkToB".  It
+wraps ErrSymCycle.
+*/
+type SymCycleError struct {
+	Paths []string
+}
+
+func (e *SymCycleError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrSymCycle, strings.Join(e.Paths, " -> "))
+}
+
+func (e *SymCycleError) Unwrap() error {
+	return ErrSymCycle
+}
+
+/*
+RecordArtifactsError is returned by RecordArtifactsWithOptionsCtx if
+ContinueOnError is set and some paths could not be recorded.  Errors maps
+each of these paths to the error that occurred for it.  It unwraps to these
+errors, so that e.g. errors.Is(err, fs.ErrPermission) reports whether any path
+was not accessible.
+*/
+type RecordArtifactsError struct {
+	Errors map[string]error
+}
+
+func (e *RecordArtifactsError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, path := range e.sortedPaths() {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", path, e.Errors[path]))
+	}
+	return fmt.Sprintf("failed to record %d artifacts: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+func (e *RecordArtifactsError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, path := range e.sortedPaths() {
+		errs = append(errs, e.Errors[path])
+	}
+	return errs
+}
+
+func (e *RecordArtifactsError) sortedPaths() []string {
+	paths := make([]string, 0, len(e.Errors))
+	for path := range e.Errors {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// ErrSymDepthExceeded signals that RecordArtifacts followed more symlinks than allowed to reach an artifact.
+var ErrSymDepthExceeded = errors.New("symlink depth exceeded")
+
 // ErrUnsupportedHashAlgorithm signals a missing hash mapping in getHashMapping
 var ErrUnsupportedHashAlgorithm = errors.New("unsupported hash algorithm detected")
 
 var ErrEmptyCommandArgs = errors.New("the command args are empty")
 
-// visitedSymlinks is a hashset that contains all paths that we have visited.
-var visitedSymlinks Set
+// ErrCommandTerminated signals that RunCommandCtx killed the command,
+// because its context was cancelled or its deadline expired.
+var ErrCommandTerminated = errors.New("command was terminated")
+
+// ErrPrelimLinkUnverified signals that InTotoRecordStop was passed a signed
+// preliminary link, but no key to verify its signatures with.
+var ErrPrelimLinkUnverified = errors.New("signed preliminary link cannot be verified without a key")
+
+/*
+DefaultMaxByproductSize is the maximum number of bytes of stdout and stderr
+each, that the in-toto run command captures as byproducts by default, so that a
+command that prints hundreds of megabytes does not exhaust memory or bloat the
+link.  The library captures output without limit, unless a limit is passed in
+RunCommandOptions.MaxByproductSize.
+*/
+const DefaultMaxByproductSize = 16 * 1024 * 1024
+
+/*
+ByproductTruncationMarker is appended to captured stdout or stderr that was
+truncated, because it exceeded the maximum byproduct size, so that truncated
+output is recognizable as such in the link.
+*/
+const ByproductTruncationMarker = "\n[truncated]"
+
+/*
+ArtifactModeKey is the key under which RecordArtifactsWithOptions records the
+Unix mode of an artifact next to its hashes, if RecordFileMode is set.
+*/
+const ArtifactModeKey = "mode"
+
+/*
+ArtifactDirKey and ArtifactDirValue form the sentinel that
+RecordArtifactsWithOptions records instead of hashes for an empty directory,
+if RecordEmptyDirs is set, i.e. {"dir": "1"}.  The value is a valid hex string,
+so that links recording empty directories pass link validation.
+*/
+const (
+	ArtifactDirKey   = "dir"
+	ArtifactDirValue = "1"
+)
+
+/*
+DefaultMaxSymlinkDepth is the default maximum number of symlinks that are
+followed to reach an artifact, the same limit that Linux applies when
+resolving a path.
+*/
+const DefaultMaxSymlinkDepth = 40
+
+// commandWaitDelay bounds the time RunCommandCtx waits for the output of a
+// killed command.
+const commandWaitDelay = 5 * time.Second
 
 /*
 RecordArtifact reads and hashes the contents of the file at the passed path
-using sha256 and returns a map in the following format:
+using each of the passed hash algorithms (any of "sha256", "sha384", "sha512",
+"sha3_256", "sha3_512", "blake2b-256" and "blake2b-512") and returns a map in
+the following format:
 
 	{
 		"<path>": {
@@ -36,35 +152,107 @@ using sha256 and returns a map in the following format:
 		}
 	}
 
+The file is streamed through the hash functions in fixed-size chunks, so that
+memory usage does not depend on the file size.
 If reading the file fails, the first return value is nil and the second return
 value is the error.
-NOTE: For cross-platform consistency Windows-style line separators (CRLF) are
-normalized to Unix-style line separators (LF) before hashing file contents.
+NOTE: If lineNormalization is enabled, Windows-style (CRLF) and old Mac-style
+(CR) line separators are normalized to Unix-style line separators (LF) before
+hashing file contents, for cross-platform consistency.  This matches the
+"--normalize-line-endings" option of the Python reference implementation, e.g.
+"this\r\nis\r\n" and "this\nis\n" both hash to the same value.  Like there,
+all files are normalized, including binary ones, see
+RecordArtifactsOptions.SkipBinaryNormalization to leave those as is.
 */
 func RecordArtifact(path string, hashAlgorithms []string, lineNormalization bool) (HashObj, error) {
-	supportedHashMappings := getHashMapping()
-	// Read file from passed path
-	contents, err := os.ReadFile(path)
-	hashedContentsMap := make(HashObj)
+	if err := ValidateHashAlgorithms(hashAlgorithms); err != nil {
+		return nil, err
+	}
+	// Open file at passed path
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
+
+	return hashReader(file, hashAlgorithms, lineEndingsFor(lineNormalization, false))
+}
 
-	if lineNormalization {
+/*
+RecordArtifactFromReader hashes the data read from the passed reader until EOF
+using each of the passed hash algorithms, and returns a map with the passed
+name as single key in the same format as RecordArtifacts:
+
+	{
+		"<name>": {
+			"sha256": <hex representation of hash>
+		}
+	}
+
+This allows to record artifacts, which never exist on disk, e.g. data that is
+downloaded or generated in memory.  Like paths passed to RecordArtifacts, the
+name is converted to use forward slashes, so that the returned map can be merged
+into the Materials or Products of a Link.  If reading fails, the first return value is
+nil and the second return value is the error.
+*/
+func RecordArtifactFromReader(name string, r io.Reader, hashAlgorithms []string) (map[string]HashObj, error) {
+	if err := ValidateHashAlgorithms(hashAlgorithms); err != nil {
+		return nil, err
+	}
+
+	artifact, err := hashReader(r, hashAlgorithms, rawLineEndings)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the same key format as RecordArtifacts, which always records
+	// artifact paths with forward slashes
+	return map[string]HashObj{filepath.ToSlash(name): artifact}, nil
+}
+
+/*
+hashReader streams the data read from the passed reader through each of the
+passed hash algorithms in fixed-size chunks and returns the resulting HashObj.
+Line separators are treated as selected by endings.  The hash algorithms must
+have been validated before.
+*/
+func hashReader(r io.Reader, hashAlgorithms []string, endings lineEndings) (HashObj, error) {
+	supportedHashMappings := getHashMapping()
+
+	// Create a hash for each of the algorithms present in the hash_func list
+	hashes := make([]hash.Hash, len(hashAlgorithms))
+	writers := make([]io.Writer, len(hashAlgorithms))
+	for i, element := range hashAlgorithms {
+		hashes[i] = supportedHashMappings[element]()
+		writers[i] = hashes[i]
+	}
+
+	var w io.Writer = io.MultiWriter(writers...)
+	binary := false
+	if endings == textLineEndings {
+		// Binary files are hashed as is, so that normalization does not
+		// alter data that merely happens to contain line separator bytes
+		br := bufio.NewReaderSize(r, binaryDetectionSize)
+		var err error
+		if binary, err = isBinary(br); err != nil {
+			return nil, err
+		}
+		r = br
+	}
+	if endings != rawLineEndings && !binary {
 		// "Normalize" file contents. We convert all line separators to '\n'
 		// for keeping operating system independence
-		contents = bytes.ReplaceAll(contents, []byte("\r\n"), []byte("\n"))
-		contents = bytes.ReplaceAll(contents, []byte("\r"), []byte("\n"))
+		w = &lineNormalizer{w: w}
 	}
 
-	// Create a map of all the hashes present in the hash_func list
-	for _, element := range hashAlgorithms {
-		if _, ok := supportedHashMappings[element]; !ok {
-			return nil, fmt.Errorf("%w: %s", ErrUnsupportedHashAlgorithm, element)
-		}
-		h := supportedHashMappings[element]
-		result := fmt.Sprintf("%x", hashToHex(h(), contents))
-		hashedContentsMap[element] = result
+	buf := make([]byte, hashBufferSize)
+	if _, err := io.CopyBuffer(w, r, buf); err != nil {
+		return nil, err
+	}
+
+	hashedContentsMap := make(HashObj)
+	for i, element := range hashAlgorithms {
+		hashedContentsMap[element] = fmt.Sprintf("%x", hashes[i].Sum(nil))
 	}
 
 	// Return it in a format that is conformant with link metadata artifacts
@@ -72,12 +260,99 @@ func RecordArtifact(path string, hashAlgorithms []string, lineNormalization bool
 }
 
 /*
-RecordArtifacts is a wrapper around recordArtifacts.
-RecordArtifacts initializes a set for storing visited symlinks,
-calls recordArtifacts and deletes the set if no longer needed.
-recordArtifacts walks through the passed slice of paths, traversing
-subdirectories, and calls RecordArtifact for each file. It returns a map in
-the following format:
+RecordArtifactsOptions configures how RecordArtifactsWithOptions walks and
+hashes artifacts.  HashAlgorithms, GitignorePatterns, LStripPaths,
+LineNormalization and FollowSymlinkDirs have the same meaning as the
+corresponding RecordArtifacts parameters.  Concurrency limits the number of
+files that are hashed in parallel; a value smaller than one defaults to
+runtime.NumCPU().
+
+LineNormalization normalizes the line separators of all files, like the
+"--normalize-line-endings" option of the Python reference implementation, see
+RecordArtifact.  If SkipBinaryNormalization is set as well, files that look
+binary, i.e. contain a NUL byte within their first 8000 bytes, are hashed as
+is, so that normalization does not alter data that merely happens to contain
+line separator bytes.  The hashes of such files then differ from the ones that
+the Python reference implementation records with line normalization.
+
+By default symlinks are resolved and recorded with the hashes of the files they
+point to.  If RecordSymlinks is set, symlinks are not followed at all, neither
+to files nor to directories, but recorded as entries of their own.  The hashes
+of such an entry are computed over the slash separated link target as returned
+by os.Readlink, e.g. the sha256 of "../lib/libfoo.so", so that a link that is
+changed to point elsewhere results in different hashes.  RecordFileSymlinks
+does the same only for symlinks that do not point to a directory, including
+dangling ones, while symlinks to directories are still handled according to
+FollowSymlinkDirs.
+
+MaxSymlinkDepth limits the number of symlinks that are followed to reach an
+artifact, counting both chains of symlinks to symlinks and symlinks to
+directories beneath followed symlinks, also if they do not form a cycle.  If
+the limit is exceeded, an error wrapping ErrSymDepthExceeded is returned.  A
+value smaller than one defaults to DefaultMaxSymlinkDepth.
+
+If UseGitignoreFiles is set, .gitignore files found in walked directories are
+honored in addition to GitignorePatterns, see RecordArtifactsWithGitignore.
+
+By default recording stops at the first path that cannot be walked or hashed,
+e.g. because of missing permissions.  If ContinueOnError is set, such paths
+are skipped instead, so that everything else is still recorded, see
+RecordArtifactsWithOptionsCtx.  Symlink cycles, exceeded symlink depths and non
+unique keys remain fatal.
+
+If RecordFileMode is set, the permission bits of each file, including the
+setuid, setgid and sticky bits, are recorded as zero padded octal number
+under ArtifactModeKey next to the hashes, e.g. "mode": "0755", so that two
+files with the same contents but e.g. a different executable bit result in
+different artifacts, which artifact rules such as MATCH and MODIFY compare.
+Followed symlinks are recorded with the mode of their target, and symlinks that
+are recorded as entries of their own without a mode.  Windows does not have
+Unix modes, so the option has no effect there.
+
+If NormalizeCase is set, artifact paths are recorded in lower case, after left
+stripping, so that links created on case-insensitive filesystems, e.g. of
+macOS and Windows, record the same paths regardless of how a file was
+referenced, e.g. as "Foo.txt" or "foo.txt".  This trades fidelity for
+cross-platform consistency: the original case is lost, and artifact rules must
+use lower case patterns to match the recorded paths.  Paths that only differ in
+case and name the same file are recorded once, whereas distinct files whose
+paths only differ in case, as on case-sensitive filesystems, result in an error.
+
+By default only files are recorded, so that an empty directory leaves no trace
+and cannot be told apart from a missing path.  If RecordEmptyDirs is set,
+directories that do not contain any entries are recorded as artifacts of their
+own, with the sentinel {ArtifactDirKey: ArtifactDirValue} in place of hashes,
+e.g. "dir": "1", which artifact rules compare like hashes.  Non-empty
+directories are still only recorded through the files beneath them.  A
+directory that only contains excluded entries is not empty.
+
+RecordSubmodules only applies to the files of git trees, see
+RecordArtifactsFromGitTree, whose submodules are skipped unless it is set.
+*/
+type RecordArtifactsOptions struct {
+	HashAlgorithms          []string
+	GitignorePatterns       []string
+	LStripPaths             []string
+	LineNormalization       bool
+	SkipBinaryNormalization bool
+	FollowSymlinkDirs       bool
+	RecordSymlinks          bool
+	RecordFileSymlinks      bool
+	MaxSymlinkDepth         int
+	UseGitignoreFiles       bool
+	Concurrency             int
+	ContinueOnError         bool
+	RecordFileMode          bool
+	NormalizeCase           bool
+	RecordEmptyDirs         bool
+	RecordSubmodules        bool
+}
+
+/*
+RecordArtifacts is a wrapper around RecordArtifactsCtx, which uses
+context.Background() and thus cannot be cancelled.  It walks through
+the passed slice of paths, traversing subdirectories, and calls RecordArtifact
+for each file. It returns a map in the following format:
 
 	{
 		"<path>": {
@@ -89,67 +364,555 @@ the following format:
 		...
 	}
 
+Paths matching any of the passed gitignorePatterns are excluded before they
+are hashed.  Patterns follow gitignore semantics, e.g. "*.log" excludes log
+files at any depth and "node_modules" excludes the node_modules directory
+together with everything beneath it.
+
+The first of the passed lStripPaths that an artifact path starts with is
+removed from the recorded key.  Keys always use forward slashes.  If stripping
+results in the same key for two artifacts, an error is returned.
+
 If recording an artifact fails the first return value is nil and the second
-return value is the error.
+return value is the error.  Unsupported hash algorithms are rejected with
+ValidateHashAlgorithms before any path is walked.
 */
 func RecordArtifacts(paths []string, hashAlgorithms []string, gitignorePatterns []string, lStripPaths []string, lineNormalization bool, followSymlinkDirs bool) (evalArtifacts map[string]HashObj, err error) {
+	return RecordArtifactsCtx(context.Background(), paths, hashAlgorithms, gitignorePatterns, lStripPaths, lineNormalization, followSymlinkDirs)
+}
+
+/*
+RecordArtifactsCtx is like RecordArtifacts, but stops walking and hashing
+artifacts once the passed context is done, e.g. because a surrounding build
+step timed out.  The context is checked between files, in which case ctx.Err()
+is returned.  It calls RecordArtifactsWithOptionsCtx with the passed
+parameters as options and the default concurrency.
+*/
+func RecordArtifactsCtx(ctx context.Context, paths []string, hashAlgorithms []string, gitignorePatterns []string, lStripPaths []string, lineNormalization bool, followSymlinkDirs bool) (map[string]HashObj, error) {
+	return RecordArtifactsWithOptionsCtx(ctx, paths, RecordArtifactsOptions{
+		HashAlgorithms:    hashAlgorithms,
+		GitignorePatterns: gitignorePatterns,
+		LStripPaths:       lStripPaths,
+		LineNormalization: lineNormalization,
+		FollowSymlinkDirs: followSymlinkDirs,
+	})
+}
+
+/*
+RecordArtifactsWithOptions is a wrapper around RecordArtifactsWithOptionsCtx,
+which uses context.Background().
+*/
+func RecordArtifactsWithOptions(paths []string, opts RecordArtifactsOptions) (map[string]HashObj, error) {
+	return RecordArtifactsWithOptionsCtx(context.Background(), paths, opts)
+}
+
+/*
+RecordArtifactsWithGitignore is like RecordArtifacts with the sha256 hash
+algorithm, but skips files that are ignored by .gitignore files found in the
+walked directories.  As in git, the patterns of a .gitignore file are relative
+to the directory that contains it and only apply beneath that directory.
+Patterns of .gitignore files in subdirectories take precedence over those of
+their parent directories, so that e.g. "!keep.log" in "sub/.gitignore"
+re-includes a file excluded by "*.log" in the root.  Negation with "!",
+directory-only patterns with a trailing "/" and "**" globs are supported.
+*/
+func RecordArtifactsWithGitignore(paths []string) (map[string]HashObj, error) {
+	return RecordArtifactsWithOptions(paths, RecordArtifactsOptions{
+		HashAlgorithms:    []string{"sha256"},
+		UseGitignoreFiles: true,
+	})
+}
+
+/*
+readGitignoreFile reads the .gitignore file in the passed directory, if there
+is one, and returns its patterns rewritten to be relative to the walked root,
+where relDir is the slash separated path of the directory relative to that
+root.  Blank lines and comments are skipped.
+*/
+func readGitignoreFile(dir string, relDir string) ([]string, error) {
+	content, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(content), "\n") {
+		pattern := strings.TrimSpace(line)
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		if relDir == "." {
+			patterns = append(patterns, pattern)
+			continue
+		}
+		// Anchor the pattern beneath relDir, keeping negation in front
+		negate := ""
+		if strings.HasPrefix(pattern, "!") {
+			negate = "!"
+			pattern = pattern[1:]
+		}
+		pattern = strings.TrimPrefix(pattern, "\\")
+		switch {
+		case strings.HasPrefix(pattern, "/"):
+			pattern = "/" + relDir + pattern
+		case strings.HasPrefix(pattern, "**/"):
+			pattern = "/" + relDir + "/" + pattern
+		default:
+			pattern = "/" + relDir + "/**/" + pattern
+		}
+		patterns = append(patterns, negate+pattern)
+	}
+	return patterns, nil
+}
+
+/*
+RecordArtifactsWithOptionsCtx initializes a set for storing visited symlinks and
+calls recordArtifacts to collect the files found at the passed paths.  The set
+is local to each call, so that RecordArtifactsWithOptionsCtx may be called
+from multiple goroutines at the same time.  The collected files are then hashed
+concurrently by up to opts.Concurrency workers.  The returned map has the same format as the one returned by
+RecordArtifacts and does not depend on the concurrency.
+
+If hashing fails for several files, the error of the file that comes first in
+walk order is returned, i.e. the same error that sequential hashing would have
+returned.  Once the passed context is done, no further files are walked or
+hashed and ctx.Err() is returned.
+
+If opts.ContinueOnError is set and some paths could not be walked or hashed,
+the artifacts that could be recorded are returned together with a
+*RecordArtifactsError, which lists the failed paths, so that callers can decide
+whether the partial result is acceptable.
+*/
+func RecordArtifactsWithOptionsCtx(ctx context.Context, paths []string, opts RecordArtifactsOptions) (map[string]HashObj, error) {
+	if err := ValidateHashAlgorithms(opts.HashAlgorithms); err != nil {
+		return nil, err
+	}
 	// Make sure to initialize a fresh hashset for every RecordArtifacts call
-	visitedSymlinks = NewSet()
-	evalArtifactsUnnormalized, err := recordArtifacts(paths, hashAlgorithms, gitignorePatterns, lStripPaths, lineNormalization, followSymlinkDirs)
+	visitedSymlinks := NewSet()
+	maxSymlinkDepth := opts.MaxSymlinkDepth
+	if maxSymlinkDepth < 1 {
+		maxSymlinkDepth = DefaultMaxSymlinkDepth
+	}
+	// Errors of individual paths are collected instead of returned, if the
+	// map is not nil
+	var pathErrs map[string]error
+	if opts.ContinueOnError {
+		pathErrs = make(map[string]error)
+	}
+	files, err := recordArtifacts(ctx, paths, visitedSymlinks, nil, opts.GitignorePatterns, opts.FollowSymlinkDirs, opts.RecordSymlinks, opts.RecordFileSymlinks, maxSymlinkDepth, opts.UseGitignoreFiles, opts.RecordEmptyDirs, pathErrs)
 	if err != nil {
 		return nil, err
 	}
 
-	// Normalize all paths in evalArtifactsUnnormalized.
-	evalArtifacts = make(map[string]HashObj, len(evalArtifactsUnnormalized))
-	for key, value := range evalArtifactsUnnormalized {
+	hashes, err := hashArtifacts(ctx, files, opts.HashAlgorithms,
+		lineEndingsFor(opts.LineNormalization, opts.SkipBinaryNormalization), opts.Concurrency, pathErrs)
+	if err != nil {
+		return nil, err
+	}
+	if opts.RecordFileMode && runtime.GOOS != "windows" {
+		if err := recordFileModes(files, hashes, pathErrs); err != nil {
+			return nil, err
+		}
+	}
+
+	evalArtifacts := make(map[string]HashObj, len(files))
+	// Remember the file of each key to report collisions
+	keyFiles := make(map[string]artifactFile, len(files))
+	for i, file := range files {
+		// Skip files that failed to be hashed
+		if hashes[i] == nil {
+			continue
+		}
 		// Convert windows filepath to unix filepath.
-		evalArtifacts[filepath.ToSlash(key)] = value
+		path := filepath.ToSlash(file.key)
+		stripped := lStripPath(path, opts.LStripPaths)
+		key := stripped
+		if opts.NormalizeCase {
+			key = strings.ToLower(key)
+		}
+		// Check if path is unique
+		if other, exists := keyFiles[key]; exists {
+			otherPath := filepath.ToSlash(other.key)
+			if lStripPath(otherPath, opts.LStripPaths) == stripped {
+				return nil, fmt.Errorf("left stripping has resulted in non unique dictionary key: %s (%s and %s)", key, otherPath, path)
+			}
+			// Paths that only differ in case may name the same file on
+			// case-insensitive filesystems
+			if sameArtifactFile(other, file) {
+				continue
+			}
+			return nil, fmt.Errorf("case normalization has resulted in non unique dictionary key: %s (%s and %s)", key, otherPath, path)
+		}
+		evalArtifacts[key] = hashes[i]
+		keyFiles[key] = file
 	}
 
+	if len(pathErrs) != 0 {
+		return evalArtifacts, &RecordArtifactsError{Errors: pathErrs}
+	}
 	return evalArtifacts, nil
 }
 
 /*
-recordArtifacts walks through the passed slice of paths, traversing
-subdirectories, and calls RecordArtifact for each file. It returns a map in
-the following format:
+sameArtifactFile returns true if the passed artifacts are the same file, e.g.
+because their paths only differ in case on a case-insensitive filesystem.
+Symlinks that are recorded as entries of their own are compared themselves,
+not their targets.
+*/
+func sameArtifactFile(a, b artifactFile) bool {
+	stat := os.Stat
+	if a.symlink || b.symlink {
+		stat = os.Lstat
+	}
+	aInfo, err := stat(a.path)
+	if err != nil {
+		return false
+	}
+	bInfo, err := stat(b.path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(aInfo, bInfo)
+}
 
-	{
-		"<path>": {
-			"sha256": <hex representation of hash>
-		},
-		"<path>": {
-		"sha256": <hex representation of hash>
-		},
-		...
+/*
+recordFileModes adds the Unix mode of each of the passed files to its hashes,
+skipping files that failed to be hashed and symlinks that are recorded as
+entries of their own.  If pathErrs is not nil, errors are added to it and the
+hashes of the failed files are set to nil, instead of being returned.
+*/
+func recordFileModes(files []artifactFile, hashes []HashObj, pathErrs map[string]error) error {
+	for i, file := range files {
+		if hashes[i] == nil || file.symlink {
+			continue
+		}
+		info, err := os.Stat(file.path)
+		if err != nil {
+			if pathErrs == nil {
+				return err
+			}
+			pathErrs[file.key] = err
+			hashes[i] = nil
+			continue
+		}
+		hashes[i][ArtifactModeKey] = fmt.Sprintf("%04o", unixMode(info.Mode()))
+	}
+	return nil
+}
+
+/*
+unixMode converts the permission and special bits of the passed FileMode to
+their numeric Unix representation.
+*/
+func unixMode(mode os.FileMode) uint32 {
+	unix := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		unix |= 04000
 	}
+	if mode&os.ModeSetgid != 0 {
+		unix |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		unix |= 01000
+	}
+	return unix
+}
 
-If recording an artifact fails the first return value is nil and the second
-return value is the error.
+/*
+lStripPath removes the first of the passed prefixes that the passed slash
+separated path starts with.  Prefixes are compared in their slash separated
+form, so that they match independently of the operating system.
+*/
+func lStripPath(path string, lStripPaths []string) string {
+	for _, strip := range lStripPaths {
+		strip = filepath.ToSlash(strip)
+		if strings.HasPrefix(path, strip) {
+			return strings.TrimPrefix(path, strip)
+		}
+	}
+	return path
+}
+
+/*
+ExpandGlobs expands each of the passed paths that contains glob meta
+characters to the paths it matches, in lexical order, and returns the
+remaining paths unchanged.  Besides the syntax of filepath.Match, a "**" path
+element matches zero or more directories, e.g. to match tarballs anywhere
+beneath a build directory.  Patterns with a "**" element only match files, not
+directories, as the files beneath a matched directory would otherwise be
+recorded twice.  A glob may match no paths at all, whereas a path without meta
+characters that does not exist is an error, just like when recording it.
+
+Paths that exist are never expanded, so that files whose names contain meta
+characters, e.g. "file[1].txt", are recorded as they are.
+*/
+func ExpandGlobs(paths []string) ([]string, error) {
+	var expanded []string
+	for _, p := range paths {
+		if _, err := os.Lstat(p); err == nil {
+			expanded = append(expanded, p)
+			continue
+		} else if !hasGlobMeta(p) {
+			return nil, err
+		}
+		matches, err := expandGlob(p)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
+
+// hasGlobMeta reports whether path contains any of the meta characters of filepath.Match.
+func hasGlobMeta(path string) bool {
+	magicChars := `*?[`
+	if runtime.GOOS != "windows" {
+		magicChars = `*?[\`
+	}
+	return strings.ContainsAny(path, magicChars)
+}
+
+/*
+expandGlob returns the paths matching the passed pattern.  Patterns without a
+"**" element are passed to filepath.Glob.  Otherwise the directory named by the
+elements before the first one with meta characters is walked, and each path
+beneath it, except for directories, is matched element-wise against the
+pattern.
+*/
+func expandGlob(pattern string) ([]string, error) {
+	elems := strings.Split(filepath.ToSlash(pattern), "/")
+	if !slices.Contains(elems, "**") {
+		return filepath.Glob(pattern)
+	}
+	// Validate pattern syntax upfront, like filepath.Glob does
+	for _, elem := range elems {
+		if _, err := filepath.Match(elem, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	i := slices.IndexFunc(elems, hasGlobMeta)
+	root := filepath.FromSlash(strings.Join(elems[:i], "/"))
+	if root == "" && i > 0 {
+		// Absolute pattern, e.g. "/**/*.go"
+		root = string(filepath.Separator)
+	} else if root == "" {
+		root = "."
+	}
+	if _, err := os.Stat(root); err != nil {
+		// Like filepath.Glob, treat a missing directory as no matches
+		return nil, nil
+	}
+
+	var matches []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." || info.IsDir() {
+			return nil
+		}
+		if matchGlobElems(elems[i:], strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// matchGlobElems matches path elements against pattern elements, where "**" matches zero or more elements.
+func matchGlobElems(pattern []string, elems []string) bool {
+	if len(pattern) == 0 {
+		return len(elems) == 0
+	}
+	if pattern[0] == "**" {
+		for j := 0; j <= len(elems); j++ {
+			if matchGlobElems(pattern[1:], elems[j:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	// Syntax errors were ruled out before walking
+	ok, _ := filepath.Match(pattern[0], elems[0])
+	return ok && matchGlobElems(pattern[1:], elems[1:])
+}
+
+/*
+artifactFile associates the key under which an artifact is recorded with the
+path of the file whose contents are hashed for it.  The two differ for
+symlinks, which are recorded under their own path but hashed via their target.
+*/
+type artifactFile struct {
+	key  string
+	path string
+	// symlink is set for symlinks that are recorded as entries of their own,
+	// in which case linkTarget holds the target they point to
+	symlink    bool
+	linkTarget string
+	// dir is set for empty directories, which are recorded with a sentinel
+	// instead of hashes
+	dir bool
+}
+
+/*
+hash returns the hashes recorded for the artifact.  Symlinks that are recorded
+as entries of their own are hashed via their slash separated target path, empty
+directories are recorded with the ArtifactDirKey sentinel, and all other
+artifacts are hashed via the contents of the file at path.
 */
-func recordArtifacts(paths []string, hashAlgorithms []string, gitignorePatterns []string, lStripPaths []string, lineNormalization bool, followSymlinkDirs bool) (map[string]HashObj, error) {
-	artifacts := make(map[string]HashObj)
-	for _, path := range paths {
-		err := filepath.Walk(path,
+func (f artifactFile) hash(hashAlgorithms []string, endings lineEndings) (HashObj, error) {
+	if f.dir {
+		return HashObj{ArtifactDirKey: ArtifactDirValue}, nil
+	}
+	if f.symlink {
+		return hashReader(strings.NewReader(filepath.ToSlash(f.linkTarget)), hashAlgorithms, rawLineEndings)
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return hashReader(file, hashAlgorithms, endings)
+}
+
+/*
+artifactFiles is an ordered collection of artifactFile entries with unique
+keys.  Setting an existing key replaces the entry in place, so that the walk
+order is retained.
+*/
+type artifactFiles struct {
+	files []artifactFile
+	index map[string]int
+}
+
+func newArtifactFiles() *artifactFiles {
+	return &artifactFiles{index: make(map[string]int)}
+}
+
+func (a *artifactFiles) has(key string) bool {
+	_, ok := a.index[key]
+	return ok
+}
+
+func (a *artifactFiles) set(key string, path string) {
+	if i, ok := a.index[key]; ok {
+		a.files[i].path = path
+		return
+	}
+	a.index[key] = len(a.files)
+	a.files = append(a.files, artifactFile{key: key, path: path})
+}
+
+func (a *artifactFiles) setSymlink(key string, linkTarget string) {
+	a.set(key, key)
+	i := a.index[key]
+	a.files[i].symlink = true
+	a.files[i].linkTarget = linkTarget
+}
+
+func (a *artifactFiles) setDir(key string, path string) {
+	a.set(key, path)
+	a.files[a.index[key]].dir = true
+}
+
+/*
+recordArtifacts walks through the passed slice of paths, traversing
+subdirectories, and collects every file that should be recorded, together
+with the key it is recorded under.  Followed symlinks are added to the passed
+visitedSymlinks set, in order to detect symlink cycles.  The files are returned in walk order.
+If walking a path fails the first return value is nil and the second return
+value is the error.  If pathErrs is not nil, paths that cannot be accessed or
+resolved are skipped instead and their errors are added to pathErrs.  If
+recordEmptyDirs is set, directories without entries are collected as well.
+*/
+func recordArtifacts(ctx context.Context, paths []string, visitedSymlinks Set, symlinkChain []string, gitignorePatterns []string, followSymlinkDirs bool, recordSymlinks bool, recordFileSymlinks bool, maxSymlinkDepth int, useGitignoreFiles bool, recordEmptyDirs bool, pathErrs map[string]error) ([]artifactFile, error) {
+	artifacts := newArtifactFiles()
+	for _, root := range paths {
+		// Patterns read from .gitignore files beneath the current root, in the
+		// order in which the files were found
+		var filePatterns []string
+		err := filepath.Walk(root,
 			func(path string, info os.FileInfo, err error) error {
 				// Abort if Walk function has a problem,
-				// e.g. path does not exist
+				// e.g. path does not exist, or skip the path if
+				// errors are collected
 				if err != nil {
+					if pathErrs != nil {
+						pathErrs[path] = err
+						return nil
+					}
+					return err
+				}
+				// Stop walking as soon as the caller gives up
+				if err := ctx.Err(); err != nil {
 					return err
 				}
 				// We need to call pathspec.GitIgnore inside of our filepath.Walk, because otherwise
 				// we will not catch all paths. Just imagine a path like "." and a pattern like "*.pub".
 				// If we would call pathspec outside of the filepath.Walk this would not match.
-				ignore, err := pathspec.GitIgnore(gitignorePatterns, path)
+				// Patterns are always matched against slash separated paths, so that
+				// they behave the same on Windows.
+				ignore, err := pathspec.GitIgnore(gitignorePatterns, filepath.ToSlash(path))
 				if err != nil {
 					return err
 				}
+				if !ignore && useGitignoreFiles {
+					// Patterns of .gitignore files are relative to the walked root
+					relPath, err := filepath.Rel(root, path)
+					if err != nil {
+						return err
+					}
+					ignore, err = pathspec.GitIgnore(filePatterns, filepath.ToSlash(relPath))
+					if err != nil {
+						return err
+					}
+					if !ignore && info.IsDir() {
+						patterns, err := readGitignoreFile(path, filepath.ToSlash(relPath))
+						if err != nil {
+							return err
+						}
+						filePatterns = append(filePatterns, patterns...)
+					}
+				}
 				if ignore {
+					// Prune excluded directories, so that nothing beneath them
+					// is recorded. Excluded symlinks are skipped here, before
+					// they are evaluated for symlink cycles.
+					if info.IsDir() {
+						return filepath.SkipDir
+					}
 					return nil
 				}
-				// Don't hash directories
+				// Don't hash directories, but record empty ones if requested
 				if info.IsDir() {
+					if !recordEmptyDirs {
+						return nil
+					}
+					entries, err := os.ReadDir(path)
+					if err != nil {
+						if pathErrs != nil {
+							pathErrs[path] = err
+							return nil
+						}
+						return err
+					}
+					if len(entries) == 0 {
+						artifacts.setDir(path, path)
+					}
 					return nil
 				}
 
@@ -160,20 +923,58 @@ func recordArtifacts(paths []string, hashAlgorithms []string, gitignorePatterns
 				// iterations. infoMode()&os.ModeSymlink uses the file
 				// type bitmask to check for a symlink.
 				if info.Mode()&os.ModeSymlink == os.ModeSymlink {
+					// Record the symlink itself instead of following it,
+					// so that a swapped link target is detected
+					recordSymlink := func() error {
+						if artifacts.has(path) {
+							return fmt.Errorf("non unique dictionary key: %s", path)
+						}
+						linkTarget, err := os.Readlink(path)
+						if err != nil {
+							return err
+						}
+						artifacts.setSymlink(path, linkTarget)
+						return nil
+					}
+					if recordSymlinks {
+						return recordSymlink()
+					}
 					// return with error if we detect a symlink cycle
 					if ok := visitedSymlinks.Has(path); ok {
 						// this error will get passed through
-						// to RecordArtifacts()
-						return ErrSymCycle
+						// to RecordArtifacts(), reporting the symlinks
+						// followed since path was first reached, if it was
+						// reached via this chain
+						cycle := append(slices.Clone(symlinkChain), path)
+						if i := slices.Index(symlinkChain, path); i >= 0 {
+							cycle = cycle[i:]
+						}
+						return &SymCycleError{Paths: cycle}
 					}
 					evalSym, err := filepath.EvalSymlinks(path)
 					if err != nil {
+						// A dangling or cyclic link does not point to a
+						// directory, so it is a file symlink
+						if recordFileSymlinks {
+							return recordSymlink()
+						}
+						if pathErrs != nil {
+							pathErrs[path] = err
+							return nil
+						}
 						return err
 					}
 					info, err := os.Stat(evalSym)
 					if err != nil {
+						if pathErrs != nil {
+							pathErrs[path] = err
+							return nil
+						}
 						return err
 					}
+					if !info.IsDir() && recordFileSymlinks {
+						return recordSymlink()
+					}
 					targetIsDir := false
 					if info.IsDir() {
 						if !followSymlinkDirs {
@@ -182,44 +983,41 @@ func recordArtifacts(paths []string, hashAlgorithms []string, gitignorePatterns
 						}
 						targetIsDir = true
 					}
+					// Symlinks followed to get here plus the ones in the
+					// chain starting at path
+					if depth := len(symlinkChain) + countSymlinkHops(path, maxSymlinkDepth); depth > maxSymlinkDepth {
+						return fmt.Errorf("%w: %s is reached via more than %d symlinks", ErrSymDepthExceeded, path, maxSymlinkDepth)
+					}
 					// add symlink to visitedSymlinks set
 					// this way, we know which link we have visited already
 					// if we visit a symlink twice, we have detected a symlink cycle
 					visitedSymlinks.Add(path)
 					// We recursively call recordArtifacts() to follow
 					// the new path.
-					evalArtifacts, evalErr := recordArtifacts([]string{evalSym}, hashAlgorithms, gitignorePatterns, lStripPaths, lineNormalization, followSymlinkDirs)
+					evalArtifacts, evalErr := recordArtifacts(ctx, []string{evalSym}, visitedSymlinks, append(slices.Clone(symlinkChain), path), gitignorePatterns, followSymlinkDirs, recordSymlinks, recordFileSymlinks, maxSymlinkDepth, useGitignoreFiles, recordEmptyDirs, pathErrs)
 					if evalErr != nil {
 						return evalErr
 					}
-					for key, value := range evalArtifacts {
+					for _, evalArtifact := range evalArtifacts {
 						if targetIsDir {
-							symlinkPath := filepath.Join(path, strings.TrimPrefix(key, evalSym))
-							artifacts[symlinkPath] = value
+							symlinkPath := filepath.Join(path, strings.TrimPrefix(evalArtifact.key, evalSym))
+							if evalArtifact.dir {
+								artifacts.setDir(symlinkPath, evalArtifact.path)
+								continue
+							}
+							artifacts.set(symlinkPath, evalArtifact.path)
 						} else {
-							artifacts[path] = value
+							artifacts.set(path, evalArtifact.path)
 						}
 					}
 					return nil
 				}
-				artifact, err := RecordArtifact(path, hashAlgorithms, lineNormalization)
-				// Abort if artifact can't be recorded, e.g.
-				// due to file permissions
-				if err != nil {
-					return err
-				}
 
-				for _, strip := range lStripPaths {
-					if strings.HasPrefix(path, strip) {
-						path = strings.TrimPrefix(path, strip)
-						break
-					}
-				}
 				// Check if path is unique
-				if _, exists := artifacts[path]; exists {
-					return fmt.Errorf("left stripping has resulted in non unique dictionary key: %s", path)
+				if artifacts.has(path) {
+					return fmt.Errorf("non unique dictionary key: %s", path)
 				}
-				artifacts[path] = artifact
+				artifacts.set(path, path)
 				return nil
 			})
 
@@ -228,12 +1026,118 @@ func recordArtifacts(paths []string, hashAlgorithms []string, gitignorePatterns
 		}
 	}
 
-	return artifacts, nil
+	return artifacts.files, nil
+}
+
+/*
+countSymlinkHops returns the number of symlinks in the chain of symlinks to
+symlinks that starts at the passed path, counting at most limit+1 of them.
+*/
+func countSymlinkHops(path string, limit int) int {
+	hops := 0
+	for hops <= limit {
+		info, err := os.Lstat(path)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			break
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			break
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+		hops++
+	}
+	return hops
+}
+
+/*
+hashArtifacts calls RecordArtifact for each of the passed files using a pool of
+up to concurrency workers, and returns the resulting hashes in the order of the
+passed files.  Once a file fails to be hashed, no files that come after it are
+started anymore.  The returned error is the one of the first failing file in
+the passed order, which keeps the result independent of scheduling.  If the
+passed context is done, no further files are started and the context error is
+returned once the files that are being hashed are finished.
+
+If pathErrs is not nil, all files are hashed regardless of failures, the
+errors are added to pathErrs, keyed by the artifact paths, and the hashes of
+the failed files are nil.
+*/
+func hashArtifacts(ctx context.Context, files []artifactFile, hashAlgorithms []string, endings lineEndings, concurrency int, pathErrs map[string]error) ([]HashObj, error) {
+	if concurrency < 1 {
+		concurrency = runtime.NumCPU()
+	}
+
+	hashes := make([]HashObj, len(files))
+	errs := make([]error, len(files))
+
+	// firstFailed holds the lowest index of a file that failed to be hashed.
+	// Files before that index are always hashed, so that the returned error
+	// is deterministic, files after that index are skipped.
+	var firstFailed atomic.Int64
+	firstFailed.Store(int64(len(files)))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if int64(i) > firstFailed.Load() || ctx.Err() != nil {
+					continue
+				}
+				hashes[i], errs[i] = files[i].hash(hashAlgorithms, endings)
+				if errs[i] == nil || pathErrs != nil {
+					continue
+				}
+				for {
+					failed := firstFailed.Load()
+					if int64(i) >= failed || firstFailed.CompareAndSwap(failed, int64(i)) {
+						break
+					}
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := range files {
+		if int64(i) > firstFailed.Load() {
+			break
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if pathErrs == nil {
+			return nil, err
+		}
+		hashes[i] = nil
+		pathErrs[files[i].key] = err
+	}
+	return hashes, nil
 }
 
 /*
 waitErrToExitCode converts an error returned by Cmd.wait() to an exit code.  It
-returns -1 if no exit code can be inferred.
+returns the negative signal number if the program was killed by a signal, and
+-1 if no exit code can be inferred.
 */
 func waitErrToExitCode(err error) int {
 	// If there's no exit code, we return -1
@@ -249,6 +1153,12 @@ func waitErrToExitCode(err error) int {
 			// an ExitStatus() method with the same signature.
 			if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
 				retVal = status.ExitStatus()
+				// A program that was killed by a signal has no exit code,
+				// we return the negative signal number instead, e.g. -9
+				// for SIGKILL, like Python's subprocess module does.
+				if status.Signaled() {
+					retVal = -int(status.Signal())
+				}
 			}
 		}
 	} else {
@@ -269,58 +1179,289 @@ and returns stdout, stderr and exit code.  The format of the returned map is:
 		"stderr": "<standard error>"
 	}
 
-If the command cannot be executed or no pipes for stdout or stderr can be
-created the first return value is nil and the second return value is the error.
+Use RunCommandWithOptions to limit the number of bytes that are captured of
+each of stdout and stderr.
+
+If the command cannot be executed the first return value is nil and the second
+return value is the error.
 NOTE: Since stdout and stderr are captured, they cannot be seen during the
 command execution.
 */
 func RunCommand(cmdArgs []string, runDir string) (map[string]interface{}, error) {
+	return RunCommandCtx(context.Background(), cmdArgs, runDir)
+}
+
+/*
+RunCommandCtx provides the same functionality as RunCommand, but kills the
+command, including any processes it started, once the passed context is
+cancelled or its deadline expires.  In that case the returned map contains the
+stdout and stderr captured until the command was killed, the return value of
+the killed command, e.g. -9 for SIGKILL on Unix, and an additional entry
+"terminated" set to true.  The returned error wraps ErrCommandTerminated as well
+as the context error.  A command that exits on its own before it is killed is
+not terminated, even if the context is done before its output is complete, and
+its byproducts contain its actual return value.
+*/
+func RunCommandCtx(ctx context.Context, cmdArgs []string, runDir string) (map[string]interface{}, error) {
+	return RunCommandWithOptions(ctx, cmdArgs, runDir, RunCommandOptions{})
+}
+
+/*
+RunCommandContext is an alias of RunCommandCtx, which is kept for callers that
+use this name.
+*/
+func RunCommandContext(ctx context.Context, cmdArgs []string, runDir string) (map[string]interface{}, error) {
+	return RunCommandCtx(ctx, cmdArgs, runDir)
+}
+
+/*
+RunCommandOptions configures how RunCommandWithOptions handles the output of a
+command.  If Stdout or Stderr are set, the standard output or standard error
+of the command is copied to them while the command runs, e.g. to os.Stdout and
+os.Stderr to show the output in interactive logs, in addition to being
+captured for the byproducts.  MaxByproductSize limits the number of bytes that
+are captured for each of stdout and stderr, so that chatty commands do not
+exhaust memory or bloat link metadata, e.g. DefaultMaxByproductSize.  Zero or
+a negative value means no limit.  Output beyond the limit is still copied to
+Stdout and Stderr.
+
+If ReturnValueOnly is set, stdout and stderr are not captured at all, e.g.
+because they may contain sensitive data, and the byproducts only contain the
+"return-value".  The output is then copied to Stdout and Stderr, which default
+to os.Stdout and os.Stderr, so that it can still be seen while the command runs.
+*/
+type RunCommandOptions struct {
+	Stdout           io.Writer
+	Stderr           io.Writer
+	MaxByproductSize int
+	ReturnValueOnly  bool
+}
+
+/*
+RunCommandWithOptions provides the same functionality as RunCommandCtx, but
+handles the output of the command as configured by the passed options.  If
+captured output is truncated because it exceeds opts.MaxByproductSize, the
+first opts.MaxByproductSize bytes of the output are followed by
+ByproductTruncationMarker, and the returned map contains the additional
+entries "stdout-truncated" or "stderr-truncated" set to true, and
+"stdout-length" or "stderr-length" set to the original length of the output in
+bytes.  Truncation does not affect the return value of the command.
+*/
+func RunCommandWithOptions(ctx context.Context, cmdArgs []string, runDir string, opts RunCommandOptions) (map[string]interface{}, error) {
 	if len(cmdArgs) == 0 {
 		return nil, ErrEmptyCommandArgs
 	}
 
-	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	cmd := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
 
 	if runDir != "" {
 		cmd.Dir = runDir
 	}
 
-	stderrPipe, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, err
+	// Run the command in its own process group, so that cancellation also
+	// terminates processes started by the command
+	setProcessGroup(cmd)
+	// Remember whether the command was killed because of the context, as it
+	// may also have exited on its own just before the context was done
+	var killed atomic.Bool
+	cmd.Cancel = func() error {
+		err := killProcessGroup(cmd)
+		if err == nil {
+			killed.Store(true)
+		}
+		return err
 	}
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
+	// Don't wait forever for output of processes that escaped the process group
+	cmd.WaitDelay = commandWaitDelay
+
+	stdout := &cappedBuffer{max: opts.MaxByproductSize}
+	stderr := &cappedBuffer{max: opts.MaxByproductSize}
+	if opts.ReturnValueOnly {
+		cmd.Stdout = opts.Stdout
+		if cmd.Stdout == nil {
+			cmd.Stdout = os.Stdout
+		}
+		cmd.Stderr = opts.Stderr
+		if cmd.Stderr == nil {
+			cmd.Stderr = os.Stderr
+		}
+	} else {
+		cmd.Stdout = teeWriter(stdout, opts.Stdout)
+		cmd.Stderr = teeWriter(stderr, opts.Stderr)
 	}
 
 	if err := cmd.Start(); err != nil {
 		return nil, err
 	}
 
-	// TODO: duplicate stdout, stderr
-	stdout, _ := io.ReadAll(stdoutPipe)
-	stderr, _ := io.ReadAll(stderrPipe)
-
-	retVal := waitErrToExitCode(cmd.Wait())
+	waitErr := cmd.Wait()
+	// Wait returns the context error instead of nil, if the command was
+	// cancelled but exited successfully nonetheless
+	if _, ok := waitErr.(*exec.ExitError); !ok && cmd.ProcessState != nil && cmd.ProcessState.Success() {
+		waitErr = nil
+	}
+	retVal := waitErrToExitCode(waitErr)
+	terminated := killed.Load() && cmd.ProcessState != nil && killedByProcessGroupKill(cmd.ProcessState)
 
-	return map[string]interface{}{
+	byProducts := map[string]interface{}{
 		"return-value": float64(retVal),
-		"stdout":       string(stdout),
-		"stderr":       string(stderr),
-	}, nil
+	}
+	if !opts.ReturnValueOnly {
+		byProducts["stdout"] = stdout.String()
+		byProducts["stderr"] = stderr.String()
+	}
+	if stdout.truncated {
+		byProducts["stdout"] = stdout.String() + ByproductTruncationMarker
+		byProducts["stdout-truncated"] = true
+		byProducts["stdout-length"] = float64(stdout.written)
+	}
+	if stderr.truncated {
+		byProducts["stderr"] = stderr.String() + ByproductTruncationMarker
+		byProducts["stderr-truncated"] = true
+		byProducts["stderr-length"] = float64(stderr.written)
+	}
+
+	if terminated {
+		byProducts["terminated"] = true
+		return byProducts, fmt.Errorf("%w: %w", ErrCommandTerminated, ctx.Err())
+	}
+
+	return byProducts, nil
+}
+
+/*
+teeWriter returns a writer that writes to the passed capture buffer and, if it
+is not nil, to the passed writer.
+*/
+func teeWriter(capture io.Writer, w io.Writer) io.Writer {
+	if w == nil {
+		return capture
+	}
+	return io.MultiWriter(capture, w)
+}
+
+/*
+cappedBuffer is an io.Writer that captures at most max bytes, or everything if
+max is smaller than one.  Writes beyond the limit are discarded and recorded
+as truncation, but never fail, so that the command is not interrupted.  The
+total number of bytes written, including discarded ones, is counted in written.
+*/
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+	written   int
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	c.written += len(p)
+	if c.max < 1 {
+		return c.buf.Write(p)
+	}
+	if remaining := c.max - c.buf.Len(); remaining < len(p) {
+		c.truncated = true
+		c.buf.Write(p[:max(remaining, 0)])
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
+
+func (c *cappedBuffer) String() string {
+	return c.buf.String()
 }
 
 /*
 InTotoRun executes commands, e.g. for software supply chain steps or
 inspections of an in-toto layout, and creates and returns corresponding link
 metadata.  Link metadata contains recorded products at the passed productPaths
-and materials at the passed materialPaths.  The returned link is wrapped in a
+and materials at the passed materialPaths.  If runDir is not empty, the command
+is executed in runDir, e.g. a subdirectory of a monorepo, without changing the
+working directory of the process.  materialPaths and productPaths remain
+relative to the current working directory and may contain glob patterns, e.g.
+"dist/*.tar.gz", which are expanded with ExpandGlobs.  The returned link is wrapped in a
 Metablock object.  If command execution or artifact recording fails the first
 return value is an empty Metablock and the second return value is the error.
+
+If the passed key is the zero Key, the link is recorded without being signed,
+e.g. to inspect the recorded materials, products and byproducts in a dry run.
+The returned Metablock then has an empty signatures field, or the returned
+Envelope no signatures, and cannot be verified against any layout.
 */
 func InTotoRun(name string, runDir string, materialPaths []string, productPaths []string, cmdArgs []string, key Key, hashAlgorithms []string, gitignorePatterns []string, lStripPaths []string, lineNormalization bool, followSymlinkDirs bool, useDSSE bool) (Metadata, error) {
-	materials, err := RecordArtifacts(materialPaths, hashAlgorithms, gitignorePatterns, lStripPaths, lineNormalization, followSymlinkDirs)
+	return InTotoRunWithEnv(name, runDir, materialPaths, productPaths, cmdArgs, key, hashAlgorithms, gitignorePatterns, lStripPaths, lineNormalization, followSymlinkDirs, useDSSE, nil)
+}
+
+/*
+InTotoRunWithEnv provides the same functionality as InTotoRun, but
+additionally records the values of the environment variables named in
+envAllowlist in the Environment field of the created link.  The format of the
+Environment field is:
+
+	{
+		"variables": {
+			"<name>": "<value>",
+			...
+		}
+	}
+
+Only variables named in envAllowlist are read, so that secrets are not leaked
+into link metadata by accident.  Variables that are not set are omitted.  If
+envAllowlist is empty, the Environment field remains empty.
+*/
+func InTotoRunWithEnv(name string, runDir string, materialPaths []string, productPaths []string, cmdArgs []string, key Key, hashAlgorithms []string, gitignorePatterns []string, lStripPaths []string, lineNormalization bool, followSymlinkDirs bool, useDSSE bool, envAllowlist []string) (Metadata, error) {
+	return InTotoRunWithOptions(name, runDir, materialPaths, productPaths, cmdArgs, key, InTotoRunOptions{
+		RecordArtifactsOptions: RecordArtifactsOptions{
+			HashAlgorithms:    hashAlgorithms,
+			GitignorePatterns: gitignorePatterns,
+			LStripPaths:       lStripPaths,
+			LineNormalization: lineNormalization,
+			FollowSymlinkDirs: followSymlinkDirs,
+		},
+		UseDSSE:      useDSSE,
+		EnvAllowlist: envAllowlist,
+	})
+}
+
+/*
+InTotoRunOptions configures InTotoRunWithOptions.  The embedded
+RecordArtifactsOptions are used to record materials and products, the embedded
+RunCommandOptions to run the command.  UseDSSE and EnvAllowlist have the same
+meaning as the corresponding InTotoRunWithEnv parameters.  If Signer is set,
+e.g. to a Signer returned by NewSSHAgentSigner, the link is signed with it
+instead of with a key, which must then be the zero Key.
+*/
+type InTotoRunOptions struct {
+	RecordArtifactsOptions
+	RunCommandOptions
+	UseDSSE      bool
+	EnvAllowlist []string
+	Signer       Signer
+}
+
+/*
+InTotoRunWithOptions provides the same functionality as InTotoRunWithEnv, but
+is configured with the passed options, which additionally allow to stream the
+output of the command and to limit the size of the captured output.
+*/
+func InTotoRunWithOptions(name string, runDir string, materialPaths []string, productPaths []string, cmdArgs []string, key Key, opts InTotoRunOptions) (Metadata, error) {
+	return InTotoRunWithOptionsCtx(context.Background(), name, runDir, materialPaths, productPaths, cmdArgs, key, opts)
+}
+
+/*
+InTotoRunWithOptionsCtx provides the same functionality as
+InTotoRunWithOptions, but stops recording artifacts and kills the command once
+the passed context is done, see RecordArtifactsWithOptionsCtx and
+RunCommandCtx.
+*/
+func InTotoRunWithOptionsCtx(ctx context.Context, name string, runDir string, materialPaths []string, productPaths []string, cmdArgs []string, key Key, opts InTotoRunOptions) (Metadata, error) {
+	if opts.Signer != nil && !reflect.ValueOf(key).IsZero() {
+		return nil, errors.New("either a key or a signer must be passed, not both")
+	}
+	materialPaths, err := ExpandGlobs(materialPaths)
+	if err != nil {
+		return nil, err
+	}
+	materials, err := RecordArtifactsWithOptionsCtx(ctx, materialPaths, opts.RecordArtifactsOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -328,13 +1469,18 @@ func InTotoRun(name string, runDir string, materialPaths []string, productPaths
 	// make sure that we only run RunCommand if cmdArgs is not nil or empty
 	byProducts := map[string]interface{}{}
 	if len(cmdArgs) != 0 {
-		byProducts, err = RunCommand(cmdArgs, runDir)
+		byProducts, err = RunCommandWithOptions(ctx, cmdArgs, runDir, opts.RunCommandOptions)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	products, err := RecordArtifacts(productPaths, hashAlgorithms, gitignorePatterns, lStripPaths, lineNormalization, followSymlinkDirs)
+	// Products are expanded after the command ran, which may have created them
+	productPaths, err = ExpandGlobs(productPaths)
+	if err != nil {
+		return nil, err
+	}
+	products, err := RecordArtifactsWithOptionsCtx(ctx, productPaths, opts.RecordArtifactsOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -346,16 +1492,20 @@ func InTotoRun(name string, runDir string, materialPaths []string, productPaths
 		Products:    products,
 		ByProducts:  byProducts,
 		Command:     cmdArgs,
-		Environment: map[string]interface{}{},
+		Environment: recordEnvironment(opts.EnvAllowlist),
 	}
 
-	if useDSSE {
+	if opts.UseDSSE {
 		env := &Envelope{}
 		if err := env.SetPayload(link); err != nil {
 			return nil, err
 		}
 
-		if !reflect.ValueOf(key).IsZero() {
+		if opts.Signer != nil {
+			if err := env.SignWith(opts.Signer); err != nil {
+				return nil, err
+			}
+		} else if !reflect.ValueOf(key).IsZero() {
 			if err := env.Sign(key); err != nil {
 				return nil, err
 			}
@@ -365,7 +1515,11 @@ func InTotoRun(name string, runDir string, materialPaths []string, productPaths
 	}
 
 	linkMb := &Metablock{Signed: link, Signatures: []Signature{}}
-	if !reflect.ValueOf(key).IsZero() {
+	if opts.Signer != nil {
+		if err := linkMb.SignWith(opts.Signer); err != nil {
+			return nil, err
+		}
+	} else if !reflect.ValueOf(key).IsZero() {
 		if err := linkMb.Sign(key); err != nil {
 			return nil, err
 		}
@@ -374,6 +1528,27 @@ func InTotoRun(name string, runDir string, materialPaths []string, productPaths
 	return linkMb, nil
 }
 
+/*
+recordEnvironment returns the Environment field of a link, which contains the
+values of the set environment variables named in envAllowlist.  See
+InTotoRunWithEnv for the format.
+*/
+func recordEnvironment(envAllowlist []string) map[string]interface{} {
+	environment := map[string]interface{}{}
+	if len(envAllowlist) == 0 {
+		return environment
+	}
+
+	variables := map[string]interface{}{}
+	for _, name := range envAllowlist {
+		if value, ok := os.LookupEnv(name); ok {
+			variables[name] = value
+		}
+	}
+	environment["variables"] = variables
+	return environment
+}
+
 /*
 InTotoRecordStart begins the creation of a link metablock file in two steps,
 in order to provide evidence for supply chain steps that cannot be carries out
@@ -427,11 +1602,20 @@ InTotoRecordStop ends the creation of a metatadata link file created by
 InTotoRecordStart. InTotoRecordStop takes in a signed unfinished link metablock
 created by InTotoRecordStart and records the hashes of any products creted by
 commands run between InTotoRecordStart and InTotoRecordStop.  The resultant
-finished link metablock is then signed by the provided key and returned.
+finished link metablock is then signed by the provided key and returned.  As
+with InTotoRun, the zero Key records an unsigned link.  Since the unfinished
+link cannot be verified then, it must be unsigned as well, i.e. created by
+InTotoRecordStart with the zero Key, otherwise an error wrapping
+ErrPrelimLinkUnverified is returned, rather than dropping its signatures
+unchecked.
 */
 func InTotoRecordStop(prelimLinkEnv Metadata, productPaths []string, key Key, hashAlgorithms, gitignorePatterns []string, lStripPaths []string, lineNormalization bool, followSymlinkDirs bool, useDSSE bool) (Metadata, error) {
-	if err := prelimLinkEnv.VerifySignature(key); err != nil {
-		return nil, err
+	if !reflect.ValueOf(key).IsZero() {
+		if err := prelimLinkEnv.VerifySignature(key); err != nil {
+			return nil, err
+		}
+	} else if sigs := prelimLinkEnv.Sigs(); len(sigs) > 0 {
+		return nil, fmt.Errorf("%w: signed by '%s'", ErrPrelimLinkUnverified, sigs[0].KeyID)
 	}
 
 	link, ok := prelimLinkEnv.GetPayload().(Link)
@@ -1,16 +1,24 @@
 package in_toto
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 	"github.com/stretchr/testify/assert"
@@ -44,6 +52,14 @@ func TestRecordArtifact(t *testing.T) {
 	if !errors.Is(err, ErrUnsupportedHashAlgorithm) {
 		t.Errorf("RecordArtifact returned '(%s, %s)', expected '(nil, %s)'", result, err, ErrUnsupportedHashAlgorithm)
 	}
+
+	// Test BLAKE2b digests, as computed by "b2sum -l 256" and "b2sum"
+	result, err = RecordArtifact("foo.tar.gz", []string{"blake2b-256", "blake2b-512"}, testOSisWindows())
+	assert.Nil(t, err)
+	assert.Equal(t, HashObj{
+		"blake2b-256": "79952b04d721e6b940c54a3ab00c1dca75847b9eb125732d8b557740d8a309fb",
+		"blake2b-512": "7b000ead6d3e223c8c6fec960cd5f0fb36d81e256da8801b430bf17714342814b6680071eda0a7a0fa7db0dbfc09adc63f09a2eff4dd76b35771cee3f897cbc4",
+	}, result)
 }
 
 // copy helper function for building more complex test cases
@@ -177,6 +193,16 @@ func TestIndirectSymlinkCycles(t *testing.T) {
 	if !errors.Is(err, ErrSymCycle) {
 		t.Errorf("we expected: %s, we got: %s", ErrSymCycle, err)
 	}
+	// The error reports the cycle of both links
+	var cycleErr *SymCycleError
+	if errors.As(err, &cycleErr) {
+		assert.GreaterOrEqual(t, len(cycleErr.Paths), 3)
+		assert.Equal(t, cycleErr.Paths[0], cycleErr.Paths[len(cycleErr.Paths)-1])
+		assert.Contains(t, err.Error(), "linkToA.sym")
+		assert.Contains(t, err.Error(), "linkToB.sym")
+	} else {
+		t.Errorf("expected SymCycleError, got: %s", err)
+	}
 
 	// make sure to clean up everything
 	if err := os.Remove("symTestA/linkToB.sym"); err != nil {
@@ -324,6 +350,27 @@ func TestRecordArtifacts(t *testing.T) {
 		t.Errorf("RecordArtifacts returned '(%s, %s)', expected '(nil, %s)'",
 			result, err, os.ErrNotExist)
 	}
+
+	// Test unsupported hash algorithm is reported before walking paths
+	result, err = RecordArtifacts([]string{"file-does-not-exist"}, []string{"sha256", "md5"}, nil, nil, testOSisWindows(), false)
+	if !errors.Is(err, ErrUnsupportedHashAlgorithm) {
+		t.Errorf("RecordArtifacts returned '(%s, %s)', expected '(nil, %s)'",
+			result, err, ErrUnsupportedHashAlgorithm)
+	}
+}
+
+func TestValidateHashAlgorithms(t *testing.T) {
+	assert.Nil(t, ValidateHashAlgorithms([]string{"sha256", "sha512", "sha384", "sha3_256", "sha3_512", "blake2b-256", "blake2b-512"}))
+	assert.Nil(t, ValidateHashAlgorithms(nil))
+
+	// All unknown names are reported
+	err := ValidateHashAlgorithms([]string{"sha256", "md5", "foo"})
+	assert.ErrorIs(t, err, ErrUnsupportedHashAlgorithm)
+	assert.ErrorContains(t, err, "md5, foo")
+
+	_, err = RecordArtifacts([]string{"foo.tar.gz"}, []string{"sha256", "md5", "foo"}, nil, nil, false, false)
+	assert.ErrorIs(t, err, ErrUnsupportedHashAlgorithm)
+	assert.ErrorContains(t, err, "md5, foo")
 }
 
 func TestWaitErrToExitCode(t *testing.T) {
@@ -396,6 +443,46 @@ func TestRunCommandErrors(t *testing.T) {
 	}
 }
 
+func TestRunCommandCtx(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result, err := RunCommandCtx(ctx, []string{"sh", "-c", "printf out; sleep 10"}, "")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunCommandCtx took %s, expected command to be terminated", elapsed)
+	}
+	if !errors.Is(err, ErrCommandTerminated) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RunCommandCtx returned error '%s', expected '%s'", err, ErrCommandTerminated)
+	}
+	assert.Equal(t, "out", result["stdout"])
+	assert.Equal(t, float64(-int(syscall.SIGKILL)), result["return-value"])
+	assert.Equal(t, true, result["terminated"])
+
+	// Commands that finish in time are not marked as terminated
+	result, err = RunCommandCtx(context.Background(), []string{"sh", "-c", "kill -TERM $$"}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(-int(syscall.SIGTERM)), result["return-value"])
+	assert.NotContains(t, result, "terminated")
+
+	// Commands that exit on their own before the deadline are not marked as
+	// terminated, even if their output is only complete after it
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result, err = RunCommandCtx(ctx, []string{"sh", "-c", "(sleep 1; printf late) & exit 3"}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(3), result["return-value"])
+	assert.Equal(t, "late", result["stdout"])
+	assert.NotContains(t, result, "terminated")
+
+	// RunCommandContext is an alias of RunCommandCtx
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result, err = RunCommandContext(ctx, []string{"sleep", "10"}, "")
+	assert.ErrorIs(t, err, ErrCommandTerminated)
+	assert.Equal(t, true, result["terminated"])
+}
+
 func TestInTotoRun(t *testing.T) {
 	// Successfully run InTotoRun
 	linkName := "Name"
@@ -507,7 +594,12 @@ func TestInTotoRun(t *testing.T) {
 				assert.True(t, ok, "result must be envelope")
 				assert.Equal(t, resultEnvelope.envelope, loadedResultEnvelope.envelope, fmt.Sprintf("dump and loading of signed Link failed. Loaded result: '%s', dumped result '%s'", loadedResult, result))
 			} else {
-				assert.True(t, reflect.DeepEqual(loadedResult, result), fmt.Sprintf("dump and loading of signed Link failed. Loaded result: '%s', dumped result '%s'", loadedResult, result))
+				// A loaded Metablock additionally retains the signed bytes
+				loadedResultMb, ok := loadedResult.(*Metablock)
+				assert.True(t, ok, "loaded result must be metablock")
+				resultMb, ok := result.(*Metablock)
+				assert.True(t, ok, "result must be metablock")
+				assert.True(t, reflect.DeepEqual(loadedResultMb.Signed, resultMb.Signed) && reflect.DeepEqual(loadedResultMb.Signatures, resultMb.Signatures), fmt.Sprintf("dump and loading of signed Link failed. Loaded result: '%s', dumped result '%s'", loadedResult, result))
 			}
 
 			if err := os.Remove(linkName + ".link"); err != nil {
@@ -516,7 +608,10 @@ func TestInTotoRun(t *testing.T) {
 		}
 	}
 
-	// Run InToToRun with errors
+	// Run InToToRun with errors, e.g. with a key whose keyid belongs to
+	// another key
+	mismatchedKey := validKey
+	mismatchedKey.KeyID = strings.Repeat("0", 64)
 	tablesInvalid := []struct {
 		materialPaths  []string
 		productPaths   []string
@@ -535,6 +630,7 @@ func TestInTotoRun(t *testing.T) {
 			KeyVal:              KeyVal{},
 			Scheme:              "",
 		}, []string{"sha256"}},
+		{[]string{"demo.layout"}, []string{"foo.tar.gz"}, []string{"sh", "-c", "printf out"}, mismatchedKey, []string{"sha256"}},
 	}
 
 	for _, table := range tablesInvalid {
@@ -685,6 +781,17 @@ func TestRecordArtifactWithBlobs(t *testing.T) {
 				"sha512": "bb040966a5a6aefb646909f636f7f99c9e16b684a1f0e83a87dc30c3ab4d9dec2f9b0091d8be74bbc78ba29cb0c2dd027c223579028cf9822b0bccc49d493a6d"},
 			wantErr: nil,
 		},
+		{
+			name: "test binary blob with sha3 hash algorithms",
+			args: args{
+				path:           "foo.tar.gz",
+				hashAlgorithms: []string{"sha384", "sha3_256", "sha3_512"},
+			},
+			want: HashObj{"sha384": "ce17464027a7d7c15b15032b404fc76fdbadfa1fa566d7f7747020df2542a293b3098873a98dbbda6e461f7767b8ff6c",
+				"sha3_256": "63e1308cf6bccfecc0eccebedab98d0185f7e72dc41dd3e5443626003c755c5a",
+				"sha3_512": "d34ee00c929dd6fc09b99c0804aa366e91c44e44b08a208919bd39e32ef370df3a5d526b782889e97cf74aa8cf40c20c70e8e4c679e1ef9b2ac65a9011a7636b"},
+			wantErr: nil,
+		},
 		{
 			name: "test binary blob with windows-like line breaks as byte segments",
 			args: args{
@@ -712,7 +819,6 @@ func TestRecordArtifactWithBlobs(t *testing.T) {
 }
 
 // Copy of TestRecordArtifact and TestRecordArtifactWithBlobs with lineNormalization parameter set as true.
-// Need to be changed when line normalization is properly implemented.
 func TestLineNormalizationFlag(t *testing.T) {
 	type args struct {
 		path           string
@@ -775,6 +881,24 @@ func TestLineNormalizationFlag(t *testing.T) {
 	}
 }
 
+func TestRecordArtifactsWithOptionsLineNormalization(t *testing.T) {
+	paths := []string{"line-ending-linux", "line-ending-windows", "line-ending-mixed"}
+	opts := RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}}
+
+	// Without normalization, only files with identical bytes have equal digests
+	artifacts, err := RecordArtifactsWithOptions(paths, opts)
+	assert.Nil(t, err)
+	assert.NotEqual(t, artifacts["line-ending-linux"], artifacts["line-ending-windows"])
+	assert.NotEqual(t, artifacts["line-ending-linux"], artifacts["line-ending-mixed"])
+
+	// With normalization, CRLF and lone CR hash like LF
+	opts.LineNormalization = true
+	artifacts, err = RecordArtifactsWithOptions(paths, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, artifacts["line-ending-linux"], artifacts["line-ending-windows"])
+	assert.Equal(t, artifacts["line-ending-linux"], artifacts["line-ending-mixed"])
+}
+
 func TestInTotoMatchProducts(t *testing.T) {
 	link := &Link{
 		Products: map[string]HashObj{
@@ -859,3 +983,1115 @@ func TestInTotoMatchProducts(t *testing.T) {
 		assert.Equal(t, test.expectedDiffer, differ)
 	}
 }
+
+func TestRecordArtifactsExcludePatterns(t *testing.T) {
+	filesToBeCreated := []string{
+		"excludeTest/.git/config",
+		"excludeTest/node_modules/left-pad/index.js",
+		"excludeTest/build.log",
+		"excludeTest/src/build.log",
+		"excludeTest/src/main.go",
+	}
+	for _, f := range filesToBeCreated {
+		p := filepath.FromSlash(f)
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatalf("could not create directory: %s", err)
+		}
+		if err := os.WriteFile(p, []byte("abc"), 0400); err != nil {
+			t.Fatalf("could not write file: %s", err)
+		}
+	}
+	defer func() {
+		if err := os.RemoveAll("excludeTest"); err != nil {
+			t.Errorf("could not clean up excludeTest directory: %s", err)
+		}
+	}()
+
+	result, err := RecordArtifacts([]string{"excludeTest"}, []string{"sha256"},
+		[]string{".git/**", "node_modules/**", "*.log"}, nil, testOSisWindows(), false)
+	assert.Nil(t, err)
+	expected := map[string]HashObj{
+		"excludeTest/src/main.go": {
+			"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+	}
+	assert.Equal(t, expected, result)
+
+	// Excluded directories prune their whole subtree
+	result, err = RecordArtifacts([]string{"excludeTest"}, []string{"sha256"},
+		[]string{".git", "node_modules", "*.log"}, nil, testOSisWindows(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+
+	// Excluded symlinks are skipped before they are checked for cycles
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "excludeTest"), filepath.FromSlash("excludeTest/src/cycle.sym")); err != nil {
+		t.Fatalf("could not create a symlink: %s", err)
+	}
+	result, err = RecordArtifacts([]string{"excludeTest"}, []string{"sha256"},
+		[]string{".git", "node_modules", "*.log", "*.sym"}, nil, testOSisWindows(), true)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+}
+
+func TestRecordArtifactsWithOptionsConcurrency(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		p := filepath.Join("concurrencyTest", fmt.Sprintf("dir%d", i%5), fmt.Sprintf("file%d", i))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatalf("could not create directory: %s", err)
+		}
+		if err := os.WriteFile(p, []byte(fmt.Sprintf("content %d", i)), 0400); err != nil {
+			t.Fatalf("could not write file: %s", err)
+		}
+	}
+	defer func() {
+		if err := os.RemoveAll("concurrencyTest"); err != nil {
+			t.Errorf("could not clean up concurrencyTest directory: %s", err)
+		}
+	}()
+
+	paths := []string{"concurrencyTest", "foo.tar.gz"}
+	expected, err := RecordArtifactsWithOptions(paths, RecordArtifactsOptions{
+		HashAlgorithms: []string{"sha256"},
+		Concurrency:    1,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, expected, 51)
+
+	for _, concurrency := range []int{0, 2, 8, 100} {
+		result, err := RecordArtifactsWithOptions(paths, RecordArtifactsOptions{
+			HashAlgorithms: []string{"sha256"},
+			Concurrency:    concurrency,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, expected, result, "concurrency %d", concurrency)
+	}
+}
+
+func TestRecordArtifactsWithOptionsContinueOnError(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "readable"), []byte("readable"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("does-not-exist", filepath.Join(dir, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+	failed := []string{filepath.Join(dir, "dangling"), missing}
+	// Root can read files regardless of their permissions
+	if runtime.GOOS != "windows" && os.Geteuid() != 0 {
+		unreadable := filepath.Join(dir, "unreadable")
+		if err := os.WriteFile(unreadable, []byte("unreadable"), 0000); err != nil {
+			t.Fatal(err)
+		}
+		failed = append(failed, unreadable)
+	}
+	opts := RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}, LStripPaths: []string{dir + string(filepath.Separator)}}
+
+	// Fail fast by default
+	artifacts, err := RecordArtifactsWithOptions([]string{dir, missing}, opts)
+	assert.Nil(t, artifacts)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	opts.ContinueOnError = true
+	artifacts, err = RecordArtifactsWithOptions([]string{dir, missing}, opts)
+	assert.Equal(t, map[string]HashObj{
+		"readable": {"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte("readable")))},
+	}, artifacts)
+	var recordErr *RecordArtifactsError
+	if assert.ErrorAs(t, err, &recordErr) {
+		var paths []string
+		for path := range recordErr.Errors {
+			paths = append(paths, path)
+		}
+		assert.ElementsMatch(t, failed, paths)
+	}
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.ErrorContains(t, err, fmt.Sprintf("failed to record %d artifacts", len(failed)))
+
+	// Structural errors remain fatal
+	if err := os.Symlink("readable", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("link", filepath.Join(dir, "linkToLink")); err != nil {
+		t.Fatal(err)
+	}
+	opts.MaxSymlinkDepth = 1
+	artifacts, err = RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, artifacts)
+	assert.ErrorIs(t, err, ErrSymDepthExceeded)
+	assert.False(t, errors.As(err, &recordErr))
+}
+
+func TestRecordArtifactsWithOptionsFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows does not have Unix modes")
+	}
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"script.sh": 0755, "data.sh": 0644, "setuid": 0755 | os.ModeSetuid} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte("echo foo"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		// Set the mode explicitly, as WriteFile applies the umask
+		if err := os.Chmod(p, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("script.sh", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	opts := RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}, LStripPaths: []string{dir + "/"}}
+	sha256Hex := fmt.Sprintf("%x", sha256.Sum256([]byte("echo foo")))
+
+	// Modes are not recorded by default
+	artifacts, err := RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, HashObj{"sha256": sha256Hex}, artifacts["script.sh"])
+
+	opts.RecordFileMode = true
+	artifacts, err = RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{
+		"script.sh": {"sha256": sha256Hex, ArtifactModeKey: "0755"},
+		"data.sh":   {"sha256": sha256Hex, ArtifactModeKey: "0644"},
+		"setuid":    {"sha256": sha256Hex, ArtifactModeKey: "4755"},
+		"link":      {"sha256": sha256Hex, ArtifactModeKey: "0755"},
+	}, artifacts)
+
+	// Symlinks recorded as entries of their own have no mode
+	opts.RecordSymlinks = true
+	artifacts, err = RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, HashObj{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte("script.sh")))}, artifacts["link"])
+
+	// Links recording modes pass link validation
+	assert.Nil(t, validateArtifacts(artifacts))
+}
+
+func TestRecordArtifactsWithOptionsEmptyDirs(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"empty", "nested/empty", "full"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "full", "foo"), []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}, LStripPaths: []string{dir + "/"}}
+	fooHashes := HashObj{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte("foo")))}
+
+	// Empty directories are not recorded by default
+	artifacts, err := RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{"full/foo": fooHashes}, artifacts)
+
+	// Only empty directories are recorded, non-empty ones via their files
+	opts.RecordEmptyDirs = true
+	artifacts, err = RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	dirSentinel := HashObj{ArtifactDirKey: ArtifactDirValue}
+	assert.Equal(t, map[string]HashObj{
+		"empty":        dirSentinel,
+		"nested/empty": dirSentinel,
+		"full/foo":     fooHashes,
+	}, artifacts)
+	assert.Nil(t, validateArtifacts(artifacts))
+
+	// Empty directories beneath followed symlinks are recorded via the link
+	if err := os.Symlink("nested", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	opts.FollowSymlinkDirs = true
+	artifacts, err = RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, dirSentinel, artifacts["link/empty"])
+}
+
+func TestRecordArtifactStreaming(t *testing.T) {
+	// Create a sparse file, which is much larger than the hash buffer
+	const size = 64 * 1024 * 1024
+	f, err := os.Create("sparse-file")
+	if err != nil {
+		t.Fatalf("could not create sparse file: %s", err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatalf("could not truncate sparse file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("could not close sparse file: %s", err)
+	}
+	defer func() {
+		if err := os.Remove("sparse-file"); err != nil {
+			t.Errorf("could not remove sparse file: %s", err)
+		}
+	}()
+
+	h := sha256.New()
+	zeros := make([]byte, 1024*1024)
+	for i := 0; i < size/len(zeros); i++ {
+		h.Write(zeros)
+	}
+	expected := HashObj{"sha256": fmt.Sprintf("%x", h.Sum(nil))}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	result, err := RecordArtifact("sparse-file", []string{"sha256"}, true)
+	runtime.ReadMemStats(&after)
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+	// Memory usage must not depend on the file size
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1024*1024 {
+		t.Errorf("RecordArtifact allocated %d bytes for a %d bytes file", allocated, size)
+	}
+}
+
+func TestLineNormalizerSplitWrites(t *testing.T) {
+	var buf bytes.Buffer
+	n := &lineNormalizer{w: &buf}
+	for _, chunk := range []string{"a\r", "\nb\r", "\r", "\nc\n", "\r"} {
+		if _, err := n.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, "a\nb\n\nc\n\n", buf.String())
+}
+
+func TestInTotoRunWithEnv(t *testing.T) {
+	t.Setenv("IN_TOTO_TEST_SET", "set-value")
+	if err := os.Unsetenv("IN_TOTO_TEST_UNSET"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := InTotoRunWithEnv("Name", "", []string{"alice.pub"}, []string{"foo.tar.gz"}, []string{"sh", "-c", "true"}, Key{}, []string{"sha256"}, nil, nil, testOSisWindows(), false, false,
+		[]string{"IN_TOTO_TEST_SET", "IN_TOTO_TEST_UNSET"})
+	assert.Nil(t, err)
+
+	link, ok := result.GetPayload().(Link)
+	assert.True(t, ok, "payload must be link")
+	expected := map[string]interface{}{
+		"variables": map[string]interface{}{
+			"IN_TOTO_TEST_SET": "set-value",
+		},
+	}
+	assert.Equal(t, expected, link.Environment)
+
+	// Without allowlist nothing is recorded
+	result, err = InTotoRunWithEnv("Name", "", []string{"alice.pub"}, []string{"foo.tar.gz"}, []string{"sh", "-c", "true"}, Key{}, []string{"sha256"}, nil, nil, testOSisWindows(), false, false, nil)
+	assert.Nil(t, err)
+	link, ok = result.GetPayload().(Link)
+	assert.True(t, ok, "payload must be link")
+	assert.Equal(t, map[string]interface{}{}, link.Environment)
+}
+
+func TestRecordArtifactsWithOptionsNormalizeCase(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Foo"), []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := os.Stat(filepath.Join(dir, "FOO"))
+	caseInsensitive := err == nil
+	opts := RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}, LStripPaths: []string{dir + "/"}}
+	fooHashes := HashObj{"sha256": "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"}
+
+	result, err := RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{"Foo": fooHashes}, result)
+
+	opts.NormalizeCase = true
+	result, err = RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{"foo": fooHashes}, result)
+
+	// Differently cased paths of the same file are recorded once, which a
+	// hard link emulates on case-sensitive filesystems
+	paths := []string{filepath.Join(dir, "Foo"), filepath.Join(dir, "foo")}
+	if !caseInsensitive {
+		if err := os.Link(paths[0], paths[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err = RecordArtifactsWithOptions(paths, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{"foo": fooHashes}, result)
+
+	// Distinct files whose paths only differ in case are an error
+	if caseInsensitive {
+		t.Skip("filesystem is case-insensitive")
+	}
+	dir = t.TempDir()
+	for _, name := range []string{"Bar", "bar"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts.LStripPaths = []string{dir + "/"}
+	_, err = RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.ErrorContains(t, err, "case normalization has resulted in non unique dictionary key: bar")
+}
+
+func TestRecordArtifactsConcurrentCalls(t *testing.T) {
+	// Each call tracks visited symlinks on its own, hence concurrent calls
+	// neither race nor report bogus symlink cycles
+	if err := os.Symlink("foo.tar.gz", "concurrent-calls.sym"); err != nil {
+		t.Fatalf("could not create a symlink: %s", err)
+	}
+	defer func() {
+		if err := os.Remove("concurrent-calls.sym"); err != nil {
+			t.Errorf("could not remove concurrent-calls.sym: %s", err)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = RecordArtifacts([]string{"concurrent-calls.sym"}, []string{"sha256"}, nil, nil, testOSisWindows(), false)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.Nil(t, err)
+	}
+}
+
+func BenchmarkRecordArtifacts(b *testing.B) {
+	dir := b.TempDir()
+	for i := 0; i < 5000; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("dir%d", i%50), fmt.Sprintf("file%d", i))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(fmt.Sprintf("content %d", i)), 0400); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for _, concurrency := range []int{1, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := RecordArtifactsWithOptions([]string{dir}, RecordArtifactsOptions{
+					HashAlgorithms: []string{"sha256"},
+					Concurrency:    concurrency,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordArtifactsDirectory(t *testing.T) {
+	for _, dir := range []string{"dirTest/empty", "dirTest/sub/subsub"} {
+		if err := os.MkdirAll(filepath.FromSlash(dir), 0700); err != nil {
+			t.Fatalf("could not create directory: %s", err)
+		}
+	}
+	for _, file := range []string{"dirTest/a", "dirTest/sub/b", "dirTest/sub/subsub/c"} {
+		if err := os.WriteFile(filepath.FromSlash(file), []byte("abc"), 0400); err != nil {
+			t.Fatalf("could not write file: %s", err)
+		}
+	}
+	defer func() {
+		if err := os.RemoveAll("dirTest"); err != nil {
+			t.Errorf("could not clean up dirTest directory: %s", err)
+		}
+	}()
+
+	hash := HashObj{"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"}
+	expected := map[string]HashObj{
+		"dirTest/a":            hash,
+		"dirTest/sub/b":        hash,
+		"dirTest/sub/subsub/c": hash,
+	}
+	result, err := RecordArtifacts([]string{"dirTest"}, []string{"sha256"}, nil, nil, testOSisWindows(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+
+	// Empty directories produce no entries
+	result, err = RecordArtifacts([]string{"dirTest/empty"}, []string{"sha256"}, nil, nil, testOSisWindows(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{}, result)
+}
+
+func TestRecordArtifactsLStripPaths(t *testing.T) {
+	for _, dir := range []string{"lstripTest/a", "lstripTest/b"} {
+		if err := os.MkdirAll(filepath.FromSlash(dir), 0700); err != nil {
+			t.Fatalf("could not create directory: %s", err)
+		}
+	}
+	if err := os.WriteFile(filepath.FromSlash("lstripTest/a/x"), []byte("abc"), 0400); err != nil {
+		t.Fatalf("could not write file: %s", err)
+	}
+	if err := os.WriteFile(filepath.FromSlash("lstripTest/b/x"), []byte("abc"), 0400); err != nil {
+		t.Fatalf("could not write file: %s", err)
+	}
+	if err := os.Symlink(filepath.Join("..", "..", "foo.tar.gz"), filepath.FromSlash("lstripTest/b/foo.sym")); err != nil {
+		t.Fatalf("could not create a symlink: %s", err)
+	}
+	defer func() {
+		if err := os.RemoveAll("lstripTest"); err != nil {
+			t.Errorf("could not clean up lstripTest directory: %s", err)
+		}
+	}()
+
+	// Symlinks are left-stripped just like regular files
+	result, err := RecordArtifacts([]string{"lstripTest/b"}, []string{"sha256"}, nil, []string{"lstripTest/b/"}, testOSisWindows(), false)
+	assert.Nil(t, err)
+	expected := map[string]HashObj{
+		"x": {
+			"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+		"foo.sym": {
+			"sha256": "52947cb78b91ad01fe81cd6aef42d1f6817e92b9e6936c1e5aabb7c98514f355",
+		},
+	}
+	assert.Equal(t, expected, result)
+
+	// Stripping different prefixes must not result in the same key
+	_, err = RecordArtifacts([]string{"lstripTest/a", "lstripTest/b"}, []string{"sha256"}, nil, []string{"lstripTest/a/", "lstripTest/b/"}, testOSisWindows(), false)
+	assert.ErrorContains(t, err, "non unique dictionary key: x (lstripTest/a/x and lstripTest/b/x)")
+
+	// Stripping a build directory records its files relative to it
+	buildDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(buildDir, "build"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.o", "b.o"} {
+		if err := os.WriteFile(filepath.Join(buildDir, "build", name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err = RecordArtifactsWithOptions([]string{filepath.Join(buildDir, "build")}, RecordArtifactsOptions{
+		HashAlgorithms: []string{"sha256"},
+		LStripPaths:    []string{filepath.ToSlash(buildDir) + "/build/"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{
+		"a.o": {"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte("a.o")))},
+		"b.o": {"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte("b.o")))},
+	}, result)
+}
+
+func TestRecordArtifactFromReader(t *testing.T) {
+	result, err := RecordArtifactFromReader("in-memory/abc", bytes.NewReader([]byte("abc")), []string{"sha256"})
+	assert.Nil(t, err)
+	expected := map[string]HashObj{
+		"in-memory/abc": {
+			"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+	}
+	assert.Equal(t, expected, result)
+
+	// The hashes match the ones recorded from disk
+	f, err := os.Open("foo.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	hashAlgorithms := []string{"sha256", "sha512", "sha3_256"}
+	result, err = RecordArtifactFromReader("foo.tar.gz", f, hashAlgorithms)
+	assert.Nil(t, err)
+	onDisk, err := RecordArtifacts([]string{"foo.tar.gz"}, hashAlgorithms, nil, nil, false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, onDisk, result)
+
+	// In-memory artifacts can be combined with recorded files in a Link
+	rendered, err := RecordArtifactFromReader(filepath.Join("templates", "rendered.txt"), bytes.NewReader([]byte("abc")), []string{"sha256"})
+	assert.Nil(t, err)
+	materials, err := RecordArtifacts([]string{"foo.tar.gz"}, []string{"sha256"}, nil, nil, false, false)
+	assert.Nil(t, err)
+	for name, hashes := range rendered {
+		materials[name] = hashes
+	}
+	assert.Equal(t, map[string]HashObj{
+		"foo.tar.gz": {
+			"sha256": "52947cb78b91ad01fe81cd6aef42d1f6817e92b9e6936c1e5aabb7c98514f355",
+		},
+		"templates/rendered.txt": {
+			"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+	}, materials)
+
+	_, err = RecordArtifactFromReader("abc", bytes.NewReader([]byte("abc")), []string{"md5"})
+	assert.ErrorIs(t, err, ErrUnsupportedHashAlgorithm)
+}
+
+func TestLineNormalizationSkipsBinary(t *testing.T) {
+	dir := t.TempDir()
+	binaryPath := filepath.Join(dir, "binary")
+	if err := os.WriteFile(binaryPath, []byte("this\r\nis\x00binary\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	textPath := filepath.Join(dir, "text")
+	if err := os.WriteFile(textPath, []byte("this\r\nis\r\ntext\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Like in the Python reference implementation, all files are normalized
+	normalized, err := RecordArtifact(binaryPath, []string{"sha256"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, HashObj{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte("this\nis\x00binary\n")))}, normalized)
+
+	// Unless binary files are skipped, which are then hashed as is
+	raw, err := RecordArtifact(binaryPath, []string{"sha256"}, false)
+	assert.Nil(t, err)
+	artifacts, err := RecordArtifactsWithOptions([]string{binaryPath, textPath}, RecordArtifactsOptions{
+		HashAlgorithms:          []string{"sha256"},
+		LineNormalization:       true,
+		SkipBinaryNormalization: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, raw, artifacts[filepath.ToSlash(binaryPath)])
+
+	// Text files are still normalized
+	normalized, err = RecordArtifact(textPath, []string{"sha256"}, true)
+	assert.Nil(t, err)
+	raw, err = RecordArtifact(textPath, []string{"sha256"}, false)
+	assert.Nil(t, err)
+	assert.NotEqual(t, raw, normalized)
+	assert.Equal(t, HashObj{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte("this\nis\ntext\n")))}, normalized)
+	assert.Equal(t, normalized, artifacts[filepath.ToSlash(textPath)])
+}
+
+func TestRecordArtifactsWithGitignore(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		".gitignore":             "# root\n*.log\nbuild/\n/top.tmp\ndocs/**/*.html\n",
+		"main.go":                "main",
+		"debug.log":              "log",
+		"top.tmp":                "tmp",
+		"build/out.bin":          "bin",
+		"docs/api/index.html":    "html",
+		"docs/readme.md":         "md",
+		"sub/.gitignore":         "!keep.log\n*.bak\nlocal/\n",
+		"sub/keep.log":           "keep",
+		"sub/drop.log":           "drop",
+		"sub/top.tmp":            "tmp",
+		"sub/file.bak":           "bak",
+		"sub/local/cache":        "cache",
+		"sub/deeper/other.bak":   "bak",
+		"sub/deeper/local/file":  "file",
+		"other/file.bak":         "bak",
+		"other/local/config.txt": "config",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A file named like a directory-only pattern is not excluded
+	if err := os.WriteFile(filepath.Join(dir, "sub", "deeper", "build"), []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := RecordArtifactsWithGitignore([]string{dir})
+	assert.Nil(t, err)
+
+	prefix := filepath.ToSlash(dir) + "/"
+	var recorded []string
+	for name := range result {
+		recorded = append(recorded, strings.TrimPrefix(name, prefix))
+	}
+	sort.Strings(recorded)
+	assert.Equal(t, []string{
+		".gitignore",
+		"docs/readme.md",
+		"main.go",
+		"other/file.bak",
+		"other/local/config.txt",
+		"sub/.gitignore",
+		"sub/deeper/build",
+		"sub/keep.log",
+		"sub/top.tmp",
+	}, recorded)
+	assert.Equal(t, HashObj{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte("keep")))}, result[prefix+"sub/keep.log"])
+
+	// Without the option .gitignore files are not consulted
+	result, err = RecordArtifactsWithOptions([]string{dir}, RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}})
+	assert.Nil(t, err)
+	assert.Len(t, result, len(files)+1)
+}
+
+func TestRecordArtifactsRecordSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "foo"), []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("foo", filepath.Join(dir, "foo-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(dir, "sub-link")); err != nil {
+		t.Fatal(err)
+	}
+	// A cycle is harmless, because symlinks are not followed
+	if err := os.Symlink("cycle", filepath.Join(dir, "cycle")); err != nil {
+		t.Fatal(err)
+	}
+
+	targetHash := func(target string) HashObj {
+		return HashObj{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte(target)))}
+	}
+
+	result, err := RecordArtifactsWithOptions([]string{dir}, RecordArtifactsOptions{
+		HashAlgorithms:    []string{"sha256"},
+		LStripPaths:       []string{filepath.ToSlash(dir) + "/"},
+		FollowSymlinkDirs: true,
+		RecordSymlinks:    true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{
+		"foo":      {"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		"foo-link": targetHash("foo"),
+		"sub-link": targetHash("sub"),
+		"cycle":    targetHash("cycle"),
+	}, result)
+
+	// Changing the link target changes the recorded hashes
+	if err := os.Remove(filepath.Join(dir, "foo-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(dir, "foo-link")); err != nil {
+		t.Fatal(err)
+	}
+	result, err = RecordArtifactsWithOptions([]string{filepath.Join(dir, "foo-link")}, RecordArtifactsOptions{
+		HashAlgorithms: []string{"sha256"},
+		RecordSymlinks: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, targetHash("sub"), result[filepath.ToSlash(filepath.Join(dir, "foo-link"))])
+
+	// By default symlinks are still followed
+	if err := os.Remove(filepath.Join(dir, "cycle")); err != nil {
+		t.Fatal(err)
+	}
+	result, err = RecordArtifacts([]string{dir}, []string{"sha256"}, nil, []string{filepath.ToSlash(dir) + "/"}, false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{
+		"foo": {"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	}, result)
+}
+
+func TestRecordArtifactsMaxSymlinkDepth(t *testing.T) {
+	// link1 -> link2 -> ... -> link5 -> foo
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "foo"), []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := "foo"
+	for i := 5; i > 0; i-- {
+		link := fmt.Sprintf("link%d", i)
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatal(err)
+		}
+		target = link
+	}
+
+	opts := RecordArtifactsOptions{
+		HashAlgorithms:  []string{"sha256"},
+		LStripPaths:     []string{filepath.ToSlash(dir) + "/"},
+		MaxSymlinkDepth: 3,
+	}
+	_, err := RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.ErrorIs(t, err, ErrSymDepthExceeded)
+
+	opts.MaxSymlinkDepth = 10
+	result, err := RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	assert.Len(t, result, 6)
+	assert.Equal(t, result["foo"], result["link1"])
+
+	// The default limit is well above
+	opts.MaxSymlinkDepth = 0
+	_, err = RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+}
+
+func TestRecordArtifactsRecordFileSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "foo"), []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "bar"), []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("foo", filepath.Join(dir, "foo-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub", filepath.Join(dir, "sub-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing", filepath.Join(dir, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	abcHash := HashObj{"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"}
+	targetHash := func(target string) HashObj {
+		return HashObj{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte(target)))}
+	}
+	opts := RecordArtifactsOptions{
+		HashAlgorithms:     []string{"sha256"},
+		LStripPaths:        []string{filepath.ToSlash(dir) + "/"},
+		FollowSymlinkDirs:  true,
+		RecordFileSymlinks: true,
+	}
+
+	// File symlinks are recorded by target, directory symlinks are followed
+	result, err := RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]HashObj{
+		"foo":          abcHash,
+		"sub/bar":      abcHash,
+		"foo-link":     targetHash("foo"),
+		"dangling":     targetHash("missing"),
+		"sub-link/bar": abcHash,
+	}, result)
+
+	// Directory symlinks can still be skipped
+	opts.FollowSymlinkDirs = false
+	result, err = RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	assert.NotContains(t, result, "sub-link/bar")
+	assert.Contains(t, result, "foo-link")
+
+	// When following file symlinks, they are recorded with the hashes of
+	// their targets, and dangling ones are an error
+	opts.RecordFileSymlinks = false
+	_, err = RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.True(t, os.IsNotExist(err))
+	if err := os.Remove(filepath.Join(dir, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	result, err = RecordArtifactsWithOptions([]string{dir}, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, abcHash, result["foo-link"])
+}
+
+func TestRecordArtifactsCtx(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 100; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%d", i)), []byte("abc"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := RecordArtifactsCtx(context.Background(), []string{dir}, []string{"sha256"}, nil, nil, false, false)
+	assert.Nil(t, err)
+	assert.Len(t, result, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err = RecordArtifactsCtx(ctx, []string{dir}, []string{"sha256"}, nil, nil, false, false)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, result)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	result, err = RecordArtifactsWithOptionsCtx(ctx, []string{dir}, RecordArtifactsOptions{
+		HashAlgorithms: []string{"sha256"},
+		Concurrency:    1,
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, result)
+
+	// Hashing stops as well, if the context is done after walking
+	files, err := recordArtifacts(context.Background(), []string{dir}, NewSet(), nil, nil, false, false, false, DefaultMaxSymlinkDepth, false, false, nil)
+	assert.Nil(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	hashes, err := hashArtifacts(ctx, files, []string{"sha256"}, rawLineEndings, 1, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, hashes)
+}
+
+func TestRunCommandWithOptions(t *testing.T) {
+	// Output is streamed to the passed writers and captured at the same time
+	var streamedOut, streamedErr bytes.Buffer
+	result, err := RunCommandWithOptions(context.Background(), []string{"sh", "-c", "printf out; printf err >&2; exit 3"}, "", RunCommandOptions{
+		Stdout: &streamedOut,
+		Stderr: &streamedErr,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"return-value": float64(3),
+		"stdout":       "out",
+		"stderr":       "err",
+	}, result)
+	assert.Equal(t, "out", streamedOut.String())
+	assert.Equal(t, "err", streamedErr.String())
+
+	// Captured output is capped, streamed output and return value are not
+	// affected
+	streamedOut.Reset()
+	result, err = RunCommandWithOptions(context.Background(), []string{"sh", "-c", "head -c 1048576 /dev/zero | tr '\\0' a; printf err >&2; exit 5"}, "", RunCommandOptions{
+		Stdout:           &streamedOut,
+		MaxByproductSize: 1024,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, float64(5), result["return-value"])
+	assert.Equal(t, strings.Repeat("a", 1024)+ByproductTruncationMarker, result["stdout"])
+	assert.Equal(t, true, result["stdout-truncated"])
+	assert.Equal(t, float64(1048576), result["stdout-length"])
+	assert.Equal(t, "err", result["stderr"])
+	assert.NotContains(t, result, "stderr-truncated")
+	assert.NotContains(t, result, "stderr-length")
+	assert.Equal(t, 1048576, streamedOut.Len())
+}
+
+func TestRunCommandReturnValueOnly(t *testing.T) {
+	var streamedOut, streamedErr bytes.Buffer
+	result, err := RunCommandWithOptions(context.Background(), []string{"sh", "-c", "printf secret; printf err >&2; exit 2"}, "", RunCommandOptions{
+		Stdout:          &streamedOut,
+		Stderr:          &streamedErr,
+		ReturnValueOnly: true,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"return-value": float64(2)}, result)
+	assert.Equal(t, "secret", streamedOut.String())
+	assert.Equal(t, "err", streamedErr.String())
+
+	// The option is passed through by InTotoRunWithOptions
+	metadata, err := InTotoRunWithOptions("quiet", "", nil, nil, []string{"true"}, Key{}, InTotoRunOptions{
+		RunCommandOptions: RunCommandOptions{ReturnValueOnly: true},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"return-value": float64(0)}, metadata.GetPayload().(Link).ByProducts)
+}
+
+func TestRunCommandDefaultMaxByproductSize(t *testing.T) {
+	// Output is captured without limit by default
+	result, err := RunCommand([]string{"sh", "-c", fmt.Sprintf("head -c %d /dev/zero; exit 7", DefaultMaxByproductSize+1)}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(7), result["return-value"])
+	assert.Len(t, result["stdout"], DefaultMaxByproductSize+1)
+	assert.NotContains(t, result, "stdout-truncated")
+
+	// Output beyond DefaultMaxByproductSize is truncated, if it is passed
+	result, err = RunCommandWithOptions(context.Background(), []string{"sh", "-c", fmt.Sprintf("head -c %d /dev/zero", DefaultMaxByproductSize+1)}, "", RunCommandOptions{
+		MaxByproductSize: DefaultMaxByproductSize,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), result["return-value"])
+	assert.Len(t, result["stdout"], DefaultMaxByproductSize+len(ByproductTruncationMarker))
+	assert.Equal(t, true, result["stdout-truncated"])
+}
+
+func TestRunCommandByproductTruncation(t *testing.T) {
+	const limit = 64 * 1024
+	output := strings.Repeat("a", 1024*1024)
+	script := fmt.Sprintf("head -c %d /dev/zero | tr '\\0' a; head -c %d /dev/zero | tr '\\0' a >&2", len(output), limit)
+	result, err := RunCommandWithOptions(context.Background(), []string{"sh", "-c", script}, "", RunCommandOptions{
+		MaxByproductSize: limit,
+	})
+	assert.Nil(t, err)
+
+	// 1MB of stdout is truncated to the limit and marked as truncated
+	assert.Equal(t, output[:limit]+ByproductTruncationMarker, result["stdout"])
+	assert.Equal(t, true, result["stdout-truncated"])
+	assert.Equal(t, float64(len(output)), result["stdout-length"])
+
+	// Output that fits the limit is neither truncated nor marked
+	assert.Equal(t, output[:limit], result["stderr"])
+	assert.NotContains(t, result, "stderr-truncated")
+	assert.NotContains(t, result, "stderr-length")
+}
+
+func TestCappedBuffer(t *testing.T) {
+	c := &cappedBuffer{max: 5}
+	for _, p := range []string{"ab", "cd", "ef", "gh"} {
+		n, err := c.Write([]byte(p))
+		assert.Nil(t, err)
+		assert.Equal(t, len(p), n)
+	}
+	assert.Equal(t, "abcde", c.String())
+	assert.True(t, c.truncated)
+	assert.Equal(t, 8, c.written)
+
+	unlimited := &cappedBuffer{}
+	if _, err := unlimited.Write([]byte("abcdefgh")); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "abcdefgh", unlimited.String())
+	assert.False(t, unlimited.truncated)
+}
+
+func TestInTotoRunRunDir(t *testing.T) {
+	runDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The material is resolved relative to the current working directory,
+	// not to runDir
+	metadata, err := InTotoRun("pwd", runDir, []string{"foo.tar.gz"}, nil, []string{"pwd"}, Key{}, []string{"sha256"}, nil, nil, false, false, false)
+	assert.Nil(t, err)
+	link := metadata.GetPayload().(Link)
+	assert.Equal(t, runDir+"\n", link.ByProducts["stdout"])
+	assert.Contains(t, link.Materials, "foo.tar.gz")
+}
+
+func TestInTotoRunWithOptions(t *testing.T) {
+	var streamed bytes.Buffer
+	metadata, err := InTotoRunWithOptions("stream", "", nil, nil, []string{"sh", "-c", "printf 'hello world'"}, Key{}, InTotoRunOptions{
+		RecordArtifactsOptions: RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}},
+		RunCommandOptions: RunCommandOptions{
+			Stdout:           &streamed,
+			MaxByproductSize: 5,
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", streamed.String())
+	link := metadata.GetPayload().(Link)
+	assert.Equal(t, "hello"+ByproductTruncationMarker, link.ByProducts["stdout"])
+	assert.Equal(t, true, link.ByProducts["stdout-truncated"])
+}
+
+func TestInTotoRunProductGlob(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("readme"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The products only exist after the command ran
+	metadata, err := InTotoRun("package", dir, nil, []string{filepath.Join(dir, "*.tar.gz")}, []string{"sh", "-c", "touch a.tar.gz b.tar.gz"}, Key{}, []string{"sha256"}, nil, nil, false, false, false)
+	if err != nil {
+		t.Fatalf("InTotoRun returned '%s'", err)
+	}
+	products := metadata.GetPayload().(Link).Products
+	assert.Len(t, products, 2)
+	assert.Contains(t, products, filepath.ToSlash(filepath.Join(dir, "a.tar.gz")))
+	assert.Contains(t, products, filepath.ToSlash(filepath.Join(dir, "b.tar.gz")))
+}
+
+func TestInTotoRunUnsigned(t *testing.T) {
+	metadata, err := InTotoRun("dry-run", "", []string{"alice.pub"}, []string{"alice.pub"},
+		[]string{"sh", "-c", "printf out"}, Key{}, []string{"sha256"}, nil, nil, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb, ok := metadata.(*Metablock)
+	if !ok {
+		t.Fatalf("InTotoRun returned %T, expected *Metablock", metadata)
+	}
+	assert.NotNil(t, mb.Signatures)
+	assert.Empty(t, mb.Signatures)
+	assert.Nil(t, validateLink(mb.Signed.(Link)))
+	link := mb.Signed.(Link)
+	assert.Equal(t, "dry-run", link.Name)
+	assert.Contains(t, link.Materials, "alice.pub")
+	assert.Contains(t, link.Products, "alice.pub")
+	assert.Equal(t, "out", link.ByProducts["stdout"])
+
+	// The unsigned link is dumped with an empty signatures field and loads again
+	linkPath := filepath.Join(t.TempDir(), "dry-run.link")
+	if err := mb.Dump(linkPath); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadMetadata(linkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, loaded.Sigs())
+
+	var alice Key
+	if err := alice.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, mb.VerifySignature(alice))
+
+	// Unsigned links are also recorded in two steps
+	prelim, err := InTotoRecordStart("dry-run", []string{"alice.pub"}, Key{}, []string{"sha256"}, nil, nil, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	final, err := InTotoRecordStop(prelim, []string{"alice.pub"}, Key{}, []string{"sha256"}, nil, nil, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, final.Sigs())
+	assert.Contains(t, final.GetPayload().(Link).Products, "alice.pub")
+
+	// Signed preliminary links are not finished unverified
+	var aliceKey Key
+	if err := aliceKey.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, useDSSE := range []bool{false, true} {
+		prelim, err = InTotoRecordStart("dry-run", []string{"alice.pub"}, aliceKey, []string{"sha256"}, nil, nil, false, false, useDSSE)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = InTotoRecordStop(prelim, []string{"alice.pub"}, Key{}, []string{"sha256"}, nil, nil, false, false, useDSSE)
+		assert.ErrorIs(t, err, ErrPrelimLinkUnverified, "useDSSE: %t", useDSSE)
+	}
+}
+
+func TestExpandGlobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.tar.gz", "file[1].txt", "sub/b.tar.gz", "sub/deep/c.tar.gz", "sub/deep/c.zip"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tables := []struct {
+		paths    []string
+		expected []string
+	}{
+		{[]string{"*.tar.gz"}, []string{"a.tar.gz"}},
+		{[]string{"**/*.tar.gz"}, []string{"a.tar.gz", "sub/b.tar.gz", "sub/deep/c.tar.gz"}},
+		{[]string{"sub/**/c.*"}, []string{"sub/deep/c.tar.gz", "sub/deep/c.zip"}},
+		{[]string{"sub/**"}, []string{"sub/b.tar.gz", "sub/deep/c.tar.gz", "sub/deep/c.zip"}},
+		{[]string{"sub", "*.rpm", "missing/**/*.rpm"}, []string{"sub"}},
+		// Existing paths are not expanded, even if they contain meta characters
+		{[]string{"file[1].txt"}, []string{"file[1].txt"}},
+		{[]string{"file[12].txt"}, nil},
+	}
+	for _, table := range tables {
+		var paths, expected []string
+		for _, p := range table.paths {
+			paths = append(paths, filepath.Join(dir, p))
+		}
+		for _, p := range table.expected {
+			expected = append(expected, filepath.Join(dir, p))
+		}
+		result, err := ExpandGlobs(paths)
+		assert.Nil(t, err, table.paths)
+		assert.Equal(t, expected, result, table.paths)
+	}
+
+	// Files matched by "**" are recorded once
+	paths, err := ExpandGlobs([]string{filepath.Join(dir, "sub", "**")})
+	assert.Nil(t, err)
+	artifacts, err := RecordArtifacts(paths, []string{"sha256"}, nil, []string{dir + string(filepath.Separator)}, false, false)
+	assert.Nil(t, err)
+	assert.Len(t, artifacts, 3)
+
+	// Literal paths must exist, globs must be well-formed
+	_, err = ExpandGlobs([]string{filepath.Join(dir, "missing.tar.gz")})
+	assert.True(t, os.IsNotExist(err))
+	_, err = ExpandGlobs([]string{filepath.Join(dir, "**", "[")})
+	assert.ErrorIs(t, err, filepath.ErrBadPattern)
+}
@@ -0,0 +1,170 @@
+package in_toto
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoSSHAgent signals that SSH_AUTH_SOCK does not point to an SSH agent.
+var ErrNoSSHAgent = errors.New("no SSH agent available, SSH_AUTH_SOCK is not set")
+
+/*
+sshAgentSigner is the Signer implementation that signs with a key held by an
+SSH agent.  If client is nil, the agent at SSH_AUTH_SOCK is connected to for
+each signature.
+*/
+type sshAgentSigner struct {
+	client    agent.Agent
+	publicKey ssh.PublicKey
+	key       Key
+}
+
+/*
+NewSSHAgentSigner returns a Signer that signs with the ed25519 key of the SSH
+agent at SSH_AUTH_SOCK, whose public key has the passed fingerprint, so that
+the private key never has to be exported from the agent.  The fingerprint may
+be given in the SHA256 format, e.g. "SHA256:uN0...", or in the legacy MD5
+format, as shown by "ssh-add -l" and "ssh-add -l -E md5".  The keyid of the
+Signer is the keyid of the public key, which is returned by
+KeyFromSSHPublicKey and used to verify the signatures.  The agent is connected
+to whenever a signature is created, so that no connection is kept open.
+*/
+func NewSSHAgentSigner(fingerprint string) (Signer, error) {
+	var s *sshAgentSigner
+	err := withSSHAgent(func(client agent.Agent) error {
+		var err error
+		s, err = newSSHAgentSigner(client, fingerprint)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.client = nil
+	return s, nil
+}
+
+/*
+NewSSHAgentSignerWithClient is like NewSSHAgentSigner, but uses the passed
+agent client, e.g. one connected to a forwarded agent or an in-memory keyring
+created with agent.NewKeyring, instead of the agent at SSH_AUTH_SOCK.
+*/
+func NewSSHAgentSignerWithClient(client agent.Agent, fingerprint string) (Signer, error) {
+	return newSSHAgentSigner(client, fingerprint)
+}
+
+func newSSHAgentSigner(client agent.Agent, fingerprint string) (*sshAgentSigner, error) {
+	agentKeys, err := client.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list SSH agent keys: %w", err)
+	}
+	for _, agentKey := range agentKeys {
+		if !sshFingerprintMatches(agentKey, fingerprint) {
+			continue
+		}
+		key, err := KeyFromSSHPublicKey(agentKey)
+		if err != nil {
+			return nil, err
+		}
+		return &sshAgentSigner{client: client, publicKey: agentKey, key: key}, nil
+	}
+	return nil, fmt.Errorf("no SSH agent key found with fingerprint '%s'", fingerprint)
+}
+
+func (s *sshAgentSigner) KeyID() string {
+	return s.key.KeyID
+}
+
+/*
+Sign asks the SSH agent to sign the passed data with the key of the Signer.
+ed25519 signatures of SSH agents are plain ed25519 signatures over the data, as
+created with a loaded ed25519 Key.
+*/
+func (s *sshAgentSigner) Sign(data []byte) (Signature, error) {
+	var sig *ssh.Signature
+	sign := func(client agent.Agent) error {
+		var err error
+		sig, err = client.Sign(s.publicKey, data)
+		return err
+	}
+	var err error
+	if s.client != nil {
+		err = sign(s.client)
+	} else {
+		err = withSSHAgent(sign)
+	}
+	if err != nil {
+		return Signature{}, fmt.Errorf("SSH agent failed to sign: %w", err)
+	}
+	if sig.Format != ssh.KeyAlgoED25519 {
+		return Signature{}, fmt.Errorf("unexpected SSH signature format '%s'", sig.Format)
+	}
+	return Signature{
+		KeyID: s.key.KeyID,
+		Sig:   hex.EncodeToString(sig.Blob),
+	}, nil
+}
+
+/*
+KeyFromSSHPublicKey returns the public Key, which verifies the signatures
+created with the passed SSH public key, e.g. to add it to the keys of a layout.
+Only ed25519 keys are supported, as SSH agents create RSA and ECDSA signatures
+that differ from the signature schemes of in-toto.
+*/
+func KeyFromSSHPublicKey(publicKey ssh.PublicKey) (Key, error) {
+	cryptoKey, ok := publicKey.(ssh.CryptoPublicKey)
+	if !ok {
+		// Keys listed by SSH agents only hold the wire format of the key
+		parsed, err := ssh.ParsePublicKey(publicKey.Marshal())
+		if err != nil {
+			return Key{}, err
+		}
+		if cryptoKey, ok = parsed.(ssh.CryptoPublicKey); !ok {
+			return Key{}, fmt.Errorf("%w: %s", ErrUnsupportedKeyType, publicKey.Type())
+		}
+	}
+	ed25519Key, ok := cryptoKey.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return Key{}, fmt.Errorf("%w: %s", ErrUnsupportedKeyType, publicKey.Type())
+	}
+	var key Key
+	if err := key.loadKey(ed25519Key, nil, ed25519Scheme, []string{"sha256", "sha512"}); err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+/*
+sshFingerprintMatches returns true if the passed fingerprint in the SHA256 or
+the legacy MD5 format is the fingerprint of the passed public key.
+*/
+func sshFingerprintMatches(publicKey ssh.PublicKey, fingerprint string) bool {
+	if fingerprint == ssh.FingerprintSHA256(publicKey) {
+		return true
+	}
+	return strings.EqualFold(strings.TrimPrefix(fingerprint, "MD5:"), ssh.FingerprintLegacyMD5(publicKey))
+}
+
+/*
+withSSHAgent connects to the SSH agent at SSH_AUTH_SOCK, calls the passed
+function with a client of the agent and closes the connection again.
+*/
+func withSSHAgent(f func(client agent.Agent) error) error {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return ErrNoSSHAgent
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SSH agent: %w", err)
+	}
+	defer conn.Close()
+	return f(agent.NewClient(conn))
+}
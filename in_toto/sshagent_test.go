@@ -0,0 +1,124 @@
+package in_toto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestSSHAgentSigner(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	for _, key := range []any{privateKey, rsaKey} {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sshPublicKey, err := ssh.NewPublicKey(privateKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := KeyFromSSHPublicKey(sshPublicKey)
+	assert.Nil(t, err)
+	assert.Equal(t, ed25519KeyType, publicKey.KeyType)
+	assert.Empty(t, publicKey.KeyVal.Private)
+
+	for _, fingerprint := range []string{ssh.FingerprintSHA256(sshPublicKey), ssh.FingerprintLegacyMD5(sshPublicKey),
+		"MD5:" + ssh.FingerprintLegacyMD5(sshPublicKey)} {
+		signer, err := NewSSHAgentSignerWithClient(keyring, fingerprint)
+		if err != nil {
+			t.Fatalf("%s: %s", fingerprint, err)
+		}
+		assert.Equal(t, publicKey.KeyID, signer.KeyID())
+	}
+	signer, err := NewSSHAgentSignerWithClient(keyring, ssh.FingerprintSHA256(sshPublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sign links of InTotoRun with the agent and verify them with the public key
+	for _, useDSSE := range []bool{false, true} {
+		link, err := InTotoRunWithOptions("foo", "", []string{"foo.tar.gz"}, []string{}, []string{}, Key{},
+			InTotoRunOptions{
+				RecordArtifactsOptions: RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}},
+				UseDSSE:                useDSSE,
+				Signer:                 signer,
+			})
+		assert.Nil(t, err)
+		assert.Len(t, link.Sigs(), 1)
+		assert.Nil(t, link.VerifySignature(publicKey), "DSSE: %t", useDSSE)
+	}
+
+	_, err = NewSSHAgentSignerWithClient(keyring, "SHA256:unknown")
+	assert.ErrorContains(t, err, "no SSH agent key found with fingerprint 'SHA256:unknown'")
+
+	rsaPublicKey, err := ssh.NewPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewSSHAgentSignerWithClient(keyring, ssh.FingerprintSHA256(rsaPublicKey))
+	assert.ErrorIs(t, err, ErrUnsupportedKeyType)
+
+	var key Key
+	if err := key.LoadKeyDefaults("carol"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = InTotoRunWithOptions("foo", "", []string{}, []string{}, []string{}, key, InTotoRunOptions{Signer: signer})
+	assert.ErrorContains(t, err, "either a key or a signer must be passed")
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	_, err = NewSSHAgentSigner(ssh.FingerprintSHA256(sshPublicKey))
+	assert.ErrorIs(t, err, ErrNoSSHAgent)
+}
+
+func TestSSHAgentSignerEnv(t *testing.T) {
+	if os.Getenv("SSH_AUTH_SOCK") == "" {
+		t.Skip("SSH_AUTH_SOCK is not set")
+	}
+	var sshPublicKey ssh.PublicKey
+	err := withSSHAgent(func(client agent.Agent) error {
+		agentKeys, err := client.List()
+		for _, agentKey := range agentKeys {
+			if agentKey.Type() == ssh.KeyAlgoED25519 {
+				sshPublicKey = agentKey
+				break
+			}
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sshPublicKey == nil {
+		t.Skip("SSH agent holds no ed25519 key")
+	}
+	publicKey, err := KeyFromSSHPublicKey(sshPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewSSHAgentSigner(ssh.FingerprintSHA256(sshPublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := InTotoRunWithOptions("foo", "", []string{"foo.tar.gz"}, []string{}, []string{}, Key{},
+		InTotoRunOptions{
+			RecordArtifactsOptions: RecordArtifactsOptions{HashAlgorithms: []string{"sha256"}},
+			Signer:                 signer,
+		})
+	assert.Nil(t, err)
+	assert.Nil(t, link.VerifySignature(publicKey))
+}
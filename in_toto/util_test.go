@@ -72,6 +72,7 @@ func TestSetFilter(t *testing.T) {
 		{"match with wildcard", "foo*", NewSet("foo", "foobar", "bar"), NewSet("foo", "foobar")},
 		{"no match", "foo", NewSet("bar"), NewSet()},
 		{"no match (due to invalid pattern)", "[", NewSet("["), NewSet()},
+		{"match recursively", "**/*.py", NewSet("foo.py", "a/foo.py", "a/b/foo.py", "foo.c"), NewSet("foo.py", "a/foo.py", "a/b/foo.py")},
 	}
 
 	for _, tc := range cases {
@@ -3,7 +3,13 @@
 
 package in_toto
 
-import "golang.org/x/sys/unix"
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
 
 func isWritable(path string) error {
 	err := unix.Access(path, unix.W_OK)
@@ -12,3 +18,29 @@ func isWritable(path string) error {
 	}
 	return nil
 }
+
+/*
+setProcessGroup makes the passed command the leader of a new process group,
+so that killProcessGroup can terminate it together with its children.
+*/
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+/*
+killProcessGroup kills the process group of the passed started command.
+*/
+func killProcessGroup(cmd *exec.Cmd) error {
+	return unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+}
+
+/*
+killedByProcessGroupKill reports whether the passed state of a command, whose
+process group was killed with killProcessGroup, shows that the command was
+killed by a signal, rather than that it exited on its own before the signal
+arrived.
+*/
+func killedByProcessGroupKill(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled()
+}
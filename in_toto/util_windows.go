@@ -3,6 +3,7 @@ package in_toto
 import (
 	"errors"
 	"os"
+	"os/exec"
 )
 
 func isWritable(path string) error {
@@ -23,3 +24,25 @@ func isWritable(path string) error {
 	}
 	return nil
 }
+
+/*
+setProcessGroup is a no-op on Windows, where there are no Unix process groups.
+*/
+func setProcessGroup(cmd *exec.Cmd) {}
+
+/*
+killProcessGroup kills the passed started command.  On Windows only the
+command's own process is killed.
+*/
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+/*
+killedByProcessGroupKill always returns true on Windows, where killing a
+command that already exited fails, so that killProcessGroup only succeeds for
+commands that are still running.
+*/
+func killedByProcessGroupKill(state *os.ProcessState) bool {
+	return true
+}
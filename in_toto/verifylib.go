@@ -6,16 +6,23 @@ See https://github.com/in-toto/docs/blob/master/in-toto-spec.md
 package in_toto
 
 import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -24,11 +31,206 @@ var ErrInspectionRunDirIsSymlink = errors.New("runDir is a symlink. This is a se
 
 var ErrNotLayout = errors.New("verification workflow passed a non-layout")
 
+// ErrLayoutExpired is wrapped by LayoutExpiredError
+var ErrLayoutExpired = errors.New("layout has expired")
+
+// ErrLinkMissing is wrapped by LinkMissingError
+var ErrLinkMissing = errors.New("link metadata is missing")
+
+// ErrThresholdNotMet is wrapped by ThresholdNotMetError
+var ErrThresholdNotMet = errors.New("link signature threshold not met")
+
+// ErrLinkArtifactsMismatch is wrapped by LinkArtifactsMismatchError
+var ErrLinkArtifactsMismatch = errors.New("links report different artifacts")
+
+// ErrLayoutThresholdNotMet is wrapped by LayoutThresholdNotMetError
+var ErrLayoutThresholdNotMet = errors.New("layout signature threshold not met")
+
+// ErrSublayoutLinksUnavailable signals a sublayout among links passed to InTotoVerifyLinks
+var ErrSublayoutLinksUnavailable = errors.New("links of sublayouts cannot be passed in memory")
+
+// ErrSublayoutCycle signals a sublayout that resolves to a layout that is already being verified
+var ErrSublayoutCycle = errors.New("sublayout cycle detected")
+
+// ErrSublayoutDepthExceeded signals sublayouts nested deeper than allowed
+var ErrSublayoutDepthExceeded = errors.New("sublayout depth exceeded")
+
+/*
+DefaultMaxSublayoutDepth is the default maximum number of nested sublayouts
+that are verified below the root layout, see InTotoVerifyOptions.
+*/
+const DefaultMaxSublayoutDepth = 10
+
+/*
+DefaultExpirationWarningWindow is the default period before the expiration of
+a layout, in which InTotoVerifyWithWarnings warns about the upcoming
+expiration, see InTotoVerifyOptions.
+*/
+const DefaultExpirationWarningWindow = 30 * 24 * time.Hour
+
+// ErrRuleViolation is wrapped by RuleViolationError
+var ErrRuleViolation = errors.New("artifact rule violation")
+
+// ErrCommandMismatch signals that a link reports a different command than expected by its step
+var ErrCommandMismatch = errors.New("command does not match expected command")
+
+/*
+LayoutExpiredError is returned if the layout has expired before the time it is
+verified at.  It wraps ErrLayoutExpired.
+*/
+type LayoutExpiredError struct {
+	Expires time.Time
+}
+
+func (e *LayoutExpiredError) Error() string {
+	return fmt.Sprintf("layout has expired on '%s'", e.Expires)
+}
+
+func (e *LayoutExpiredError) Unwrap() error {
+	return ErrLayoutExpired
+}
+
+/*
+LinkMissingError is returned if fewer link metadata files than required by the
+threshold of a step are found.  Err joins the errors of link files of the step
+that were found but could not be loaded, if any.  It wraps ErrLinkMissing and
+Err.
+*/
+type LinkMissingError struct {
+	StepName  string
+	Threshold int
+	Found     int
+	Err       error
+}
+
+func (e *LinkMissingError) Error() string {
+	msg := fmt.Sprintf("step '%s' requires '%d' link metadata file(s),"+
+		" found '%d'", e.StepName, e.Threshold, e.Found)
+	if e.Err != nil {
+		msg += fmt.Sprintf(": %v", e.Err)
+	}
+	return msg
+}
+
+func (e *LinkMissingError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrLinkMissing}
+	}
+	return []error{ErrLinkMissing, e.Err}
+}
+
+/*
+ThresholdNotMetError is returned if fewer links of a step than required by its
+threshold have a valid signature from an authorized signer.  Err holds the
+last reason why a link was rejected, if any.  It wraps ErrThresholdNotMet and
+Err.
+*/
+type ThresholdNotMetError struct {
+	StepName  string
+	Threshold int
+	Verified  int
+	Available int
+	Err       error
+}
+
+func (e *ThresholdNotMetError) Error() string {
+	return fmt.Sprintf("step '%s' requires '%d' link metadata file(s)."+
+		" '%d' out of '%d' available link(s) have a valid signature from an"+
+		" authorized signer: %v", e.StepName, e.Threshold, e.Verified,
+		e.Available, e.Err)
+}
+
+func (e *ThresholdNotMetError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrThresholdNotMet}
+	}
+	return []error{ErrThresholdNotMet, e.Err}
+}
+
+/*
+LinkArtifactsMismatchError is returned if links of a step have valid
+signatures from authorized signers, but report different materials or
+products, so that they cannot jointly meet the threshold of the step.  KeyIDs
+holds the keyids of two signers whose links disagree.  It wraps
+ErrLinkArtifactsMismatch.
+*/
+type LinkArtifactsMismatchError struct {
+	StepName string
+	KeyIDs   []string
+}
+
+func (e *LinkArtifactsMismatchError) Error() string {
+	return fmt.Sprintf("links of step '%s' signed by '%s' report different"+
+		" materials or products", e.StepName, strings.Join(e.KeyIDs, "' and '"))
+}
+
+func (e *LinkArtifactsMismatchError) Unwrap() error {
+	return ErrLinkArtifactsMismatch
+}
+
+/*
+LayoutThresholdNotMetError is returned if fewer of the passed layout keys than
+required by the layout threshold have a valid signature on the layout.  Err
+holds the reason why the first key, in keyid order, was rejected.  It wraps
+ErrLayoutThresholdNotMet and Err.
+*/
+type LayoutThresholdNotMetError struct {
+	Threshold int
+	Verified  int
+	Available int
+	Err       error
+}
+
+func (e *LayoutThresholdNotMetError) Error() string {
+	return fmt.Sprintf("layout requires '%d' valid signature(s)."+
+		" '%d' out of '%d' layout key(s) have a valid signature: %v",
+		e.Threshold, e.Verified, e.Available, e.Err)
+}
+
+func (e *LayoutThresholdNotMetError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrLayoutThresholdNotMet}
+	}
+	return []error{ErrLayoutThresholdNotMet, e.Err}
+}
+
+/*
+RuleViolationError is returned if an artifact rule of a step or inspection is
+violated, i.e. if a DISALLOW rule matches any artifacts, or if the artifact of
+a REQUIRE rule is missing.  ItemType is either "Step" or "Inspection" and
+SrcType either "materials" or "products".  For DISALLOW rules Artifacts holds
+the disallowed artifacts, for REQUIRE rules the artifacts that were available.
+It wraps ErrRuleViolation.
+*/
+type RuleViolationError struct {
+	ItemType  string
+	ItemName  string
+	SrcType   string
+	Rule      []string
+	Artifacts []string
+}
+
+func (e *RuleViolationError) Error() string {
+	if len(e.Rule) == 2 && strings.ToLower(e.Rule[0]) == "require" {
+		return fmt.Sprintf("artifact verification failed for %s in REQUIRE '%s',"+
+			" because %s is not in %s", e.SrcType, e.Rule[1], e.Rule[1],
+			e.Artifacts)
+	}
+	return fmt.Sprintf("artifact verification failed for %s '%s',"+
+		" %s %s disallowed by rule %s", e.ItemType, e.ItemName, e.SrcType,
+		e.Artifacts, e.Rule)
+}
+
+func (e *RuleViolationError) Unwrap() error {
+	return ErrRuleViolation
+}
+
 /*
 RunInspections iteratively executes the command in the Run field of all
 inspections of the passed layout, creating unsigned link metadata that records
-all files found in the current working directory as materials (before command
-execution) and products (after command execution).  A map with inspection names
+all files found in the current working directory, or in runDir if it is not
+empty, as materials (before command execution) and products (after command
+execution).  The commands also run in runDir, if it is not empty.  A map with inspection names
 as keys and Metablocks containing the generated link metadata as values is
 returned.  The format is:
 
@@ -40,9 +242,21 @@ returned.  The format is:
 
 If executing the inspection command fails, or if the executed command has a
 non-zero exit code, the first return value is an empty Metablock map and the
-second return value is the error.
+second return value is the error, which names the failed inspection.
+InTotoVerify runs the inspections after verifying the steps, and verifies the
+artifact rules of the inspections against the returned links and the step
+links, so that failing inspections fail verification.
 */
 func RunInspections(layout Layout, runDir string, lineNormalization bool, useDSSE bool) (map[string]Metadata, error) {
+	return RunInspectionsCtx(context.Background(), layout, runDir, lineNormalization, useDSSE)
+}
+
+/*
+RunInspectionsCtx provides the same functionality as RunInspections, but kills
+the running inspection command and fails once the passed context is done, e.g.
+because a timeout for the inspections expired, see RunCommandCtx.
+*/
+func RunInspectionsCtx(ctx context.Context, layout Layout, runDir string, lineNormalization bool, useDSSE bool) (map[string]Metadata, error) {
 	inspectionMetadata := make(map[string]Metadata)
 
 	for _, inspection := range layout.Inspect {
@@ -52,11 +266,18 @@ func RunInspections(layout Layout, runDir string, lineNormalization bool, useDSS
 			paths = []string{runDir}
 		}
 
-		linkEnv, err := InTotoRun(inspection.Name, runDir, paths, paths,
-			inspection.Run, Key{}, []string{"sha256"}, nil, nil, lineNormalization, false, useDSSE)
+		linkEnv, err := InTotoRunWithOptionsCtx(ctx, inspection.Name, runDir, paths, paths,
+			inspection.Run, Key{}, InTotoRunOptions{
+				RecordArtifactsOptions: RecordArtifactsOptions{
+					HashAlgorithms:    []string{"sha256"},
+					LineNormalization: lineNormalization,
+				},
+				UseDSSE: useDSSE,
+			})
 
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to run inspection '%s': %w",
+				inspection.Name, err)
 		}
 
 		link, ok := linkEnv.GetPayload().(Link)
@@ -139,8 +360,11 @@ func verifyMatchRule(ruleData map[string]string,
 	}
 	// Iterate over queue and mark consumed artifacts
 	for srcPath := range srcArtifactQueue {
-		// Remove optional source prefix from source artifact path
-		// Noop if prefix is empty, or artifact does not have it
+		// Ignore artifacts outside of the optional source prefix and
+		// remove the prefix from the source artifact path
+		if !strings.HasPrefix(srcPath, ruleData["srcPrefix"]) {
+			continue
+		}
 		srcBasePath := strings.TrimPrefix(srcPath, ruleData["srcPrefix"])
 
 		// Ignore artifacts not matched by rule pattern
@@ -224,147 +448,173 @@ func VerifyArtifacts(items []interface{},
 				" for item '%s', got: '%s'", itemName, itemsMetadata)
 		}
 
-		// Create shortcuts to materials and products (including hashes) reported
-		// by the item's link, required to verify "match" rules
+		// Extract the link reported for the item
 		link, ok := srcLinkEnv.GetPayload().(Link)
 		if !ok {
 			return fmt.Errorf("invalid metadata")
 		}
-		materials := link.Materials
-		products := link.Products
-
-		// All other rules only require the material or product paths (without
-		// hashes). We extract them from the corresponding maps and store them as
-		// sets for convenience in further processing
-		materialPaths := NewSet()
-		for _, p := range artifactsDictKeyStrings(materials) {
-			materialPaths.Add(path.Clean(p))
-		}
-		productPaths := NewSet()
-		for _, p := range artifactsDictKeyStrings(products) {
-			productPaths.Add(path.Clean(p))
-		}
-
-		// For `create`, `delete` and `modify` rules we prepare sets of artifacts
-		// (without hashes) that were created, deleted or modified in the current
-		// step or inspection
-		created := productPaths.Difference(materialPaths)
-		deleted := materialPaths.Difference(productPaths)
-		remained := materialPaths.Intersection(productPaths)
-		modified := NewSet()
-		for name := range remained {
-			if !reflect.DeepEqual(materials[name], products[name]) {
-				modified.Add(name)
-			}
-		}
-
-		// For each item we have to run rule verification, once per artifact type.
-		// Here we prepare the corresponding data for each round.
-		verificationDataList := []map[string]interface{}{
-			{
-				"srcType":       "materials",
-				"rules":         expectedMaterials,
-				"artifacts":     materials,
-				"artifactPaths": materialPaths,
-			},
-			{
-				"srcType":       "products",
-				"rules":         expectedProducts,
-				"artifacts":     products,
-				"artifactPaths": productPaths,
-			},
-		}
 		// TODO: Add logging library (see in-toto/in-toto-golang#4)
 		// fmt.Printf("Verifying %s '%s' ", reflect.TypeOf(itemI), itemName)
 
 		// Process all material rules using the corresponding materials and all
 		// product rules using the corresponding products
-		for _, verificationData := range verificationDataList {
-			// TODO: Add logging library (see in-toto/in-toto-golang#4)
-			// fmt.Printf("%s...\n", verificationData["srcType"])
+		itemType := reflect.TypeOf(itemI).Name()
+		if _, err := applyArtifactRules(itemType, itemName, "materials",
+			expectedMaterials, link, itemsMetadata); err != nil {
+			return err
+		}
+		if _, err := applyArtifactRules(itemType, itemName, "products",
+			expectedProducts, link, itemsMetadata); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			rules, ok := verificationData["rules"].([][]string)
-			if !ok {
-				return fmt.Errorf(`rules must be of type [][]string`)
-			}
-			artifacts, ok := verificationData["artifacts"].(map[string]HashObj)
-			if !ok {
-				return fmt.Errorf(`artifacts must be of type map[string]HashObj`)
-			}
-			// Use artifacts (without hashes) as base queue. Each rule only operates
-			// on artifacts in that queue.  If a rule consumes an artifact (i.e. can
-			// be applied successfully), the artifact is removed from the queue. By
-			// applying a DISALLOW rule eventually, verification may return an error,
-			// if the rule matches any artifacts in the queue that should have been
-			// consumed earlier.
-			queue, ok := verificationData["artifactPaths"].(Set)
-			if !ok {
-				return fmt.Errorf(`queue must be of type Set`)
-			}
-			// TODO: Add logging library (see in-toto/in-toto-golang#4)
-			// fmt.Printf("Initial state\nMaterials: %s\nProducts: %s\nQueue: %s\n\n",
-			// 	materialPaths.Slice(), productPaths.Slice(), queue.Slice())
-
-			// Verify rules sequentially
-			for _, rule := range rules {
-				// Parse rule and error out if it is malformed
-				// NOTE: the rule format should have been validated before
-				ruleData, err := UnpackRule(rule)
-				if err != nil {
-					return err
-				}
+/*
+ApplyArtifactRules applies the passed artifact rules to the materials or
+products, as selected by srcType ("materials" or "products"), of the passed
+link.  Rules are processed in order, following the consume/queue semantics
+described in VerifyArtifacts.  MATCH rules are resolved against the passed
+links, keyed by step or inspection name.  On success the artifacts left in the
+queue after processing all rules are returned in sorted order.  A DISALLOW or
+REQUIRE rule that fails returns a RuleViolationError.
+*/
+func ApplyArtifactRules(link Metadata, srcType string, rules [][]string,
+	links map[string]Metadata) ([]string, error) {
+	srcLink, ok := link.GetPayload().(Link)
+	if !ok {
+		return nil, fmt.Errorf("invalid metadata")
+	}
+	if srcType != "materials" && srcType != "products" {
+		return nil, fmt.Errorf("srcType must be one of 'materials' or"+
+			" 'products', got: '%s'", srcType)
+	}
+	queue, err := applyArtifactRules("Link", srcLink.Name, srcType, rules,
+		srcLink, links)
+	if err != nil {
+		return nil, err
+	}
+	remaining := queue.Slice()
+	slices.Sort(remaining)
+	return remaining, nil
+}
 
-				// Apply rule pattern to filter queued artifacts that are up for rule
-				// specific consumption
-				filtered := queue.Filter(path.Clean(ruleData["pattern"]))
-
-				var consumed Set
-				switch ruleData["type"] {
-				case "match":
-					// Note: here we need to perform more elaborate filtering
-					consumed = verifyMatchRule(ruleData, artifacts, queue, itemsMetadata)
-
-				case "allow":
-					// Consumes all filtered artifacts
-					consumed = filtered
-
-				case "create":
-					// Consumes filtered artifacts that were created
-					consumed = filtered.Intersection(created)
-
-				case "delete":
-					// Consumes filtered artifacts that were deleted
-					consumed = filtered.Intersection(deleted)
-
-				case "modify":
-					// Consumes filtered artifacts that were modified
-					consumed = filtered.Intersection(modified)
-
-				case "disallow":
-					// Does not consume but errors out if artifacts were filtered
-					if len(filtered) > 0 {
-						return fmt.Errorf("artifact verification failed for %s '%s',"+
-							" %s %s disallowed by rule %s",
-							reflect.TypeOf(itemI).Name(), itemName,
-							verificationData["srcType"], filtered.Slice(), rule)
-					}
-				case "require":
-					// REQUIRE is somewhat of a weird animal that does not use
-					// patterns bur rather single filenames (for now).
-					if !queue.Has(ruleData["pattern"]) {
-						return fmt.Errorf("artifact verification failed for %s in REQUIRE '%s',"+
-							" because %s is not in %s", verificationData["srcType"],
-							ruleData["pattern"], ruleData["pattern"], queue.Slice())
-					}
+/*
+applyArtifactRules applies rules to the srcType artifacts of the passed link
+and returns the queue of artifacts that were not consumed.  itemType and
+itemName are only used to describe rule violations.
+*/
+func applyArtifactRules(itemType string, itemName string, srcType string,
+	rules [][]string, link Link, itemsMetadata map[string]Metadata) (Set, error) {
+	// Create shortcuts to materials and products (including hashes) reported
+	// by the item's link, required to verify "match" rules
+	materials := link.Materials
+	products := link.Products
+
+	// All other rules only require the material or product paths (without
+	// hashes). We extract them from the corresponding maps and store them as
+	// sets for convenience in further processing
+	materialPaths := NewSet()
+	for _, p := range artifactsDictKeyStrings(materials) {
+		materialPaths.Add(path.Clean(p))
+	}
+	productPaths := NewSet()
+	for _, p := range artifactsDictKeyStrings(products) {
+		productPaths.Add(path.Clean(p))
+	}
+
+	// For `create`, `delete` and `modify` rules we prepare sets of artifacts
+	// (without hashes) that were created, deleted or modified in the current
+	// step or inspection
+	created := productPaths.Difference(materialPaths)
+	deleted := materialPaths.Difference(productPaths)
+	remained := materialPaths.Intersection(productPaths)
+	modified := NewSet()
+	for name := range remained {
+		if !reflect.DeepEqual(materials[name], products[name]) {
+			modified.Add(name)
+		}
+	}
+
+	// Use artifacts (without hashes) as base queue. Each rule only operates
+	// on artifacts in that queue.  If a rule consumes an artifact (i.e. can
+	// be applied successfully), the artifact is removed from the queue. By
+	// applying a DISALLOW rule eventually, verification may return an error,
+	// if the rule matches any artifacts in the queue that should have been
+	// consumed earlier.
+	artifacts := materials
+	queue := materialPaths
+	if srcType == "products" {
+		artifacts = products
+		queue = productPaths
+	}
+
+	// Verify rules sequentially
+	for _, rule := range rules {
+		// Parse rule and error out if it is malformed
+		// NOTE: the rule format should have been validated before
+		ruleData, err := UnpackRule(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		// Apply rule pattern to filter queued artifacts that are up for rule
+		// specific consumption
+		filtered := queue.Filter(path.Clean(ruleData["pattern"]))
+
+		var consumed Set
+		switch ruleData["type"] {
+		case "match":
+			// Note: here we need to perform more elaborate filtering
+			consumed = verifyMatchRule(ruleData, artifacts, queue, itemsMetadata)
+
+		case "allow":
+			// Consumes all filtered artifacts
+			consumed = filtered
+
+		case "create":
+			// Consumes filtered artifacts that were created
+			consumed = filtered.Intersection(created)
+
+		case "delete":
+			// Consumes filtered artifacts that were deleted
+			consumed = filtered.Intersection(deleted)
+
+		case "modify":
+			// Consumes filtered artifacts that were modified
+			consumed = filtered.Intersection(modified)
+
+		case "disallow":
+			// Does not consume but errors out if artifacts were filtered
+			if len(filtered) > 0 {
+				return nil, &RuleViolationError{
+					ItemType:  itemType,
+					ItemName:  itemName,
+					SrcType:   srcType,
+					Rule:      rule,
+					Artifacts: filtered.Slice(),
+				}
+			}
+		case "require":
+			// REQUIRE is somewhat of a weird animal that does not use
+			// patterns bur rather single filenames (for now).
+			if !queue.Has(path.Clean(ruleData["pattern"])) {
+				return nil, &RuleViolationError{
+					ItemType:  itemType,
+					ItemName:  itemName,
+					SrcType:   srcType,
+					Rule:      []string{"REQUIRE", ruleData["pattern"]},
+					Artifacts: queue.Slice(),
 				}
-				// Update queue by removing consumed artifacts
-				queue = queue.Difference(consumed)
-				// TODO: Add logging library (see in-toto/in-toto-golang#4)
-				// fmt.Printf("Rule: %s\nQueue: %s\n\n", rule, queue.Slice())
 			}
 		}
+		// Update queue by removing consumed artifacts
+		queue = queue.Difference(consumed)
+		// TODO: Add logging library (see in-toto/in-toto-golang#4)
+		// fmt.Printf("Rule: %s\nQueue: %s\n\n", rule, queue.Slice())
 	}
-	return nil
+	return queue, nil
 }
 
 /*
@@ -442,6 +692,91 @@ func ReduceStepsMetadata(layout Layout,
 	return stepsMetadataReduced, nil
 }
 
+/*
+LinkArtifactDiffs holds the artifacts that the links of a step do not agree on,
+keyed by artifact path.  For each path, there are the hashes recorded by every
+link, in the order of the links, or nil if a link did not record the artifact.
+*/
+type LinkArtifactDiffs struct {
+	Materials map[string][]HashObj
+	Products  map[string][]HashObj
+}
+
+/*
+Empty returns true if the links of a step recorded identical materials and
+products.
+*/
+func (d LinkArtifactDiffs) Empty() bool {
+	return len(d.Materials) == 0 && len(d.Products) == 0
+}
+
+/*
+CombineStepLinks compares the materials and products of the passed links,
+which were created by different functionaries for the same step, e.g. to meet
+a threshold greater than one.  Verification, see ReduceStepsMetadata, requires
+that all of them recorded identical artifacts, which is the case if the
+returned LinkArtifactDiffs are empty.  Otherwise they report each artifact that
+differs between the links.  An error is returned if no links are passed, if a
+Metablock does not hold a link or if the links are for different steps.
+Signatures are not verified.
+*/
+func CombineStepLinks(links []Metablock) (LinkArtifactDiffs, error) {
+	if len(links) == 0 {
+		return LinkArtifactDiffs{}, fmt.Errorf("no links to combine")
+	}
+	stepLinks := make([]Link, 0, len(links))
+	for _, mb := range links {
+		link, ok := mb.Signed.(Link)
+		if !ok {
+			return LinkArtifactDiffs{}, fmt.Errorf("invalid metadata, expected link")
+		}
+		if len(stepLinks) != 0 && link.Name != stepLinks[0].Name {
+			return LinkArtifactDiffs{}, fmt.Errorf("links are for different steps '%s' and '%s'", stepLinks[0].Name, link.Name)
+		}
+		stepLinks = append(stepLinks, link)
+	}
+
+	materials := make([]map[string]HashObj, len(stepLinks))
+	products := make([]map[string]HashObj, len(stepLinks))
+	for i, link := range stepLinks {
+		materials[i] = link.Materials
+		products[i] = link.Products
+	}
+	return LinkArtifactDiffs{
+		Materials: diffLinkArtifacts(materials),
+		Products:  diffLinkArtifacts(products),
+	}, nil
+}
+
+/*
+diffLinkArtifacts returns the hashes of every artifact that is not recorded
+with the same hashes in all of the passed artifact maps, see LinkArtifactDiffs.
+*/
+func diffLinkArtifacts(artifactMaps []map[string]HashObj) map[string][]HashObj {
+	diffs := map[string][]HashObj{}
+	compared := map[string]bool{}
+	for _, artifacts := range artifactMaps {
+		for path := range artifacts {
+			if compared[path] {
+				continue
+			}
+			compared[path] = true
+			hashes := make([]HashObj, len(artifactMaps))
+			identical := true
+			for i, other := range artifactMaps {
+				hashes[i] = other[path]
+				if !reflect.DeepEqual(hashes[i], hashes[0]) {
+					identical = false
+				}
+			}
+			if !identical {
+				diffs[path] = hashes
+			}
+		}
+	}
+	return diffs
+}
+
 /*
 VerifyStepCommandAlignment (soft) verifies that for each step of the passed
 layout the command executed, as per the passed link, matches the expected
@@ -477,6 +812,70 @@ func VerifyStepCommandAlignment(layout Layout,
 	}
 }
 
+/*
+VerifyStepLink verifies a single link against the passed step of a layout,
+without verifying the rest of the supply chain.  The passed keys are usually
+the Keys of the layout.  It checks that the link is for the step, that it has
+valid signatures from enough of the step's authorized functionaries to meet the
+step's threshold, and that the command reported by the link matches the
+expected command of the step exactly.  Functionaries that are authorized via
+certificate constraints are not considered.
+
+Since a single link usually carries a single signature, steps with a threshold
+greater than one are typically not met by a single link.  In that case, and if
+none of the signatures is valid, a ThresholdNotMetError is returned.  A
+command mismatch results in an error wrapping ErrCommandMismatch.
+*/
+func VerifyStepLink(step Step, link Metadata, keys map[string]Key) error {
+	linkPayload, ok := link.GetPayload().(Link)
+	if !ok {
+		return fmt.Errorf("invalid metadata")
+	}
+	if linkPayload.Name != step.Name {
+		return fmt.Errorf("link '%s' is not a link for step '%s'",
+			linkPayload.Name, step.Name)
+	}
+
+	// Count authorized functionaries with a valid signature
+	var sigErr error
+	verified := 0
+	for _, authorizedKeyID := range step.PubKeys {
+		verifierKey, ok := keys[authorizedKeyID]
+		if !ok {
+			continue
+		}
+		if _, err := getSignatureForKey(link.Sigs(), verifierKey); err != nil {
+			continue
+		}
+		if err := link.VerifySignature(verifierKey); err != nil {
+			sigErr = err
+			continue
+		}
+		verified++
+	}
+	if verified < step.Threshold {
+		if verified == 0 && sigErr == nil {
+			sigErr = fmt.Errorf("no signature from an authorized signer found")
+		}
+		return &ThresholdNotMetError{
+			StepName:  step.Name,
+			Threshold: step.Threshold,
+			Verified:  verified,
+			Available: 1,
+			Err:       sigErr,
+		}
+	}
+
+	if !slices.Equal(step.ExpectedCommand, linkPayload.Command) {
+		return fmt.Errorf("%w: expected command for step '%s' (%s) and command"+
+			" reported by link (%s) differ", ErrCommandMismatch, step.Name,
+			strings.Join(step.ExpectedCommand, " "),
+			strings.Join(linkPayload.Command, " "))
+	}
+
+	return nil
+}
+
 /*
 LoadLayoutCertificates loads the root and intermediate CAs from the layout if in the layout.
 This will be used to check signatures that were used to sign links but not configured
@@ -511,7 +910,19 @@ func LoadLayoutCertificates(layout Layout, intermediatePems [][]byte) (*x509.Cer
 }
 
 /*
-VerifyLinkSignatureThesholds verifies that for each step of the passed layout,
+VerifyLinkSignatureThesholds is a misspelled alias of
+VerifyLinkSignatureThresholds, kept for compatibility.
+
+Deprecated: Use VerifyLinkSignatureThresholds instead.
+*/
+func VerifyLinkSignatureThesholds(layout Layout,
+	stepsMetadata map[string]map[string]Metadata, rootCertPool, intermediateCertPool *x509.CertPool) (
+	map[string]map[string]Metadata, error) {
+	return VerifyLinkSignatureThresholds(layout, stepsMetadata, rootCertPool, intermediateCertPool)
+}
+
+/*
+VerifyLinkSignatureThresholds verifies that for each step of the passed layout,
 there are at least Threshold links, validly signed by different authorized
 functionaries.  The returned map of link metadata per steps contains only
 links with valid signatures from distinct functionaries and has the format:
@@ -532,11 +943,26 @@ links with valid signatures from distinct functionaries and has the format:
 
 If for any step of the layout there are not enough links available, the first
 return value is an empty map of Metablock maps and the second return value is
-the error.
+the error.  As the functionaries of a step must independently record the same
+artifacts, links with valid signatures that report different materials or
+products result in a LinkArtifactsMismatchError, which names the keyids of two
+conflicting signers.
 */
-func VerifyLinkSignatureThesholds(layout Layout,
+func VerifyLinkSignatureThresholds(layout Layout,
 	stepsMetadata map[string]map[string]Metadata, rootCertPool, intermediateCertPool *x509.CertPool) (
 	map[string]map[string]Metadata, error) {
+	return verifyLinkSignatureThresholds(layout, stepsMetadata, rootCertPool,
+		intermediateCertPool, time.Time{})
+}
+
+/*
+verifyLinkSignatureThresholds provides the same functionality as
+VerifyLinkSignatureThresholds, but checks the expiration of GPG keys against
+the passed reference time, or against the current time, if it is zero.
+*/
+func verifyLinkSignatureThresholds(layout Layout,
+	stepsMetadata map[string]map[string]Metadata, rootCertPool, intermediateCertPool *x509.CertPool,
+	referenceTime time.Time) (map[string]map[string]Metadata, error) {
 	// This will stores links with valid signature from an authorized functionary
 	// for all steps
 	stepsMetadataVerified := make(map[string]map[string]Metadata)
@@ -544,80 +970,187 @@ func VerifyLinkSignatureThesholds(layout Layout,
 	// Try to find enough (>= threshold) links each with a valid signature from
 	// distinct authorized functionaries for each step
 	for _, step := range layout.Steps {
-		var stepErr error
-
-		// This will store links with valid signature from an authorized
-		// functionary for the given step
-		linksPerStepVerified := make(map[string]Metadata)
+		linksPerStepVerified, err := verifyStepLinkSignatures(layout, step,
+			stepsMetadata[step.Name], rootCertPool, intermediateCertPool, referenceTime)
+		if err != nil {
+			return nil, err
+		}
+		stepsMetadataVerified[step.Name] = linksPerStepVerified
+	}
+	return stepsMetadataVerified, nil
+}
 
-		// Check if there are any links at all for the given step
-		linksPerStep, ok := stepsMetadata[step.Name]
-		if !ok || len(linksPerStep) < 1 {
-			stepErr = fmt.Errorf("no links found")
+/*
+VerifyStepLinkSignatureThreshold provides the same functionality as
+VerifyLinkSignatureThresholds, but only for the step of the passed layout with
+the passed name, given candidate links of the step keyed by the keyids of their
+signers.  It returns the links with valid signatures from distinct authorized
+functionaries, if they meet the threshold of the step, and a
+ThresholdNotMetError otherwise.  This allows to verify the links of a step as
+they arrive, before all links of the supply chain are available.
+*/
+func VerifyStepLinkSignatureThreshold(layout Layout, stepName string,
+	links map[string]Metadata, rootCertPool, intermediateCertPool *x509.CertPool) (
+	map[string]Metadata, error) {
+	for _, step := range layout.Steps {
+		if step.Name == stepName {
+			return verifyStepLinkSignatures(layout, step, links, rootCertPool,
+				intermediateCertPool, time.Time{})
 		}
+	}
+	return nil, fmt.Errorf("layout has no step '%s'", stepName)
+}
 
-		// For each link corresponding to a step, check that the signer key was
-		// authorized, the layout contains a verification key and the signature
-		// verification passes.  Only good links are stored, to verify thresholds
-		// below.
+/*
+verifyStepLinkSignatures returns the passed links of the passed step of the
+layout, that are validly signed by authorized functionaries, or a
+ThresholdNotMetError if there are fewer of them than the threshold of the step.
+Links of the same functionary, e.g. submitted under different keyids of its
+key, only count once, and only the first of them by keyid is returned.  The
+expiration of GPG keys is checked against the passed reference time, or against
+the current time, if it is zero.
+*/
+func verifyStepLinkSignatures(layout Layout, step Step, linksPerStep map[string]Metadata,
+	rootCertPool, intermediateCertPool *x509.CertPool, referenceTime time.Time) (map[string]Metadata, error) {
+	var stepErr error
+
+	// This will store links with valid signature from an authorized
+	// functionary for the given step
+	linksPerStepVerified := make(map[string]Metadata)
+
+	// Check if there are any links at all for the given step
+	if len(linksPerStep) < 1 {
+		stepErr = fmt.Errorf("no links found")
+	}
+
+	// Each functionary counts only once towards the threshold, even if it
+	// submitted the same link under several keyids of its key.  Links are
+	// checked in a stable order, so that the same one of them is kept.
+	counted := NewSet()
+	signerKeyIDs := make([]string, 0, len(linksPerStep))
+	for signerKeyID := range linksPerStep {
+		signerKeyIDs = append(signerKeyIDs, signerKeyID)
+	}
+	slices.Sort(signerKeyIDs)
+
+	// For each link corresponding to a step, check that the signer key was
+	// authorized, the layout contains a verification key and the signature
+	// verification passes.  Only good links are stored, to verify thresholds
+	// below.
+	for _, signerKeyID := range signerKeyIDs {
+		linkEnv := linksPerStep[signerKeyID]
 		isAuthorizedSignature := false
-		for signerKeyID, linkEnv := range linksPerStep {
-			for _, authorizedKeyID := range step.PubKeys {
-				if signerKeyID == authorizedKeyID {
-					if verifierKey, ok := layout.Keys[authorizedKeyID]; ok {
-						if err := linkEnv.VerifySignature(verifierKey); err == nil {
-							linksPerStepVerified[signerKeyID] = linkEnv
-							isAuthorizedSignature = true
-							break
-						}
+		for _, authorizedKeyID := range step.PubKeys {
+			// The layout may reference the signer key by another of its keyids
+			if verifierKey, ok := layout.Keys[authorizedKeyID]; ok &&
+				(signerKeyID == authorizedKeyID || verifierKey.MatchesKeyID(signerKeyID)) {
+				if err := verifySignatureAtTime(linkEnv, verifierKey, referenceTime); err == nil {
+					if functionary := functionaryID(verifierKey, authorizedKeyID); !counted.Has(functionary) {
+						counted.Add(functionary)
+						linksPerStepVerified[signerKeyID] = linkEnv
 					}
+					isAuthorizedSignature = true
+					break
 				}
 			}
+		}
 
-			// If the signer's key wasn't in our step's pubkeys array, check the cert pool to
-			// see if the key is known to us.
-			if !isAuthorizedSignature {
-				sig, err := linkEnv.GetSignatureForKeyID(signerKeyID)
-				if err != nil {
-					stepErr = err
-					continue
-				}
+		// If the signer's key wasn't in our step's pubkeys array, check the cert pool to
+		// see if the key is known to us.
+		if !isAuthorizedSignature {
+			sig, err := linkEnv.GetSignatureForKeyID(signerKeyID)
+			if err != nil {
+				stepErr = err
+				continue
+			}
 
-				cert, err := sig.GetCertificate()
-				if err != nil {
-					stepErr = err
-					continue
-				}
+			cert, err := sig.GetCertificate()
+			if err != nil {
+				stepErr = err
+				continue
+			}
 
-				// test certificate against the step's constraints to make sure it's a valid functionary
-				err = step.CheckCertConstraints(cert, layout.RootCAIDs(), rootCertPool, intermediateCertPool)
-				if err != nil {
-					stepErr = err
-					continue
-				}
+			// test certificate against the step's constraints to make sure it's a valid functionary
+			err = step.CheckCertConstraints(cert, layout.RootCAIDs(), rootCertPool, intermediateCertPool)
+			if err != nil {
+				stepErr = err
+				continue
+			}
 
-				err = linkEnv.VerifySignature(cert)
-				if err != nil {
-					stepErr = err
-					continue
-				}
+			err = verifySignatureAtTime(linkEnv, cert, referenceTime)
+			if err != nil {
+				stepErr = err
+				continue
+			}
 
+			if functionary := functionaryID(cert, cert.KeyID); !counted.Has(functionary) {
+				counted.Add(functionary)
 				linksPerStepVerified[signerKeyID] = linkEnv
 			}
 		}
+	}
+
+	if len(linksPerStepVerified) < step.Threshold {
+		return nil, &ThresholdNotMetError{
+			StepName:  step.Name,
+			Threshold: step.Threshold,
+			Verified:  len(linksPerStepVerified),
+			Available: len(linksPerStep),
+			Err:       stepErr,
+		}
+	}
 
-		// Store all good links for a step
-		stepsMetadataVerified[step.Name] = linksPerStepVerified
+	// Functionaries only meet the threshold jointly, if they independently
+	// recorded the same artifacts
+	if err := verifyLinkArtifactsAgree(step, linksPerStepVerified); err != nil {
+		return nil, err
+	}
+	return linksPerStepVerified, nil
+}
 
-		if len(linksPerStepVerified) < step.Threshold {
-			linksPerStep := stepsMetadata[step.Name]
-			return nil, fmt.Errorf("step '%s' requires '%d' link metadata file(s)."+
-				" '%d' out of '%d' available link(s) have a valid signature from an"+
-				" authorized signer: %v", step.Name, step.Threshold,
-				len(linksPerStepVerified), len(linksPerStep), stepErr)
+/*
+functionaryID identifies the functionary that holds the passed key, which is
+referenced by the passed keyid, by its public key, so that keys that are
+referenced by different keyids are recognized as the same functionary.
+*/
+func functionaryID(key Key, keyID string) string {
+	if public := strings.TrimSpace(key.KeyVal.Public); public != "" {
+		return public
+	}
+	return keyID
+}
+
+/*
+verifyLinkArtifactsAgree returns a LinkArtifactsMismatchError, if the passed
+links of the passed step do not all report the same materials and products.
+Sublayouts are compared once they are resolved to summary links, see
+ReduceStepsMetadata.
+*/
+func verifyLinkArtifactsAgree(step Step, links map[string]Metadata) error {
+	keyIDs := make([]string, 0, len(links))
+	for keyID, linkEnv := range links {
+		if _, ok := linkEnv.GetPayload().(Link); !ok {
+			return nil
 		}
+		keyIDs = append(keyIDs, keyID)
 	}
-	return stepsMetadataVerified, nil
+	if len(keyIDs) < 2 {
+		return nil
+	}
+	slices.Sort(keyIDs)
+
+	refLink := links[keyIDs[0]].GetPayload().(Link)
+	for _, keyID := range keyIDs[1:] {
+		link := links[keyID].GetPayload().(Link)
+		if !reflect.DeepEqual(link.Materials, refLink.Materials) ||
+			!reflect.DeepEqual(link.Products, refLink.Products) {
+			return &LinkArtifactsMismatchError{
+				StepName: step.Name,
+				KeyIDs:   []string{keyIDs[0], keyID},
+			}
+		}
+	}
+	return nil
 }
 
 /*
@@ -643,25 +1176,154 @@ format:
 	}
 
 If a link cannot be loaded at a constructed link name or is invalid, it is
-ignored. Only a preliminary threshold check is performed, that is, if there
-aren't at least Threshold links for any given step, the first return value
-is an empty map of Metablock maps and the second return value is the error.
+ignored, unless the threshold of its step is not met, in which case the reason
+is included in the returned LinkMissingError. Only a preliminary threshold
+check is performed, that is, if there aren't at least Threshold links for any
+given step, the first return value is an empty map of Metablock maps and the
+second return value is the error.
+Link files are loaded concurrently by up to runtime.NumCPU() workers.
 */
 func LoadLinksForLayout(layout Layout, linkDir string) (map[string]map[string]Metadata, error) {
-	stepsMetadata := make(map[string]map[string]Metadata)
+	return loadLinksForLayout(layout, linkDir, runtime.NumCPU())
+}
 
-	for _, step := range layout.Steps {
-		linksPerStep := make(map[string]Metadata)
-		// Since we can verify against certificates belonging to a CA, we need to
-		// load any possible links
+/*
+loadLinksForLayout implements LoadLinksForLayout, loading link files with the
+passed number of workers.  The links of all steps are found first and loaded
+concurrently, then they are assigned to their steps in the same order as when
+loading them one after the other.
+*/
+func loadLinksForLayout(layout Layout, linkDir string, concurrency int) (map[string]map[string]Metadata, error) {
+	if concurrency < 1 {
+		concurrency = runtime.NumCPU()
+	}
+
+	// Since we can verify against certificates belonging to a CA, we need to
+	// load any possible links
+	linkFilesPerStep := make([][]string, len(layout.Steps))
+	var linkPaths []string
+	for i, step := range layout.Steps {
 		linkFiles, err := filepath.Glob(path.Join(linkDir, fmt.Sprintf(LinkGlobFormat, step.Name)))
 		if err != nil {
 			return nil, err
 		}
+		linkFilesPerStep[i] = linkFiles
+		linkPaths = append(linkPaths, linkFiles...)
+	}
+
+	// Links that cannot be loaded are ignored, their errors are only
+	// reported if the threshold of their step is not met
+	linkEnvs := make(map[string]Metadata, len(linkPaths))
+	loadErrs := make(map[string]error)
+	var mu sync.Mutex
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for linkPath := range jobs {
+				linkEnv, err := LoadMetadata(linkPath)
+				mu.Lock()
+				if err != nil {
+					loadErrs[linkPath] = fmt.Errorf("cannot load link '%s': %w", linkPath, err)
+				} else {
+					linkEnvs[linkPath] = linkEnv
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, linkPath := range linkPaths {
+		jobs <- linkPath
+	}
+	close(jobs)
+	wg.Wait()
 
-		for _, linkPath := range linkFiles {
-			linkEnv, err := LoadMetadata(linkPath)
-			if err != nil {
+	return assignLinksToSteps(layout, linkFilesPerStep, linkEnvs, loadErrs)
+}
+
+/*
+LoadLinksForLayoutFromTar provides the same functionality as
+LoadLinksForLayout, but reads the links from the passed tar archive stream
+instead of a directory.  Regular file entries whose base names match the link
+file names of the steps are loaded, regardless of the directory they are in
+within the archive.  Other entries and links that cannot be loaded are skipped
+with a warning.  Errors reading the archive itself are returned.
+*/
+func LoadLinksForLayoutFromTar(layout Layout, tarReader io.Reader) (map[string]map[string]Metadata, error) {
+	linkEnvs := make(map[string]Metadata)
+	loadErrs := make(map[string]error)
+	var linkNames []string
+	tr := tar.NewReader(tarReader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		linkName := path.Base(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(linkName, ".link") {
+			fmt.Printf("WARNING: Skipping tar entry '%s', it is not a link file\n", hdr.Name)
+			continue
+		}
+		if slices.Contains(linkNames, linkName) {
+			fmt.Printf("WARNING: Skipping tar entry '%s', link '%s' was already"+
+				" found\n", hdr.Name, linkName)
+			continue
+		}
+		linkNames = append(linkNames, linkName)
+
+		jsonBytes, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		linkEnv, err := LoadMetadataFromBytes(jsonBytes)
+		if err != nil {
+			fmt.Printf("WARNING: Skipping tar entry '%s', %s\n", hdr.Name, err)
+			loadErrs[linkName] = fmt.Errorf("cannot load link '%s': %w", hdr.Name, err)
+			continue
+		}
+		linkEnvs[linkName] = linkEnv
+	}
+
+	// Match the links to the steps in sorted order, as filepath.Glob does
+	slices.Sort(linkNames)
+	linkFilesPerStep := make([][]string, len(layout.Steps))
+	for i, step := range layout.Steps {
+		linkGlob := fmt.Sprintf(LinkGlobFormat, step.Name)
+		for _, linkName := range linkNames {
+			if ok, _ := path.Match(linkGlob, linkName); ok {
+				linkFilesPerStep[i] = append(linkFilesPerStep[i], linkName)
+			}
+		}
+	}
+
+	return assignLinksToSteps(layout, linkFilesPerStep, linkEnvs, loadErrs)
+}
+
+/*
+assignLinksToSteps returns the loaded links of the steps of the passed layout,
+given the link file paths found for each step, keyed by the keyid of the
+signature that matches the short keyid in the file name.  It performs the
+preliminary threshold check of LoadLinksForLayout, reporting the load errors of
+the links of a step if its threshold is not met.
+*/
+func assignLinksToSteps(layout Layout, linkFilesPerStep [][]string, linkEnvs map[string]Metadata,
+	loadErrs map[string]error) (map[string]map[string]Metadata, error) {
+	stepsMetadata := make(map[string]map[string]Metadata)
+	for i, step := range layout.Steps {
+		linksPerStep := make(map[string]Metadata)
+		var stepErrs []error
+		for _, linkPath := range linkFilesPerStep[i] {
+			linkEnv, ok := linkEnvs[linkPath]
+			if !ok {
+				stepErrs = append(stepErrs, loadErrs[linkPath])
 				continue
 			}
 
@@ -677,8 +1339,12 @@ func LoadLinksForLayout(layout Layout, linkDir string) (map[string]map[string]Me
 		}
 
 		if len(linksPerStep) < step.Threshold {
-			return nil, fmt.Errorf("step '%s' requires '%d' link metadata file(s),"+
-				" found '%d'", step.Name, step.Threshold, len(linksPerStep))
+			return nil, &LinkMissingError{
+				StepName:  step.Name,
+				Threshold: step.Threshold,
+				Found:     len(linksPerStep),
+				Err:       errors.Join(stepErrs...),
+			}
 		}
 
 		stepsMetadata[step.Name] = linksPerStep
@@ -692,17 +1358,76 @@ VerifyLayoutExpiration verifies that the passed Layout has not expired.  It
 returns an error if the (zulu) date in the Expires field is in the past.
 */
 func VerifyLayoutExpiration(layout Layout) error {
+	return VerifyLayoutExpirationAtTime(layout, time.Now())
+}
+
+/*
+VerifyLayoutExpirationAtTime verifies that the passed Layout has not expired at
+the passed reference time.  It returns an error if the (zulu) date in the
+Expires field is before the reference time.
+*/
+func VerifyLayoutExpirationAtTime(layout Layout, referenceTime time.Time) error {
 	expires, err := time.Parse(ISO8601DateSchema, layout.Expires)
 	if err != nil {
 		return err
 	}
 	// Uses timezone of expires, i.e. UTC
-	if time.Until(expires) < 0 {
-		return fmt.Errorf("layout has expired on '%s'", expires)
+	if expires.Before(referenceTime) {
+		return &LayoutExpiredError{Expires: expires}
 	}
 	return nil
 }
 
+/*
+VerifyLayoutKeyIDs verifies that each key in the keys of the passed layout is
+stored under its keyid, and that the keyid matches the hash of the public
+portion of the key, as computed when loading a key with LoadKey.  A mismatch
+hints at a tampered or malformed key and results in an error wrapping
+ErrInvalidKeyID.
+*/
+func VerifyLayoutKeyIDs(layout Layout) error {
+	keyIDs := make([]string, 0, len(layout.Keys))
+	for keyID := range layout.Keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	slices.Sort(keyIDs)
+
+	for _, keyID := range keyIDs {
+		key := layout.Keys[keyID]
+		if key.KeyID != keyID {
+			return fmt.Errorf("%w: key stored as '%s' has keyid '%s'",
+				ErrInvalidKeyID, keyID, key.KeyID)
+		}
+		if err := key.ValidateKeyID(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+RequiredKeyIDs returns the sorted keyids of the functionary keys that the
+steps of the passed layout authorize, without duplicates, so that tools can
+gather exactly the keys needed to verify links, e.g. before calling
+InTotoVerify.  Inspections are run during verification and thus do not require
+keys.  Functionaries authorized by certificate constraints, and the keys of
+sublayouts, are not known before their links are loaded and are not returned.
+*/
+func RequiredKeyIDs(layout Layout) []string {
+	seen := make(map[string]bool)
+	keyIDs := []string{}
+	for _, step := range layout.Steps {
+		for _, keyID := range step.PubKeys {
+			if !seen[keyID] {
+				seen[keyID] = true
+				keyIDs = append(keyIDs, keyID)
+			}
+		}
+	}
+	slices.Sort(keyIDs)
+	return keyIDs
+}
+
 /*
 VerifyLayoutSignatures verifies for each key in the passed key map the
 corresponding signature of the Layout in the passed Metablock's Signed field.
@@ -724,6 +1449,66 @@ func VerifyLayoutSignatures(layoutEnv Metadata,
 	return nil
 }
 
+/*
+VerifyLayoutSignaturesThreshold verifies the signature of the Layout in the
+passed Metadata for each key in the passed key map, like
+VerifyLayoutSignatures, but only requires threshold of the keys to have a valid
+signature, e.g. 2 out of 3 release managers.  Keys with the same keyid count
+once.  It returns for each keyid of the key map nil, if its signature verified,
+or the reason why it did not.  If the key map is empty or the threshold is
+less than one, an error is returned, and if fewer than threshold keys
+verified, a LayoutThresholdNotMetError.
+*/
+func VerifyLayoutSignaturesThreshold(layoutEnv Metadata,
+	layoutKeys map[string]Key, threshold int) (map[string]error, error) {
+	return verifyLayoutSignaturesThreshold(layoutEnv, layoutKeys, threshold, time.Time{})
+}
+
+/*
+verifyLayoutSignaturesThreshold provides the same functionality as
+VerifyLayoutSignaturesThreshold, but checks the expiration of GPG keys against
+the passed reference time, or against the current time, if it is zero.
+*/
+func verifyLayoutSignaturesThreshold(layoutEnv Metadata,
+	layoutKeys map[string]Key, threshold int, referenceTime time.Time) (map[string]error, error) {
+	if len(layoutKeys) < 1 {
+		return nil, fmt.Errorf("layout verification requires at least one key")
+	}
+	if threshold < 1 {
+		return nil, fmt.Errorf("layout threshold must be at least one, got: '%d'", threshold)
+	}
+
+	keyIDs := make([]string, 0, len(layoutKeys))
+	for keyID := range layoutKeys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	slices.Sort(keyIDs)
+
+	results := make(map[string]error, len(layoutKeys))
+	verified := NewSet()
+	var firstErr error
+	for _, keyID := range keyIDs {
+		key := layoutKeys[keyID]
+		err := verifySignatureAtTime(layoutEnv, key, referenceTime)
+		results[keyID] = err
+		if err == nil {
+			verified.Add(key.KeyID)
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if len(verified) < threshold {
+		return results, &LayoutThresholdNotMetError{
+			Threshold: threshold,
+			Verified:  len(verified),
+			Available: len(layoutKeys),
+			Err:       firstErr,
+		}
+	}
+	return results, nil
+}
+
 /*
 GetSummaryLink merges the materials of the first step (as mentioned in the
 layout) and the products of the last step and returns a new link. This link
@@ -772,26 +1557,80 @@ func GetSummaryLink(layout Layout, stepsMetadataReduced map[string]Metadata,
 	return &Metablock{Signed: summaryLink}, nil
 }
 
+/*
+layoutDigest returns the hex encoded SHA256 digest of the canonical JSON
+encoding of the passed layout, which identifies a layout among sublayouts.
+*/
+func layoutDigest(layout Layout) (string, error) {
+	layoutCanonical, err := EncodeCanonical(layout)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(layoutCanonical)), nil
+}
+
+/*
+DefaultSublayoutLinkDir returns the name of the directory, relative to the
+link directory of a layout, that holds the links of the sublayout that the
+functionary with the passed keyid submitted for the passed step, formatted with
+SublayoutLinkDirFormat, e.g. "sub_layout.70ca5750".
+*/
+func DefaultSublayoutLinkDir(stepName, keyID string) string {
+	return fmt.Sprintf(SublayoutLinkDirFormat, stepName, keyID)
+}
+
 /*
 VerifySublayouts checks if any step in the supply chain is a sublayout, and if
 so, recursively resolves it and replaces it with a summary link summarizing the
-steps carried out in the sublayout.
+steps carried out in the sublayout.  Sublayouts that are identical to a layout
+above them, as identified by their digests, fail with an error wrapping
+ErrSublayoutCycle, and sublayouts nested deeper than DefaultMaxSublayoutDepth
+with an error wrapping ErrSublayoutDepthExceeded.  InTotoVerifyWithOptions
+allows to configure the maximum depth.
 */
 func VerifySublayouts(layout Layout,
 	stepsMetadataVerified map[string]map[string]Metadata,
 	superLayoutLinkPath string, intermediatePems [][]byte, lineNormalization bool) (map[string]map[string]Metadata, error) {
+	digest, err := layoutDigest(layout)
+	if err != nil {
+		return nil, err
+	}
+	return verifySublayouts(layout, stepsMetadataVerified, superLayoutLinkPath,
+		intermediatePems, InTotoVerifyOptions{LineNormalization: lineNormalization},
+		[]string{digest})
+}
+
+/*
+verifySublayouts provides the same functionality as VerifySublayouts, but
+verifies sublayouts with the passed options.  The layout threshold of the
+options is not used, a sublayout must always be signed by the functionary of
+its step.  layoutChain holds the digests of the layouts that are being
+verified, from the root layout down to the passed layout, see verifyLayout.
+*/
+func verifySublayouts(layout Layout,
+	stepsMetadataVerified map[string]map[string]Metadata,
+	superLayoutLinkPath string, intermediatePems [][]byte,
+	opts InTotoVerifyOptions, layoutChain []string) (map[string]map[string]Metadata, error) {
 	for stepName, linkData := range stepsMetadataVerified {
 		for keyID, metadata := range linkData {
 			if _, ok := metadata.GetPayload().(Layout); ok {
 				layoutKeys := make(map[string]Key)
 				layoutKeys[keyID] = layout.Keys[keyID]
 
-				sublayoutLinkDir := fmt.Sprintf(SublayoutLinkDirFormat,
-					stepName, keyID)
-				sublayoutLinkPath := filepath.Join(superLayoutLinkPath,
-					sublayoutLinkDir)
-				summaryLink, err := InTotoVerify(metadata, layoutKeys,
-					sublayoutLinkPath, stepName, make(map[string]string), intermediatePems, lineNormalization)
+				sublayoutLinkDir := DefaultSublayoutLinkDir
+				if opts.SublayoutLinkDir != nil {
+					sublayoutLinkDir = opts.SublayoutLinkDir
+				}
+				sublayoutLinkPath := sublayoutLinkDir(stepName, keyID)
+				if !filepath.IsAbs(sublayoutLinkPath) {
+					sublayoutLinkPath = filepath.Join(superLayoutLinkPath,
+						sublayoutLinkPath)
+				}
+				if err := VerifyLayoutSignatures(metadata, layoutKeys); err != nil {
+					return nil, err
+				}
+				summaryLink, err := verifyLayout(metadata, sublayoutLinkPath, nil,
+					stepName, make(map[string]string), intermediatePems, opts, layoutChain)
 				if err != nil {
 					return nil, err
 				}
@@ -905,17 +1744,223 @@ value. If any of the verification routines fail, verification is aborted and
 error is returned. In such an instance, the first value remains an empty
 Metablock object.
 
-NOTE: Artifact rules of type "create", "modify"
-and "delete" are currently not supported.
+Artifact rules of all types, i.e. MATCH, CREATE, DELETE, MODIFY, ALLOW,
+DISALLOW and REQUIRE, are applied in order, see ApplyArtifactRules.
+
+The keyids of the keys in the layout are not validated, so that layouts whose
+keyids were computed differently keep verifying.  Use InTotoVerifyWithOptions
+to validate them, see VerifyLayoutKeyIDs.
 */
 func InTotoVerify(layoutEnv Metadata, layoutKeys map[string]Key,
 	linkDir string, stepName string, parameterDictionary map[string]string, intermediatePems [][]byte, lineNormalization bool) (
 	Metadata, error) {
+	return InTotoVerifyAtTime(layoutEnv, layoutKeys, linkDir, stepName,
+		parameterDictionary, intermediatePems, lineNormalization, time.Now())
+}
+
+/*
+InTotoVerifyAtTime provides the same functionality as InTotoVerify, but checks
+the expiration of the layout, and of any sublayouts, against the passed
+reference time instead of the current time.  This allows to verify historical
+supply chains, whose layouts were valid at the time of the release, but have
+expired since.
+*/
+func InTotoVerifyAtTime(layoutEnv Metadata, layoutKeys map[string]Key,
+	linkDir string, stepName string, parameterDictionary map[string]string, intermediatePems [][]byte, lineNormalization bool,
+	referenceTime time.Time) (Metadata, error) {
+	summaryLink, _, err := InTotoVerifyWithOptions(layoutEnv, layoutKeys, linkDir, stepName,
+		parameterDictionary, intermediatePems, InTotoVerifyOptions{
+			LineNormalization:   lineNormalization,
+			ReferenceTime:       referenceTime,
+			SkipKeyIDValidation: true,
+		})
+	return summaryLink, err
+}
+
+/*
+InTotoVerifyOptions holds the optional settings of InTotoVerifyWithOptions.  The
+expiration of the layout and of GPG keys is checked against ReferenceTime, zero
+means the current time.  LayoutThreshold is the number of layout keys that must
+have a valid signature on the layout, 0 means all of them, as for InTotoVerify,
+and negative values are rejected.  SkipKeyIDValidation
+disables the check that the keyids of the keys in the layout match the keys, see
+VerifyLayoutKeyIDs, e.g. for legacy layouts whose keyids were computed
+differently.  MaxSublayoutDepth is the maximum number of nested sublayouts below
+the layout, 0 means DefaultMaxSublayoutDepth.  ExpirationWarningWindow is the
+period before the expiration of the layout, in which InTotoVerifyWithWarnings
+warns about it, 0 means DefaultExpirationWarningWindow.  InspectionTimeout
+limits how long the inspection commands of the layout may run in total, see
+RunInspectionsCtx, 0 means no limit.  SublayoutLinkDir maps the step name and
+keyid of a sublayout to the directory that holds its links, which is relative to
+the link directory of the layout of the step, unless it is absolute, e.g. to
+load sublayout links from a content-addressed store.  nil means
+DefaultSublayoutLinkDir.
+*/
+type InTotoVerifyOptions struct {
+	LineNormalization       bool
+	ReferenceTime           time.Time
+	LayoutThreshold         int
+	SkipKeyIDValidation     bool
+	MaxSublayoutDepth       int
+	ExpirationWarningWindow time.Duration
+	InspectionTimeout       time.Duration
+	SublayoutLinkDir        func(stepName, keyID string) string
+}
+
+/*
+InTotoVerifyWithOptions provides the same functionality as InTotoVerify, but
+verifies the layout signatures against the threshold in the passed options,
+see VerifyLayoutSignaturesThreshold.  Besides the summary link it returns for
+each keyid of the passed layout keys nil, if its signature on the layout
+verified, or the reason why it did not.  These results are also returned if
+verification fails later on.
+*/
+func InTotoVerifyWithOptions(layoutEnv Metadata, layoutKeys map[string]Key,
+	linkDir string, stepName string, parameterDictionary map[string]string, intermediatePems [][]byte,
+	opts InTotoVerifyOptions) (Metadata, map[string]error, error) {
+	threshold := opts.LayoutThreshold
+	if threshold < 0 {
+		return nil, nil, fmt.Errorf("layout threshold must not be negative, got: '%d'", threshold)
+	}
+	if threshold == 0 {
+		threshold = len(layoutKeys)
+	}
 
 	// Verify root signatures
+	signatureResults, err := verifyLayoutSignaturesThreshold(layoutEnv, layoutKeys, threshold,
+		opts.ReferenceTime)
+	if err != nil {
+		return nil, signatureResults, err
+	}
+	summaryLink, err := verifyLayout(layoutEnv, linkDir, nil, stepName, parameterDictionary,
+		intermediatePems, opts, nil)
+	return summaryLink, signatureResults, err
+}
+
+/*
+InTotoVerifyWithWarnings provides the same functionality as
+InTotoVerifyWithOptions, but besides the summary link it returns warnings
+about conditions that do not fail verification, but will need attention
+soon.  Currently this is a warning such as "layout expires in 3 days", if the
+layout expires within the ExpirationWarningWindow of the passed options after
+the reference time, so that it can be re-signed in time.  Warnings never
+affect the verification result and are also returned if verification fails.
+*/
+func InTotoVerifyWithWarnings(layoutEnv Metadata, layoutKeys map[string]Key,
+	linkDir string, stepName string, parameterDictionary map[string]string, intermediatePems [][]byte,
+	opts InTotoVerifyOptions) (Metadata, []string, error) {
+	referenceTime := opts.ReferenceTime
+	if referenceTime.IsZero() {
+		referenceTime = time.Now()
+	}
+	window := opts.ExpirationWarningWindow
+	if window == 0 {
+		window = DefaultExpirationWarningWindow
+	}
+
+	var warnings []string
+	if layout, ok := layoutEnv.GetPayload().(Layout); ok {
+		if warning := layoutExpirationWarning(layout, referenceTime, window); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	summaryLink, _, err := InTotoVerifyWithOptions(layoutEnv, layoutKeys, linkDir, stepName,
+		parameterDictionary, intermediatePems, opts)
+	return summaryLink, warnings, err
+}
+
+/*
+layoutExpirationWarning returns a warning with the number of started days
+until the passed layout expires, if it expires within the passed window after
+the reference time, and an empty string otherwise.  Expired layouts and
+layouts with an invalid expiration date are left to verification.
+*/
+func layoutExpirationWarning(layout Layout, referenceTime time.Time, window time.Duration) string {
+	expires, err := time.Parse(ISO8601DateSchema, layout.Expires)
+	if err != nil {
+		return ""
+	}
+	remaining := expires.Sub(referenceTime)
+	if remaining < 0 || remaining > window {
+		return ""
+	}
+	days := int(math.Ceil(remaining.Hours() / 24))
+	if days <= 1 {
+		return "layout expires in 1 day"
+	}
+	return fmt.Sprintf("layout expires in %d days", days)
+}
+
+/*
+InTotoVerifyLinks provides the same functionality as InTotoVerify, but takes
+the link metadata of the steps of the layout from the passed map of step names
+to maps of signer keyids to links, e.g. links that were received over the
+network, instead of loading them from a directory.  Links of steps that are
+not in the layout are ignored.  Sublayouts are not supported, because their
+links cannot be passed, and result in ErrSublayoutLinksUnavailable.
+*/
+func InTotoVerifyLinks(layoutEnv Metadata, layoutKeys map[string]Key,
+	links map[string]map[string]Metadata, stepName string, parameterDictionary map[string]string,
+	intermediatePems [][]byte, lineNormalization bool) (Metadata, error) {
 	if err := VerifyLayoutSignatures(layoutEnv, layoutKeys); err != nil {
 		return nil, err
 	}
+	if links == nil {
+		links = map[string]map[string]Metadata{}
+	}
+	return verifyLayout(layoutEnv, "", links, stepName, parameterDictionary,
+		intermediatePems, InTotoVerifyOptions{
+			LineNormalization:   lineNormalization,
+			SkipKeyIDValidation: true,
+		}, nil)
+}
+
+/*
+selectLinksForLayout returns the passed links of the steps of the passed layout,
+performing the same preliminary threshold check as LoadLinksForLayout.
+*/
+func selectLinksForLayout(layout Layout, links map[string]map[string]Metadata) (map[string]map[string]Metadata, error) {
+	stepsMetadata := make(map[string]map[string]Metadata)
+	for _, step := range layout.Steps {
+		linksPerStep := make(map[string]Metadata, len(links[step.Name]))
+		for keyID, linkEnv := range links[step.Name] {
+			if _, ok := linkEnv.GetPayload().(Layout); ok {
+				return nil, fmt.Errorf("%w: step '%s'", ErrSublayoutLinksUnavailable, step.Name)
+			}
+			linksPerStep[keyID] = linkEnv
+		}
+
+		if len(linksPerStep) < step.Threshold {
+			return nil, &LinkMissingError{
+				StepName:  step.Name,
+				Threshold: step.Threshold,
+				Found:     len(linksPerStep),
+			}
+		}
+
+		stepsMetadata[step.Name] = linksPerStep
+	}
+	return stepsMetadata, nil
+}
+
+/*
+verifyLayout performs all steps of InTotoVerify after the verification of the
+layout signatures, using the passed options.  Links are loaded from linkDir,
+unless links is not nil.  layoutChain holds the digests of the layouts above
+the passed layout, if it is a sublayout.  A sublayout that is nested deeper
+than allowed by the options, or whose digest is in the chain and which would
+hence be verified again and again, results in an error wrapping
+ErrSublayoutDepthExceeded or ErrSublayoutCycle, respectively.
+*/
+func verifyLayout(layoutEnv Metadata, linkDir string, links map[string]map[string]Metadata,
+	stepName string, parameterDictionary map[string]string, intermediatePems [][]byte,
+	opts InTotoVerifyOptions, layoutChain []string) (Metadata, error) {
+	lineNormalization := opts.LineNormalization
+	referenceTime := opts.ReferenceTime
+	if referenceTime.IsZero() {
+		referenceTime = time.Now()
+	}
 
 	useDSSE := false
 	if _, ok := layoutEnv.(*Envelope); ok {
@@ -928,13 +1973,39 @@ func InTotoVerify(layoutEnv Metadata, layoutKeys map[string]Key,
 		return nil, ErrNotLayout
 	}
 
+	// Guard against sublayouts that recurse indefinitely
+	digest, err := layoutDigest(layout)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(layoutChain, digest) {
+		return nil, fmt.Errorf("%w: sublayout of step '%s' at depth %d was"+
+			" identical to a layout above it", ErrSublayoutCycle, stepName, len(layoutChain))
+	}
+	maxDepth := opts.MaxSublayoutDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxSublayoutDepth
+	}
+	if len(layoutChain) > maxDepth {
+		return nil, fmt.Errorf("%w: sublayout of step '%s' at depth %d exceeds"+
+			" the maximum of %d", ErrSublayoutDepthExceeded, stepName, len(layoutChain), maxDepth)
+	}
+	layoutChain = append(slices.Clone(layoutChain), digest)
+
 	// Verify layout expiration
-	if err := VerifyLayoutExpiration(layout); err != nil {
+	if err := VerifyLayoutExpirationAtTime(layout, referenceTime); err != nil {
 		return nil, err
 	}
 
+	// Verify that the keyids of the layout keys match the keys
+	if !opts.SkipKeyIDValidation {
+		if err := VerifyLayoutKeyIDs(layout); err != nil {
+			return nil, err
+		}
+	}
+
 	// Substitute parameters in layout
-	layout, err := SubstituteParameters(layout, parameterDictionary)
+	layout, err = SubstituteParameters(layout, parameterDictionary)
 	if err != nil {
 		return nil, err
 	}
@@ -944,22 +2015,28 @@ func InTotoVerify(layoutEnv Metadata, layoutKeys map[string]Key,
 		return nil, err
 	}
 
-	// Load links for layout
-	stepsMetadata, err := LoadLinksForLayout(layout, linkDir)
+	// Load links for layout, unless they were passed in memory
+	var stepsMetadata map[string]map[string]Metadata
+	if links == nil {
+		stepsMetadata, err = LoadLinksForLayout(layout, linkDir)
+	} else {
+		stepsMetadata, err = selectLinksForLayout(layout, links)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Verify link signatures
-	stepsMetadataVerified, err := VerifyLinkSignatureThesholds(layout,
-		stepsMetadata, rootCertPool, intermediateCertPool)
+	stepsMetadataVerified, err := verifyLinkSignatureThresholds(layout,
+		stepsMetadata, rootCertPool, intermediateCertPool, referenceTime)
 	if err != nil {
 		return nil, err
 	}
 
 	// Verify and resolve sublayouts
-	stepsSublayoutVerified, err := VerifySublayouts(layout,
-		stepsMetadataVerified, linkDir, intermediatePems, lineNormalization)
+	opts.ReferenceTime = referenceTime
+	stepsSublayoutVerified, err := verifySublayouts(layout,
+		stepsMetadataVerified, linkDir, intermediatePems, opts, layoutChain)
 	if err != nil {
 		return nil, err
 	}
@@ -983,7 +2060,13 @@ func InTotoVerify(layoutEnv Metadata, layoutKeys map[string]Key,
 		return nil, err
 	}
 
-	inspectionMetadata, err := RunInspections(layout, "", lineNormalization, useDSSE)
+	ctx := context.Background()
+	if opts.InspectionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.InspectionTimeout)
+		defer cancel()
+	}
+	inspectionMetadata, err := RunInspectionsCtx(ctx, layout, "", lineNormalization, useDSSE)
 	if err != nil {
 		return nil, err
 	}
@@ -1091,7 +2174,7 @@ func InTotoVerifyWithDirectory(layoutEnv Metadata, layoutKeys map[string]Key,
 	}
 
 	// Verify link signatures
-	stepsMetadataVerified, err := VerifyLinkSignatureThesholds(layout,
+	stepsMetadataVerified, err := VerifyLinkSignatureThresholds(layout,
 		stepsMetadata, rootCertPool, intermediateCertPool)
 	if err != nil {
 		return nil, err
@@ -1,14 +1,24 @@
 package in_toto
 
 import (
+	"archive/tar"
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
@@ -230,6 +240,329 @@ func TestVerifySublayouts(t *testing.T) {
 	}
 }
 
+func TestVerifySublayoutsRecursion(t *testing.T) {
+	var alice, alicePub Key
+	if err := alice.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := alicePub.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layoutKeys := map[string]Key{alice.KeyID: alicePub}
+	// newLayout returns a layout signed by alice, that has a step "sub"
+	// performed by alice, if sub is set
+	newLayout := func(readme string, sub bool) *Metablock {
+		layout := Layout{
+			Type:    "layout",
+			Expires: time.Now().Add(time.Hour).UTC().Format(ISO8601DateSchema),
+			Readme:  readme,
+			Keys:    map[string]Key{alicePub.KeyID: alicePub},
+			Steps:   []Step{},
+		}
+		if sub {
+			layout.Steps = append(layout.Steps, Step{
+				Type:            "step",
+				SupplyChainItem: SupplyChainItem{Name: "sub"},
+				PubKeys:         []string{alice.KeyID},
+				Threshold:       1,
+			})
+		}
+		mb := &Metablock{Signed: layout}
+		if err := mb.Sign(alice); err != nil {
+			t.Fatal(err)
+		}
+		return mb
+	}
+	linkName := fmt.Sprintf(LinkNameFormat, "sub", alice.KeyID)
+	subDir := fmt.Sprintf(SublayoutLinkDirFormat, "sub", alice.KeyID)
+
+	// A layout that is its own sublayout, with a sublayout link directory
+	// that points back to the link directory, is detected as cycle
+	dir := t.TempDir()
+	root := newLayout("root", true)
+	if err := root.Dump(filepath.Join(dir, linkName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(".", filepath.Join(dir, subDir)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := InTotoVerify(root, layoutKeys, dir, "", make(map[string]string),
+		[][]byte{}, testOSisWindows())
+	assert.ErrorIs(t, err, ErrSublayoutCycle)
+	assert.ErrorContains(t, err, "step 'sub' at depth 1")
+
+	// A sublayout whose own sublayout has the same digest as the root layout
+	// is detected as cycle, too
+	dir = t.TempDir()
+	if err := newLayout("intermediate", true).Dump(filepath.Join(dir, linkName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, subDir), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Dump(filepath.Join(dir, subDir, linkName)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = InTotoVerify(root, layoutKeys, dir, "", make(map[string]string),
+		[][]byte{}, testOSisWindows())
+	assert.ErrorIs(t, err, ErrSublayoutCycle)
+	assert.ErrorContains(t, err, "step 'sub' at depth 2")
+
+	// Distinct nested sublayouts are limited in depth
+	dir = t.TempDir()
+	root = newLayout("level 0", true)
+	linkDir := dir
+	for level := 1; level <= 3; level++ {
+		if err := newLayout(fmt.Sprintf("level %d", level), level < 3).Dump(filepath.Join(linkDir, linkName)); err != nil {
+			t.Fatal(err)
+		}
+		linkDir = filepath.Join(linkDir, subDir)
+		if err := os.Mkdir(linkDir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _, err = InTotoVerifyWithOptions(root, layoutKeys, dir, "", make(map[string]string),
+		[][]byte{}, InTotoVerifyOptions{LineNormalization: testOSisWindows(), MaxSublayoutDepth: 2})
+	assert.ErrorIs(t, err, ErrSublayoutDepthExceeded)
+	assert.ErrorContains(t, err, "at depth 3 exceeds the maximum of 2")
+	_, _, err = InTotoVerifyWithOptions(root, layoutKeys, dir, "", make(map[string]string),
+		[][]byte{}, InTotoVerifyOptions{LineNormalization: testOSisWindows(), MaxSublayoutDepth: 3})
+	assert.Nil(t, err)
+}
+
+func TestInTotoVerifySublayoutLinkDir(t *testing.T) {
+	var alice, alicePub Key
+	if err := alice.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := alicePub.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layoutKeys := map[string]Key{alice.KeyID: alicePub}
+	// newLayout returns a layout signed by alice, that has a step named step
+	// performed by alice, if step is not empty
+	newLayout := func(step string) *Metablock {
+		layout := Layout{
+			Type:    "layout",
+			Expires: time.Now().Add(time.Hour).UTC().Format(ISO8601DateSchema),
+			Keys:    map[string]Key{alicePub.KeyID: alicePub},
+			Steps:   []Step{},
+		}
+		if step != "" {
+			layout.Steps = append(layout.Steps, Step{
+				Type:            "step",
+				SupplyChainItem: SupplyChainItem{Name: step},
+				PubKeys:         []string{alice.KeyID},
+				Threshold:       1,
+			})
+		}
+		mb := &Metablock{Signed: layout}
+		if err := mb.Sign(alice); err != nil {
+			t.Fatal(err)
+		}
+		return mb
+	}
+	dump := func(mb *Metablock, dir, step string) {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := mb.Dump(filepath.Join(dir, fmt.Sprintf(LinkNameFormat, step, alice.KeyID))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The links of the sublayout of step "build" are kept in an absolute
+	// store directory, and those of its sublayout of step "test" in a
+	// directory relative to it
+	linkDir := t.TempDir()
+	store := t.TempDir()
+	root := newLayout("build")
+	dump(newLayout("test"), linkDir, "build")
+	dump(newLayout(""), filepath.Join(store, "build-"+alice.KeyID), "test")
+	sublayoutLinkDir := func(stepName, keyID string) string {
+		if stepName == "build" {
+			return filepath.Join(store, stepName+"-"+keyID)
+		}
+		return "links-" + stepName
+	}
+	if err := os.Mkdir(filepath.Join(store, "build-"+alice.KeyID, "links-test"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := InTotoVerifyOptions{LineNormalization: testOSisWindows(), SublayoutLinkDir: sublayoutLinkDir}
+	_, _, err := InTotoVerifyWithOptions(root, layoutKeys, linkDir, "", make(map[string]string), [][]byte{}, opts)
+	assert.Nil(t, err)
+
+	// The default directory format does not find the links
+	opts.SublayoutLinkDir = nil
+	_, _, err = InTotoVerifyWithOptions(root, layoutKeys, linkDir, "", make(map[string]string), [][]byte{}, opts)
+	assert.ErrorContains(t, err, "step 'test' requires '1' link metadata file(s), found '0'")
+	assert.Equal(t, fmt.Sprintf(SublayoutLinkDirFormat, "build", alice.KeyID), DefaultSublayoutLinkDir("build", alice.KeyID))
+}
+
+func TestInTotoVerifyLinksCertificateFunctionaries(t *testing.T) {
+	rootCert, rootPEM, rootPriv, err := createSelfSignedCA(&x509.Certificate{
+		Subject:    pkix.Name{CommonName: "Root CA"},
+		MaxPathLen: 1,
+	}, x509.Ed25519, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediateCert, intermediatePEM, intermediatePriv, err := createCA(&x509.Certificate{
+		Subject: pkix.Name{CommonName: "Intermediate CA"},
+	}, rootCert, rootPriv, x509.Ed25519, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rootKey, intermediateKey Key
+	if err := rootKey.LoadKeyReaderDefaults(bytes.NewReader(rootPEM)); err != nil {
+		t.Fatal(err)
+	}
+	if err := intermediateKey.LoadKeyReaderDefaults(bytes.NewReader(intermediatePEM)); err != nil {
+		t.Fatal(err)
+	}
+
+	// newFunctionary returns a key that signs with a new key pair and a
+	// certificate for it with the passed common name
+	newFunctionary := func(commonName string) Key {
+		uri, _ := url.Parse("spiffe://example.com/write-code")
+		_, certPEM, priv, err := createEndEntityCert(&x509.Certificate{
+			Subject: pkix.Name{CommonName: commonName},
+			URIs:    []*url.URL{uri},
+		}, intermediateCert, intermediatePriv, x509.Ed25519, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var key Key
+		if err := key.LoadKeyFromBytes(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}),
+			"ed25519", []string{"sha256", "sha512"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := key.LoadCertificateReader(bytes.NewReader(certPEM)); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	var alice Key
+	if err := alice.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layoutMb := &Metablock{Signed: Layout{
+		Type:            "layout",
+		Expires:         time.Now().Add(time.Hour).UTC().Format(ISO8601DateSchema),
+		Keys:            map[string]Key{},
+		RootCas:         map[string]Key{rootKey.KeyID: rootKey},
+		IntermediateCas: map[string]Key{intermediateKey.KeyID: intermediateKey},
+		Steps: []Step{{
+			Type:            "step",
+			SupplyChainItem: SupplyChainItem{Name: "write-code"},
+			Threshold:       1,
+			CertificateConstraints: []CertificateConstraint{{
+				CommonName: "write-code.example.com",
+				URIs:       []string{"spiffe://example.com/write-code"},
+				Roots:      []string{"*"},
+			}},
+		}},
+		Inspect: []Inspection{},
+	}}
+	if err := layoutMb.Sign(alice); err != nil {
+		t.Fatal(err)
+	}
+	layoutKeys := map[string]Key{alice.KeyID: alice}
+
+	// Links signed with rotated keys are accepted, as long as their
+	// certificates meet the constraints, without changing the layout
+	for _, functionary := range []Key{newFunctionary("write-code.example.com"),
+		newFunctionary("write-code.example.com")} {
+		link := &Metablock{Signed: Link{Type: "link", Name: "write-code",
+			Materials: map[string]HashObj{}, Products: map[string]HashObj{},
+			ByProducts: map[string]interface{}{}, Environment: map[string]interface{}{}}}
+		if err := link.Sign(functionary); err != nil {
+			t.Fatal(err)
+		}
+		links := map[string]map[string]Metadata{"write-code": {functionary.KeyID: link}}
+		_, err := InTotoVerifyLinks(layoutMb, layoutKeys, links, "", make(map[string]string),
+			[][]byte{}, testOSisWindows())
+		assert.Nil(t, err)
+	}
+
+	// Certificates that do not meet the constraints are rejected
+	functionary := newFunctionary("package.example.com")
+	link := &Metablock{Signed: Link{Type: "link", Name: "write-code"}}
+	if err := link.Sign(functionary); err != nil {
+		t.Fatal(err)
+	}
+	_, err = InTotoVerifyLinks(layoutMb, layoutKeys,
+		map[string]map[string]Metadata{"write-code": {functionary.KeyID: link}}, "",
+		make(map[string]string), [][]byte{}, testOSisWindows())
+	assert.ErrorIs(t, err, ErrThresholdNotMet)
+	assert.ErrorContains(t, err, "common name")
+}
+
+func TestInTotoVerifyLinks(t *testing.T) {
+	var alice Key
+	if err := alice.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layoutKeys := map[string]Key{alice.KeyID: alice}
+	loadLinks := func(names ...string) map[string]map[string]Metadata {
+		links := map[string]map[string]Metadata{}
+		for _, name := range names {
+			linkEnv, err := LoadMetadata(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stepName := strings.SplitN(name, ".", 2)[0]
+			links[stepName] = map[string]Metadata{linkEnv.Sigs()[0].KeyID: linkEnv}
+		}
+		return links
+	}
+
+	layoutMb, err := LoadMetadata("demo.layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := loadLinks("write-code.b7d643de.link", "package.d3ffd108.link")
+	summary, err := InTotoVerifyLinks(layoutMb, layoutKeys, links, "",
+		make(map[string]string), [][]byte{}, testOSisWindows())
+	if err != nil {
+		t.Fatalf("InTotoVerifyLinks returned '%s'", err)
+	}
+	assert.Equal(t, links["write-code"]["b7d643dec0a051096ee5d87221b5d91a33daa658699d30903e1cefb90c418401"].GetPayload().(Link).Materials,
+		summary.GetPayload().(Link).Materials)
+
+	// Missing links are detected like when loading them from a directory
+	delete(links, "package")
+	_, err = InTotoVerifyLinks(layoutMb, layoutKeys, links, "",
+		make(map[string]string), [][]byte{}, testOSisWindows())
+	assert.ErrorIs(t, err, ErrLinkMissing)
+
+	// Links of sublayouts cannot be passed
+	superLayoutMb, err := LoadMetadata("super.layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var alicePriv Key
+	if err := alicePriv.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	// super.layout is neither signed nor current
+	superLayout := superLayoutMb.GetPayload().(Layout)
+	superLayout.Expires = "2030-11-18T16:06:36Z"
+	superLayoutMb.(*Metablock).Signed = superLayout
+	if err := superLayoutMb.Sign(alicePriv); err != nil {
+		t.Fatal(err)
+	}
+	_, err = InTotoVerifyLinks(superLayoutMb, layoutKeys, loadLinks("sub_layout.70ca5750.link"), "",
+		make(map[string]string), [][]byte{}, testOSisWindows())
+	assert.ErrorIs(t, err, ErrSublayoutLinksUnavailable)
+}
+
 func TestRunInspections(t *testing.T) {
 	// Load layout template used as basis for all tests
 	mb, err := LoadMetadata("demo.layout")
@@ -326,6 +659,26 @@ func TestRunInspections(t *testing.T) {
 		t.Errorf("RunInspections returned '(%s, %s)', expected"+
 			" '(nil, *exec.Error)'", result, err)
 	}
+
+	// Test 4
+	// Fail RunInspectionsCtx due to a command that exceeds the timeout
+	layout.Inspect = []Inspection{
+		{
+			SupplyChainItem: SupplyChainItem{Name: "foo"},
+			Run:             []string{"sh", "-c", "sleep 10"},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result, err = RunInspectionsCtx(ctx, layout, "", testOSisWindows(), false)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunInspectionsCtx took %s, expected inspection to be terminated", elapsed)
+	}
+	assert.Nil(t, result)
+	assert.ErrorIs(t, err, ErrCommandTerminated)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "failed to run inspection 'foo'")
 }
 
 func TestVerifyArtifact(t *testing.T) {
@@ -667,6 +1020,19 @@ func TestVerifyMatchRule(t *testing.T) {
 			item:        map[string]Metadata{"foo": &Metablock{Signed: Link{Name: "foo", Materials: map[string]HashObj{"bar/foo.py": {"sha265": "abc"}}}}},
 			expectSet:   NewSet("bar/foo.py"),
 		},
+		{
+			name: "Match products at any depth with recursive glob dist/**/*.whl",
+			rule: map[string]string{"pattern": "dist/**/*.whl", "dstName": "foo", "dstType": "products"},
+			srcArtifact: map[string]HashObj{
+				"dist/a.whl": {"sha265": "abc"}, "dist/py3/b.whl": {"sha265": "abc"},
+				"dist/py3/linux/c.whl": {"sha265": "abc"}, "dist/d.tar.gz": {"sha265": "abc"}, "build/dist/e.whl": {"sha265": "abc"},
+			},
+			item: map[string]Metadata{"foo": &Metablock{Signed: Link{Name: "foo", Products: map[string]HashObj{
+				"dist/a.whl": {"sha265": "abc"}, "dist/py3/b.whl": {"sha265": "abc"},
+				"dist/py3/linux/c.whl": {"sha265": "abc"}, "dist/d.tar.gz": {"sha265": "abc"}, "build/dist/e.whl": {"sha265": "abc"},
+			}}}},
+			expectSet: NewSet("dist/a.whl", "dist/py3/b.whl", "dist/py3/linux/c.whl"),
+		},
 	}
 
 	for _, tt := range testCases {
@@ -680,6 +1046,240 @@ func TestVerifyMatchRule(t *testing.T) {
 	}
 }
 
+func TestApplyArtifactRulesMatchInPrefixes(t *testing.T) {
+	// Step "build" writes out/foo, which step "package" consumes as vendor/foo
+	links := map[string]Metadata{
+		"build": &Metablock{Signed: Link{
+			Name:     "build",
+			Products: map[string]HashObj{"out/foo": {"sha256": "aaa"}},
+		}},
+	}
+	newPackageLink := func() Metadata {
+		return &Metablock{Signed: Link{
+			Name: "package",
+			Materials: map[string]HashObj{
+				"vendor/foo": {"sha256": "aaa"},
+				"foo":        {"sha256": "aaa"},
+			},
+		}}
+	}
+
+	var testCases = []struct {
+		name      string
+		rules     [][]string
+		expected  []string
+		violation bool
+	}{
+		{
+			name:     "IN on both sides",
+			rules:    [][]string{{"MATCH", "foo", "IN", "vendor", "WITH", "PRODUCTS", "IN", "out", "FROM", "build"}, {"ALLOW", "foo"}, {"DISALLOW", "*"}},
+			expected: []string{},
+		},
+		{
+			name:      "without IN",
+			rules:     [][]string{{"MATCH", "*", "WITH", "PRODUCTS", "FROM", "build"}, {"ALLOW", "foo"}, {"DISALLOW", "*"}},
+			violation: true,
+		},
+		{
+			name:      "only destination IN",
+			rules:     [][]string{{"MATCH", "vendor/foo", "WITH", "PRODUCTS", "IN", "out", "FROM", "build"}, {"ALLOW", "foo"}, {"DISALLOW", "*"}},
+			violation: true,
+		},
+		{
+			name:     "artifacts outside of source prefix are not matched",
+			rules:    [][]string{{"MATCH", "foo", "IN", "vendor/", "WITH", "PRODUCTS", "IN", "out/", "FROM", "build"}},
+			expected: []string{"foo"},
+		},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			queue, err := ApplyArtifactRules(newPackageLink(), "materials", tt.rules, links)
+			if tt.violation {
+				assert.ErrorIs(t, err, ErrRuleViolation)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, queue)
+		})
+	}
+}
+
+func TestApplyArtifactRules(t *testing.T) {
+	link := &Metablock{Signed: Link{
+		Name: "build",
+		Materials: map[string]HashObj{
+			"src/foo.c": {"sha256": "aaa"},
+			"old.txt":   {"sha256": "bbb"},
+			"keep.txt":  {"sha256": "ccc"},
+			"edit.txt":  {"sha256": "ddd"},
+		},
+		Products: map[string]HashObj{
+			"foo.o":    {"sha256": "eee"},
+			"keep.txt": {"sha256": "ccc"},
+			"edit.txt": {"sha256": "fff"},
+		},
+	}}
+	links := map[string]Metadata{
+		"checkout": &Metablock{Signed: Link{
+			Name:     "checkout",
+			Products: map[string]HashObj{"repo/foo.c": {"sha256": "aaa"}},
+		}},
+		"compile": &Metablock{Signed: Link{
+			Name:      "compile",
+			Materials: map[string]HashObj{"foo.o": {"sha256": "eee"}},
+		}},
+	}
+
+	var testCases = []struct {
+		name      string
+		srcType   string
+		rules     [][]string
+		expected  []string
+		violation bool
+	}{
+		{
+			name:     "no rules",
+			srcType:  "products",
+			rules:    [][]string{},
+			expected: []string{"edit.txt", "foo.o", "keep.txt"},
+		},
+		{
+			name:     "ALLOW",
+			srcType:  "materials",
+			rules:    [][]string{{"ALLOW", "*.txt"}},
+			expected: []string{"src/foo.c"},
+		},
+		{
+			name:     "CREATE",
+			srcType:  "products",
+			rules:    [][]string{{"CREATE", "*"}},
+			expected: []string{"edit.txt", "keep.txt"},
+		},
+		{
+			name:     "DELETE",
+			srcType:  "materials",
+			rules:    [][]string{{"DELETE", "*"}},
+			expected: []string{"edit.txt", "keep.txt"},
+		},
+		{
+			name:     "MODIFY",
+			srcType:  "products",
+			rules:    [][]string{{"MODIFY", "*"}},
+			expected: []string{"foo.o", "keep.txt"},
+		},
+		{
+			name:     "MATCH IN WITH PRODUCTS",
+			srcType:  "materials",
+			rules:    [][]string{{"MATCH", "foo.c", "IN", "src", "WITH", "PRODUCTS", "IN", "repo", "FROM", "checkout"}},
+			expected: []string{"edit.txt", "keep.txt", "old.txt"},
+		},
+		{
+			name:     "MATCH WITH MATERIALS",
+			srcType:  "products",
+			rules:    [][]string{{"MATCH", "*.o", "WITH", "MATERIALS", "FROM", "compile"}},
+			expected: []string{"edit.txt", "keep.txt"},
+		},
+		{
+			name:     "MATCH without prefix does not consume",
+			srcType:  "materials",
+			rules:    [][]string{{"MATCH", "*", "WITH", "PRODUCTS", "FROM", "checkout"}},
+			expected: []string{"edit.txt", "keep.txt", "old.txt", "src/foo.c"},
+		},
+		{
+			name:      "DISALLOW fails on queued artifacts",
+			srcType:   "products",
+			rules:     [][]string{{"CREATE", "*"}, {"DISALLOW", "*"}},
+			violation: true,
+		},
+		{
+			name:     "DISALLOW passes on consumed artifacts",
+			srcType:  "products",
+			rules:    [][]string{{"ALLOW", "*"}, {"DISALLOW", "*"}},
+			expected: []string{},
+		},
+		{
+			name:     "REQUIRE",
+			srcType:  "products",
+			rules:    [][]string{{"REQUIRE", "foo.o"}},
+			expected: []string{"edit.txt", "foo.o", "keep.txt"},
+		},
+		{
+			name:      "REQUIRE fails on missing artifact",
+			srcType:   "products",
+			rules:     [][]string{{"REQUIRE", "foo.c"}},
+			violation: true,
+		},
+		{
+			name:     "REQUIRE with unclean path",
+			srcType:  "products",
+			rules:    [][]string{{"REQUIRE", "./foo.o"}},
+			expected: []string{"edit.txt", "foo.o", "keep.txt"},
+		},
+		{
+			name:      "REQUIRE fails on consumed artifact",
+			srcType:   "products",
+			rules:     [][]string{{"CREATE", "foo.o"}, {"REQUIRE", "foo.o"}},
+			violation: true,
+		},
+		{
+			name:      "DISALLOW fails on artifacts left by MODIFY",
+			srcType:   "products",
+			rules:     [][]string{{"MODIFY", "*"}, {"DISALLOW", "*.txt"}},
+			violation: true,
+		},
+		{
+			name:     "DISALLOW passes if pattern matches no queued artifacts",
+			srcType:  "products",
+			rules:    [][]string{{"MODIFY", "*"}, {"ALLOW", "keep.txt"}, {"DISALLOW", "*.txt"}},
+			expected: []string{"foo.o"},
+		},
+		{
+			name:     "rules only consume what earlier rules left",
+			srcType:  "materials",
+			rules:    [][]string{{"DELETE", "*"}, {"MODIFY", "*"}, {"ALLOW", "keep.txt"}, {"DISALLOW", "*"}},
+			expected: []string{},
+		},
+		{
+			name:      "DISALLOW before consuming rule",
+			srcType:   "materials",
+			rules:     [][]string{{"DISALLOW", "old.txt"}, {"DELETE", "*"}},
+			violation: true,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			queue, err := ApplyArtifactRules(link, tt.srcType, tt.rules, links)
+			if tt.violation {
+				var ruleErr *RuleViolationError
+				if !errors.As(err, &ruleErr) {
+					t.Fatalf("ApplyArtifactRules returned '%v', expected RuleViolationError", err)
+				}
+				if ruleErr.ItemName != "build" || ruleErr.SrcType != tt.srcType {
+					t.Errorf("unexpected RuleViolationError: %+v", ruleErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyArtifactRules returned '%s'", err)
+			}
+			if !reflect.DeepEqual(queue, tt.expected) {
+				t.Errorf("ApplyArtifactRules returned '%s', expected '%s'", queue, tt.expected)
+			}
+		})
+	}
+
+	if _, err := ApplyArtifactRules(link, "byproducts", nil, links); err == nil {
+		t.Errorf("ApplyArtifactRules accepted invalid srcType")
+	}
+	if _, err := ApplyArtifactRules(link, "products", [][]string{{"FOO", "*"}}, links); err == nil {
+		t.Errorf("ApplyArtifactRules accepted invalid rule")
+	}
+	if _, err := ApplyArtifactRules(&Metablock{Signed: Layout{}}, "products", nil, links); err == nil {
+		t.Errorf("ApplyArtifactRules accepted non-link metadata")
+	}
+}
+
 func TestReduceStepsMetadata(t *testing.T) {
 	mb, err := LoadMetadata("demo.layout")
 	if err != nil {
@@ -869,6 +1469,250 @@ func TestVerifyLinkSignatureThesholds(t *testing.T) {
 	}
 }
 
+func TestInTotoVerifyKeyIDHashAlgorithms(t *testing.T) {
+	var alice, alicePub Key
+	if err := alice.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := alicePub.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	keyIDs, err := alicePub.ComputeKeyIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256KeyID, sha512KeyID := keyIDs[0], keyIDs[1]
+
+	// newLayout returns a layout signed by alice, that references the
+	// functionary alice by the passed keyid
+	newLayout := func(keyID string) *Metablock {
+		functionary := alicePub
+		functionary.KeyID = keyID
+		mb := &Metablock{Signed: Layout{
+			Type:    "layout",
+			Expires: time.Now().Add(time.Hour).UTC().Format(ISO8601DateSchema),
+			Keys:    map[string]Key{keyID: functionary},
+			Steps: []Step{{
+				Type:            "step",
+				SupplyChainItem: SupplyChainItem{Name: "write-code"},
+				PubKeys:         []string{keyID},
+				Threshold:       1,
+			}},
+		}}
+		if err := mb.Sign(alice); err != nil {
+			t.Fatal(err)
+		}
+		return mb
+	}
+	// dumpLink dumps a link for the step, signed by alice with the passed
+	// keyid, to a new directory
+	dumpLink := func(keyID string) string {
+		signer := alice
+		signer.KeyID = keyID
+		link := &Metablock{Signed: Link{Type: "link", Name: "write-code"}, Signatures: []Signature{}}
+		if err := link.Sign(signer); err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		if err := link.Dump(filepath.Join(dir, fmt.Sprintf(LinkNameFormat, "write-code", keyID))); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	layoutKeys := map[string]Key{alicePub.KeyID: alicePub}
+	for _, layoutKeyID := range keyIDs {
+		for _, linkKeyID := range keyIDs {
+			_, err := InTotoVerify(newLayout(layoutKeyID), layoutKeys, dumpLink(linkKeyID), "",
+				make(map[string]string), [][]byte{}, testOSisWindows())
+			assert.Nil(t, err, "layout keyid '%s', link keyid '%s'", layoutKeyID, linkKeyID)
+		}
+	}
+	assert.NotEqual(t, sha256KeyID, sha512KeyID)
+
+	// Keyids of other keys still do not match
+	var dan Key
+	if err := dan.LoadKey("dan.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layout := newLayout(sha512KeyID).Signed.(Layout)
+	layout.Keys = map[string]Key{sha512KeyID: dan}
+	link, err := LoadMetadata(filepath.Join(dumpLink(sha256KeyID), fmt.Sprintf(LinkNameFormat, "write-code", sha256KeyID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = VerifyLinkSignatureThresholds(layout,
+		map[string]map[string]Metadata{"write-code": {sha256KeyID: link}},
+		x509.NewCertPool(), x509.NewCertPool())
+	assert.ErrorIs(t, err, ErrThresholdNotMet)
+}
+
+func TestVerifyStepLinkSignatureThreshold(t *testing.T) {
+	keyID1 := "b7d643dec0a051096ee5d87221b5d91a33daa658699d30903e1cefb90c418401"
+	keyID2 := "d3ffd1086938b3698618adf088bf14b13db4c8ae19e4e78d73da49ee88492710"
+	mb, err := LoadMetadata("demo.layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := mb.GetPayload().(Layout)
+	layout.Steps = []Step{
+		{SupplyChainItem: SupplyChainItem{Name: "foo"}, Threshold: 2, PubKeys: []string{keyID1, keyID2}},
+		{SupplyChainItem: SupplyChainItem{Name: "bar"}, Threshold: 1, PubKeys: []string{keyID1}},
+	}
+	link1, err := LoadMetadata("foo.b7d643de.link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link2, err := LoadMetadata("foo.d3ffd108.link")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The links of one step are verified, even if other steps have none
+	links := map[string]Metadata{keyID1: link1, keyID2: link2}
+	result, err := VerifyStepLinkSignatureThreshold(layout, "foo", links,
+		x509.NewCertPool(), x509.NewCertPool())
+	assert.Nil(t, err)
+	assert.Equal(t, links, result)
+	_, err = VerifyLinkSignatureThresholds(layout, map[string]map[string]Metadata{"foo": links},
+		x509.NewCertPool(), x509.NewCertPool())
+	assert.ErrorIs(t, err, ErrThresholdNotMet)
+
+	// A link stored under a keyid it is not signed with is not counted
+	_, err = VerifyStepLinkSignatureThreshold(layout, "foo",
+		map[string]Metadata{keyID1: link1, keyID2: link1}, x509.NewCertPool(), x509.NewCertPool())
+	var thresholdErr *ThresholdNotMetError
+	if assert.ErrorAs(t, err, &thresholdErr) {
+		assert.Equal(t, "foo", thresholdErr.StepName)
+		assert.Equal(t, 1, thresholdErr.Verified)
+		assert.Equal(t, 2, thresholdErr.Available)
+	}
+
+	_, err = VerifyStepLinkSignatureThreshold(layout, "baz", links,
+		x509.NewCertPool(), x509.NewCertPool())
+	assert.ErrorContains(t, err, "layout has no step 'baz'")
+}
+
+func TestVerifyLinkSignatureThresholdsDuplicateFunctionary(t *testing.T) {
+	var alice, alicePub, carol Key
+	if err := alice.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := alicePub.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := carol.LoadKeyDefaults("carol"); err != nil {
+		t.Fatal(err)
+	}
+	keyIDs, err := alicePub.ComputeKeyIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256KeyID, sha512KeyID := keyIDs[0], keyIDs[1]
+	layout := Layout{
+		Type: "layout",
+		Keys: map[string]Key{alicePub.KeyID: alicePub, carol.KeyID: publicLayoutKey(carol)},
+		Steps: []Step{{
+			Type:            "step",
+			SupplyChainItem: SupplyChainItem{Name: "build"},
+			PubKeys:         []string{alicePub.KeyID, carol.KeyID},
+			Threshold:       2,
+		}},
+	}
+	// newLink returns a build link signed by the passed key with the passed
+	// keyid
+	newLink := func(key Key, keyID string) Metadata {
+		key.KeyID = keyID
+		mb := &Metablock{Signed: Link{Type: "link", Name: "build"}}
+		if err := mb.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		return mb
+	}
+
+	// The same link of alice under both of her keyids counts once
+	links := map[string]Metadata{sha256KeyID: newLink(alice, sha256KeyID), sha512KeyID: newLink(alice, sha512KeyID)}
+	_, err = VerifyLinkSignatureThresholds(layout, map[string]map[string]Metadata{"build": links},
+		x509.NewCertPool(), x509.NewCertPool())
+	var thresholdErr *ThresholdNotMetError
+	if assert.ErrorAs(t, err, &thresholdErr) {
+		assert.Equal(t, 1, thresholdErr.Verified)
+		assert.Equal(t, 2, thresholdErr.Available)
+	}
+
+	// Also if the layout lists her key under both keyids
+	sha512Key := alicePub
+	sha512Key.KeyID = sha512KeyID
+	layout.Keys[sha512KeyID] = sha512Key
+	layout.Steps[0].PubKeys = []string{sha256KeyID, sha512KeyID}
+	_, err = VerifyLinkSignatureThresholds(layout, map[string]map[string]Metadata{"build": links},
+		x509.NewCertPool(), x509.NewCertPool())
+	assert.ErrorIs(t, err, ErrThresholdNotMet)
+
+	// A second functionary meets the threshold
+	layout.Steps[0].PubKeys = []string{sha256KeyID, carol.KeyID}
+	links[carol.KeyID] = newLink(carol, carol.KeyID)
+	result, err := VerifyLinkSignatureThresholds(layout, map[string]map[string]Metadata{"build": links},
+		x509.NewCertPool(), x509.NewCertPool())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]Metadata{sha256KeyID: links[sha256KeyID], carol.KeyID: links[carol.KeyID]}, result["build"])
+}
+
+func TestVerifyLinkSignatureThresholdsDivergentLinks(t *testing.T) {
+	var dan, carol Key
+	if err := dan.LoadKeyDefaults("dan"); err != nil {
+		t.Fatal(err)
+	}
+	if err := carol.LoadKeyDefaults("carol"); err != nil {
+		t.Fatal(err)
+	}
+	layout := Layout{
+		Type: "layout",
+		Keys: map[string]Key{dan.KeyID: publicLayoutKey(dan), carol.KeyID: publicLayoutKey(carol)},
+		Steps: []Step{{
+			Type:            "step",
+			SupplyChainItem: SupplyChainItem{Name: "build"},
+			PubKeys:         []string{dan.KeyID, carol.KeyID},
+			Threshold:       2,
+		}},
+	}
+	// newLink returns a build link that reports the passed product hash,
+	// signed by the passed key
+	newLink := func(key Key, productHash string) Metadata {
+		mb := &Metablock{Signed: Link{
+			Type:      "link",
+			Name:      "build",
+			Materials: map[string]HashObj{"foo.py": {"sha256": "abc"}},
+			Products:  map[string]HashObj{"foo.tar.gz": {"sha256": productHash}},
+		}}
+		if err := mb.Sign(key); err != nil {
+			t.Fatal(err)
+		}
+		return mb
+	}
+
+	// Validly signed links that agree on their artifacts meet the threshold
+	links := map[string]Metadata{dan.KeyID: newLink(dan, "def"), carol.KeyID: newLink(carol, "def")}
+	result, err := VerifyLinkSignatureThresholds(layout, map[string]map[string]Metadata{"build": links},
+		x509.NewCertPool(), x509.NewCertPool())
+	assert.Nil(t, err)
+	assert.Equal(t, links, result["build"])
+
+	// Validly signed links that disagree do not
+	links[carol.KeyID] = newLink(carol, "bad")
+	_, err = VerifyLinkSignatureThresholds(layout, map[string]map[string]Metadata{"build": links},
+		x509.NewCertPool(), x509.NewCertPool())
+	assert.ErrorIs(t, err, ErrLinkArtifactsMismatch)
+	var mismatchErr *LinkArtifactsMismatchError
+	if assert.ErrorAs(t, err, &mismatchErr) {
+		assert.Equal(t, "build", mismatchErr.StepName)
+		// Keyids are reported in sorted order
+		assert.Equal(t, []string{dan.KeyID, carol.KeyID}, mismatchErr.KeyIDs)
+	}
+	assert.ErrorContains(t, err, fmt.Sprintf("links of step 'build' signed by '%s' and '%s' report different materials or products",
+		dan.KeyID, carol.KeyID))
+}
+
 func TestLoadLinksForLayout(t *testing.T) {
 	keyID1 := "d3ffd1086938b3698618adf088bf14b13db4c8ae19e4e78d73da49ee88492710"
 	keyID2 := "b7d643dec0a051096ee5d87221b5d91a33daa658699d30903e1cefb90c418401"
@@ -906,6 +1750,151 @@ func TestLoadLinksForLayout(t *testing.T) {
 	}
 }
 
+// writeLinksForLayout writes links links for each of steps steps to dir and
+// returns a layout with these steps
+func writeLinksForLayout(tb testing.TB, dir string, steps int, links int) Layout {
+	layout := Layout{Type: "layout", Steps: []Step{}}
+	for s := 0; s < steps; s++ {
+		step := Step{Type: "step", Threshold: links, SupplyChainItem: SupplyChainItem{Name: fmt.Sprintf("step%d", s)}}
+		for l := 0; l < links; l++ {
+			keyID := fmt.Sprintf("%08x%056d", l, 0)
+			mb := Metablock{
+				Signed: Link{
+					Type:        "link",
+					Name:        step.Name,
+					Materials:   map[string]HashObj{},
+					Products:    map[string]HashObj{fmt.Sprintf("%s-%d", step.Name, l): {"sha256": keyID}},
+					ByProducts:  map[string]interface{}{},
+					Command:     []string{},
+					Environment: map[string]interface{}{},
+				},
+				Signatures: []Signature{{KeyID: keyID, Sig: "00"}},
+			}
+			if err := mb.Dump(filepath.Join(dir, fmt.Sprintf(LinkNameFormat, step.Name, keyID))); err != nil {
+				tb.Fatal(err)
+			}
+			step.PubKeys = append(step.PubKeys, keyID)
+		}
+		layout.Steps = append(layout.Steps, step)
+	}
+	return layout
+}
+
+func TestLoadLinksForLayoutFromTar(t *testing.T) {
+	keyID1 := "d3ffd1086938b3698618adf088bf14b13db4c8ae19e4e78d73da49ee88492710"
+	keyID2 := "b7d643dec0a051096ee5d87221b5d91a33daa658699d30903e1cefb90c418401"
+	layout := Layout{Type: "layout", Steps: []Step{
+		{SupplyChainItem: SupplyChainItem{Name: "foo"}, Threshold: 2, PubKeys: []string{keyID1, keyID2}},
+		{SupplyChainItem: SupplyChainItem{Name: "write-code"}, Threshold: 1, PubKeys: []string{keyID2}},
+		{SupplyChainItem: SupplyChainItem{Name: "package"}, Threshold: 1, PubKeys: []string{keyID1}},
+	}}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	writeEntry := func(name string, typeflag byte, content []byte) {
+		hdr := &tar.Header{Name: name, Typeflag: typeflag, Mode: 0644, Size: int64(len(content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEntry("links/", tar.TypeDir, nil)
+	for _, linkName := range []string{"foo.b7d643de.link", "foo.d3ffd108.link",
+		"write-code.b7d643de.link", "package.d3ffd108.link"} {
+		content, err := os.ReadFile(linkName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeEntry("links/"+linkName, tar.TypeReg, content)
+	}
+	// Non-link and malformed entries are skipped
+	writeEntry("links/README", tar.TypeReg, []byte("links"))
+	writeEntry("links/foo.ffffffff.link", tar.TypeReg, []byte("{"))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := LoadLinksForLayout(layout, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := LoadLinksForLayoutFromTar(layout, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, result)
+
+	// The reason a link was skipped is reported if the threshold is not met
+	layout.Steps[0].Threshold = 3
+	_, err = LoadLinksForLayoutFromTar(layout, bytes.NewReader(buf.Bytes()))
+	assert.ErrorIs(t, err, ErrLinkMissing)
+	assert.ErrorContains(t, err, "links/foo.ffffffff.link")
+
+	// Errors reading the archive are returned
+	_, err = LoadLinksForLayoutFromTar(layout, bytes.NewReader(buf.Bytes()[:700]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestLoadLinksForLayoutConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	layout := writeLinksForLayout(t, dir, 20, 3)
+	// An invalid link is ignored, as when loading serially
+	if err := os.WriteFile(filepath.Join(dir, "step0.ffffffff.link"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := loadLinksForLayout(layout, dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, serial, 20)
+	assert.Len(t, serial["step19"], 3)
+	parallel, err := loadLinksForLayout(layout, dir, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, serial, parallel)
+
+	// Load errors are reported if they cause a threshold error, in order
+	if err := os.WriteFile(filepath.Join(dir, "step0.eeeeeeee.link"), []byte("["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	layout.Steps[0].Threshold = 4
+	_, err = loadLinksForLayout(layout, dir, 8)
+	var loadErr *LinkMissingError
+	if assert.ErrorAs(t, err, &loadErr) {
+		assert.ErrorIs(t, err, ErrLinkMissing)
+		assert.Regexp(t, "(?s)step0.eeeeeeee.link.*step0.ffffffff.link", err.Error())
+	}
+	layout.Steps[0].Threshold = 3
+
+	// Threshold errors are reported for the first step in layout order
+	layout.Steps[5].Threshold = 4
+	layout.Steps[10].Threshold = 4
+	_, err = loadLinksForLayout(layout, dir, 8)
+	var missingErr *LinkMissingError
+	if assert.ErrorAs(t, err, &missingErr) {
+		assert.Equal(t, "step5", missingErr.StepName)
+	}
+}
+
+func BenchmarkLoadLinksForLayout(b *testing.B) {
+	dir := b.TempDir()
+	layout := writeLinksForLayout(b, dir, 50, 5)
+
+	for _, concurrency := range []int{1, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := loadLinksForLayout(layout, dir, concurrency); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func TestVerifyLayoutExpiration(t *testing.T) {
 	mb, err := LoadMetadata("demo.layout")
 	if err != nil {
@@ -937,6 +1926,454 @@ func TestVerifyLayoutExpiration(t *testing.T) {
 	if err != nil {
 		t.Errorf("VerifyLayoutExpiration returned '%s', expected nil", err)
 	}
+
+	// Test expiration relative to a reference time
+	layout.Expires = "2020-01-01T00:00:00Z"
+	if err := VerifyLayoutExpirationAtTime(layout, time.Date(2019, time.December, 31, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Errorf("VerifyLayoutExpirationAtTime returned '%s', expected nil", err)
+	}
+	if err := VerifyLayoutExpirationAtTime(layout, time.Date(2020, time.January, 2, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Errorf("VerifyLayoutExpirationAtTime returned nil, expected 'has expired' error")
+	}
+}
+
+func TestInTotoVerifyAtTime(t *testing.T) {
+	var privKey, pubKey Key
+	if err := privKey.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pubKey.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layoutKeys := map[string]Key{pubKey.KeyID: pubKey}
+
+	// Re-sign the demo layout with an expiration date in the past
+	var mb Metablock
+	if err := mb.Load("demo.layout"); err != nil {
+		t.Fatal(err)
+	}
+	layout := mb.Signed.(Layout)
+	layout.Expires = "2020-01-01T00:00:00Z"
+	expired := &Metablock{Signed: layout}
+	if err := expired.Sign(privKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// The layout was valid at the reference time
+	releaseTime := time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := InTotoVerifyAtTime(expired, layoutKeys, ".", "",
+		make(map[string]string), [][]byte{}, testOSisWindows(), releaseTime); err != nil {
+		t.Errorf("InTotoVerifyAtTime returned '%s', expected nil", err)
+	}
+
+	// The layout has expired since
+	_, err := InTotoVerify(expired, layoutKeys, ".", "",
+		make(map[string]string), [][]byte{}, testOSisWindows())
+	if err == nil || !strings.Contains(err.Error(), "has expired") {
+		t.Errorf("InTotoVerify returned '%s', expected 'has expired' error", err)
+	}
+	assert.ErrorIs(t, err, ErrLayoutExpired)
+	_, err = InTotoVerifyAtTime(expired, layoutKeys, ".", "",
+		make(map[string]string), [][]byte{}, testOSisWindows(), time.Now())
+	if err == nil || !strings.Contains(err.Error(), "has expired") {
+		t.Errorf("InTotoVerifyAtTime returned '%s', expected 'has expired' error", err)
+	}
+}
+
+func TestInTotoVerifyInspectionTimeout(t *testing.T) {
+	var pubKey Key
+	if err := pubKey.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layoutKeys := map[string]Key{pubKey.KeyID: pubKey}
+	var mb Metablock
+	if err := mb.Load("demo.layout"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The untar inspection of the demo layout finishes in time
+	opts := InTotoVerifyOptions{LineNormalization: testOSisWindows(), InspectionTimeout: time.Minute}
+	_, _, err := InTotoVerifyWithOptions(&mb, layoutKeys, ".", "", make(map[string]string), [][]byte{}, opts)
+	assert.Nil(t, err)
+
+	// The inspection fails verification, once the timeout expired
+	opts.InspectionTimeout = time.Nanosecond
+	_, _, err = InTotoVerifyWithOptions(&mb, layoutKeys, ".", "", make(map[string]string), [][]byte{}, opts)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "failed to run inspection 'untar'")
+}
+
+func TestInTotoVerifyWithWarnings(t *testing.T) {
+	var privKey, pubKey Key
+	if err := privKey.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pubKey.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layoutKeys := map[string]Key{pubKey.KeyID: pubKey}
+
+	// Re-sign the demo layout with an expiration date in 3 days
+	var mb Metablock
+	if err := mb.Load("demo.layout"); err != nil {
+		t.Fatal(err)
+	}
+	layout := mb.Signed.(Layout)
+	layout.Expires = time.Now().Add(3 * 24 * time.Hour).UTC().Format(ISO8601DateSchema)
+	expiring := &Metablock{Signed: layout}
+	if err := expiring.Sign(privKey); err != nil {
+		t.Fatal(err)
+	}
+	opts := InTotoVerifyOptions{LineNormalization: testOSisWindows()}
+
+	summaryLink, warnings, err := InTotoVerifyWithWarnings(expiring, layoutKeys, ".", "",
+		make(map[string]string), [][]byte{}, opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, summaryLink)
+	assert.Equal(t, []string{"layout expires in 3 days"}, warnings)
+
+	// No warning outside of the window
+	opts.ExpirationWarningWindow = 2 * 24 * time.Hour
+	_, warnings, err = InTotoVerifyWithWarnings(expiring, layoutKeys, ".", "",
+		make(map[string]string), [][]byte{}, opts)
+	assert.Nil(t, err)
+	assert.Empty(t, warnings)
+
+	// Warnings are returned, but do not affect the result, if verification fails
+	opts.ExpirationWarningWindow = 0
+	_, warnings, err = InTotoVerifyWithWarnings(expiring, map[string]Key{}, ".", "",
+		make(map[string]string), [][]byte{}, opts)
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"layout expires in 3 days"}, warnings)
+
+	// Expired layouts are left to verification
+	opts.ReferenceTime = time.Now().Add(4 * 24 * time.Hour)
+	_, warnings, err = InTotoVerifyWithWarnings(expiring, layoutKeys, ".", "",
+		make(map[string]string), [][]byte{}, opts)
+	assert.ErrorIs(t, err, ErrLayoutExpired)
+	assert.Empty(t, warnings)
+
+	layout.Expires = "2030-01-01T12:00:00Z"
+	for _, tt := range []struct {
+		referenceTime time.Time
+		warning       string
+	}{
+		{time.Date(2029, time.December, 31, 13, 0, 0, 0, time.UTC), "layout expires in 1 day"},
+		{time.Date(2029, time.December, 30, 12, 0, 0, 0, time.UTC), "layout expires in 2 days"},
+		{time.Date(2029, time.November, 1, 0, 0, 0, 0, time.UTC), ""},
+	} {
+		assert.Equal(t, tt.warning, layoutExpirationWarning(layout, tt.referenceTime, DefaultExpirationWarningWindow))
+	}
+}
+
+func TestVerificationErrorTypes(t *testing.T) {
+	mb, err := LoadMetadata("demo.layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout, ok := mb.GetPayload().(Layout)
+	if !ok {
+		t.Fatal("invalid metadata")
+	}
+
+	t.Run("layout expired", func(t *testing.T) {
+		expiredLayout := layout
+		expiredLayout.Expires = "1970-01-01T00:00:00Z"
+		err := VerifyLayoutExpiration(expiredLayout)
+		var expiredErr *LayoutExpiredError
+		if !errors.As(err, &expiredErr) {
+			t.Fatalf("VerifyLayoutExpiration returned '%s', expected LayoutExpiredError", err)
+		}
+		assert.Equal(t, time.Unix(0, 0).UTC(), expiredErr.Expires)
+		assert.ErrorIs(t, err, ErrLayoutExpired)
+	})
+
+	t.Run("link missing", func(t *testing.T) {
+		missingLayout := layout
+		missingLayout.Steps = []Step{{SupplyChainItem: SupplyChainItem{Name: "missing"}, Threshold: 1}}
+		_, err := LoadLinksForLayout(missingLayout, ".")
+		var missingErr *LinkMissingError
+		if !errors.As(err, &missingErr) {
+			t.Fatalf("LoadLinksForLayout returned '%s', expected LinkMissingError", err)
+		}
+		assert.Equal(t, &LinkMissingError{StepName: "missing", Threshold: 1, Found: 0}, missingErr)
+		assert.ErrorIs(t, err, ErrLinkMissing)
+	})
+
+	t.Run("threshold not met", func(t *testing.T) {
+		link, err := LoadMetadata("foo.b7d643de.link")
+		if err != nil {
+			t.Fatal(err)
+		}
+		thresholdLayout := layout
+		thresholdLayout.Steps = []Step{{SupplyChainItem: SupplyChainItem{Name: "foo"}, Threshold: 1}}
+		// The link's signer is not authorized for the step
+		_, err = VerifyLinkSignatureThesholds(thresholdLayout,
+			map[string]map[string]Metadata{"foo": {"b7d643dec0a051096ee5d87221b5d91a33daa658699d30903e1cefb90c418401": link}},
+			x509.NewCertPool(), x509.NewCertPool())
+		var thresholdErr *ThresholdNotMetError
+		if !errors.As(err, &thresholdErr) {
+			t.Fatalf("VerifyLinkSignatureThesholds returned '%s', expected ThresholdNotMetError", err)
+		}
+		assert.Equal(t, "foo", thresholdErr.StepName)
+		assert.Equal(t, 1, thresholdErr.Threshold)
+		assert.Equal(t, 0, thresholdErr.Verified)
+		assert.Equal(t, 1, thresholdErr.Available)
+		assert.ErrorIs(t, err, ErrThresholdNotMet)
+	})
+
+	t.Run("rule violation", func(t *testing.T) {
+		metadata := map[string]Metadata{
+			"foo": &Metablock{Signed: Link{
+				Name:      "foo",
+				Materials: map[string]HashObj{"foo.py": {"sha256": "abc"}},
+				Products:  map[string]HashObj{},
+			}},
+		}
+
+		err := VerifyArtifacts([]interface{}{Step{SupplyChainItem: SupplyChainItem{
+			Name:              "foo",
+			ExpectedMaterials: [][]string{{"DISALLOW", "*"}},
+		}}}, metadata)
+		var ruleErr *RuleViolationError
+		if !errors.As(err, &ruleErr) {
+			t.Fatalf("VerifyArtifacts returned '%s', expected RuleViolationError", err)
+		}
+		assert.Equal(t, &RuleViolationError{
+			ItemType:  "Step",
+			ItemName:  "foo",
+			SrcType:   "materials",
+			Rule:      []string{"DISALLOW", "*"},
+			Artifacts: []string{"foo.py"},
+		}, ruleErr)
+		assert.ErrorIs(t, err, ErrRuleViolation)
+
+		err = VerifyArtifacts([]interface{}{Inspection{SupplyChainItem: SupplyChainItem{
+			Name:             "foo",
+			ExpectedProducts: [][]string{{"REQUIRE", "bar.py"}},
+		}}}, metadata)
+		if !errors.As(err, &ruleErr) {
+			t.Fatalf("VerifyArtifacts returned '%s', expected RuleViolationError", err)
+		}
+		assert.Equal(t, "Inspection", ruleErr.ItemType)
+		assert.Equal(t, []string{"REQUIRE", "bar.py"}, ruleErr.Rule)
+		assert.ErrorIs(t, err, ErrRuleViolation)
+	})
+}
+
+func TestVerifyStepLink(t *testing.T) {
+	mb, err := LoadMetadata("demo.layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout, ok := mb.GetPayload().(Layout)
+	if !ok {
+		t.Fatal("invalid metadata")
+	}
+	step := layout.Steps[0]
+	assert.Equal(t, "write-code", step.Name)
+
+	link, err := LoadMetadata("write-code.b7d643de.link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, VerifyStepLink(step, link, layout.Keys))
+
+	// A link that reports a different command fails
+	var key Key
+	if err := key.LoadKey("dan", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	wrongCommand := link.GetPayload().(Link)
+	wrongCommand.Command = []string{"curl", "https://example.com/foo.py"}
+	wrongCommandMb := &Metablock{Signed: wrongCommand}
+	if err := wrongCommandMb.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyStepLink(step, wrongCommandMb, layout.Keys)
+	assert.ErrorIs(t, err, ErrCommandMismatch)
+
+	// A link without a valid signature of an authorized functionary fails
+	unsignedMb := &Metablock{Signed: link.GetPayload().(Link)}
+	err = VerifyStepLink(step, unsignedMb, layout.Keys)
+	var thresholdErr *ThresholdNotMetError
+	assert.True(t, errors.As(err, &thresholdErr), "expected ThresholdNotMetError, got '%s'", err)
+
+	// A single link cannot meet a threshold of two
+	thresholdStep := step
+	thresholdStep.Threshold = 2
+	err = VerifyStepLink(thresholdStep, link, layout.Keys)
+	assert.ErrorIs(t, err, ErrThresholdNotMet)
+
+	// A link for another step fails
+	err = VerifyStepLink(layout.Steps[1], link, layout.Keys)
+	assert.ErrorContains(t, err, "is not a link for step 'package'")
+}
+
+func TestVerifyLayoutSignaturesThreshold(t *testing.T) {
+	var alice, carol, dan Key
+	if err := alice.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := carol.LoadKey("carol", "ed25519", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dan.LoadKey("dan", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	// demo.layout is signed by alice, add carol's signature
+	layoutMb, err := LoadMetadata("demo.layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := layoutMb.Sign(carol); err != nil {
+		t.Fatal(err)
+	}
+	layoutKeys := map[string]Key{alice.KeyID: alice, carol.KeyID: carol, dan.KeyID: dan}
+
+	// 2 out of 3 keys have signed
+	results, err := VerifyLayoutSignaturesThreshold(layoutMb, layoutKeys, 2)
+	assert.Nil(t, err)
+	assert.Len(t, results, 3)
+	assert.Nil(t, results[alice.KeyID])
+	assert.Nil(t, results[carol.KeyID])
+	assert.ErrorContains(t, results[dan.KeyID], "no signature found for key")
+
+	_, err = VerifyLayoutSignaturesThreshold(layoutMb, layoutKeys, 3)
+	assert.ErrorIs(t, err, ErrLayoutThresholdNotMet)
+	var thresholdErr *LayoutThresholdNotMetError
+	if assert.ErrorAs(t, err, &thresholdErr) {
+		assert.Equal(t, 2, thresholdErr.Verified)
+		assert.Equal(t, 3, thresholdErr.Available)
+	}
+
+	_, err = VerifyLayoutSignaturesThreshold(layoutMb, map[string]Key{}, 1)
+	assert.ErrorContains(t, err, "at least one key")
+
+	// Thresholds below one would accept the layout without any signature
+	for _, threshold := range []int{0, -1} {
+		results, err = VerifyLayoutSignaturesThreshold(layoutMb, map[string]Key{dan.KeyID: dan}, threshold)
+		assert.ErrorContains(t, err, "layout threshold must be at least one")
+		assert.Nil(t, results)
+	}
+	_, _, err = InTotoVerifyWithOptions(layoutMb, map[string]Key{dan.KeyID: dan}, ".", "",
+		make(map[string]string), [][]byte{}, InTotoVerifyOptions{LayoutThreshold: -1})
+	assert.ErrorContains(t, err, "layout threshold must not be negative")
+
+	// InTotoVerify requires all keys, unless a threshold is passed
+	_, err = InTotoVerify(layoutMb, layoutKeys, ".", "",
+		make(map[string]string), [][]byte{}, testOSisWindows())
+	assert.ErrorIs(t, err, ErrLayoutThresholdNotMet)
+	_, results, err = InTotoVerifyWithOptions(layoutMb, layoutKeys, ".", "",
+		make(map[string]string), [][]byte{}, InTotoVerifyOptions{
+			LineNormalization: testOSisWindows(),
+			LayoutThreshold:   2,
+		})
+	assert.Nil(t, err)
+	assert.NotNil(t, results[dan.KeyID])
+}
+
+func TestRequiredKeyIDs(t *testing.T) {
+	var mb Metablock
+	if err := mb.Load("demo.layout"); err != nil {
+		t.Fatal(err)
+	}
+	layout := mb.Signed.(Layout)
+	// The demo layout is signed by alice, whose key is not required, as
+	// only the write-code and package steps authorize functionaries
+	expected := []string{
+		"b7d643dec0a051096ee5d87221b5d91a33daa658699d30903e1cefb90c418401",
+		"d3ffd1086938b3698618adf088bf14b13db4c8ae19e4e78d73da49ee88492710",
+	}
+	assert.Equal(t, expected, RequiredKeyIDs(layout))
+
+	// Keys of multiple steps are listed once
+	layout.Steps = append(slices.Clone(layout.Steps), Step{
+		SupplyChainItem: SupplyChainItem{Name: "test"},
+		PubKeys:         []string{expected[1], expected[0]},
+	})
+	assert.Equal(t, expected, RequiredKeyIDs(layout))
+
+	assert.Equal(t, []string{}, RequiredKeyIDs(Layout{}))
+}
+
+func TestVerifyLayoutKeyIDs(t *testing.T) {
+	var alice Key
+	if err := alice.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layoutMb, err := LoadMetadata("demo.layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := layoutMb.GetPayload().(Layout)
+	assert.Nil(t, VerifyLayoutKeyIDs(layout))
+
+	// Replace the key of a functionary by another key, keeping its keyid
+	keyID := "b7d643dec0a051096ee5d87221b5d91a33daa658699d30903e1cefb90c418401"
+	var carol Key
+	if err := carol.LoadKey("carol.pub", "ed25519", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	tampered := carol
+	tampered.KeyID = keyID
+	layout.Keys = map[string]Key{keyID: tampered,
+		"d3ffd1086938b3698618adf088bf14b13db4c8ae19e4e78d73da49ee88492710": layout.Keys["d3ffd1086938b3698618adf088bf14b13db4c8ae19e4e78d73da49ee88492710"]}
+	err = VerifyLayoutKeyIDs(layout)
+	assert.ErrorIs(t, err, ErrInvalidKeyID)
+	assert.ErrorContains(t, err, carol.KeyID)
+
+	// A key stored under another keyid is invalid too
+	err = VerifyLayoutKeyIDs(Layout{Keys: map[string]Key{keyID: carol}})
+	assert.ErrorIs(t, err, ErrInvalidKeyID)
+
+	// Keys without keyid hash algorithms may have the keyid that
+	// securesystemslib computes without the field
+	legacy := carol
+	legacy.KeyIDHashAlgorithms = nil
+	keyCanonical, err := EncodeCanonical(map[string]interface{}{
+		"keytype": legacy.KeyType,
+		"scheme":  legacy.Scheme,
+		"keyval":  map[string]string{"public": legacy.KeyVal.Public},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy.KeyID = fmt.Sprintf("%x", sha256.Sum256(keyCanonical))
+	assert.Nil(t, VerifyLayoutKeyIDs(Layout{Keys: map[string]Key{legacy.KeyID: legacy}}))
+
+	// InTotoVerifyWithOptions fails, unless the check is skipped for legacy
+	// layouts, while the other entry points do not check
+	tamperedMb := &Metablock{Signed: layout}
+	if err := tamperedMb.Sign(alice); err != nil {
+		t.Fatal(err)
+	}
+	layoutKeys := map[string]Key{alice.KeyID: alice}
+	_, _, err = InTotoVerifyWithOptions(tamperedMb, layoutKeys, ".", "",
+		make(map[string]string), [][]byte{}, InTotoVerifyOptions{
+			LineNormalization: testOSisWindows(),
+		})
+	assert.ErrorIs(t, err, ErrInvalidKeyID)
+	_, _, err = InTotoVerifyWithOptions(tamperedMb, layoutKeys, ".", "",
+		make(map[string]string), [][]byte{}, InTotoVerifyOptions{
+			LineNormalization:   testOSisWindows(),
+			SkipKeyIDValidation: true,
+		})
+	assert.NotErrorIs(t, err, ErrInvalidKeyID)
+	_, err = InTotoVerify(tamperedMb, layoutKeys, ".", "",
+		make(map[string]string), [][]byte{}, testOSisWindows())
+	assert.NotErrorIs(t, err, ErrInvalidKeyID)
+	_, err = InTotoVerifyLinks(tamperedMb, layoutKeys, nil, "",
+		make(map[string]string), [][]byte{}, testOSisWindows())
+	assert.NotErrorIs(t, err, ErrInvalidKeyID)
+	runDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(runDir, "foo"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = InTotoVerifyWithDirectory(tamperedMb, layoutKeys, ".", runDir, "",
+		make(map[string]string), [][]byte{}, testOSisWindows())
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidKeyID)
 }
 
 func TestVerifyLayoutSignatures(t *testing.T) {
@@ -1087,6 +2524,104 @@ func TestInTotoVerifyWithDirectory(t *testing.T) {
 	}
 }
 
+func TestInTotoVerifyInspections(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar is not installed")
+	}
+	var alice, alicePub Key
+	if err := alice.LoadKey("alice", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := alicePub.LoadKey("alice.pub", "rsassa-pss-sha256", []string{"sha256", "sha512"}); err != nil {
+		t.Fatal(err)
+	}
+	layoutKeys := map[string]Key{alice.KeyID: alicePub}
+
+	// The step "package" tars foo.py, the inspection "untar" extracts it again
+	// and matches the extracted file with the packaged one
+	runDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(runDir, "foo.py"), []byte("print('foo')\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	linkDir := t.TempDir()
+	packageLink, err := InTotoRun("package", runDir, []string{runDir}, []string{runDir},
+		[]string{"tar", "cf", "foo.tar", "foo.py"}, alice, []string{"sha256"}, nil,
+		[]string{runDir + "/"}, testOSisWindows(), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := packageLink.Dump(filepath.Join(linkDir, fmt.Sprintf(LinkNameFormat, "package", alice.KeyID))); err != nil {
+		t.Fatal(err)
+	}
+	// The inspection link is dumped to the current working directory
+	t.Cleanup(func() { os.Remove(fmt.Sprintf(LinkNameFormatShort, "untar")) })
+
+	newLayout := func(run []string) *Metablock {
+		layout := Layout{
+			Type:    "layout",
+			Expires: time.Now().Add(time.Hour).UTC().Format(ISO8601DateSchema),
+			Keys:    map[string]Key{alicePub.KeyID: alicePub},
+			Steps: []Step{{
+				Type: "step",
+				SupplyChainItem: SupplyChainItem{
+					Name:              "package",
+					ExpectedMaterials: [][]string{{"ALLOW", "foo.py"}, {"DISALLOW", "*"}},
+					ExpectedProducts:  [][]string{{"CREATE", "foo.tar"}, {"ALLOW", "foo.py"}, {"DISALLOW", "*"}},
+				},
+				ExpectedCommand: []string{"tar", "cf", "foo.tar", "foo.py"},
+				PubKeys:         []string{alice.KeyID},
+				Threshold:       1,
+			}},
+			Inspect: []Inspection{{
+				Type: "inspection",
+				SupplyChainItem: SupplyChainItem{
+					Name: "untar",
+					ExpectedMaterials: [][]string{
+						{"MATCH", "foo.tar", "IN", runDir, "WITH", "PRODUCTS", "FROM", "package"},
+						{"ALLOW", runDir + "/foo.py"},
+						{"DISALLOW", "*"},
+					},
+					ExpectedProducts: [][]string{
+						{"MATCH", "foo.py", "IN", runDir, "WITH", "MATERIALS", "FROM", "package"},
+						{"ALLOW", runDir + "/foo.tar"},
+						{"DISALLOW", "*"},
+					},
+				},
+				Run: run,
+			}},
+		}
+		mb := &Metablock{Signed: layout}
+		if err := mb.Sign(alice); err != nil {
+			t.Fatal(err)
+		}
+		return mb
+	}
+
+	_, err = InTotoVerifyWithDirectory(newLayout([]string{"tar", "xf", "foo.tar"}), layoutKeys,
+		linkDir, runDir, "", make(map[string]string), [][]byte{}, testOSisWindows())
+	assert.Nil(t, err)
+
+	// Failing inspection commands fail verification
+	_, err = InTotoVerifyWithDirectory(newLayout([]string{"tar", "xf", "missing.tar"}), layoutKeys,
+		linkDir, runDir, "", make(map[string]string), [][]byte{}, testOSisWindows())
+	assert.ErrorContains(t, err, "inspection 'untar'")
+
+	// An archive whose content differs from the packaged file fails the
+	// artifact rules of the inspection
+	if err := os.WriteFile(filepath.Join(runDir, "foo.py"), []byte("print('bar')\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("tar", "cf", filepath.Join(runDir, "foo.tar"), "-C", runDir, "foo.py").CombinedOutput(); err != nil {
+		t.Fatalf("failed to create archive: %s: %s", err, out)
+	}
+	_, err = InTotoVerifyWithDirectory(newLayout([]string{"tar", "xf", "foo.tar"}), layoutKeys,
+		linkDir, runDir, "", make(map[string]string), [][]byte{}, testOSisWindows())
+	var ruleErr *RuleViolationError
+	if assert.ErrorAs(t, err, &ruleErr) {
+		assert.Equal(t, "untar", ruleErr.ItemName)
+	}
+}
+
 func TestLoadLayoutCertificates(t *testing.T) {
 	certTemplate := &x509.Certificate{
 		Subject: pkix.Name{
@@ -1136,3 +2671,38 @@ func TestLoadLayoutCertificates(t *testing.T) {
 	_, _, err = LoadLayoutCertificates(testLayout, [][]byte{[]byte("123123123")})
 	assert.NotNil(t, err, "expected error with invalid extra intermediates")
 }
+
+func TestCombineStepLinks(t *testing.T) {
+	link := func(name string, materials, products map[string]HashObj) Metablock {
+		return Metablock{Signed: Link{Type: "link", Name: name, Materials: materials, Products: products}}
+	}
+	foo := HashObj{"sha256": "abc"}
+	bar := HashObj{"sha256": "def"}
+
+	// Functionaries that recorded identical artifacts
+	diffs, err := CombineStepLinks([]Metablock{
+		link("build", map[string]HashObj{"foo.py": foo}, map[string]HashObj{"foo.tar.gz": bar}),
+		link("build", map[string]HashObj{"foo.py": foo}, map[string]HashObj{"foo.tar.gz": bar}),
+	})
+	assert.Nil(t, err)
+	assert.True(t, diffs.Empty())
+
+	// Functionaries that recorded a different and a missing artifact
+	diffs, err = CombineStepLinks([]Metablock{
+		link("build", map[string]HashObj{"foo.py": foo, "bar.py": bar}, map[string]HashObj{"foo.tar.gz": bar}),
+		link("build", map[string]HashObj{"foo.py": foo}, map[string]HashObj{"foo.tar.gz": foo}),
+	})
+	assert.Nil(t, err)
+	assert.False(t, diffs.Empty())
+	assert.Equal(t, LinkArtifactDiffs{
+		Materials: map[string][]HashObj{"bar.py": {bar, nil}},
+		Products:  map[string][]HashObj{"foo.tar.gz": {bar, foo}},
+	}, diffs)
+
+	_, err = CombineStepLinks(nil)
+	assert.ErrorContains(t, err, "no links to combine")
+	_, err = CombineStepLinks([]Metablock{link("build", nil, nil), {Signed: Layout{}}})
+	assert.ErrorContains(t, err, "invalid metadata")
+	_, err = CombineStepLinks([]Metablock{link("build", nil, nil), link("package", nil, nil)})
+	assert.ErrorContains(t, err, "links are for different steps 'build' and 'package'")
+}
@@ -0,0 +1,102 @@
+#!/usr/bin/env python3
+"""
+Generates external.layout, a layout in the format of the in-toto reference
+implementation, whose functionary key is in the format of current versions of
+securesystemslib, i.e. without "keyid_hash_algorithms".  in-toto-golang
+re-encodes such a key with "keyid_hash_algorithms": null, so the layout only
+verifies against the signed bytes as loaded.
+
+The script only depends on the Python standard library and openssl.  It follows
+securesystemslib (securesystemslib/signer/_key.py and _crypto_signer.py) for the
+key format, keyid and signature, and in-toto (in_toto/models/metadata.py) for the
+metadata file, without using any code of in-toto-golang:
+
+  - The functionary key dan.pub is listed like SSlibKey.to_dict does, plus its
+    "keyid", which is the sha256 over the canonical JSON of that dict.
+  - The layout is signed with alice like CryptoSigner.sign does for the
+    rsassa-pss-sha256 scheme, i.e. RSASSA-PSS with SHA-256, MGF1 with SHA-256
+    and a salt as long as the digest, over the canonical JSON of "signed".
+  - The file is written like Metablock.dump does.
+
+Run it from test/data to regenerate the fixture.
+"""
+
+import hashlib
+import json
+import re
+import subprocess
+
+# The keyid of alice.pub as loaded by in-toto-golang, which is passed to verify
+ALICE_KEYID = "70ca5750c2eda80b18f41f4ec5f92146789b5d68dd09577be422a0159bd13680"
+
+
+def encode_canonical(obj):
+    """securesystemslib.formats.encode_canonical"""
+    if isinstance(obj, bool):
+        return "true" if obj else "false"
+    if obj is None:
+        return "null"
+    if isinstance(obj, int):
+        return str(obj)
+    if isinstance(obj, str):
+        return '"' + re.sub(r'(["\\])', r"\\\1", obj) + '"'
+    if isinstance(obj, (list, tuple)):
+        return "[" + ",".join(encode_canonical(item) for item in obj) + "]"
+    if isinstance(obj, dict):
+        return "{" + ",".join(
+            encode_canonical(key) + ":" + encode_canonical(obj[key])
+            for key in sorted(obj)) + "}"
+    raise TypeError(obj)
+
+
+def import_rsa_key(path):
+    """securesystemslib SSlibKey.to_dict of an RSA public key and its keyid"""
+    with open(path) as f:
+        public = f.read().strip()
+    key = {
+        "keytype": "rsa",
+        "scheme": "rsassa-pss-sha256",
+        "keyval": {"public": public},
+    }
+    keyid = hashlib.sha256(encode_canonical(key).encode()).hexdigest()
+    return keyid, dict(key, keyid=keyid)
+
+
+def sign(path, data):
+    """securesystemslib CryptoSigner.sign for the rsassa-pss-sha256 scheme"""
+    return subprocess.run(
+        ["openssl", "dgst", "-sha256", "-sign", path,
+         "-sigopt", "rsa_padding_mode:pss", "-sigopt", "rsa_pss_saltlen:digest",
+         "-sigopt", "rsa_mgf1_md:sha256"],
+        input=data, stdout=subprocess.PIPE, check=True).stdout.hex()
+
+
+def main():
+    dan, dan_key = import_rsa_key("dan.pub")
+    layout = {
+        "_type": "layout",
+        "expires": "2030-11-18T16:06:36Z",
+        "inspect": [],
+        "keys": {dan: dan_key},
+        "readme": "",
+        "steps": [{
+            "_type": "step",
+            "expected_command": ["tar", "zcvf", "foo.tar.gz", "foo.py"],
+            "expected_materials": [],
+            "expected_products": [["CREATE", "foo.tar.gz"], ["DISALLOW", "*"]],
+            "name": "package",
+            "pubkeys": [dan],
+            "threshold": 1,
+        }],
+    }
+    signature = {
+        "keyid": ALICE_KEYID,
+        "sig": sign("alice", encode_canonical(layout).encode()),
+    }
+    with open("external.layout", "w") as f:
+        f.write(json.dumps({"signatures": [signature], "signed": layout},
+                           indent=1, separators=(",", ": "), sort_keys=True))
+
+
+if __name__ == "__main__":
+    main()
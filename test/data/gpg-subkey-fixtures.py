@@ -0,0 +1,248 @@
+#!/usr/bin/env python3
+"""
+Generates the GPG subkey fixtures, i.e. gpg-subkey.pub.asc, gpg-subkey.sec.asc,
+gpg-subkey.layout and write-code-subkey.<keyid>.link, in the formats of the
+in-toto reference implementation.
+
+The script only depends on the Python standard library and gpg.  It follows
+securesystemslib (securesystemslib/gpg/common.py, rsa.py and functions.py) for
+the key and signature formats and in-toto (in_toto/models/metadata.py) for the
+metadata files, without using any code of in-toto-golang:
+
+  - Keys are parsed from "gpg --export" like parse_pubkey_bundle and
+    parse_pubkey_payload do, i.e. the signing subkeys of the primary key are
+    listed in "subkeys".
+  - Metadata is signed like create_signature does, i.e. with
+    "gpg --sign --detach-sign --digest-algo SHA256 --local-user <primary>" over
+    the canonical JSON of "signed", so that gpg picks the signing subkey.  The
+    signature packet is split like parse_signature_packet does.
+  - Files are written like Metablock.dump does.
+
+Run it from test/data to regenerate the fixtures with a new key.
+"""
+
+import hashlib
+import json
+import re
+import struct
+import subprocess
+import sys
+import tempfile
+
+USER_ID = "in-toto subkey test <subkey@in-toto.io>"
+CREATION_TIME = "20260101T000000!"
+
+
+def gpg(homedir, *args, data=None):
+    return subprocess.run(
+        ["gpg", "--homedir", homedir, "--batch", "--no-tty", *args],
+        input=data, stdout=subprocess.PIPE, check=True).stdout
+
+
+def encode_canonical(obj):
+    """securesystemslib.formats.encode_canonical"""
+    if isinstance(obj, bool):
+        return "true" if obj else "false"
+    if obj is None:
+        return "null"
+    if isinstance(obj, int):
+        return str(obj)
+    if isinstance(obj, str):
+        return '"' + re.sub(r'(["\\])', r"\\\1", obj) + '"'
+    if isinstance(obj, (list, tuple)):
+        return "[" + ",".join(encode_canonical(item) for item in obj) + "]"
+    if isinstance(obj, dict):
+        return "{" + ",".join(
+            encode_canonical(key) + ":" + encode_canonical(obj[key])
+            for key in sorted(obj)) + "}"
+    raise TypeError(obj)
+
+
+def read_packets(data):
+    """Yields the (tag, header, body) of the OpenPGP packets in data"""
+    ptr = 0
+    while ptr < len(data):
+        first = data[ptr]
+        if first & 0x40:
+            tag = first & 0x3f
+            length = data[ptr + 1]
+            if length < 192:
+                header_length = 2
+            elif length < 224:
+                length = ((length - 192) << 8) + data[ptr + 2] + 192
+                header_length = 3
+            else:
+                length = struct.unpack(">I", data[ptr + 2:ptr + 6])[0]
+                header_length = 6
+        else:
+            tag = (first & 0x3c) >> 2
+            header_length = {0: 2, 1: 3, 2: 5}[first & 0x03]
+            length = int.from_bytes(data[ptr + 1:ptr + header_length], "big")
+        yield tag, data[ptr:ptr + header_length], \
+            data[ptr + header_length:ptr + header_length + length]
+        ptr += header_length + length
+
+
+def read_mpi(data, ptr):
+    bits = struct.unpack(">H", data[ptr:ptr + 2])[0]
+    length = (bits + 7) // 8
+    return data[ptr + 2:ptr + 2 + length], ptr + 2 + length
+
+
+def parse_subpackets(data):
+    """Yields the (type, data) of the passed signature subpackets"""
+    ptr = 0
+    while ptr < len(data):
+        length = data[ptr]
+        if length < 192:
+            ptr += 1
+        elif length < 255:
+            length = ((length - 192) << 8) + data[ptr + 1] + 192
+            ptr += 2
+        else:
+            length = struct.unpack(">I", data[ptr + 1:ptr + 5])[0]
+            ptr += 5
+        yield data[ptr] & 0x7f, data[ptr + 1:ptr + length]
+        ptr += length
+
+
+def parse_pubkey_payload(body):
+    """securesystemslib.gpg.common.parse_pubkey_payload for RSA keys"""
+    assert body[0] == 4 and body[5] == 1, "only version 4 RSA keys"
+    n, ptr = read_mpi(body, 6)
+    e, _ = read_mpi(body, ptr)
+    fingerprint = hashlib.sha1(
+        b"\x99" + struct.pack(">H", len(body)) + body).hexdigest()
+    return {
+        "method": "pgp+rsa-pkcsv1.5",
+        "type": "rsa",
+        "hashes": ["pgp+SHA2"],
+        "creation_time": struct.unpack(">I", body[1:5])[0],
+        "keyid": fingerprint,
+        "keyval": {
+            "private": "",
+            "public": {"e": e.hex(), "n": n.hex()},
+        },
+    }
+
+
+def signature_info(body):
+    """Returns the type, key flags and key expiration of a signature packet"""
+    hashed_length = struct.unpack(">H", body[4:6])[0]
+    flags, expiration = None, None
+    for subpacket_type, data in parse_subpackets(body[6:6 + hashed_length]):
+        if subpacket_type == 27:
+            flags = data[0]
+        elif subpacket_type == 9:
+            expiration = struct.unpack(">I", data)[0]
+    return body[1], flags, expiration
+
+
+def export_pubkey(homedir, keyid):
+    """securesystemslib.gpg.functions.export_pubkey"""
+    primary, subkeys, current = None, {}, None
+    for tag, _, body in read_packets(gpg(homedir, "--export", keyid)):
+        if tag == 6:
+            primary = current = parse_pubkey_payload(body)
+        elif tag == 14:
+            current = parse_pubkey_payload(body)
+            subkeys[current["keyid"]] = current
+        elif tag == 2:
+            sig_type, flags, expiration = signature_info(body)
+            # Self-signatures of user ids and subkey binding signatures
+            if sig_type in (0x10, 0x11, 0x12, 0x13, 0x18):
+                if expiration:
+                    current["validity_period"] = expiration
+                if sig_type == 0x18 and flags is not None:
+                    current["_flags"] = flags
+    signing = {}
+    for subkey_id, subkey in subkeys.items():
+        if subkey.pop("_flags", 0x02) & 0x02:
+            signing[subkey_id] = subkey
+    if signing:
+        primary["subkeys"] = signing
+    return primary
+
+
+def create_signature(homedir, content, keyid):
+    """securesystemslib.gpg.functions.create_signature"""
+    signature_packet = gpg(homedir, "--sign", "--detach-sign", "--digest-algo",
+                           "SHA256", "--local-user", keyid, data=content)
+    (_, _, data), = read_packets(signature_packet)
+    ptr = 6 + struct.unpack(">H", data[4:6])[0]
+    other_headers = data[:ptr]
+    keyid = ""
+    for subpacket_type, subpacket in parse_subpackets(data[6:ptr]):
+        if subpacket_type == 33:
+            keyid = subpacket[1:].hex()
+    unhashed_length = struct.unpack(">H", data[ptr:ptr + 2])[0]
+    ptr += 2 + unhashed_length + 2
+    signature, _ = read_mpi(data, ptr)
+    assert keyid, "gpg did not create an issuer fingerprint"
+    # create_signature drops the short keyid once the keyid is known
+    return {
+        "keyid": keyid,
+        "other_headers": other_headers.hex(),
+        "signature": signature.hex(),
+    }
+
+
+def dump(path, signed, signature):
+    """in_toto.models.metadata.Metablock.dump"""
+    with open(path, "w") as f:
+        f.write(json.dumps({"signatures": [signature], "signed": signed},
+                           indent=1, separators=(",", ": "), sort_keys=True))
+
+
+def main():
+    homedir = tempfile.mkdtemp()
+    faked = ["--faked-system-time", CREATION_TIME, "--passphrase", "",
+             "--pinentry-mode", "loopback"]
+    gpg(homedir, *faked, "--quick-gen-key", USER_ID, "rsa2048", "cert", "never")
+    primary = export_pubkey(homedir, USER_ID)["keyid"]
+    gpg(homedir, *faked, "--quick-add-key", primary, "rsa2048", "sign", "never")
+    pubkey = export_pubkey(homedir, primary)
+    subkey = next(iter(pubkey["subkeys"]))
+
+    with open("gpg-subkey.pub.asc", "wb") as f:
+        f.write(gpg(homedir, "--armor", "--export", primary))
+    with open("gpg-subkey.sec.asc", "wb") as f:
+        f.write(gpg(homedir, *faked, "--armor", "--export-secret-keys", primary))
+
+    link = {
+        "_type": "link",
+        "byproducts": {"return-value": 0, "stderr": "", "stdout": ""},
+        "command": ["touch", "foo.py"],
+        "environment": {},
+        "materials": {},
+        "name": "write-code-subkey",
+        "products": {"foo.py": {"sha256": hashlib.sha256(b"").hexdigest()}},
+    }
+    signature = create_signature(homedir, encode_canonical(link).encode(), primary)
+    assert signature["keyid"] == subkey, "gpg did not sign with the subkey"
+    dump("write-code-subkey.{}.link".format(subkey[:8]), link, signature)
+
+    layout = {
+        "_type": "layout",
+        "expires": "2050-01-01T00:00:00Z",
+        "inspect": [],
+        "keys": {primary: pubkey},
+        "readme": "",
+        "steps": [{
+            "_type": "step",
+            "expected_command": ["touch", "foo.py"],
+            "expected_materials": [],
+            "expected_products": [["CREATE", "foo.py"], ["DISALLOW", "*"]],
+            "name": "write-code-subkey",
+            "pubkeys": [primary],
+            "threshold": 1,
+        }],
+    }
+    signature = create_signature(homedir, encode_canonical(layout).encode(), primary)
+    dump("gpg-subkey.layout", layout, signature)
+    print("primary key {}, signing subkey {}".format(primary, subkey),
+          file=sys.stderr)
+
+
+if __name__ == "__main__":
+    main()